@@ -4,7 +4,13 @@ from fastapi import APIRouter, HTTPException, status
 
 from core.rag.embedding import OllamaDenseEmbeddingModel, SparseEmbeddingModel
 from core.rag.retrieval.search import hybrid_search
-from models.search import SearchRequest, SearchResponse, SearchResult
+from models.search import (
+    SearchRequest,
+    SearchResponse,
+    SearchResult,
+    SparseEmbeddingRequest,
+    SparseEmbeddingResponse,
+)
 from utils import logger
 
 router = APIRouter(prefix="/search", tags=["search"])
@@ -81,3 +87,40 @@ async def search_documents(request: SearchRequest) -> SearchResponse:
             status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
             detail=f"Search failed: {e}",
         )
+
+
+@router.post(
+    "/sparse_embedding",
+    response_model=SparseEmbeddingResponse,
+    status_code=status.HTTP_200_OK,
+    summary="Sparse query embedding",
+    description="Compute sparse (lexical weight) vectors so other services can run sparse search in Milvus",
+)
+async def sparse_embedding(request: SparseEmbeddingRequest) -> SparseEmbeddingResponse:
+    """
+    Compute sparse embeddings for query texts.
+
+    Args:
+        request: SparseEmbeddingRequest containing the texts to embed
+
+    Returns:
+        SparseEmbeddingResponse with one sparse vector per text
+
+    Raises:
+        HTTPException: If embedding fails
+    """
+    try:
+        sparse_embedding_model = SparseEmbeddingModel()
+        embeddings = await sparse_embedding_model.get_embeddings(request.texts)
+        return SparseEmbeddingResponse(
+            embeddings=[
+                {int(index): float(weight) for index, weight in embedding.items()} for embedding in embeddings
+            ]
+        )
+
+    except Exception as e:
+        logger.error(f"Sparse embedding failed: {e}")
+        raise HTTPException(
+            status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=f"Sparse embedding failed: {e}",
+        )
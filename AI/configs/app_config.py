@@ -80,6 +80,10 @@ class Settings(BaseSettings):
     def MINIO_ENDPOINT(self) -> str:
         return f"{self.MINIO_HOST}:{self.MINIO_PORT}"
 
+    # RABBITMQ
+    # Topic exchange of the file and dataset lifecycle events, the Go server's RABBITMQ_EXCHANGE
+    RABBITMQ_EXCHANGE: str = "info-weaver-events"
+
     # OLLAMA
     OLLAMA_HOST: str = "localhost"
     OLLAMA_PORT: int = 11434
@@ -1,7 +1,7 @@
 """Agentic RAG module using llama-index FunctionAgent."""
 
 import json
-from typing import AsyncGenerator
+from typing import Any, AsyncGenerator
 
 from llama_index.core.agent import FunctionAgent
 from llama_index.core.agent.workflow import (
@@ -65,6 +65,7 @@ class AgenticRAG:
             dataset_id=dataset_id,
             embedding_config=embedding_config,
             top_k=self.retrieval_config.top_k,
+            collection_name=self.retrieval_config.collection_name,
         )
         # Build LLM and agent, then wrap in AgentWorkflow
         sampling = llm_config.sampling_params or SamplingParams()
@@ -111,13 +112,20 @@ When answering questions, you should:
             ("thinking", text) - model internal reasoning
             ("text", text)    - final response content
             ("source", json)  - retrieved chunk metadata (JSON array)
+            ("usage", json)   - tokens of the LLM calls, when the provider reported them
             ("error", text)   - stream-level error
         """
+        usage = {"input_tokens": 0, "output_tokens": 0}
+        reported = False
         try:
             handler = self.workflow.run(user_msg=query)
             async for event in handler.stream_events():
                 match event:
                     case AgentStream():
+                        if counts := _usage_of(event.raw):
+                            usage["input_tokens"] += counts[0]
+                            usage["output_tokens"] += counts[1]
+                            reported = True
                         if event.thinking_delta:
                             yield ("thinking", event.thinking_delta)
                         if event.delta:
@@ -144,15 +152,38 @@ When answering questions, you should:
                         if isinstance(raw, RetrievalResult) and raw.sources:
                             yield ("source", json.dumps(raw.sources, ensure_ascii=False))
                     case StopEvent():
-                        return
+                        break
                     case WorkflowFailedEvent():
                         logger.error(
                             f"Workflow failed | step={event.step_name} | "
                             f"exception={type(event.exception).__name__}: {event.exception}"
                         )
                         yield ("error", f"LLM call failed in step '{event.step_name}': {event.exception}")
-                        return
+                        break
 
         except Exception as e:
             logger.error(f"Streaming generation failed: {e}")
             yield ("error", str(e))
+
+        # Calls made before a failure are billed too
+        if reported:
+            yield ("usage", json.dumps(usage))
+
+
+def _usage_of(raw: Any) -> tuple[int, int] | None:
+    """Read the prompt and completion tokens of a raw streamed LLM chunk.
+
+    OpenAI-compatible servers put the usage in the last chunk of a call, if at all.
+
+    Args:
+        raw: Raw chunk of the LLM client, an object or a dict.
+
+    Returns:
+        (prompt_tokens, completion_tokens), or None when the chunk carries no usage.
+    """
+    usage = raw.get("usage") if isinstance(raw, dict) else getattr(raw, "usage", None)
+    if not usage:
+        return None
+    if isinstance(usage, dict):
+        return int(usage.get("prompt_tokens") or 0), int(usage.get("completion_tokens") or 0)
+    return int(getattr(usage, "prompt_tokens", 0) or 0), int(getattr(usage, "completion_tokens", 0) or 0)
@@ -21,6 +21,7 @@ def dense_search(
     dataset_id: int,
     limit: int = 10,
     expr: str | None = None,
+    collection_name: str | None = None,
 ) -> list[SearchResult]:
     """Search using dense vectors only.
 
@@ -29,6 +30,7 @@ def dense_search(
         dataset_id: Dataset ID to filter results.
         limit: Number of results to return.
         expr: Additional filter expression.
+        collection_name: Collection to search, the default collection when None.
 
     Returns:
         List of SearchResult objects.
@@ -38,7 +40,7 @@ def dense_search(
     search_params = {"metric_type": "IP", "params": {}}
 
     results = milvus_db.client.search(
-        collection_name=settings.MILVUS_COLLECTION_NAME,
+        collection_name=collection_name or settings.MILVUS_COLLECTION_NAME,
         data=[query_dense_embedding],
         anns_field="dense_vector",
         limit=limit,
@@ -56,6 +58,7 @@ def sparse_search(
     dataset_id: int,
     limit: int = 10,
     expr: str | None = None,
+    collection_name: str | None = None,
 ) -> list[SearchResult]:
     """Search using sparse vectors only.
 
@@ -64,6 +67,7 @@ def sparse_search(
         dataset_id: Dataset ID to filter results.
         limit: Number of results to return.
         expr: Additional filter expression.
+        collection_name: Collection to search, the default collection when None.
 
     Returns:
         List of SearchResult objects.
@@ -73,7 +77,7 @@ def sparse_search(
     search_params = {"metric_type": "IP", "params": {}}
 
     results = milvus_db.client.search(
-        collection_name=settings.MILVUS_COLLECTION_NAME,
+        collection_name=collection_name or settings.MILVUS_COLLECTION_NAME,
         data=[query_sparse_embedding],
         anns_field="sparse_vector",
         limit=limit,
@@ -94,6 +98,7 @@ def hybrid_search(
     dense_weight: float = 1.0,
     limit: int = 10,
     expr: str | None = None,
+    collection_name: str | None = None,
 ) -> list[SearchResult]:
     """Hybrid search combining dense and sparse vectors.
 
@@ -107,6 +112,7 @@ def hybrid_search(
         dense_weight: Weight for dense vector search (default: 1.0).
         limit: Number of results to return.
         expr: Additional filter expression.
+        collection_name: Collection to search, the default collection when None.
 
     Returns:
         List of SearchResult objects with reranked scores.
@@ -133,7 +139,7 @@ def hybrid_search(
     )
     ranker = WeightedRanker(sparse_weight, dense_weight)
     results = milvus_db.client.hybrid_search(
-        collection_name=settings.MILVUS_COLLECTION_NAME,
+        collection_name=collection_name or settings.MILVUS_COLLECTION_NAME,
         reqs=[sparse_req, dense_req],
         ranker=ranker,
         limit=limit,
@@ -53,6 +53,7 @@ def create_retrieval_tool(
     dataset_id: int,
     embedding_config: EmbeddingModelConfig,
     top_k: int = 10,
+    collection_name: str | None = None,
 ) -> FunctionTool:
     """
     Create a retrieval tool for the agent to search documents.
@@ -61,6 +62,7 @@ def create_retrieval_tool(
         dataset_id: Dataset ID to search in
         embedding_config: Embedding model configuration
         top_k: Number of results to retrieve
+        collection_name: Milvus collection to search, the default collection when None
 
     Returns:
         FunctionTool for document retrieval
@@ -113,6 +115,7 @@ def create_retrieval_tool(
                 query_sparse_embedding=sparse_embeddings[0],
                 dataset_id=dataset_id,
                 limit=top_k,
+                collection_name=collection_name,
             )
 
             # Build sources for frontend (chunk IDs, scores, previews)
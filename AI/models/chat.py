@@ -19,6 +19,9 @@ class RetrievalConfig(BaseModel):
     """Configuration for retrieval/search parameters."""
 
     top_k: int = Field(default=10, ge=1, description="Number of search results to retrieve")
+    collection_name: str | None = Field(
+        default=None, description="Milvus collection holding the dataset's vectors, the default collection when unset"
+    )
 
 
 class ModelConfig(BaseModel):
@@ -30,6 +30,18 @@ class SearchResponse(BaseModel):
     total: int
 
 
+class SparseEmbeddingRequest(BaseModel):
+    """Request model for sparse query embeddings."""
+
+    texts: list[str] = Field(..., min_length=1, description="Texts to embed")
+
+
+class SparseEmbeddingResponse(BaseModel):
+    """Response model for sparse query embeddings."""
+
+    embeddings: list[dict[int, float]]
+
+
 @dataclass
 class RetrievalResult:
     """Wrapper returned by retrieve_documents tool.
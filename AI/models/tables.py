@@ -101,10 +101,31 @@ class Chunk(Base, BaseModelMixin):
         String(20), default="pending", server_default="pending", nullable=False
     )  # pending | embedding | completed | failed
     vector_id: Mapped[str | None] = mapped_column(String(255), unique=True, nullable=True, index=True)
+    # Vector ID in the collection being built by a dataset reindex job, swapped into vector_id by the Go server
+    pending_vector_id: Mapped[str | None] = mapped_column(String(255), nullable=True, index=True)
     file_id: Mapped[int] = mapped_column(Integer, ForeignKey("files.id", ondelete="CASCADE"), nullable=False)
     file: Mapped["File"] = relationship("File", backref="chunks", lazy="select")
 
 
+class OutboxEvent(Base):
+    """OutboxEvent table - messages relayed to RabbitMQ by the Go server once the transaction writing them commits"""
+
+    __tablename__ = "outbox_events"
+
+    id: Mapped[int] = mapped_column(Integer, primary_key=True, autoincrement=True)
+    created_at: Mapped[datetime] = mapped_column(
+        DateTime(timezone=True), server_default=func.now(), default=func.now(), nullable=False
+    )
+    exchange: Mapped[str] = mapped_column(Text, nullable=False, default="")
+    routing_key: Mapped[str] = mapped_column(Text, nullable=False)
+    payload: Mapped[dict[str, Any]] = mapped_column(JSONB, nullable=False)
+    available_at: Mapped[datetime | None] = mapped_column(
+        DateTime(timezone=True), default=func.now(), nullable=True
+    )  # NULL while held back by the upload of a file
+    attempts: Mapped[int] = mapped_column(Integer, nullable=False, default=0)
+    last_error: Mapped[str] = mapped_column(Text, nullable=False, default="")
+
+
 class ChatSession(Base, BaseModelMixin):
     """ChatSession table - represents a conversation session with an AI model"""
 
@@ -112,7 +133,9 @@ class ChatSession(Base, BaseModelMixin):
 
     title: Mapped[str] = mapped_column(String(500), nullable=False)
     owner_id: Mapped[int] = mapped_column(Integer, ForeignKey("users.id", ondelete="CASCADE"), nullable=False)
+    dataset_id: Mapped[int] = mapped_column(Integer, ForeignKey("datasets.id", ondelete="CASCADE"), nullable=False)
     user: Mapped["User"] = relationship("User", backref="chat_sessions", lazy="select")
+    dataset: Mapped["Dataset"] = relationship("Dataset", backref="chat_sessions", lazy="select")
 
 
 # Association table for many-to-many relationship between Memory and Chunk
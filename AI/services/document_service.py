@@ -1,12 +1,20 @@
 """Document service — database operations for document chunks."""
 
+import uuid
+from datetime import UTC, datetime
+
+from sqlalchemy import func, select
 from sqlalchemy.orm import Session
 
+from configs.app_config import settings
 from core.rag.doc_store.document_store import DocumentChunk, add_document_chunks
 from models.document import EmbeddingModelConfig
-from models.tables import Chunk
+from models.tables import Chunk, File, OutboxEvent
 from utils import logger
 
+EVENT_FILE_PROCESSED = "file.processed"
+EVENT_FILE_FAILED = "file.failed"
+
 
 def persist_chunks(db: Session, contents: list[str], file_id: int) -> list[int]:
     """Persist chunk contents to PostgreSQL and return their IDs.
@@ -71,6 +79,65 @@ def update_chunk_status(db: Session, chunk_ids: list[int], status: str) -> None:
     db.commit()
 
 
+def settle_chunks(db: Session, chunk_ids: list[int], status: str, error: str = "") -> None:
+    """Set the final status of embedded chunks and publish the lifecycle events of the files it settles.
+
+    A file is processed once all its chunks are completed, and failed when its first chunk fails.
+    The events are written to the Go server's outbox in the same transaction as the statuses, the server
+    relays them to the RABBITMQ_EXCHANGE topic exchange where webhooks pick them up. The files are locked,
+    so that the last two batches of a file settling together cannot both miss that the file is done.
+
+    Args:
+        db: SQLAlchemy database session.
+        chunk_ids: List of chunk IDs whose embedding ended.
+        status: Final status value (completed | failed).
+        error: Why embedding failed, sent with file.failed.
+    """
+    if not chunk_ids:
+        return
+    file_ids = select(Chunk.file_id).where(Chunk.id.in_(chunk_ids)).distinct()
+    files = db.scalars(select(File).where(File.id.in_(file_ids)).order_by(File.id).with_for_update()).all()
+    already_failed = set(
+        db.scalars(
+            select(Chunk.file_id)
+            .where(Chunk.file_id.in_([file.id for file in files]), Chunk.status == "failed", Chunk.deleted_at.is_(None))
+            .distinct()
+        )
+    )
+    logger.info(f"Updating status of {len(chunk_ids)} chunks to '{status}'")
+    db.query(Chunk).filter(Chunk.id.in_(chunk_ids)).update({"status": status}, synchronize_session=False)
+
+    for file in files:
+        if file.deleted_at is not None:
+            continue
+        if status == "failed":
+            if file.id not in already_failed:
+                db.add(_file_event(EVENT_FILE_FAILED, file, error=error))
+            continue
+        unsettled = db.scalar(
+            select(func.count())
+            .select_from(Chunk)
+            .where(Chunk.file_id == file.id, Chunk.status != "completed", Chunk.deleted_at.is_(None))
+        )
+        if unsettled == 0:
+            db.add(_file_event(EVENT_FILE_PROCESSED, file))
+    db.commit()
+
+
+def _file_event(event: str, file: File, **data: str) -> OutboxEvent:
+    """Build the outbox entry of a file lifecycle event, shaped like the events of the Go server."""
+    payload = {
+        "id": uuid.uuid4().hex,
+        "event": event,
+        "user_id": file.user_id,
+        "dataset_id": file.dataset_id,
+        "file_id": file.id,
+        "data": {"name": file.name, "type": file.type, "size": file.size, **data},
+        "timestamp": datetime.now(UTC).isoformat(),
+    }
+    return OutboxEvent(exchange=settings.RABBITMQ_EXCHANGE, routing_key=event, payload=payload)
+
+
 async def background_embed_chunks(chunk_ids: list[int], embedding_config: EmbeddingModelConfig) -> None:
     """Run embedding in the background with status tracking.
 
@@ -78,7 +145,7 @@ async def background_embed_chunks(chunk_ids: list[int], embedding_config: Embedd
     1. Sets chunk status to 'embedding'
     2. Generates dense and sparse embeddings for each chunk
     3. Stores the chunks with embeddings in Milvus
-    4. Sets chunk status to 'completed' or 'failed'
+    4. Sets chunk status to 'completed' or 'failed', publishing file.processed or file.failed
 
     Args:
         chunk_ids: List of chunk IDs to embed.
@@ -113,11 +180,11 @@ async def background_embed_chunks(chunk_ids: list[int], embedding_config: Embedd
                 pg_chunk.vector_id = str(entity_id)
             db.commit()
 
-            # Step 3: Mark as completed
-            update_chunk_status(db, chunk_ids, "completed")
+            # Step 3: Mark as completed, publishing file.processed for the files now fully embedded
+            settle_chunks(db, chunk_ids, "completed")
             logger.success(f"Background embedding completed for {len(chunk_ids)} chunks")
 
         except Exception as e:
             logger.error(f"Background embedding failed for chunk IDs {chunk_ids}: {e}")
             with DBSession(engine) as recovery_db:
-                update_chunk_status(recovery_db, chunk_ids, "failed")
+                settle_chunks(recovery_db, chunk_ids, "failed", error=str(e)[:500])
@@ -12,4 +12,15 @@ func InitRouter(e *echo.Echo) {
 	v1.SetFileRouter(e)
 	v1.SetDatasetRouter(e)
 	v1.SetProviderRouter(e)
+	v1.SetSearchRouter(e)
+	v1.SetReindexRouter(e)
+	v1.SetChatRouter(e)
+	v1.SetAPIKeyRouter(e)
+	v1.SetOpenAIRouter(e)
+	v1.SetFeedbackRouter(e)
+	v1.SetUsageRouter(e)
+	v1.SetQuotaRouter(e)
+	v1.SetSharedProviderRouter(e)
+	v1.SetWebhookRouter(e)
+	v1.SetDeadLetterRouter(e)
 }
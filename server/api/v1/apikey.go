@@ -0,0 +1,117 @@
+package v1
+
+import (
+	"errors"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetAPIKeyRouter(e *echo.Echo) {
+	apiKeyRouterGroup := e.Group(config.API_V1+"/user/api-key", middleware.TokenMiddleware())
+	apiKeyHandler := &apiKeyApi{}
+	apiKeyRouterGroup.POST("/create", apiKeyHandler.createAPIKey)
+	apiKeyRouterGroup.GET("", apiKeyHandler.listAPIKeys)
+	apiKeyRouterGroup.POST("/delete/:key_id", apiKeyHandler.deleteAPIKey)
+}
+
+type apiKeyApi struct{}
+
+// createAPIKey godoc
+//
+//	@Summary		Create API Key
+//	@Description	Create an API key for the OpenAI-compatible API. The key is only returned by this request, store it safely.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			key	body		models.APIKeyCreateReq							true	"API key creation request"
+//	@Success		200	{object}	response.ResponseBase[models.APIKeyCreateResp]	"API key created successfully"
+//	@Failure		400	{object}	response.ResponseBase[any]						"Invalid request parameters"
+//	@Failure		401	{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		500	{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/user/api-key/create [post]
+func (this *apiKeyApi) createAPIKey(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.APIKeyCreateReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	key, err := apiKeyService.CreateAPIKey(ctx.Request().Context(), currentUser.ID, args.Name)
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, key)
+}
+
+// listAPIKeys godoc
+//
+//	@Summary		List API Keys
+//	@Description	List the API keys of the authenticated user. Only the leading characters of each key are shown.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	response.ResponseBase[models.APIKeyListResp]	"List of API keys"
+//	@Failure		401	{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		500	{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/user/api-key [get]
+func (this *apiKeyApi) listAPIKeys(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+
+	total, keys, err := apiKeyService.ListAPIKeys(ctx.Request().Context(), currentUser.ID)
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, models.APIKeyListResp{
+		Total: total,
+		Keys:  keys,
+	})
+}
+
+// deleteAPIKey godoc
+//
+//	@Summary		Delete API Key
+//	@Description	Revoke an API key of the authenticated user
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			key_id	path		int							true	"API key ID"
+//	@Success		200		{object}	response.ResponseBase[any]	"API key deleted successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		404		{object}	response.ResponseBase[any]	"API key not found"
+//	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/user/api-key/delete/{key_id} [post]
+func (this *apiKeyApi) deleteAPIKey(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.APIKeyReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := apiKeyService.DeleteAPIKey(ctx.Request().Context(), args.ID, currentUser.ID); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrAPIKeyNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
@@ -0,0 +1,361 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetChatRouter(e *echo.Echo) {
+
+	sessionRouterGroup := e.Group(config.API_V1+"/chat/session", middleware.TokenMiddleware())
+
+	chatHandler := &chatApi{}
+	sessionRouterGroup.POST("/create", chatHandler.createSession)
+	sessionRouterGroup.GET("", chatHandler.listSessions)
+	sessionRouterGroup.POST("/rename", chatHandler.renameSession)
+	sessionRouterGroup.POST("/fallback", chatHandler.setSessionFallback)
+	sessionRouterGroup.POST("/delete/:session_id", chatHandler.deleteSession)
+	sessionRouterGroup.GET("/:session_id/messages", chatHandler.listMessages)
+
+	chatRouterGroup := e.Group(config.API_V1+"/chat", middleware.TokenMiddleware())
+	chatRouterGroup.POST("/:session_id/stream", chatHandler.streamChat, middleware.RateLimitMiddleware("chat", config.Settings.GetChatRateLimit()))
+}
+
+type chatApi struct{}
+
+// createSession godoc
+//
+//	@Summary		Create Chat Session
+//	@Description	Create a chat session bound to a dataset of the authenticated user
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			session	body		models.ChatSessionCreateReq						true	"Chat session creation request"
+//	@Success		200		{object}	response.ResponseBase[models.ChatSessionInfo]	"Chat session created successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]						"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		404		{object}	response.ResponseBase[any]						"Dataset not found"
+//	@Failure		500		{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/chat/session/create [post]
+func (this *chatApi) createSession(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ChatSessionCreateReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch session, err := chatService.CreateSession(ctx.Request().Context(), currentUser.ID, args.DatasetID, args.Title); {
+	case err == nil:
+		return response.OkWithData(ctx, session)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrDatasetNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// listSessions godoc
+//
+//	@Summary		List Chat Sessions
+//	@Description	List the chat sessions of the authenticated user, most recently active first. If dataset_id is provided, only sessions of that dataset are listed.
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			dataset_id	query		int												false	"Dataset ID to filter by"
+//	@Success		200			{object}	response.ResponseBase[models.ChatSessionListResp]	"List of chat sessions"
+//	@Failure		400			{object}	response.ResponseBase[any]							"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]							"Invalid or expired token"
+//	@Failure		500			{object}	response.ResponseBase[any]							"Internal server error"
+//	@Router			/chat/session [get]
+func (this *chatApi) listSessions(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ChatSessionListReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	total, sessions, err := chatService.ListSessions(ctx.Request().Context(), currentUser.ID, args.DatasetID)
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, models.ChatSessionListResp{
+		Total:    total,
+		Sessions: sessions,
+	})
+}
+
+// renameSession godoc
+//
+//	@Summary		Rename Chat Session
+//	@Description	Change the title of a chat session
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			session	body		models.ChatSessionRenameReq	true	"Chat session rename request"
+//	@Success		200		{object}	response.ResponseBase[any]	"Chat session renamed successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		404		{object}	response.ResponseBase[any]	"Chat session not found"
+//	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/chat/session/rename [post]
+func (this *chatApi) renameSession(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ChatSessionRenameReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := chatService.RenameSession(ctx.Request().Context(), args.ID, currentUser.ID, args.Title); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrChatSessionNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// setSessionFallback godoc
+//
+//	@Summary		Set Chat Session Fallback Chain
+//	@Description	Set the ordered providers and models the session's chats fail over to when the provider fails before answering, with one of the failover_on error classes (default timeout, server, rate_limit and connection). A null chain uses the chat chain of the session's dataset. The provider that answered is reported in served_by of the done event.
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			fallback	body		models.ChatSessionFallbackReq	true	"Fallback chain"
+//	@Success		200			{object}	response.ResponseBase[any]		"Fallback chain set successfully"
+//	@Failure		400			{object}	response.ResponseBase[any]		"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]		"Invalid or expired token"
+//	@Failure		403			{object}	response.ResponseBase[any]		"Provider not owned or model not granted"
+//	@Failure		404			{object}	response.ResponseBase[any]		"Chat session not found"
+//	@Failure		500			{object}	response.ResponseBase[any]		"Internal server error"
+//	@Router			/chat/session/fallback [post]
+func (this *chatApi) setSessionFallback(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ChatSessionFallbackReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+	if args.Chat != nil {
+		for _, target := range args.Chat.Targets {
+			if err := checkProviderModel(ctx, currentUser.ID, target.ProviderID, target.Model); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch err := chatService.SetSessionFallback(ctx.Request().Context(), args.ID, currentUser.ID, args.Chat); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrChatSessionNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// deleteSession godoc
+//
+//	@Summary		Delete Chat Session
+//	@Description	Delete a chat session and its messages
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		int							true	"Chat session ID"
+//	@Success		200			{object}	response.ResponseBase[any]	"Chat session deleted successfully"
+//	@Failure		400			{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]	"Chat session not found"
+//	@Failure		500			{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/chat/session/delete/{session_id} [post]
+func (this *chatApi) deleteSession(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ChatSessionReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := chatService.DeleteSession(ctx.Request().Context(), args.ID, currentUser.ID); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrChatSessionNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// listMessages godoc
+//
+//	@Summary		List Chat Messages
+//	@Description	Page through the messages of a chat session in conversation order. Each message holds the question, the answer and the chunks cited by the answer.
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			session_id	path		int													true	"Chat session ID"
+//	@Param			page		query		int													false	"Page number"					minimum(1)
+//	@Param			page_size	query		int													false	"Number of messages per page"	minimum(1)	maximum(100)
+//	@Success		200			{object}	response.ResponseBase[models.ChatMessageListResp]	"Messages of the session"
+//	@Failure		400			{object}	response.ResponseBase[any]							"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]							"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]							"Chat session not found"
+//	@Failure		500			{object}	response.ResponseBase[any]							"Internal server error"
+//	@Router			/chat/session/{session_id}/messages [get]
+func (this *chatApi) listMessages(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ChatMessageListReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch total, messages, err := chatService.ListMessages(ctx.Request().Context(), args.ID, currentUser.ID, args.Page, args.PageSize); {
+	case err == nil:
+		return response.OkWithData(ctx, models.ChatMessageListResp{
+			Total:    total,
+			Messages: messages,
+		})
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrChatSessionNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// streamChat godoc
+//
+//	@Summary		Stream Chat
+//	@Description	Ask a question in a chat session. The question is answered by the RAG backend with the session's dataset as knowledge base and relayed as server-sent events named after the backend event types (thinking, text, source, error). Once the answer is saved in the session history, a final done event carries the message ID, the cited chunk IDs and the provider that answered. If the provider fails before answering, the question goes to the next provider of the session's or dataset's fallback chain.
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		text/event-stream
+//	@Param			session_id	path		int							true	"Chat session ID"
+//	@Param			body		body		models.ChatStreamReq		true	"Chat request"
+//	@Success		200			{object}	models.ChatStreamEvent		"Stream of chat events"
+//	@Failure		400			{object}	response.ResponseBase[any]	"Invalid request parameters, dataset without embedding model or Azure OpenAI provider"
+//	@Failure		401			{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		403			{object}	response.ResponseBase[any]	"Provider not owned, model not granted or monthly tokens used up"
+//	@Failure		404			{object}	response.ResponseBase[any]	"Chat session not found"
+//	@Failure		429			{object}	response.ResponseBase[any]	"Too many requests, see the Retry-After header"
+//	@Failure		500			{object}	response.ResponseBase[any]	"Internal server error"
+//	@Failure		502			{object}	response.ResponseBase[any]	"RAG backend unavailable"
+//	@Router			/chat/{session_id}/stream [post]
+func (this *chatApi) streamChat(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ChatStreamReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+	if args.ProviderID != 0 {
+		if err := checkProviderModel(ctx, currentUser.ID, args.ProviderID, args.Model); err != nil {
+			return err
+		}
+	}
+
+	// Headers are only sent with the first event, so failures before the backend answers are plain JSON errors
+	stream := &eventStream{writer: ctx.Response()}
+	emit := func(event models.ChatStreamEvent) error {
+		return stream.send(event.Type, event)
+	}
+	done, err := chatService.StreamChat(ctx.Request().Context(), args.ID, currentUser.ID, *args, emit)
+
+	if stream.started || err == nil {
+		switch {
+		case err == nil:
+			_ = stream.send(models.CHAT_EVENT_DONE, done)
+		case errors.Is(err, context.Canceled):
+			// Client went away, nothing left to tell
+		default:
+			Logger.Errorf("Chat stream of session %d failed: %v", args.ID, err)
+			_ = emit(models.ChatStreamEvent{Type: models.CHAT_EVENT_ERROR, Content: "Failed to complete the answer"})
+		}
+		return nil
+	}
+
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrChatSessionNotFound()
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return response.ErrQuotaExceeded(err.Error())
+	case errors.Is(err, service.ErrModelNotGranted):
+		return response.ErrModelNotGranted()
+	case errors.Is(err, service.ErrNoEmbeddingModel):
+		return response.ErrNoEmbeddingModel()
+	case errors.Is(err, service.ErrChatModeUnsupported):
+		return response.ErrChatModeUnsupported()
+	case errors.Is(err, service.ErrChatBackendUnavailable), errors.Is(err, service.ErrChatBackendFailed):
+		Logger.Error(err)
+		return response.ErrChatBackendUnavailable()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// eventStream writes server-sent events, flushing each one so tokens reach the client immediately
+type eventStream struct {
+	writer  http.ResponseWriter
+	started bool
+}
+
+func (this *eventStream) send(event string, data any) error {
+	if !this.started {
+		this.started = true
+		writeEventStreamHeader(this.writer)
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(this.writer, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return http.NewResponseController(this.writer).Flush()
+}
+
+func writeEventStreamHeader(writer http.ResponseWriter) {
+	header := writer.Header()
+	header.Set(echo.HeaderContentType, "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	writer.WriteHeader(http.StatusOK)
+}
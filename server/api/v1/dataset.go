@@ -8,6 +8,7 @@ import (
 	"server/models/common/response"
 	"server/service"
 	"server/utils"
+	"slices"
 
 	"github.com/labstack/echo/v5"
 )
@@ -21,6 +22,7 @@ func SetDatasetRouter(e *echo.Echo) {
 	datasetRouterGroup.GET("", datasetHandler.listDatasets)
 	datasetRouterGroup.GET("/:dataset_id", datasetHandler.getDatasetInfo)
 	datasetRouterGroup.POST("/update", datasetHandler.updateDatasetInfo)
+	datasetRouterGroup.POST("/fallback", datasetHandler.setDatasetFallback)
 	datasetRouterGroup.POST("/delete/:dataset_id", datasetHandler.deleteDataset)
 }
 
@@ -29,15 +31,15 @@ type datasetApi struct{}
 // createDataset godoc
 //
 //	@Summary		Create Dataset
-//	@Description	Create a new dataset for the authenticated user
+//	@Description	Create a new dataset for the authenticated user. The embedding model must be classified as an embedding model by the provider's model catalog.
 //	@Tags			Dataset
 //	@Accept			json
 //	@Produce		json
 //	@Param			dataset	body		models.DatasetCreateReq		true	"Dataset creation request"
 //	@Success		200		{object}	response.ResponseBase[any]	"Dataset created successfully"
-//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters or not an embedding model"
 //	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
-//	@Failure		403		{object}	response.ResponseBase[any]	"Dataset name already exists or provider not owned"
+//	@Failure		403		{object}	response.ResponseBase[any]	"Dataset name already exists, provider not owned, model not granted or dataset quota exceeded"
 //	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
 //	@Router			/dataset/create [post]
 func (this *datasetApi) createDataset(ctx *echo.Context) error {
@@ -64,18 +66,38 @@ func (this *datasetApi) createDataset(ctx *echo.Context) error {
 		return response.ErrProviderNotOwned()
 	}
 
-	if err := datasetService.CreateNewDataset(ctx.Request().Context(),
+	if err := this.checkRerankConfig(ctx, currentUser.ID, args.RerankType, args.RerankProviderID, args.RerankModel); err != nil {
+		return err
+	}
+	if args.EmbeddingModel != "" {
+		if err := this.checkEmbeddingModel(ctx, currentUser.ID, args.ProviderID, args.EmbeddingModel); err != nil {
+			return err
+		}
+	}
+
+	switch err := datasetService.CreateNewDataset(ctx.Request().Context(),
 		args.Icon,
 		args.Name,
 		args.Description,
 		args.SearchType,
 		args.EmbeddingModel,
 		args.ProviderID,
-		currentUser.ID); err != nil {
+		args.Tags,
+		args.TextSearchConfig,
+		models.DatasetRerankConfig{
+			Type:       args.RerankType,
+			ProviderID: args.RerankProviderID,
+			Model:      args.RerankModel,
+		},
+		currentUser.ID); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return response.ErrQuotaExceeded(err.Error())
+	default:
 		Logger.Error(err)
 		return response.ErrUnknownError()
 	}
-	return response.Ok(ctx)
 }
 
 // listDatasets godoc
@@ -161,17 +183,19 @@ func (this *datasetApi) getDatasetInfo(ctx *echo.Context) error {
 // updateDatasetInfo godoc
 //
 //	@Summary		Update Dataset
-//	@Description	Update an existing dataset
+//	@Description	Update an existing dataset. Changing the embedding model or provider of a dataset that already has vectors is rejected unless reindex is true, in which case the other changes are saved, then a reindex job is started and the new embedding takes effect once all vectors are rebuilt.
 //	@Tags			Dataset
 //	@Accept			json
 //	@Produce		json
-//	@Param			dataset	body		models.DatasetUpdateReq		true	"Dataset update request"
-//	@Success		200		{object}	response.ResponseBase[any]	"Dataset updated successfully"
-//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
-//	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
-//	@Failure		403		{object}	response.ResponseBase[any]	"Provider not owned"
-//	@Failure		404		{object}	response.ResponseBase[any]	"Dataset not found"
-//	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
+//	@Param			dataset	body		models.DatasetUpdateReq							true	"Dataset update request"
+//	@Success		200		{object}	response.ResponseBase[models.ReindexJobInfo]	"Dataset updated successfully, data holds the reindex job if one was started"
+//	@Failure		400		{object}	response.ResponseBase[any]						"Invalid request parameters, not an embedding model, embedding model unavailable or missing for the search type"
+//	@Failure		401		{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]						"Provider not owned or model not granted"
+//	@Failure		404		{object}	response.ResponseBase[any]						"Dataset not found"
+//	@Failure		409		{object}	response.ResponseBase[any]						"Reindex required or already running"
+//	@Failure		500		{object}	response.ResponseBase[any]						"Internal server error"
+//	@Failure		503		{object}	response.ResponseBase[any]						"Vector store unavailable"
 //	@Router			/dataset/update [post]
 func (this *datasetApi) updateDatasetInfo(ctx *echo.Context) error {
 	// Get user ID from token context
@@ -194,11 +218,121 @@ func (this *datasetApi) updateDatasetInfo(ctx *echo.Context) error {
 		}
 	}
 
-	switch err := datasetService.UpdateDataset(ctx.Request().Context(), args.ID, currentUser.ID, args.Icon, args.Name, args.Description, args.SearchType, args.EmbeddingModel, args.ProviderID); {
+	if err := this.checkRerankConfig(ctx, currentUser.ID, args.RerankType, args.RerankProviderID, args.RerankModel); err != nil {
+		return err
+	}
+	// Only keyword search works for a dataset without an embedding model
+	needsEmbedding := args.SearchType != "" && args.SearchType != models.SEARCH_TYPE_KEYWORD
+	embeddingProviderID := args.ProviderID
+	if (args.EmbeddingModel != "" && args.ProviderID == 0) || (args.EmbeddingModel == "" && needsEmbedding) {
+		switch dataset, err := datasetService.GetDatasetInfoByID(ctx.Request().Context(), args.ID, currentUser.ID); {
+		case errors.Is(err, service.ErrNotFound):
+			return response.ErrDatasetNotFound()
+		case err != nil:
+			Logger.Error(err)
+			return response.ErrUnknownError()
+		case args.EmbeddingModel == "" && dataset.EmbeddingModel == "":
+			return response.ErrNoEmbeddingModel()
+		case embeddingProviderID == 0:
+			embeddingProviderID = dataset.ProviderID
+		}
+	}
+	if args.EmbeddingModel != "" {
+		if err := this.checkEmbeddingModel(ctx, currentUser.ID, embeddingProviderID, args.EmbeddingModel); err != nil {
+			return err
+		}
+	}
+
+	// Stored vectors become unusable with another embedding, so such a change is only applied by a reindex job
+	needsReindex, err := reindexService.EmbeddingChangeNeedsReindex(ctx.Request().Context(), args.ID, currentUser.ID, args.ProviderID, args.EmbeddingModel)
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrDatasetNotFound()
+	case err != nil:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	case needsReindex && !args.Reindex:
+		return response.ErrReindexRequired()
+	}
+
+	// The job switches the embedding once all vectors are rebuilt, so it is only started once the other changes are saved
+	providerID, embeddingModel := args.ProviderID, args.EmbeddingModel
+	if needsReindex {
+		providerID, embeddingModel = 0, ""
+	}
+	rerank := models.DatasetRerankConfig{
+		Type:       args.RerankType,
+		ProviderID: args.RerankProviderID,
+		Model:      args.RerankModel,
+	}
+	switch err := datasetService.UpdateDataset(ctx.Request().Context(), args.ID, currentUser.ID, args.Icon, args.Name, args.Description, args.SearchType, embeddingModel, providerID, args.Tags, args.TextSearchConfig, rerank); {
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrDatasetNotFound()
+	case err != nil:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	case !needsReindex:
+		return response.Ok(ctx)
+	}
+
+	switch job, err := reindexService.StartReindexJob(ctx.Request().Context(), args.ID, currentUser.ID, args.ProviderID, args.EmbeddingModel); {
+	case err == nil:
+		return response.OkWithData(ctx, job)
+	case errors.Is(err, service.ErrReindexJobRunning):
+		return response.ErrReindexJobRunning()
+	case errors.Is(err, service.ErrEmbeddingProbeFailed):
+		Logger.Warn(err)
+		return response.ErrEmbeddingModelUnavailable()
+	case errors.Is(err, service.ErrVectorStoreUnavailable):
+		return response.ErrVectorStoreUnavailable()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// setDatasetFallback godoc
+//
+//	@Summary		Set Dataset Fallback Chains
+//	@Description	Set the ordered providers and models a dataset fails over to when its provider fails with one of the failover_on error classes (default timeout, server, rate_limit and connection). The chat chain serves chat sessions without a chain of their own and chat completions; the embedding chain embeds search queries and only accepts models embedding with the dimension of the dataset's model. Empty targets turn failover off, and changing the dataset's embedding clears the embedding chain.
+//	@Tags			Dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			fallback	body		models.DatasetFallbackReq	true	"Fallback chains"
+//	@Success		200			{object}	response.ResponseBase[any]	"Fallback chains set successfully"
+//	@Failure		400			{object}	response.ResponseBase[any]	"Invalid request parameters, embedding dimension mismatch, embedding model unavailable or missing"
+//	@Failure		401			{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		403			{object}	response.ResponseBase[any]	"Provider not owned or model not granted"
+//	@Failure		404			{object}	response.ResponseBase[any]	"Dataset not found"
+//	@Failure		500			{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/dataset/fallback [post]
+func (this *datasetApi) setDatasetFallback(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.DatasetFallbackReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+	for _, target := range slices.Concat(args.Chat.Targets, args.Embedding.Targets) {
+		if err := checkProviderModel(ctx, currentUser.ID, target.ProviderID, target.Model); err != nil {
+			return err
+		}
+	}
+
+	switch err := datasetService.SetDatasetFallback(ctx.Request().Context(), args.ID, currentUser.ID, args.Chat, args.Embedding); {
 	case err == nil:
 		return response.Ok(ctx)
 	case errors.Is(err, service.ErrNotFound):
 		return response.ErrDatasetNotFound()
+	case errors.Is(err, service.ErrNoEmbeddingModel):
+		return response.ErrNoEmbeddingModel()
+	case errors.Is(err, service.ErrEmbeddingDimensionMismatch):
+		return response.BadRequestWithMsg(err.Error())
+	case errors.Is(err, service.ErrEmbeddingProbeFailed):
+		Logger.Warn(err)
+		return response.ErrEmbeddingModelUnavailable()
 	default:
 		Logger.Error(err)
 		return response.ErrUnknownError()
@@ -240,3 +374,31 @@ func (this *datasetApi) deleteDataset(ctx *echo.Context) error {
 		return response.ErrUnknownError()
 	}
 }
+
+// checkRerankConfig verifies that remote rerankers come with a model and a provider owned by the user
+func (this *datasetApi) checkRerankConfig(ctx *echo.Context, userID uint, rerankType string, rerankProviderID uint, rerankModel string) error {
+	if rerankType != models.RERANK_TYPE_COHERE && rerankType != models.RERANK_TYPE_JINA {
+		return nil
+	}
+	if rerankProviderID == 0 || rerankModel == "" {
+		return response.ErrRerankConfigIncomplete()
+	}
+	return checkProviderModel(ctx, userID, rerankProviderID, rerankModel)
+}
+
+// checkEmbeddingModel verifies that the provider's model catalog classifies the model as an embedding model
+func (this *datasetApi) checkEmbeddingModel(ctx *echo.Context, userID uint, providerID uint, model string) error {
+	switch err := providerService.CheckEmbeddingModel(ctx.Request().Context(), providerID, userID, model); {
+	case err == nil:
+		return nil
+	case errors.Is(err, service.ErrNotEmbeddingModel):
+		return response.ErrNotEmbeddingModel()
+	case errors.Is(err, service.ErrModelNotGranted):
+		return response.ErrModelNotGranted()
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrProviderNotOwned()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
@@ -0,0 +1,107 @@
+package v1
+
+import (
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetDeadLetterRouter(e *echo.Echo) {
+	deadLetterHandler := &deadLetterApi{}
+
+	adminRouterGroup := e.Group(config.API_V1+"/admin/dead-letter", middleware.TokenMiddleware(), middleware.AdminMiddleware())
+	adminRouterGroup.GET("/list", deadLetterHandler.listDeadLetters)
+	adminRouterGroup.POST("/requeue", deadLetterHandler.requeueDeadLetters)
+	adminRouterGroup.POST("/purge", deadLetterHandler.purgeDeadLetters)
+}
+
+type deadLetterApi struct{}
+
+// listDeadLetters godoc
+//
+//	@Summary		List Dead-Lettered Messages
+//	@Description	Inspect the oldest messages of a queue dead-lettered after their last retry, or at once when malformed. They stay in the dead-letter queue. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			queue	query		string											false	"Queue of the messages, ingestion by default"	Enums(ingestion, webhook_events)
+//	@Param			limit	query		int												false	"Messages returned, 20 by default"				minimum(1)	maximum(100)
+//	@Success		200		{object}	response.ResponseBase[models.DeadLetterListResp]	"Dead-lettered messages"
+//	@Failure		400		{object}	response.ResponseBase[any]						"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]						"Administrator permission required"
+//	@Failure		500		{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/admin/dead-letter/list [get]
+func (this *deadLetterApi) listDeadLetters(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.DeadLetterListReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	total, messages, err := deadLetterService.ListDeadLetters(ctx.Request().Context(), args.Queue, args.Limit)
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, models.DeadLetterListResp{Total: total, Messages: messages})
+}
+
+// requeueDeadLetters godoc
+//
+//	@Summary		Requeue Dead-Lettered Messages
+//	@Description	Send dead-lettered messages of a queue back to it with their retries reset, those with the given IDs or all of them. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			requeue	body		models.DeadLetterRequeueReq							true	"Messages to requeue, all of them when empty"
+//	@Success		200		{object}	response.ResponseBase[models.DeadLetterCountResp]	"Number of requeued messages"
+//	@Failure		400		{object}	response.ResponseBase[any]							"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]							"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]							"Administrator permission required"
+//	@Failure		500		{object}	response.ResponseBase[any]							"Internal server error"
+//	@Router			/admin/dead-letter/requeue [post]
+func (this *deadLetterApi) requeueDeadLetters(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.DeadLetterRequeueReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	count, err := deadLetterService.RequeueDeadLetters(ctx.Request().Context(), args.Queue, args.MessageIDs)
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, models.DeadLetterCountResp{Count: count})
+}
+
+// purgeDeadLetters godoc
+//
+//	@Summary		Purge Dead-Lettered Messages
+//	@Description	Delete every dead-lettered message of a queue. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			purge	body		models.DeadLetterPurgeReq							true	"Queue to purge, ingestion when empty"
+//	@Success		200		{object}	response.ResponseBase[models.DeadLetterCountResp]	"Number of purged messages"
+//	@Failure		400		{object}	response.ResponseBase[any]							"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]							"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]							"Administrator permission required"
+//	@Failure		500		{object}	response.ResponseBase[any]							"Internal server error"
+//	@Router			/admin/dead-letter/purge [post]
+func (this *deadLetterApi) purgeDeadLetters(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.DeadLetterPurgeReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	count, err := deadLetterService.PurgeDeadLetters(ctx.Request().Context(), args.Queue)
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, models.DeadLetterCountResp{Count: count})
+}
@@ -7,8 +7,18 @@ import (
 
 var Logger = utils.Logger
 var (
-	userService     = service.UserServiceApp
-	fileService     = service.FileServiceApp
-	datasetService  = service.DatasetServiceApp
-	providerService = service.ProviderServiceApp
+	userService       = service.UserServiceApp
+	fileService       = service.FileServiceApp
+	datasetService    = service.DatasetServiceApp
+	providerService   = service.ProviderServiceApp
+	searchService     = service.SearchServiceApp
+	reindexService    = service.ReindexServiceApp
+	chatService       = service.ChatServiceApp
+	apiKeyService     = service.APIKeyServiceApp
+	completionService = service.CompletionServiceApp
+	feedbackService   = service.FeedbackServiceApp
+	usageService      = service.UsageServiceApp
+	quotaService      = service.QuotaServiceApp
+	webhookService    = service.WebhookServiceApp
+	deadLetterService = service.DeadLetterServiceApp
 )
@@ -0,0 +1,169 @@
+package v1
+
+import (
+	"errors"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetFeedbackRouter(e *echo.Echo) {
+	feedbackHandler := &feedbackApi{}
+
+	messageRouterGroup := e.Group(config.API_V1+"/chat/message", middleware.TokenMiddleware())
+	messageRouterGroup.POST("/:message_id/feedback", feedbackHandler.submitFeedback)
+	messageRouterGroup.POST("/:message_id/feedback/delete", feedbackHandler.deleteFeedback)
+
+	datasetRouterGroup := e.Group(config.API_V1+"/dataset", middleware.TokenMiddleware())
+	datasetRouterGroup.GET("/:dataset_id/feedback/report", feedbackHandler.getDatasetFeedbackReport)
+
+	adminRouterGroup := e.Group(config.API_V1+"/admin/feedback", middleware.TokenMiddleware(), middleware.AdminMiddleware())
+	adminRouterGroup.GET("/report", feedbackHandler.getFeedbackReport)
+}
+
+type feedbackApi struct{}
+
+// submitFeedback godoc
+//
+//	@Summary		Submit Answer Feedback
+//	@Description	Rate an answer with a thumbs up (1) or down (-1) and an optional reason. A thumbs down can flag chunks cited by the answer as wrong. Rating an answer again replaces the previous feedback.
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			message_id	path		int											true	"Chat message ID"
+//	@Param			feedback	body		models.FeedbackReq							true	"Feedback"
+//	@Success		200			{object}	response.ResponseBase[models.FeedbackInfo]	"Feedback saved"
+//	@Failure		400			{object}	response.ResponseBase[any]					"Invalid request parameters or flagged chunk not cited"
+//	@Failure		401			{object}	response.ResponseBase[any]					"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]					"Chat message not found"
+//	@Failure		500			{object}	response.ResponseBase[any]					"Internal server error"
+//	@Router			/chat/message/{message_id}/feedback [post]
+func (this *feedbackApi) submitFeedback(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.FeedbackReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch feedback, err := feedbackService.SubmitFeedback(ctx.Request().Context(), args.ID, currentUser.ID, args.Rating, args.Reason, args.WrongChunkIDs); {
+	case err == nil:
+		return response.OkWithData(ctx, feedback)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrChatMessageNotFound()
+	case errors.Is(err, service.ErrWrongChunksOnPositiveFeedback), errors.Is(err, service.ErrChunkNotCited):
+		return response.BadRequestWithMsg(err.Error())
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// deleteFeedback godoc
+//
+//	@Summary		Delete Answer Feedback
+//	@Description	Withdraw the feedback given to an answer
+//	@Tags			Chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			message_id	path		int							true	"Chat message ID"
+//	@Success		200			{object}	response.ResponseBase[any]	"Feedback deleted"
+//	@Failure		400			{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]	"Feedback not found"
+//	@Failure		500			{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/chat/message/{message_id}/feedback/delete [post]
+func (this *feedbackApi) deleteFeedback(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.FeedbackMessageReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := feedbackService.DeleteFeedback(ctx.Request().Context(), args.ID, currentUser.ID); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrFeedbackNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// getDatasetFeedbackReport godoc
+//
+//	@Summary		Get Dataset Feedback Report
+//	@Description	Aggregate the feedback given to answers from a dataset of the authenticated user. Files and chunks cited by answers rated down are ranked by wrong chunk flags, then by negative feedback.
+//	@Tags			Dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			dataset_id	path		int											true	"Dataset ID"
+//	@Param			days		query		int											false	"Only count feedback of the last days"			minimum(1)	maximum(365)
+//	@Param			limit		query		int											false	"Maximum number of files and chunks reported"	minimum(1)	maximum(100)
+//	@Success		200			{object}	response.ResponseBase[models.FeedbackReport]	"Feedback report"
+//	@Failure		400			{object}	response.ResponseBase[any]					"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]					"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]					"Dataset not found"
+//	@Failure		500			{object}	response.ResponseBase[any]					"Internal server error"
+//	@Router			/dataset/{dataset_id}/feedback/report [get]
+func (this *feedbackApi) getDatasetFeedbackReport(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.DatasetFeedbackReportReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch report, err := feedbackService.DatasetFeedbackReport(ctx.Request().Context(), args.DatasetID, currentUser.ID, args.Days, args.Limit); {
+	case err == nil:
+		return response.OkWithData(ctx, report)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrDatasetNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// getFeedbackReport godoc
+//
+//	@Summary		Get Feedback Report
+//	@Description	Aggregate the feedback given to answers across all datasets, or a single one. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			dataset_id	query		int											false	"Dataset ID to report on"
+//	@Param			days		query		int											false	"Only count feedback of the last days"			minimum(1)	maximum(365)
+//	@Param			limit		query		int											false	"Maximum number of files and chunks reported"	minimum(1)	maximum(100)
+//	@Success		200			{object}	response.ResponseBase[models.FeedbackReport]	"Feedback report"
+//	@Failure		400			{object}	response.ResponseBase[any]					"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]					"Invalid or expired token"
+//	@Failure		403			{object}	response.ResponseBase[any]					"Administrator permission required"
+//	@Failure		500			{object}	response.ResponseBase[any]					"Internal server error"
+//	@Router			/admin/feedback/report [get]
+func (this *feedbackApi) getFeedbackReport(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.FeedbackReportReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	report, err := feedbackService.FeedbackReport(ctx.Request().Context(), args.DatasetID, args.Days, args.Limit)
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, report)
+}
@@ -17,7 +17,7 @@ func SetFileRouter(e *echo.Echo) {
 	fileRouterGroup := e.Group(config.API_V1+"/file", middleware.TokenMiddleware())
 
 	fileHandler := &fileApi{}
-	fileRouterGroup.POST("/upload", fileHandler.uploadFile)
+	fileRouterGroup.POST("/upload", fileHandler.uploadFile, middleware.RateLimitMiddleware("upload", config.Settings.GetUploadRateLimit()))
 	fileRouterGroup.GET("/list", fileHandler.ListFiles)
 	fileRouterGroup.GET("/info/:file_id", fileHandler.getSingleDetailedFileInfo)
 	fileRouterGroup.GET("/download/:file_id", fileHandler.getDownloadFileURL)
@@ -39,8 +39,9 @@ type fileApi struct{}
 //	@Success		200		{object}	response.ResponseBase[models.MultiFileUploadResp]	"Files uploaded successfully"
 //	@Failure		400		{object}	response.ResponseBase[any]							"Invalid request parameters or no files provided"
 //	@Failure		401		{object}	response.ResponseBase[any]							"Invalid or expired token"
-//	@Failure		403		{object}	response.ResponseBase[any]							"Dataset not found or access denied"
+//	@Failure		403		{object}	response.ResponseBase[any]							"Storage or file quota exceeded"
 //	@Failure		404		{object}	response.ResponseBase[any]							"Dataset not found"
+//	@Failure		429		{object}	response.ResponseBase[any]							"Too many requests, see the Retry-After header"
 //	@Failure		500		{object}	response.ResponseBase[any]							"Internal server error"
 //	@Router			/file/upload [post]
 func (this *fileApi) uploadFile(ctx *echo.Context) error {
@@ -76,16 +77,26 @@ func (this *fileApi) uploadFile(ctx *echo.Context) error {
 
 	Logger.Infof("Received %d files for upload", fileNumber)
 
-	uploadedFiles, errors := fileService.UploadFile(ctx.Request().Context(), fileHeaders, fileNumber, currentUser.ID, datasetID)
+	// The whole upload is rejected when the files exceed the quota
+	dbFiles, err := fileService.ReserveFiles(ctx.Request().Context(), fileHeaders, currentUser.ID, datasetID)
+	switch {
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return response.ErrQuotaExceeded(err.Error())
+	case err != nil:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+
+	uploadedFiles, errs := fileService.UploadFile(ctx.Request().Context(), fileHeaders, dbFiles)
 	// If all files failed, return error
-	if len(uploadedFiles) == 0 && len(errors) > 0 {
-		Logger.Errorf("All file uploads failed: %v", errors)
+	if len(uploadedFiles) == 0 && len(errs) > 0 {
+		Logger.Errorf("All file uploads failed: %v", errs)
 		return response.ErrUnknownError()
 	}
 
 	// If some files failed, log warnings but return success for successful uploads
-	if len(errors) > 0 {
-		Logger.Warnf("Some file uploads failed: %v", errors)
+	if len(errs) > 0 {
+		Logger.Warnf("Some file uploads failed: %v", errs)
 	}
 
 	Logger.Infof("Successfully uploaded %d out of %d files", len(uploadedFiles), len(fileHeaders))
@@ -0,0 +1,229 @@
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+	"time"
+
+	"github.com/labstack/echo/v5"
+)
+
+// SetOpenAIRouter registers the OpenAI-compatible API, so OpenAI SDK clients can chat with datasets.
+// Requests and responses follow the OpenAI API reference instead of this API's response envelope,
+// hence these routes are left out of the swagger docs.
+func SetOpenAIRouter(e *echo.Echo) {
+	openAIRouterGroup := e.Group(config.OPENAI_V1, middleware.APIKeyMiddleware())
+	openAIHandler := &openAIApi{}
+	openAIRouterGroup.GET("/models", openAIHandler.listModels)
+	openAIRouterGroup.POST("/chat/completions", openAIHandler.createChatCompletion, middleware.OpenAIRateLimitMiddleware("chat", config.Settings.GetChatRateLimit()))
+}
+
+type openAIApi struct{}
+
+// listModels lists every dataset and chat model combination usable as the model of a chat completion
+func (this *openAIApi) listModels(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.OpenAIFail(ctx, http.StatusUnauthorized, models.OPENAI_ERROR_AUTHENTICATION, "invalid_api_key", err.Error())
+	}
+
+	completionModels, err := completionService.ListCompletionModels(ctx.Request().Context(), currentUser.ID)
+	if err != nil {
+		Logger.Error(err)
+		return response.OpenAIFail(ctx, http.StatusInternalServerError, models.OPENAI_ERROR_SERVER, "", "Failed to list models")
+	}
+	return ctx.JSON(http.StatusOK, models.OpenAIModelList{
+		Object: models.OPENAI_OBJECT_LIST,
+		Data:   completionModels,
+	})
+}
+
+// createChatCompletion answers a conversation from the dataset selected by the model.
+// The passages given to the model are returned in the "citations" extension field and the provider
+// that answered, a fallback one if the chosen provider failed, in "served_by"; both in the first chunk when streaming.
+func (this *openAIApi) createChatCompletion(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.OpenAIFail(ctx, http.StatusUnauthorized, models.OPENAI_ERROR_AUTHENTICATION, "invalid_api_key", err.Error())
+	}
+	args, err := utils.BindAndValidate[models.OpenAIChatCompletionReq](ctx)
+	if err != nil {
+		return response.OpenAIFail(ctx, http.StatusBadRequest, models.OPENAI_ERROR_INVALID_REQUEST, "", err.Error())
+	}
+
+	reqCtx := ctx.Request().Context()
+	completion, err := completionService.PrepareChatCompletion(reqCtx, currentUser.ID, *args)
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrCompletionModelInvalid), errors.Is(err, service.ErrNotFound), errors.Is(err, service.ErrModelNotGranted):
+		return response.OpenAIFail(ctx, http.StatusNotFound, models.OPENAI_ERROR_INVALID_REQUEST, "model_not_found",
+			fmt.Sprintf("The model `%s` does not exist or you do not have access to it.", args.Model))
+	case errors.Is(err, service.ErrNoUserMessage):
+		return response.OpenAIFail(ctx, http.StatusBadRequest, models.OPENAI_ERROR_INVALID_REQUEST, "", err.Error())
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return response.OpenAIFail(ctx, http.StatusTooManyRequests, models.OPENAI_ERROR_QUOTA, models.OPENAI_ERROR_QUOTA, err.Error())
+	case errors.Is(err, service.ErrVectorStoreUnavailable):
+		Logger.Error(err)
+		return response.OpenAIFail(ctx, http.StatusServiceUnavailable, models.OPENAI_ERROR_SERVER, "", err.Error())
+	default:
+		Logger.Error(err)
+		return response.OpenAIFail(ctx, http.StatusInternalServerError, models.OPENAI_ERROR_SERVER, "", "Failed to retrieve passages from the dataset")
+	}
+
+	snowID, err := utils.GenerateSnowID()
+	if err != nil {
+		Logger.Error(err)
+		return response.OpenAIFail(ctx, http.StatusInternalServerError, models.OPENAI_ERROR_SERVER, "", "Unknown error")
+	}
+	stream := &completionStream{
+		writer:  ctx.Response(),
+		id:      fmt.Sprintf("chatcmpl-%d", snowID),
+		created: time.Now().Unix(),
+		model:   args.Model,
+	}
+
+	if !args.Stream {
+		result, err := completion.Stream(reqCtx, func(string) error { return nil })
+		if err != nil {
+			return this.completionFailed(ctx, err)
+		}
+		return ctx.JSON(http.StatusOK, models.OpenAIChatCompletionResp{
+			ID:      stream.id,
+			Object:  models.OPENAI_OBJECT_CHAT_COMPLETION,
+			Created: stream.created,
+			Model:   stream.model,
+			Choices: []models.OpenAIChatCompletionChoice{{
+				Message:      models.OpenAIResponseMessage{Role: models.LLM_ROLE_ASSISTANT, Content: result.Content},
+				FinishReason: result.FinishReason,
+			}},
+			Usage:     completionUsage(result),
+			Citations: completion.Citations,
+			ServedBy:  completion.ServedBy,
+		})
+	}
+
+	// The stream starts with the first token, so failures before it are still plain HTTP errors
+	start := func() error {
+		if stream.started {
+			return nil
+		}
+		first := stream.chunk(models.OpenAIChunkDelta{Role: models.LLM_ROLE_ASSISTANT}, nil)
+		first.Citations, first.ServedBy = completion.Citations, completion.ServedBy
+		return stream.write(first)
+	}
+	result, err := completion.Stream(reqCtx, func(delta string) error {
+		if err := start(); err != nil {
+			return err
+		}
+		return stream.send(models.OpenAIChunkDelta{Content: delta}, nil)
+	})
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Client went away, nothing left to tell
+		return nil
+	case err != nil && !stream.started:
+		return this.completionFailed(ctx, err)
+	case err != nil:
+		Logger.Errorf("Chat completion stream %s failed: %v", stream.id, err)
+		_ = stream.write(models.OpenAIErrorResp{Error: models.OpenAIError{
+			Message: "The model failed to complete the answer",
+			Type:    models.OPENAI_ERROR_UPSTREAM,
+		}})
+		return nil
+	}
+
+	if err := start(); err != nil {
+		return nil
+	}
+	if err := stream.send(models.OpenAIChunkDelta{}, &result.FinishReason); err != nil {
+		return nil
+	}
+	if args.StreamOptions != nil && args.StreamOptions.IncludeUsage {
+		usage := completionUsage(result)
+		if err := stream.write(models.OpenAIChatCompletionChunk{
+			ID:      stream.id,
+			Object:  models.OPENAI_OBJECT_CHAT_COMPLETION_CHUNK,
+			Created: stream.created,
+			Model:   stream.model,
+			Choices: []models.OpenAIChatCompletionChunkChoice{},
+			Usage:   &usage,
+		}); err != nil {
+			return nil
+		}
+	}
+	_ = stream.done()
+	return nil
+}
+
+func (this *openAIApi) completionFailed(ctx *echo.Context, err error) error {
+	if errors.Is(err, service.ErrLLMRequestFailed) {
+		Logger.Warn(err)
+		return response.OpenAIFail(ctx, http.StatusBadGateway, models.OPENAI_ERROR_UPSTREAM, "", err.Error())
+	}
+	Logger.Error(err)
+	return response.OpenAIFail(ctx, http.StatusInternalServerError, models.OPENAI_ERROR_SERVER, "", "Unknown error")
+}
+
+func completionUsage(result *models.LLMResult) models.OpenAIUsage {
+	return models.OpenAIUsage{
+		PromptTokens:     result.PromptTokens,
+		CompletionTokens: result.CompletionTokens,
+		TotalTokens:      result.PromptTokens + result.CompletionTokens,
+	}
+}
+
+// completionStream writes chat completion chunks as data-only server-sent events, as the OpenAI API does
+type completionStream struct {
+	writer  http.ResponseWriter
+	started bool
+	id      string
+	created int64
+	model   string
+}
+
+func (this *completionStream) send(delta models.OpenAIChunkDelta, finishReason *string) error {
+	return this.write(this.chunk(delta, finishReason))
+}
+
+func (this *completionStream) chunk(delta models.OpenAIChunkDelta, finishReason *string) models.OpenAIChatCompletionChunk {
+	return models.OpenAIChatCompletionChunk{
+		ID:      this.id,
+		Object:  models.OPENAI_OBJECT_CHAT_COMPLETION_CHUNK,
+		Created: this.created,
+		Model:   this.model,
+		Choices: []models.OpenAIChatCompletionChunkChoice{{Delta: delta, FinishReason: finishReason}},
+	}
+}
+
+func (this *completionStream) write(data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return this.writeData(payload)
+}
+
+// done ends the stream the way OpenAI SDK clients expect
+func (this *completionStream) done() error {
+	return this.writeData([]byte("[DONE]"))
+}
+
+func (this *completionStream) writeData(payload []byte) error {
+	if !this.started {
+		this.started = true
+		writeEventStreamHeader(this.writer)
+	}
+	if _, err := fmt.Fprintf(this.writer, "data: %s\n\n", payload); err != nil {
+		return err
+	}
+	return http.NewResponseController(this.writer).Flush()
+}
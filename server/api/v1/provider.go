@@ -21,6 +21,7 @@ func SetProviderRouter(e *echo.Echo) {
 	providerRouterGroup.GET("/models/:provider_id", providerHandler.listModels)
 	providerRouterGroup.POST("/update", providerHandler.updateProviderInfo)
 	providerRouterGroup.POST("/delete/:provider_id", providerHandler.deleteProvider)
+	providerRouterGroup.POST("/test", providerHandler.testProvider)
 }
 
 type providerApi struct{}
@@ -28,15 +29,18 @@ type providerApi struct{}
 // createProvider godoc
 //
 //	@Summary		Create Provider
-//	@Description	Create a new provider
+//	@Description	Create a new provider. azure_openai takes an api_version and optional deployments, openai_compatible an optional path_prefix.
+//	@Description	Every mode accepts extra headers, a proxy, a CA bundle, a timeout and a number of retries applied to all calls this server makes to the provider.
+//	@Description	Session chat is answered by the RAG backend, which reaches the provider with its base URL and API key only and ignores these settings.
+//	@Description	The base URL and proxy of a user's provider must resolve to public addresses or OUTBOUND_ALLOWED_NETWORKS, administrators' providers may reach any address.
 //	@Tags			Provider
 //	@Accept			json
 //	@Produce		json
 //	@Param			body	body		models.ProviderCreateReq	true	"Create Provider Request Body"
 //	@Success		200		{object}	response.ResponseBase[any]	"Provider created successfully"
-//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters or address not public"
 //	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
-//	@Failure		403		{object}	response.ResponseBase[any]	"Provider name already exists"
+//	@Failure		403		{object}	response.ResponseBase[any]	"Provider name already exists or provider quota exceeded"
 //	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
 //	@Router			/provider [post]
 func (this *providerApi) createProvider(ctx *echo.Context) error {
@@ -58,11 +62,17 @@ func (this *providerApi) createProvider(ctx *echo.Context) error {
 		return response.ErrProviderNameAlreadyExists()
 	}
 
-	if err := providerService.CreateProvider(ctx.Request().Context(), currentUser.ID, args.Name, args.BaseURL, args.APIKey, args.Mode); err != nil {
+	switch err := providerService.CreateProvider(ctx.Request().Context(), currentUser.ID, args.Name, args.BaseURL, args.APIKey, args.Mode, args.ProviderSettings); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrInvalidCACert), errors.Is(err, service.ErrProviderAddressRefused):
+		return response.BadRequestWithMsg(err.Error())
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return response.ErrQuotaExceeded(err.Error())
+	default:
 		Logger.Error(err)
 		return response.ErrUnknownError()
 	}
-	return response.Ok(ctx)
 }
 
 // getAllProviders godoc
@@ -134,13 +144,13 @@ func (this *providerApi) getProviderInfoByID(ctx *echo.Context) error {
 // updateProviderInfo godoc
 //
 //	@Summary		Update Provider
-//	@Description	Update an existing provider
+//	@Description	Update an existing provider. The API key and the mode settings are replaced by the ones sent.
 //	@Tags			Provider
 //	@Accept			json
 //	@Produce		json
 //	@Param			body	body		models.ProviderUpdateReq	true	"Update Provider Request Body"
 //	@Success		200		{object}	response.ResponseBase[any]	"Provider updated successfully"
-//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters or address not public"
 //	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
 //	@Failure		403		{object}	response.ResponseBase[any]	"Provider name already exists"
 //	@Failure		404		{object}	response.ResponseBase[any]	"Provider not found"
@@ -165,13 +175,16 @@ func (this *providerApi) updateProviderInfo(ctx *echo.Context) error {
 		args.Name,
 		args.BaseURL,
 		args.APIKey,
-		args.Mode); {
+		args.Mode,
+		args.ProviderSettings); {
 	case err == nil:
 		return response.Ok(ctx)
 	case errors.Is(err, service.ErrNotFound):
 		return response.ErrProviderNotFound()
 	case errors.Is(err, service.ErrDuplicatedKey):
 		return response.ErrProviderNameAlreadyExists()
+	case errors.Is(err, service.ErrInvalidCACert), errors.Is(err, service.ErrProviderAddressRefused):
+		return response.BadRequestWithMsg(err.Error())
 	default:
 		Logger.Error(err)
 		return response.ErrUnknownError()
@@ -218,11 +231,14 @@ func (this *providerApi) deleteProvider(ctx *echo.Context) error {
 // listModels godoc
 //
 //	@Summary		List Models
-//	@Description	Get available models from a provider
+//	@Description	Get the model catalog of a provider: each model with its types (chat, embedding, rerank, vision), embedding dimension and context length.
+//	@Description	The catalog is cached, refresh lists the models from the provider again. Listing calls no model:
+//	@Description	a dimension the provider does not tell is learned once the model is picked for a dataset.
 //	@Tags			Provider
 //	@Accept			json
 //	@Produce		json
 //	@Param			provider_id	path		int													true	"Provider ID"
+//	@Param			refresh		query		bool												false	"Bypass the cached catalog"
 //	@Success		200			{object}	response.ResponseBase[models.ProviderModelsResp]	"Models retrieved successfully"
 //	@Failure		400			{object}	response.ResponseBase[any]							"Invalid request parameters"
 //	@Failure		401			{object}	response.ResponseBase[any]							"Invalid or expired token"
@@ -240,7 +256,7 @@ func (this *providerApi) listModels(ctx *echo.Context) error {
 		return response.BadRequestWithMsg(err.Error())
 	}
 
-	modelsResp, err := providerService.ListModels(ctx.Request().Context(), args.ID, currentUser.ID)
+	modelsResp, err := providerService.ListModels(ctx.Request().Context(), args.ID, currentUser.ID, args.Refresh)
 	switch err {
 	case nil:
 		return response.OkWithData(ctx, modelsResp)
@@ -251,3 +267,60 @@ func (this *providerApi) listModels(ctx *echo.Context) error {
 		return response.ErrUnknownError()
 	}
 }
+
+// testProvider godoc
+//
+//	@Summary		Test Provider
+//	@Description	Test a saved provider (id), or provider settings before saving them (base_url, api_key, mode).
+//	@Description	Lists the models, then embeds and completes a tiny text, reporting success, latency and a normalized error per capability.
+//	@Description	The embedding and chat models are picked from the listed models unless given.
+//	@Description	Unsaved settings may only reach public addresses, besides the networks of OUTBOUND_ALLOWED_NETWORKS.
+//	@Tags			Provider
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.ProviderTestReq							true	"Provider to test"
+//	@Success		200		{object}	response.ResponseBase[models.ProviderTestResp]	"Probe results, failed probes included"
+//	@Failure		400		{object}	response.ResponseBase[any]						"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		404		{object}	response.ResponseBase[any]						"Provider not found"
+//	@Failure		500		{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/provider/test [post]
+func (this *providerApi) testProvider(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ProviderTestReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch result, err := providerService.TestProvider(ctx.Request().Context(), currentUser.ID, *args); {
+	case err == nil:
+		return response.OkWithData(ctx, result)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrProviderNotFound()
+	case errors.Is(err, service.ErrInvalidCACert), errors.Is(err, service.ErrProviderAddressRefused):
+		return response.BadRequestWithMsg(err.Error())
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// checkProviderModel verifies that the provider belongs or is granted to the user, and that the user may use the model
+func checkProviderModel(ctx *echo.Context, userID uint, providerID uint, model string) error {
+	if belongs, err := providerService.CheckProviderOwnership(ctx.Request().Context(), providerID, userID); err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	} else if !belongs {
+		return response.ErrProviderNotOwned()
+	}
+	if allowed, err := providerService.CheckProviderModel(ctx.Request().Context(), providerID, userID, model); err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	} else if !allowed {
+		return response.ErrModelNotGranted()
+	}
+	return nil
+}
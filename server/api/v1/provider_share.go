@@ -0,0 +1,160 @@
+package v1
+
+import (
+	"errors"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetSharedProviderRouter(e *echo.Echo) {
+	sharedProviderHandler := &sharedProviderApi{}
+
+	sharedProviderRouterGroup := e.Group(config.API_V1+"/admin/provider", middleware.TokenMiddleware(), middleware.AdminMiddleware())
+	sharedProviderRouterGroup.POST("/create", sharedProviderHandler.createSharedProvider)
+	sharedProviderRouterGroup.GET("/list", sharedProviderHandler.listSharedProviders)
+	sharedProviderRouterGroup.POST("/grant", sharedProviderHandler.grantProvider)
+	sharedProviderRouterGroup.POST("/revoke", sharedProviderHandler.revokeProvider)
+}
+
+type sharedProviderApi struct{}
+
+// createSharedProvider godoc
+//
+//	@Summary		Create Shared Provider
+//	@Description	Create a provider owned by the administrator that users may use once granted, without ever seeing its key or connection settings.
+//	@Description	It is updated and deleted like the administrator's other providers, and does not count against their provider quota. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.ProviderCreateReq					true	"Shared provider creation request"
+//	@Success		200		{object}	response.ResponseBase[models.ProviderInfo]	"Shared provider created successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]					"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]					"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]					"Administrator permission required or provider name already exists"
+//	@Failure		500		{object}	response.ResponseBase[any]					"Internal server error"
+//	@Router			/admin/provider/create [post]
+func (this *sharedProviderApi) createSharedProvider(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ProviderCreateReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	if exist, err := providerService.CheckProviderExistsByName(ctx.Request().Context(), currentUser.ID, args.Name); err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	} else if exist {
+		return response.ErrProviderNameAlreadyExists()
+	}
+
+	switch provider, err := providerService.CreateSharedProvider(ctx.Request().Context(), currentUser.ID, args.Name, args.BaseURL, args.APIKey, args.Mode, args.ProviderSettings); {
+	case err == nil:
+		return response.OkWithData(ctx, provider)
+	case errors.Is(err, service.ErrInvalidCACert):
+		return response.BadRequestWithMsg(err.Error())
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// listSharedProviders godoc
+//
+//	@Summary		List Shared Providers
+//	@Description	Get every shared provider with the users it is granted to and their models. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	response.ResponseBase[models.SharedProviderListResp]	"Shared providers retrieved successfully"
+//	@Failure		401	{object}	response.ResponseBase[any]								"Invalid or expired token"
+//	@Failure		403	{object}	response.ResponseBase[any]								"Administrator permission required"
+//	@Failure		500	{object}	response.ResponseBase[any]								"Internal server error"
+//	@Router			/admin/provider/list [get]
+func (this *sharedProviderApi) listSharedProviders(ctx *echo.Context) error {
+	total, providers, err := providerService.ListSharedProviders(ctx.Request().Context())
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, models.SharedProviderListResp{
+		Total:     total,
+		Providers: providers,
+	})
+}
+
+// grantProvider godoc
+//
+//	@Summary		Grant Shared Provider
+//	@Description	Let a user, or every user when user_id is 0, use a shared provider with the given models, all of them when empty.
+//	@Description	Granting the same user again replaces the models. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			grant	body		models.ProviderGrantReq		true	"Grant request"
+//	@Success		200		{object}	response.ResponseBase[any]	"Provider granted successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]	"Administrator permission required"
+//	@Failure		404		{object}	response.ResponseBase[any]	"Shared provider or user not found"
+//	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/admin/provider/grant [post]
+func (this *sharedProviderApi) grantProvider(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.ProviderGrantReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := providerService.GrantProvider(ctx.Request().Context(), args.ProviderID, args.UserID, args.Models); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrUserNotFound):
+		return response.ErrUserNotFound()
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrProviderNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// revokeProvider godoc
+//
+//	@Summary		Revoke Shared Provider
+//	@Description	Take back the grant of a shared provider to a user, or the grant to every user when user_id is 0.
+//	@Description	Datasets of the user built on the provider stop working until it is granted again. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			grant	body		models.ProviderRevokeReq	true	"Revoke request"
+//	@Success		200		{object}	response.ResponseBase[any]	"Grant revoked successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]	"Administrator permission required"
+//	@Failure		404		{object}	response.ResponseBase[any]	"Grant not found"
+//	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/admin/provider/revoke [post]
+func (this *sharedProviderApi) revokeProvider(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.ProviderRevokeReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := providerService.RevokeProvider(ctx.Request().Context(), args.ProviderID, args.UserID); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrProviderGrantNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
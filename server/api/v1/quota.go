@@ -0,0 +1,216 @@
+package v1
+
+import (
+	"errors"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetQuotaRouter(e *echo.Echo) {
+	quotaHandler := &quotaApi{}
+
+	quotaRouterGroup := e.Group(config.API_V1+"/admin/quota", middleware.TokenMiddleware(), middleware.AdminMiddleware())
+	quotaRouterGroup.POST("/plan/create", quotaHandler.createPlan)
+	quotaRouterGroup.GET("/plan/list", quotaHandler.listPlans)
+	quotaRouterGroup.POST("/plan/update", quotaHandler.updatePlan)
+	quotaRouterGroup.POST("/plan/delete/:plan_id", quotaHandler.deletePlan)
+	quotaRouterGroup.POST("/user/assign", quotaHandler.assignPlan)
+	quotaRouterGroup.GET("/user/:user_id", quotaHandler.getUserUsage)
+}
+
+type quotaApi struct{}
+
+// createPlan godoc
+//
+//	@Summary		Create Quota Plan
+//	@Description	Create a plan limiting the storage, files per dataset, datasets, providers and monthly tokens of its users, 0 meaning unlimited.
+//	@Description	Users assigned no plan are limited by the default plan; creating a default plan replaces the previous one. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			plan	body		models.QuotaPlanReq							true	"Quota plan creation request"
+//	@Success		200		{object}	response.ResponseBase[models.QuotaPlanInfo]	"Quota plan created successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]					"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]					"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]					"Administrator permission required or plan name already exists"
+//	@Failure		500		{object}	response.ResponseBase[any]					"Internal server error"
+//	@Router			/admin/quota/plan/create [post]
+func (this *quotaApi) createPlan(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.QuotaPlanReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch plan, err := quotaService.CreatePlan(ctx.Request().Context(), *args); {
+	case err == nil:
+		return response.OkWithData(ctx, plan)
+	case errors.Is(err, service.ErrDuplicatedKey):
+		return response.ErrQuotaPlanNameAlreadyExists()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// listPlans godoc
+//
+//	@Summary		List Quota Plans
+//	@Description	Get all quota plans. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	response.ResponseBase[models.QuotaPlanListResp]	"Quota plans retrieved successfully"
+//	@Failure		401	{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		403	{object}	response.ResponseBase[any]						"Administrator permission required"
+//	@Failure		500	{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/admin/quota/plan/list [get]
+func (this *quotaApi) listPlans(ctx *echo.Context) error {
+	total, plans, err := quotaService.ListPlans(ctx.Request().Context())
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, models.QuotaPlanListResp{
+		Total: total,
+		Plans: plans,
+	})
+}
+
+// updatePlan godoc
+//
+//	@Summary		Update Quota Plan
+//	@Description	Change the name and limits of a quota plan. The new limits apply to its users from their next request,
+//	@Description	resources already over a lowered limit are kept. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			plan	body		models.QuotaPlanUpdateReq	true	"Quota plan update request"
+//	@Success		200		{object}	response.ResponseBase[any]	"Quota plan updated successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]	"Administrator permission required or plan name already exists"
+//	@Failure		404		{object}	response.ResponseBase[any]	"Quota plan not found"
+//	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/admin/quota/plan/update [post]
+func (this *quotaApi) updatePlan(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.QuotaPlanUpdateReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := quotaService.UpdatePlan(ctx.Request().Context(), args.ID, args.QuotaPlanReq); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrQuotaPlanNotFound()
+	case errors.Is(err, service.ErrDuplicatedKey):
+		return response.ErrQuotaPlanNameAlreadyExists()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// deletePlan godoc
+//
+//	@Summary		Delete Quota Plan
+//	@Description	Delete a quota plan, its users fall back to the default plan. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			plan_id	path		int							true	"Quota plan ID"
+//	@Success		200		{object}	response.ResponseBase[any]	"Quota plan deleted successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]	"Administrator permission required"
+//	@Failure		404		{object}	response.ResponseBase[any]	"Quota plan not found"
+//	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/admin/quota/plan/delete/{plan_id} [post]
+func (this *quotaApi) deletePlan(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.QuotaPlanIDReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := quotaService.DeletePlan(ctx.Request().Context(), args.ID); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrQuotaPlanNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// assignPlan godoc
+//
+//	@Summary		Assign Quota Plan
+//	@Description	Put a user on a quota plan, or back on the default plan with a plan_id of 0. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			assignment	body		models.QuotaAssignReq		true	"Quota plan assignment request"
+//	@Success		200			{object}	response.ResponseBase[any]	"Quota plan assigned successfully"
+//	@Failure		400			{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		403			{object}	response.ResponseBase[any]	"Administrator permission required"
+//	@Failure		404			{object}	response.ResponseBase[any]	"User or quota plan not found"
+//	@Failure		500			{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/admin/quota/user/assign [post]
+func (this *quotaApi) assignPlan(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.QuotaAssignReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := quotaService.AssignPlan(ctx.Request().Context(), args.UserID, args.PlanID); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrQuotaPlanNotFound):
+		return response.ErrQuotaPlanNotFound()
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrUserNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// getUserUsage godoc
+//
+//	@Summary		Get Quota Usage of a User
+//	@Description	Get the storage, datasets, providers, files per dataset and monthly tokens used by a user against the limits of their quota plan. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			user_id	path		int											true	"User ID"
+//	@Success		200		{object}	response.ResponseBase[models.QuotaUsage]	"Quota usage"
+//	@Failure		400		{object}	response.ResponseBase[any]					"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]					"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]					"Administrator permission required"
+//	@Failure		404		{object}	response.ResponseBase[any]					"User not found"
+//	@Failure		500		{object}	response.ResponseBase[any]					"Internal server error"
+//	@Router			/admin/quota/user/{user_id} [get]
+func (this *quotaApi) getUserUsage(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.QuotaUserReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch usage, err := quotaService.GetUsage(ctx.Request().Context(), args.UserID); {
+	case err == nil:
+		return response.OkWithData(ctx, usage)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrUserNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
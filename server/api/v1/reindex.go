@@ -0,0 +1,92 @@
+package v1
+
+import (
+	"errors"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetReindexRouter(e *echo.Echo) {
+	reindexRouterGroup := e.Group(config.API_V1+"/dataset", middleware.TokenMiddleware())
+	reindexHandler := &reindexApi{}
+	reindexRouterGroup.GET("/:dataset_id/reindex", reindexHandler.getReindexJob)
+	reindexRouterGroup.POST("/:dataset_id/reindex/cancel", reindexHandler.cancelReindexJob)
+}
+
+type reindexApi struct{}
+
+// getReindexJob godoc
+//
+//	@Summary		Get Reindex Job
+//	@Description	Get the progress of the latest reindex job of a dataset, started by changing its embedding model or provider
+//	@Tags			Dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			dataset_id	path		int												true	"Dataset ID"
+//	@Success		200			{object}	response.ResponseBase[models.ReindexJobInfo]	"Reindex job progress"
+//	@Failure		400			{object}	response.ResponseBase[any]						"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]						"Reindex job not found"
+//	@Failure		500			{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/dataset/{dataset_id}/reindex [get]
+func (this *reindexApi) getReindexJob(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ReindexJobReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch job, err := reindexService.GetLatestReindexJob(ctx.Request().Context(), args.ID, currentUser.ID); {
+	case err == nil:
+		return response.OkWithData(ctx, job)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrReindexJobNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// cancelReindexJob godoc
+//
+//	@Summary		Cancel Reindex Job
+//	@Description	Cancel the running reindex job of a dataset. The dataset keeps its current embedding model and vectors.
+//	@Tags			Dataset
+//	@Accept			json
+//	@Produce		json
+//	@Param			dataset_id	path		int												true	"Dataset ID"
+//	@Success		200			{object}	response.ResponseBase[models.ReindexJobInfo]	"Reindex job cancelled"
+//	@Failure		400			{object}	response.ResponseBase[any]						"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]						"No running reindex job"
+//	@Failure		500			{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/dataset/{dataset_id}/reindex/cancel [post]
+func (this *reindexApi) cancelReindexJob(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.ReindexJobReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch job, err := reindexService.CancelReindexJob(ctx.Request().Context(), args.ID, currentUser.ID); {
+	case err == nil:
+		return response.OkWithData(ctx, job)
+	case errors.Is(err, service.ErrNotFound), errors.Is(err, service.ErrReindexJobNotRunning):
+		return response.ErrReindexJobNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
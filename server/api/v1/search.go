@@ -0,0 +1,116 @@
+package v1
+
+import (
+	"errors"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetSearchRouter(e *echo.Echo) {
+	searchRouterGroup := e.Group(config.API_V1+"/dataset", middleware.TokenMiddleware())
+	searchHandler := &searchApi{}
+	searchRouterGroup.POST("/:dataset_id/search", searchHandler.searchDataset)
+
+	crossSearchRouterGroup := e.Group(config.API_V1+"/search", middleware.TokenMiddleware())
+	crossSearchRouterGroup.POST("", searchHandler.searchDatasets)
+}
+
+type searchApi struct{}
+
+// searchDataset godoc
+//
+//	@Summary		Search Dataset
+//	@Description	Semantic search in a dataset. The query is embedded with the dataset's provider and embedding model, then searched in Milvus according to the dataset's search type (dense, sparse or hybrid). Keyword datasets use PostgreSQL full-text search and return highlighted snippets.
+//	@Tags			Search
+//	@Accept			json
+//	@Produce		json
+//	@Param			dataset_id	path		int										true	"Dataset ID"
+//	@Param			body		body		models.DatasetSearchReq					true	"Search Request Body"
+//	@Success		200			{object}	response.ResponseBase[models.SearchResp]	"Ranked passages with source file info"
+//	@Failure		400			{object}	response.ResponseBase[any]				"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]				"Invalid or expired token"
+//	@Failure		403			{object}	response.ResponseBase[any]				"Monthly tokens used up or shared provider no longer granted"
+//	@Failure		404			{object}	response.ResponseBase[any]				"Dataset not found"
+//	@Failure		500			{object}	response.ResponseBase[any]				"Internal server error"
+//	@Failure		503			{object}	response.ResponseBase[any]				"Vector store unavailable"
+//	@Router			/dataset/{dataset_id}/search [post]
+func (this *searchApi) searchDataset(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+
+	args, err := utils.BindAndValidate[models.DatasetSearchReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch results, err := searchService.SearchDataset(ctx.Request().Context(), args.ID, currentUser.ID, args.Query, args.TopK); {
+	case err == nil:
+		return response.OkWithData(ctx, models.SearchResp{
+			Total:   len(results),
+			Results: results,
+		})
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrDatasetNotFound()
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return response.ErrQuotaExceeded(err.Error())
+	case errors.Is(err, service.ErrModelNotGranted):
+		return response.ErrModelNotGranted()
+	case errors.Is(err, service.ErrVectorStoreUnavailable):
+		return response.ErrVectorStoreUnavailable()
+	default:
+		Logger.Errorf("Failed to search dataset %d: %v", args.ID, err)
+		return response.ErrUnknownError()
+	}
+}
+
+// searchDatasets godoc
+//
+//	@Summary		Search Across Datasets
+//	@Description	Search several or all datasets of the authenticated user at once. The query is embedded once per distinct embedding model and the results are merged with reciprocal rank fusion. Results can be restricted by dataset tags and file MIME types.
+//	@Tags			Search
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		models.CrossDatasetSearchReq				true	"Search Request Body"
+//	@Success		200		{object}	response.ResponseBase[models.SearchResp]	"Ranked passages labeled with their dataset"
+//	@Failure		400		{object}	response.ResponseBase[any]					"Invalid request parameters"
+//	@Failure		401		{object}	response.ResponseBase[any]					"Invalid or expired token"
+//	@Failure		403		{object}	response.ResponseBase[any]					"Monthly tokens used up or shared provider no longer granted"
+//	@Failure		500		{object}	response.ResponseBase[any]					"Internal server error"
+//	@Failure		503		{object}	response.ResponseBase[any]					"Vector store unavailable"
+//	@Router			/search [post]
+func (this *searchApi) searchDatasets(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+
+	args, err := utils.BindAndValidate[models.CrossDatasetSearchReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch results, err := searchService.SearchDatasets(ctx.Request().Context(), currentUser.ID, args.DatasetIDs, args.Tags, args.FileTypes, args.Query, args.TopK); {
+	case err == nil:
+		return response.OkWithData(ctx, models.SearchResp{
+			Total:   len(results),
+			Results: results,
+		})
+	case errors.Is(err, service.ErrQuotaExceeded):
+		return response.ErrQuotaExceeded(err.Error())
+	case errors.Is(err, service.ErrModelNotGranted):
+		return response.ErrModelNotGranted()
+	case errors.Is(err, service.ErrVectorStoreUnavailable):
+		return response.ErrVectorStoreUnavailable()
+	default:
+		Logger.Errorf("Failed to search datasets for user %d: %v", currentUser.ID, err)
+		return response.ErrUnknownError()
+	}
+}
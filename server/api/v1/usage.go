@@ -0,0 +1,152 @@
+package v1
+
+import (
+	"fmt"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+	"time"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetUsageRouter(e *echo.Echo) {
+	usageHandler := &usageApi{}
+
+	usageRouterGroup := e.Group(config.API_V1+"/usage", middleware.TokenMiddleware())
+	usageRouterGroup.GET("/report", usageHandler.getUsageReport)
+
+	adminRouterGroup := e.Group(config.API_V1+"/admin/usage", middleware.TokenMiddleware(), middleware.AdminMiddleware())
+	adminRouterGroup.GET("/report", usageHandler.getAdminUsageReport)
+	adminRouterGroup.GET("/export", usageHandler.exportUsage)
+}
+
+type usageApi struct{}
+
+// getUsageReport godoc
+//
+//	@Summary		Get Usage Report
+//	@Description	Aggregate the embedding, chat and rerank calls made to providers for the authenticated user, with their tokens and estimated cost in USD
+//	@Tags			Usage
+//	@Accept			json
+//	@Produce		json
+//	@Param			group_by	query		string									true	"Grouping of the calls"					Enums(day, dataset, provider)
+//	@Param			days		query		int										false	"Period of the report, 30 days by default"	minimum(1)	maximum(365)
+//	@Param			dataset_id	query		int										false	"Only count calls made for a dataset"
+//	@Param			provider_id	query		int										false	"Only count calls made to a provider"
+//	@Param			call_type	query		string									false	"Only count calls of a type"				Enums(embedding, chat, rerank)
+//	@Success		200			{object}	response.ResponseBase[models.UsageReport]	"Usage report"
+//	@Failure		400			{object}	response.ResponseBase[any]				"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]				"Invalid or expired token"
+//	@Failure		500			{object}	response.ResponseBase[any]				"Internal server error"
+//	@Router			/usage/report [get]
+func (this *usageApi) getUsageReport(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.UsageReportReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	report, err := usageService.Report(ctx.Request().Context(), args.GroupBy, models.UsageFilter{
+		Since:      service.UsageSince(args.Days),
+		UserID:     currentUser.ID,
+		DatasetID:  args.DatasetID,
+		ProviderID: args.ProviderID,
+		CallType:   args.CallType,
+	})
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, report)
+}
+
+// getAdminUsageReport godoc
+//
+//	@Summary		Get Usage Report of All Users
+//	@Description	Aggregate the embedding, chat and rerank calls made to providers across all users, or a single one. Administrators only.
+//	@Tags			Admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			group_by	query		string									true	"Grouping of the calls"					Enums(day, user, dataset, provider)
+//	@Param			days		query		int										false	"Period of the report, 30 days by default"	minimum(1)	maximum(365)
+//	@Param			user_id		query		int										false	"Only count calls made for a user"
+//	@Param			dataset_id	query		int										false	"Only count calls made for a dataset"
+//	@Param			provider_id	query		int										false	"Only count calls made to a provider"
+//	@Param			call_type	query		string									false	"Only count calls of a type"				Enums(embedding, chat, rerank)
+//	@Success		200			{object}	response.ResponseBase[models.UsageReport]	"Usage report"
+//	@Failure		400			{object}	response.ResponseBase[any]				"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]				"Invalid or expired token"
+//	@Failure		403			{object}	response.ResponseBase[any]				"Administrator permission required"
+//	@Failure		500			{object}	response.ResponseBase[any]				"Internal server error"
+//	@Router			/admin/usage/report [get]
+func (this *usageApi) getAdminUsageReport(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.AdminUsageReportReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	report, err := usageService.Report(ctx.Request().Context(), args.GroupBy, models.UsageFilter{
+		Since:      service.UsageSince(args.Days),
+		UserID:     args.UserID,
+		DatasetID:  args.DatasetID,
+		ProviderID: args.ProviderID,
+		CallType:   args.CallType,
+	})
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, report)
+}
+
+// exportUsage godoc
+//
+//	@Summary		Export Usage Ledger
+//	@Description	Download the provider calls of the period as CSV, one row per call, oldest first. Administrators only.
+//	@Tags			Admin
+//	@Produce		text/csv
+//	@Param			days		query		int							false	"Period of the export, 30 days by default"	minimum(1)	maximum(365)
+//	@Param			user_id		query		int							false	"Only export calls made for a user"
+//	@Param			dataset_id	query		int							false	"Only export calls made for a dataset"
+//	@Param			provider_id	query		int							false	"Only export calls made to a provider"
+//	@Param			call_type	query		string						false	"Only export calls of a type"				Enums(embedding, chat, rerank)
+//	@Success		200			{string}	string						"CSV file"
+//	@Failure		400			{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		403			{object}	response.ResponseBase[any]	"Administrator permission required"
+//	@Failure		500			{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/admin/usage/export [get]
+func (this *usageApi) exportUsage(ctx *echo.Context) error {
+	args, err := utils.BindAndValidate[models.UsageExportReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	header := ctx.Response().Header()
+	header.Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="usage-%s.csv"`, time.Now().Format("20060102")))
+	err = usageService.ExportCSV(ctx.Request().Context(), models.UsageFilter{
+		Since:      service.UsageSince(args.Days),
+		UserID:     args.UserID,
+		DatasetID:  args.DatasetID,
+		ProviderID: args.ProviderID,
+		CallType:   args.CallType,
+	}, ctx.Response())
+	if err == nil {
+		return nil
+	}
+	Logger.Error(err)
+	// Once rows are sent the status cannot change anymore, the file is left truncated
+	if resp, unwrapErr := echo.UnwrapResponse(ctx.Response()); unwrapErr == nil && resp.Committed {
+		return nil
+	}
+	header.Del(echo.HeaderContentDisposition)
+	return response.ErrUnknownError()
+}
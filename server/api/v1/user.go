@@ -16,11 +16,13 @@ func SetUserRouter(e *echo.Echo) {
 
 	userRouterGroup := e.Group(config.API_V1 + "/user")
 	userHandler := &userApi{}
-	userRouterGroup.POST("/register", userHandler.register)
-	userRouterGroup.POST("/login", userHandler.login)
+	authRateLimit := config.Settings.GetAuthRateLimit()
+	userRouterGroup.POST("/register", userHandler.register, middleware.RateLimitMiddleware("register", authRateLimit))
+	userRouterGroup.POST("/login", userHandler.login, middleware.RateLimitMiddleware("login", authRateLimit))
 	userRouterGroup.GET("/info", userHandler.getUserInfo, middleware.TokenMiddleware())
 	userRouterGroup.POST("/resetPassword", userHandler.resetUserPassword, middleware.TokenMiddleware())
 	userRouterGroup.POST("/updateInfo", userHandler.updateUserInfo, middleware.TokenMiddleware())
+	userRouterGroup.GET("/usage", userHandler.getUserUsage, middleware.TokenMiddleware())
 }
 
 type userApi struct{}
@@ -36,6 +38,7 @@ type userApi struct{}
 //	@Success		200		{object}	response.ResponseBase[any]	"Register successful"
 //	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters"
 //	@Failure		403		{object}	response.ResponseBase[any]	"Email already used"
+//	@Failure		429		{object}	response.ResponseBase[any]	"Too many requests, see the Retry-After header"
 //	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
 //	@Router			/user/register [post]
 func (this *userApi) register(ctx *echo.Context) error {
@@ -72,6 +75,7 @@ func (this *userApi) register(ctx *echo.Context) error {
 //	@Failure		400		{object}	response.ResponseBase[any]					"Invalid request parameters"
 //	@Failure		404		{object}	response.ResponseBase[any]					"User not found"
 //	@Failure		403		{object}	response.ResponseBase[any]					"Invalid password"
+//	@Failure		429		{object}	response.ResponseBase[any]					"Too many requests, see the Retry-After header"
 //	@Failure		500		{object}	response.ResponseBase[any]					"Internal server error"
 //	@Router			/user/login [post]
 func (this *userApi) login(ctx *echo.Context) error {
@@ -221,3 +225,33 @@ func (this *userApi) updateUserInfo(ctx *echo.Context) error {
 		return response.ErrUnknownError()
 	}
 }
+
+// getUserUsage godoc
+//
+//	@Summary		Get User Quota Usage
+//	@Description	Get the storage, datasets, providers, files per dataset and monthly tokens used by the current authenticated user against the limits of their quota plan. A limit of 0 is unlimited.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Security		Bearer
+//	@Success		200	{object}	response.ResponseBase[models.QuotaUsage]	"Quota usage"
+//	@Failure		401	{object}	response.ResponseBase[any]					"Invalid or expired token"
+//	@Failure		404	{object}	response.ResponseBase[any]					"User not found"
+//	@Failure		500	{object}	response.ResponseBase[any]					"Internal server error"
+//	@Router			/user/usage [get]
+func (this *userApi) getUserUsage(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+
+	switch usage, err := quotaService.GetUsage(ctx.Request().Context(), currentUser.ID); {
+	case err == nil:
+		return response.OkWithData(ctx, usage)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrUserNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
@@ -0,0 +1,242 @@
+package v1
+
+import (
+	"errors"
+	"server/config"
+	"server/middleware"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+func SetWebhookRouter(e *echo.Echo) {
+	webhookRouterGroup := e.Group(config.API_V1+"/webhook", middleware.TokenMiddleware())
+	webhookHandler := &webhookApi{}
+	webhookRouterGroup.POST("/create", webhookHandler.createWebhook)
+	webhookRouterGroup.GET("", webhookHandler.listWebhooks)
+	webhookRouterGroup.POST("/update", webhookHandler.updateWebhook)
+	webhookRouterGroup.POST("/delete/:webhook_id", webhookHandler.deleteWebhook)
+	webhookRouterGroup.GET("/:webhook_id/deliveries", webhookHandler.listDeliveries)
+	webhookRouterGroup.POST("/delivery/:delivery_id/redeliver", webhookHandler.redeliver)
+}
+
+type webhookApi struct{}
+
+// createWebhook godoc
+//
+//	@Summary		Create Webhook
+//	@Description	Register an endpoint receiving the file and dataset events of the authenticated user matching its filters:
+//	@Description	file.uploaded, file.processed, file.failed, file.deleted, dataset.created, dataset.updated, dataset.deleted, file.* and dataset.*.
+//	@Description	Events are POSTed as JSON with an X-InfoWeaver-Signature header "t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+//	@Description	The signing secret is generated when empty and only returned by this request, store it safely.
+//	@Description	The URL must resolve to public addresses, unless OUTBOUND_ALLOWED_NETWORKS allows them.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook	body		models.WebhookCreateReq							true	"Webhook creation request"
+//	@Success		200		{object}	response.ResponseBase[models.WebhookCreateResp]	"Webhook created successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]						"Invalid request parameters, or a URL resolving to a private address"
+//	@Failure		401		{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		500		{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/webhook/create [post]
+func (this *webhookApi) createWebhook(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.WebhookCreateReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch webhook, err := webhookService.CreateWebhook(ctx.Request().Context(), currentUser.ID, *args); {
+	case err == nil:
+		return response.OkWithData(ctx, webhook)
+	case errors.Is(err, service.ErrWebhookAddressRefused):
+		return response.BadRequestWithMsg(err.Error())
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// listWebhooks godoc
+//
+//	@Summary		List Webhooks
+//	@Description	List the webhooks of the authenticated user, without their signing secrets
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	response.ResponseBase[models.WebhookListResp]	"List of webhooks"
+//	@Failure		401	{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		500	{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/webhook [get]
+func (this *webhookApi) listWebhooks(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+
+	total, webhooks, err := webhookService.ListWebhooks(ctx.Request().Context(), currentUser.ID)
+	if err != nil {
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+	return response.OkWithData(ctx, models.WebhookListResp{
+		Total:    total,
+		Webhooks: webhooks,
+	})
+}
+
+// updateWebhook godoc
+//
+//	@Summary		Update Webhook
+//	@Description	Change the URL, event filters and description of a webhook, or pause it with active set to false.
+//	@Description	Events are not queued for paused webhooks, and their pending deliveries become dead. The signing secret stays the same.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook	body		models.WebhookUpdateReq		true	"Webhook update request"
+//	@Success		200		{object}	response.ResponseBase[any]	"Webhook updated successfully"
+//	@Failure		400		{object}	response.ResponseBase[any]	"Invalid request parameters, or a URL resolving to a private address"
+//	@Failure		401		{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		404		{object}	response.ResponseBase[any]	"Webhook not found"
+//	@Failure		500		{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/webhook/update [post]
+func (this *webhookApi) updateWebhook(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.WebhookUpdateReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := webhookService.UpdateWebhook(ctx.Request().Context(), currentUser.ID, *args); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrWebhookNotFound()
+	case errors.Is(err, service.ErrWebhookAddressRefused):
+		return response.BadRequestWithMsg(err.Error())
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// deleteWebhook godoc
+//
+//	@Summary		Delete Webhook
+//	@Description	Delete a webhook of the authenticated user, its pending deliveries are dropped
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook_id	path		int							true	"Webhook ID"
+//	@Success		200			{object}	response.ResponseBase[any]	"Webhook deleted successfully"
+//	@Failure		400			{object}	response.ResponseBase[any]	"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]	"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]	"Webhook not found"
+//	@Failure		500			{object}	response.ResponseBase[any]	"Internal server error"
+//	@Router			/webhook/delete/{webhook_id} [post]
+func (this *webhookApi) deleteWebhook(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.WebhookReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch err := webhookService.DeleteWebhook(ctx.Request().Context(), args.ID, currentUser.ID); {
+	case err == nil:
+		return response.Ok(ctx)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrWebhookNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// listDeliveries godoc
+//
+//	@Summary		List Webhook Deliveries
+//	@Description	Page through the delivery log of a webhook, newest first. Failed deliveries are retried with exponential backoff
+//	@Description	and become dead after their last attempt, dead ones are only sent again through redeliver.
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			webhook_id	path		int												true	"Webhook ID"
+//	@Param			status		query		string											false	"Delivery status"	Enums(pending, succeeded, dead)
+//	@Param			page		query		int												false	"Page number, from 1"
+//	@Param			page_size	query		int												false	"Deliveries per page, 20 by default"
+//	@Success		200			{object}	response.ResponseBase[models.WebhookDeliveryListResp]	"Delivery log"
+//	@Failure		400			{object}	response.ResponseBase[any]						"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]						"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]						"Webhook not found"
+//	@Failure		500			{object}	response.ResponseBase[any]						"Internal server error"
+//	@Router			/webhook/{webhook_id}/deliveries [get]
+func (this *webhookApi) listDeliveries(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.WebhookDeliveryListReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch total, deliveries, err := webhookService.ListDeliveries(ctx.Request().Context(), currentUser.ID, *args); {
+	case err == nil:
+		return response.OkWithData(ctx, models.WebhookDeliveryListResp{
+			Total:      total,
+			Deliveries: deliveries,
+		})
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrWebhookNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
+
+// redeliver godoc
+//
+//	@Summary		Redeliver Webhook Event
+//	@Description	Send the event of a delivery again right away, as a new delivery of the log retried like the others
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Param			delivery_id	path		int													true	"Delivery ID"
+//	@Success		200			{object}	response.ResponseBase[models.WebhookDeliveryInfo]	"Delivery queued"
+//	@Failure		400			{object}	response.ResponseBase[any]							"Invalid request parameters"
+//	@Failure		401			{object}	response.ResponseBase[any]							"Invalid or expired token"
+//	@Failure		404			{object}	response.ResponseBase[any]							"Delivery not found"
+//	@Failure		500			{object}	response.ResponseBase[any]							"Internal server error"
+//	@Router			/webhook/delivery/{delivery_id}/redeliver [post]
+func (this *webhookApi) redeliver(ctx *echo.Context) error {
+	currentUser, err := utils.GetCurrentUser(ctx)
+	if err != nil {
+		return response.ErrInvalidToken()
+	}
+	args, err := utils.BindAndValidate[models.WebhookDeliveryReq](ctx)
+	if err != nil {
+		return response.BadRequestWithMsg(err.Error())
+	}
+
+	switch delivery, err := webhookService.Redeliver(ctx.Request().Context(), args.ID, currentUser.ID); {
+	case err == nil:
+		return response.OkWithData(ctx, delivery)
+	case errors.Is(err, service.ErrNotFound):
+		return response.ErrWebhookDeliveryNotFound()
+	default:
+		Logger.Error(err)
+		return response.ErrUnknownError()
+	}
+}
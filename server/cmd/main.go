@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
 	"server/api"
 	"server/config"
 	"server/db"
 	_ "server/docs"
 	"server/middleware"
+	"server/service"
 	"server/utils"
+	"syscall"
 
 	"github.com/labstack/echo/v5"
 )
@@ -17,12 +22,33 @@ import (
 // @BasePath		/api/v1
 func main() {
 	config.VP, config.Settings = config.InitViper(config.DEFAULT_ENV_FILENAME)
+	if _, err := utils.CheckEncryptionKeys(); err != nil {
+		utils.Logger.Fatal(err)
+	}
+	if err := service.CheckSecretStore(); err != nil {
+		utils.Logger.Fatal(err)
+	}
 	db.InitAllDB()
+	// Stopped on SIGINT or SIGTERM, the server then finishes the requests in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// The ledger outlives the server, so the calls of the last requests are written too
+	usageCtx, stopUsage := context.WithCancel(context.Background())
+	service.ReindexServiceApp.Start(ctx)
+	service.UsageServiceApp.Start(usageCtx)
+	service.OutboxServiceApp.Start(ctx)
+	service.DeadLetterServiceApp.Start(ctx)
+	service.WebhookServiceApp.Start(ctx)
 	e := echo.New()
 
 	middleware.InitMiddleWares(e)
 	api.InitRouter(e)
 
 	server_port := config.Settings.GetServerPort()
-	utils.Logger.Fatal(e.Start(server_port))
+	err := echo.StartConfig{Address: server_port}.Start(ctx, e)
+	stopUsage()
+	service.UsageServiceApp.Wait()
+	if err != nil {
+		utils.Logger.Fatal(err)
+	}
 }
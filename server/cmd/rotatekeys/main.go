@@ -0,0 +1,42 @@
+// Command rotatekeys encrypts again every stored provider API key and headers, and every webhook signing secret,
+// under the primary key of ENCRYPTION_KEYS.
+//
+// Rotating a key: add the new key to ENCRYPTION_KEYS and make it ENCRYPTION_PRIMARY_KEY_ID, restart the API,
+// then run
+//
+//	go run ./cmd/rotatekeys
+//
+// The API keeps serving meanwhile since the old keys still decrypt. Once a run re-encrypts nothing,
+// the old key can be removed from ENCRYPTION_KEYS.
+package main
+
+import (
+	"context"
+	"server/config"
+	"server/db"
+	"server/service"
+	"server/utils"
+)
+
+func main() {
+	config.VP, config.Settings = config.InitViper(config.DEFAULT_ENV_FILENAME)
+	primaryID, err := utils.CheckEncryptionKeys()
+	if err != nil {
+		utils.Logger.Fatal(err)
+	}
+	if primaryID == utils.LEGACY_ENCRYPTION_KEY_ID {
+		utils.Logger.Fatal("ENCRYPTION_KEYS is empty, configure the key to rotate to first")
+	}
+	db.InitPgSqlDB()
+
+	rotated, err := service.ProviderServiceApp.RotateProviderSecrets(context.Background())
+	if err != nil {
+		utils.Logger.Fatalf("Rotation stopped after %d providers: %v", rotated, err)
+	}
+	utils.Logger.Infof("Re-encrypted the secrets of %d providers under key %q", rotated, primaryID)
+	rotated, err = service.WebhookServiceApp.RotateWebhookSecrets(context.Background())
+	if err != nil {
+		utils.Logger.Fatalf("Rotation stopped after %d webhooks: %v", rotated, err)
+	}
+	utils.Logger.Infof("Re-encrypted the signing secrets of %d webhooks under key %q", rotated, primaryID)
+}
@@ -4,4 +4,5 @@ const (
 	DEFAULT_ENV_FILENAME = ".env.local"
 	TEST_ENV_FILENAME    = ".env.example"
 	API_V1               = "/api/v1"
+	OPENAI_V1            = "/v1" // OpenAI-compatible API, authenticated with user API keys
 )
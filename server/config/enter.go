@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +18,15 @@ type Config struct {
 	SYSTEM_ADMIN_EMAIL           string `mapstructure:"SYSTEM_ADMIN_EMAIL"`
 	JWT_SIGNING_KEY              string `mapstructure:"JWT_SIGNING_KEY"`
 	JWT_EXPIRES_TIME             string `mapstructure:"JWT_EXPIRES_TIME"`
+	ENCRYPTION_KEYS              string `mapstructure:"ENCRYPTION_KEYS"`
+	ENCRYPTION_PRIMARY_KEY_ID    string `mapstructure:"ENCRYPTION_PRIMARY_KEY_ID"`
+	SECRETS_BACKEND              string `mapstructure:"SECRETS_BACKEND"`
+	SECRETS_FILE_DIR             string `mapstructure:"SECRETS_FILE_DIR"`
+	VAULT_ADDR                   string `mapstructure:"VAULT_ADDR"`
+	VAULT_TOKEN                  string `mapstructure:"VAULT_TOKEN"`
+	VAULT_NAMESPACE              string `mapstructure:"VAULT_NAMESPACE"`
+	VAULT_KV_MOUNT               string `mapstructure:"VAULT_KV_MOUNT"`
+	VAULT_PATH_PREFIX            string `mapstructure:"VAULT_PATH_PREFIX"`
 	REDIS_HOST                   string `mapstructure:"REDIS_HOST"`
 	REDIS_PORT                   int    `mapstructure:"REDIS_PORT"`
 	REDIS_PASSWORD               string `mapstructure:"REDIS_PASSWORD"`
@@ -42,6 +52,8 @@ type Config struct {
 	POSTGRES_MAX_OPEN_CONNS      int    `mapstructure:"POSTGRES_MAX_OPEN_CONNS"`
 	MILVUS_HOST                  string `mapstructure:"MILVUS_HOST"`
 	MILVUS_PORT                  int    `mapstructure:"MILVUS_PORT"`
+	MILVUS_DB_NAME               string `mapstructure:"MILVUS_DB_NAME"`
+	MILVUS_COLLECTION_NAME       string `mapstructure:"MILVUS_COLLECTION_NAME"`
 	MINIO_HOST                   string `mapstructure:"MINIO_HOST"`
 	MINIO_PORT                   int    `mapstructure:"MINIO_PORT"`
 	MINIO_ACCESS_KEY             string `mapstructure:"MINIO_ACCESS_KEY"`
@@ -55,6 +67,50 @@ type Config struct {
 	RABBITMQ_VHOST               string `mapstructure:"RABBITMQ_VHOST"`
 	RABBITMQ_EXCHANGE            string `mapstructure:"RABBITMQ_EXCHANGE"`
 	RABBITMQ_QUEUE               string `mapstructure:"RABBITMQ_QUEUE"`
+	RABBITMQ_RETRY_DELAYS        string `mapstructure:"RABBITMQ_RETRY_DELAYS"`
+	RABBITMQ_MANAGEMENT_URL      string `mapstructure:"RABBITMQ_MANAGEMENT_URL"`
+	AI_SERVER_HOST               string `mapstructure:"AI_SERVER_HOST"`
+	AI_SERVER_PORT               int    `mapstructure:"AI_SERVER_PORT"`
+	RAG_CHAT_STREAM_URL          string `mapstructure:"RAG_CHAT_STREAM_URL"`
+	MODEL_CATALOG_TTL            string `mapstructure:"MODEL_CATALOG_TTL"`
+	USAGE_PRICE_FILE             string `mapstructure:"USAGE_PRICE_FILE"`
+	RATE_LIMIT_AUTH              string `mapstructure:"RATE_LIMIT_AUTH"`
+	RATE_LIMIT_UPLOAD            string `mapstructure:"RATE_LIMIT_UPLOAD"`
+	RATE_LIMIT_CHAT              string `mapstructure:"RATE_LIMIT_CHAT"`
+	OUTBOUND_ALLOWED_NETWORKS    string `mapstructure:"OUTBOUND_ALLOWED_NETWORKS"`
+}
+
+// RateLimit allows Requests per sliding Window to each client, a zero Requests disables the limit
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// ParseRateLimit reads limits written "requests/window" like "10/1m" or "10/m", and "0" to disable the limit.
+// Empty or invalid values give the fallback.
+func ParseRateLimit(value string, fallback RateLimit) RateLimit {
+	value = strings.TrimSpace(value)
+	if value == "0" {
+		return RateLimit{}
+	}
+	requests, window, ok := strings.Cut(value, "/")
+	if !ok {
+		return fallback
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(requests))
+	if err != nil || count < 0 {
+		return fallback
+	}
+	window = strings.TrimSpace(window)
+	duration, err := time.ParseDuration(window)
+	if err != nil {
+		// A bare unit means one of it
+		duration, err = time.ParseDuration("1" + window)
+	}
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return RateLimit{Requests: count, Window: duration}
 }
 
 func (this *Config) GetServerPort() string {
@@ -83,6 +139,120 @@ func (this *Config) GetRabbitMQDSN() string {
 	return fmt.Sprintf("amqp://%s:%s@%s:%d/%s", this.RABBITMQ_USERNAME, this.RABBITMQ_PASSWORD, this.RABBITMQ_HOST, this.RABBITMQ_PORT, this.RABBITMQ_VHOST)
 }
 
+func (this *Config) GetAIServerURL() string {
+	return fmt.Sprintf("http://%s:%d/ai/v1", this.AI_SERVER_HOST, this.AI_SERVER_PORT)
+}
+
+// GetRAGChatStreamURL returns the streaming chat endpoint of the RAG backend,
+// which defaults to the one of the AI service
+func (this *Config) GetRAGChatStreamURL() string {
+	if this.RAG_CHAT_STREAM_URL != "" {
+		return this.RAG_CHAT_STREAM_URL
+	}
+	return this.GetAIServerURL() + "/chat/chat/stream"
+}
+
+// GetModelCatalogTTL returns how long the model catalog of a provider stays cached, 6 hours by default
+func (this *Config) GetModelCatalogTTL() time.Duration {
+	if ttl, err := time.ParseDuration(strings.TrimSpace(this.MODEL_CATALOG_TTL)); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 6 * time.Hour
+}
+
+// GetAuthRateLimit returns the limit of login and registration attempts of an IP address, 10 a minute by default
+func (this *Config) GetAuthRateLimit() RateLimit {
+	return ParseRateLimit(this.RATE_LIMIT_AUTH, RateLimit{Requests: 10, Window: time.Minute})
+}
+
+// GetUploadRateLimit returns the limit of file uploads of a user, 30 a minute by default
+func (this *Config) GetUploadRateLimit() RateLimit {
+	return ParseRateLimit(this.RATE_LIMIT_UPLOAD, RateLimit{Requests: 30, Window: time.Minute})
+}
+
+// GetChatRateLimit returns the limit of chat and completion requests of a user or API key, 20 a minute by default
+func (this *Config) GetChatRateLimit() RateLimit {
+	return ParseRateLimit(this.RATE_LIMIT_CHAT, RateLimit{Requests: 20, Window: time.Minute})
+}
+
+// GetOutboundAllowedNetworks returns the private networks that URLs given by users may reach,
+// like the one of a self-hosted Ollama. Invalid CIDRs are skipped.
+func (this *Config) GetOutboundAllowedNetworks() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(this.OUTBOUND_ALLOWED_NETWORKS, ",") {
+		if _, network, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// GetRabbitMQExchange returns the topic exchange of the lifecycle events, "info-weaver-events" by default
+func (this *Config) GetRabbitMQExchange() string {
+	if this.RABBITMQ_EXCHANGE == "" {
+		return "info-weaver-events"
+	}
+	return this.RABBITMQ_EXCHANGE
+}
+
+// GetRabbitMQQueue returns the work queue of the ingestion worker, "info-weaver-file-queue" by default
+func (this *Config) GetRabbitMQQueue() string {
+	if this.RABBITMQ_QUEUE == "" {
+		return "info-weaver-file-queue"
+	}
+	return this.RABBITMQ_QUEUE
+}
+
+// GetRabbitMQManagementURL returns the HTTP API of the RabbitMQ management plugin, on port 15672 of RABBITMQ_HOST by default
+func (this *Config) GetRabbitMQManagementURL() string {
+	if this.RABBITMQ_MANAGEMENT_URL == "" {
+		return fmt.Sprintf("http://%s:15672", this.RABBITMQ_HOST)
+	}
+	return strings.TrimRight(this.RABBITMQ_MANAGEMENT_URL, "/")
+}
+
+// GetRabbitMQRetryDelays returns the waits before each retry of a rejected message, "30s,2m,10m,30m" by default.
+// A message is dead-lettered once rejected again after the last one.
+func (this *Config) GetRabbitMQRetryDelays() []time.Duration {
+	fallback := []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute, 30 * time.Minute}
+	if strings.TrimSpace(this.RABBITMQ_RETRY_DELAYS) == "" {
+		return fallback
+	}
+	var delays []time.Duration
+	for value := range strings.SplitSeq(this.RABBITMQ_RETRY_DELAYS, ",") {
+		delay, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || delay < time.Second {
+			return fallback
+		}
+		delays = append(delays, delay)
+	}
+	return delays
+}
+
+// GetSecretsBackend returns where provider API keys and headers are kept, "database" by default
+func (this *Config) GetSecretsBackend() string {
+	if this.SECRETS_BACKEND == "" {
+		return "database"
+	}
+	return strings.ToLower(this.SECRETS_BACKEND)
+}
+
+// GetVaultKVMount returns the mount path of the Vault KV version 2 engine, "secret" by default
+func (this *Config) GetVaultKVMount() string {
+	if this.VAULT_KV_MOUNT == "" {
+		return "secret"
+	}
+	return this.VAULT_KV_MOUNT
+}
+
+// GetVaultPathPrefix returns the path provider secrets are written under in Vault, "infoweaver/providers" by default
+func (this *Config) GetVaultPathPrefix() string {
+	if this.VAULT_PATH_PREFIX == "" {
+		return "infoweaver/providers"
+	}
+	return this.VAULT_PATH_PREFIX
+}
+
 func (this *Config) GetJWTExpireTime() time.Duration {
 	parseDuration := func(d string) (time.Duration, error) {
 		d = strings.TrimSpace(d)
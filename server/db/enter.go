@@ -17,26 +17,25 @@ func InitAllDB() {
 	handler.initRedis()
 	handler.initMinio()
 	handler.initRabbitMQ()
-	// handler.InitMilvus()
+	handler.initMilvus()
+}
+
+// InitPgSqlDB connects to PostgreSQL alone, for commands that need no other store
+func InitPgSqlDB() {
+	handler := &InitDBHandler{}
+	handler.initPgSql()
 }
 
 func (this *InitDBHandler) initPgSql() {
 	var err error
-	if PgSqlDB, err = connectPgSqlDB(config.Settings); err != nil {
+	if PgSqlDB, err = ConnectPgSqlDB(config.Settings); err != nil {
 		utils.Logger.Errorf("Failed to connect to PostgreSQL:%s", err)
 		os.Exit(0)
 	}
 	utils.Logger.Info("success to connect to PostgreSQL")
 
-	// Auto-migrate database schema
-	if err = PgSqlDB.AutoMigrate(
-		&models.User{},
-		&models.File{},
-		&models.Chunk{},
-		&models.Memory{},
-		&models.Dataset{},
-		&models.Provider{}); err != nil {
-		utils.Logger.Errorf("Failed to create PostgreSQL tables:%s", err)
+	if err = MigratePgSqlDB(PgSqlDB); err != nil {
+		utils.Logger.Errorf("Failed to migrate PostgreSQL:%s", err)
 		os.Exit(0)
 	}
 
@@ -79,18 +78,20 @@ func (this *InitDBHandler) initMinio() {
 	}
 	utils.Logger.Info("success to connect to Minio")
 }
-func (this *InitDBHandler) InitMilvus() {
+func (this *InitDBHandler) initMilvus() {
 	var err error
-	if MilvusClient, err = connectMilvusDB(config.Settings); err != nil {
-		utils.Logger.Errorf("Failed to connect to Milvus:%s", err)
-		os.Exit(0)
+	if MilvusClient, err = ConnectMilvusDB(config.Settings); err != nil {
+		// Search still works without Milvus through PostgreSQL full-text search
+		utils.Logger.Warnf("Failed to connect to Milvus, vector search is unavailable:%s", err)
+		MilvusClient = nil
+		return
 	}
 	utils.Logger.Info("success to connect to Milvus")
 }
 
 func (this *InitDBHandler) initRabbitMQ() {
 	var err error
-	if RabbitMQConn, err = connectRabbitMQ(config.Settings); err != nil {
+	if RabbitMQClient, err = ConnectRabbitMQ(config.Settings); err != nil {
 		utils.Logger.Errorf("Failed to connect to RabbitMQ:%s", err)
 		os.Exit(0)
 	}
@@ -2,21 +2,40 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"server/config"
 	"server/utils"
 
+	"github.com/milvus-io/milvus/client/v2/column"
+	"github.com/milvus-io/milvus/client/v2/entity"
+	"github.com/milvus-io/milvus/client/v2/index"
 	"github.com/milvus-io/milvus/client/v2/milvusclient"
 )
 
 var MilvusClient *milvusclient.Client
 
-func connectMilvusDB(cfg *config.Config) (*milvusclient.Client, error) {
+const (
+	MILVUS_ID_FIELD      = "id"
+	MILVUS_DENSE_FIELD   = "dense_vector"
+	MILVUS_SPARSE_FIELD  = "sparse_vector"
+	MILVUS_CONTENT_FIELD = "content"
+	MILVUS_DATASET_FIELD = "dataset_id"
+)
+
+// VectorHit represents a single entity returned by a Milvus search
+type VectorHit struct {
+	ID    int64   // Milvus entity ID, stored as Chunk.VectorID in PostgreSQL
+	Score float32 // Similarity score (inner product)
+}
+
+func ConnectMilvusDB(cfg *config.Config) (*milvusclient.Client, error) {
 
 	dsn := cfg.GetMilvusDSN()
 	utils.Logger.Infof("use Milvus DSN:%s", dsn)
 
 	clientConfig := &milvusclient.ClientConfig{
 		Address: dsn,
+		DBName:  cfg.MILVUS_DB_NAME,
 	}
 	client, err := milvusclient.New(context.Background(), clientConfig)
 	if err != nil {
@@ -24,3 +43,163 @@ func connectMilvusDB(cfg *config.Config) (*milvusclient.Client, error) {
 	}
 	return client, nil
 }
+
+// SearchDenseVectors runs an ANN search against the dense vector field
+func SearchDenseVectors(ctx context.Context, collection string, vector []float32, filter string, limit int) ([]VectorHit, error) {
+	option := milvusclient.NewSearchOption(collection, limit, []entity.Vector{entity.FloatVector(vector)}).
+		WithANNSField(MILVUS_DENSE_FIELD).
+		WithFilter(filter)
+	resultSets, err := MilvusClient.Search(ctx, option)
+	if err != nil {
+		return nil, err
+	}
+	return parseVectorHits(resultSets)
+}
+
+// SearchSparseVectors runs a search against the sparse vector field
+func SearchSparseVectors(ctx context.Context, collection string, sparse map[uint32]float32, filter string, limit int) ([]VectorHit, error) {
+	sparseVector, err := newSparseEmbedding(sparse)
+	if err != nil {
+		return nil, err
+	}
+	option := milvusclient.NewSearchOption(collection, limit, []entity.Vector{sparseVector}).
+		WithANNSField(MILVUS_SPARSE_FIELD).
+		WithFilter(filter)
+	resultSets, err := MilvusClient.Search(ctx, option)
+	if err != nil {
+		return nil, err
+	}
+	return parseVectorHits(resultSets)
+}
+
+// HybridSearchVectors searches both vector fields and merges them with an equally weighted ranker,
+// mirroring the hybrid search of the AI service
+func HybridSearchVectors(ctx context.Context, collection string, dense []float32, sparse map[uint32]float32, filter string, limit int) ([]VectorHit, error) {
+	sparseVector, err := newSparseEmbedding(sparse)
+	if err != nil {
+		return nil, err
+	}
+	sparseReq := milvusclient.NewAnnRequest(MILVUS_SPARSE_FIELD, limit, sparseVector).WithFilter(filter)
+	denseReq := milvusclient.NewAnnRequest(MILVUS_DENSE_FIELD, limit, entity.FloatVector(dense)).WithFilter(filter)
+
+	option := milvusclient.NewHybridSearchOption(collection, limit, sparseReq, denseReq).
+		WithReranker(milvusclient.NewWeightedReranker([]float64{1.0, 1.0}))
+	resultSets, err := MilvusClient.HybridSearch(ctx, option)
+	if err != nil {
+		return nil, err
+	}
+	return parseVectorHits(resultSets)
+}
+
+// CreateVectorCollection creates a collection with the same schema and indexes as the one created by the AI service,
+// and loads it so it can be searched
+func CreateVectorCollection(ctx context.Context, collection string, dim int) error {
+	schema := entity.NewSchema().
+		WithAutoID(true).
+		WithDynamicFieldEnabled(true).
+		WithField(entity.NewField().WithName(MILVUS_ID_FIELD).WithDataType(entity.FieldTypeInt64).WithIsPrimaryKey(true).WithIsAutoID(true)).
+		WithField(entity.NewField().WithName(MILVUS_DENSE_FIELD).WithDataType(entity.FieldTypeFloatVector).WithDim(int64(dim))).
+		WithField(entity.NewField().WithName(MILVUS_SPARSE_FIELD).WithDataType(entity.FieldTypeSparseVector)).
+		WithField(entity.NewField().WithName(MILVUS_CONTENT_FIELD).WithDataType(entity.FieldTypeVarChar).WithMaxLength(65535)).
+		WithField(entity.NewField().WithName(MILVUS_DATASET_FIELD).WithDataType(entity.FieldTypeInt64))
+
+	option := milvusclient.NewCreateCollectionOption(collection, schema).
+		WithIndexOptions(
+			milvusclient.NewCreateIndexOption(collection, MILVUS_DENSE_FIELD, index.NewAutoIndex(entity.IP)),
+			milvusclient.NewCreateIndexOption(collection, MILVUS_SPARSE_FIELD, index.NewSparseInvertedIndex(entity.IP, 0)),
+			milvusclient.NewCreateIndexOption(collection, MILVUS_DATASET_FIELD, index.NewInvertedIndex()),
+		)
+	if err := MilvusClient.CreateCollection(ctx, option); err != nil {
+		return err
+	}
+	task, err := MilvusClient.LoadCollection(ctx, milvusclient.NewLoadCollectionOption(collection))
+	if err != nil {
+		return err
+	}
+	return task.Await(ctx)
+}
+
+// InsertVectors inserts the dense and sparse vectors of a dataset's chunks with their content,
+// and returns the entity IDs in the order of contents
+func InsertVectors(ctx context.Context, collection string, datasetID uint, contents []string, dense [][]float32, sparse []map[uint32]float32) ([]int64, error) {
+	if len(contents) == 0 {
+		return []int64{}, nil
+	}
+	if len(dense) != len(contents) || len(sparse) != len(contents) {
+		return nil, fmt.Errorf("got %d dense and %d sparse vectors for %d contents", len(dense), len(sparse), len(contents))
+	}
+	sparseVectors := make([]entity.SparseEmbedding, len(sparse))
+	for i := range sparse {
+		sparseVector, err := newSparseEmbedding(sparse[i])
+		if err != nil {
+			return nil, err
+		}
+		sparseVectors[i] = sparseVector
+	}
+	datasetIDs := make([]int64, len(contents))
+	for i := range datasetIDs {
+		datasetIDs[i] = int64(datasetID)
+	}
+
+	option := milvusclient.NewColumnBasedInsertOption(collection).
+		WithFloatVectorColumn(MILVUS_DENSE_FIELD, len(dense[0]), dense).
+		WithColumns(column.NewColumnSparseVectors(MILVUS_SPARSE_FIELD, sparseVectors)).
+		WithVarcharColumn(MILVUS_CONTENT_FIELD, contents).
+		WithInt64Column(MILVUS_DATASET_FIELD, datasetIDs)
+	result, err := MilvusClient.Insert(ctx, option)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int64, result.IDs.Len())
+	for i := range ids {
+		if ids[i], err = result.IDs.GetAsInt64(i); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// DropVectorCollection drops a collection if it exists
+func DropVectorCollection(ctx context.Context, collection string) error {
+	has, err := MilvusClient.HasCollection(ctx, milvusclient.NewHasCollectionOption(collection))
+	if err != nil || !has {
+		return err
+	}
+	return MilvusClient.DropCollection(ctx, milvusclient.NewDropCollectionOption(collection))
+}
+
+// DeleteVectors deletes the entities of a collection matching the filter
+func DeleteVectors(ctx context.Context, collection string, filter string) error {
+	_, err := MilvusClient.Delete(ctx, milvusclient.NewDeleteOption(collection).WithExpr(filter))
+	return err
+}
+
+func newSparseEmbedding(sparse map[uint32]float32) (entity.SparseEmbedding, error) {
+	positions := make([]uint32, 0, len(sparse))
+	values := make([]float32, 0, len(sparse))
+	for position, value := range sparse {
+		positions = append(positions, position)
+		values = append(values, value)
+	}
+	return entity.NewSliceSparseEmbedding(positions, values)
+}
+
+// parseVectorHits extracts IDs and scores of the first (and only) query in the result sets
+func parseVectorHits(resultSets []milvusclient.ResultSet) ([]VectorHit, error) {
+	hits := []VectorHit{}
+	if len(resultSets) == 0 {
+		return hits, nil
+	}
+	resultSet := resultSets[0]
+	if resultSet.Err != nil {
+		return nil, resultSet.Err
+	}
+	for i := 0; i < resultSet.ResultCount; i++ {
+		id, err := resultSet.IDs.GetAsInt64(i)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, VectorHit{ID: id, Score: resultSet.Scores[i]})
+	}
+	return hits, nil
+}
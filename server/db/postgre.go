@@ -1,7 +1,10 @@
 package db
 
 import (
+	"context"
+	"fmt"
 	"server/config"
+	"server/models"
 	"server/utils"
 
 	"gorm.io/driver/postgres"
@@ -10,7 +13,7 @@ import (
 
 var PgSqlDB *gorm.DB
 
-func connectPgSqlDB(cfg *config.Config) (*gorm.DB, error) {
+func ConnectPgSqlDB(cfg *config.Config) (*gorm.DB, error) {
 	dsn := cfg.GetPostgreDSN()
 	utils.Logger.Infof("use PostgreSQL DSN:%s", dsn)
 
@@ -28,3 +31,142 @@ func connectPgSqlDB(cfg *config.Config) (*gorm.DB, error) {
 	}
 	return db, nil
 }
+
+// fullTextSearchMigrations keep chunks.search_vector in sync with chunk content.
+// The text search config is taken from the chunk's dataset, so chunks written by the AI service are indexed too.
+var fullTextSearchMigrations = []string{
+	`CREATE OR REPLACE FUNCTION chunks_search_vector_update() RETURNS trigger AS $$
+BEGIN
+	NEW.search_vector := to_tsvector(
+		COALESCE((SELECT d.text_search_config FROM files f JOIN datasets d ON d.id = f.dataset_id WHERE f.id = NEW.file_id), 'simple')::regconfig,
+		COALESCE(NEW.content, ''));
+	RETURN NEW;
+END
+$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS chunks_search_vector_trigger ON chunks`,
+	`CREATE TRIGGER chunks_search_vector_trigger BEFORE INSERT OR UPDATE OF content, file_id ON chunks
+	FOR EACH ROW EXECUTE FUNCTION chunks_search_vector_update()`,
+}
+
+func migrateFullTextSearch(db *gorm.DB) error {
+	for _, sql := range fullTextSearchMigrations {
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migration changes the rows of an existing database once, which AutoMigrate cannot do
+type migration struct {
+	Name string
+	Run  func(tx *gorm.DB) error
+}
+
+// schemaMigrations run in order before AutoMigrate, for the changes it cannot apply to existing rows
+var schemaMigrations = []migration{
+	{
+		// Memories used to belong to no chat session. Nobody can be told apart as their author, so they are
+		// kept aside in legacy tables and the column is only tightened once no row lacks a session.
+		Name: "memories_session_id",
+		Run: func(tx *gorm.DB) error {
+			if !tx.Migrator().HasTable(&models.Memory{}) || tx.Migrator().HasColumn(&models.Memory{}, "session_id") {
+				return nil
+			}
+			if err := tx.Exec(`ALTER TABLE memories ADD COLUMN session_id bigint`).Error; err != nil {
+				return err
+			}
+			if tx.Migrator().HasTable("memory_documents") {
+				if err := tx.Exec(`CREATE TABLE legacy_memory_documents AS SELECT * FROM memory_documents`).Error; err != nil {
+					return err
+				}
+				if err := tx.Exec(`DELETE FROM memory_documents`).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Exec(`CREATE TABLE legacy_memories AS SELECT * FROM memories WHERE session_id IS NULL`).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(`DELETE FROM memories WHERE session_id IS NULL`).Error; err != nil {
+				return err
+			}
+			return tx.Exec(`ALTER TABLE memories ALTER COLUMN session_id SET NOT NULL`).Error
+		},
+	},
+}
+
+// dataMigrations run in order after AutoMigrate
+var dataMigrations = []migration{
+	{
+		// Chunks created before the full-text search trigger existed have no search vector
+		Name: "backfill_chunks_search_vector",
+		Run: func(tx *gorm.DB) error {
+			return tx.Exec(`UPDATE chunks SET content = content WHERE search_vector IS NULL`).Error
+		},
+	},
+}
+
+// runMigrations runs the migrations not applied yet, each in a transaction recording it in schema_migrations.
+// A replica running the same migration holds its record until it commits, the others then skip it.
+func runMigrations(db *gorm.DB, migrations []migration) error {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+	name TEXT PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`).Error; err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Exec("INSERT INTO schema_migrations (name) VALUES (?) ON CONFLICT (name) DO NOTHING", m.Name)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			utils.Logger.Infof("Running database migration %s", m.Name)
+			return m.Run(tx)
+		}); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigratePgSqlDB brings the schema and rows of a database up to date
+func MigratePgSqlDB(db *gorm.DB) error {
+	if err := runMigrations(db, schemaMigrations); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	if err := db.AutoMigrate(
+		&models.QuotaPlan{},
+		&models.User{},
+		&models.File{},
+		&models.Chunk{},
+		&models.Memory{},
+		&models.ChatSession{},
+		&models.Dataset{},
+		&models.Provider{},
+		&models.ProviderGrant{},
+		&models.ReindexJob{},
+		&models.UserAPIKey{},
+		&models.Feedback{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.OutboxEvent{},
+		&models.UsageRecord{}); err != nil {
+		return fmt.Errorf("failed to create tables: %w", err)
+	}
+	if err := migrateFullTextSearch(db); err != nil {
+		return fmt.Errorf("failed to create full-text search trigger: %w", err)
+	}
+	if err := runMigrations(db, dataMigrations); err != nil {
+		return fmt.Errorf("failed to migrate data: %w", err)
+	}
+	return nil
+}
+
+// ReindexDatasetChunks recomputes the search vectors of a dataset's chunks,
+// which is needed after its text search config changed
+func ReindexDatasetChunks(ctx context.Context, db *gorm.DB, datasetID uint) error {
+	return db.WithContext(ctx).
+		Exec("UPDATE chunks SET content = content WHERE file_id IN (SELECT id FROM files WHERE dataset_id = ?)", datasetID).
+		Error
+}
@@ -1,16 +1,69 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math/rand/v2"
 	"server/config"
 	"server/utils"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 )
 
-var RabbitMQConn *amqp.Connection
+const (
+	// Idle channels in confirm mode kept open for publishing
+	RABBITMQ_CHANNEL_POOL_SIZE = 16
+	// Backoff between attempts to dial the broker again or resume a consumer, doubled after each failure and jittered
+	RABBITMQ_RECONNECT_BASE_DELAY = time.Second
+	RABBITMQ_RECONNECT_MAX_DELAY  = 30 * time.Second
+)
+
+var (
+	ErrRabbitMQClosed = errors.New("RabbitMQ client is closed")
+	// A mandatory message no queue is bound to take, handed back by the broker
+	ErrMessageReturned = errors.New("message returned by RabbitMQ")
+	ErrMessageNacked   = errors.New("message rejected by RabbitMQ")
+)
+
+var RabbitMQClient *RabbitMQService
+
+// RabbitMQService is a RabbitMQ connection recovering by itself. A lost connection is dialed again with backoff,
+// the topology declared through the service is declared again, and consumers resume on the new connection.
+// Messages are published on a pool of channels in confirm mode.
+type RabbitMQService struct {
+	dsn        string
+	management *RabbitMQManagement
+
+	mu        sync.Mutex
+	conn      *amqp.Connection // nil while reconnecting
+	connected chan struct{}    // Closed once conn is set
+	topology  []topologyDeclaration
+	declared  map[string]*amqp.Connection // Connection each declaration was last made on
+	closed    bool
+	done      chan struct{}
+
+	channels chan *publishChannel // Idle publishing channels
+}
+
+// topologyDeclaration is an exchange, queue or binding declared again on every new connection
+type topologyDeclaration struct {
+	key     string
+	declare func(ch *amqp.Channel) error
+}
 
-func connectRabbitMQ(cfg *config.Config) (*amqp.Connection, error) {
+// publishChannel is a channel in confirm mode, with the mandatory messages the broker returned on it
+type publishChannel struct {
+	*amqp.Channel
+	returns chan amqp.Return
+}
+
+// ConnectRabbitMQ dials the broker and keeps the connection until Close
+func ConnectRabbitMQ(cfg *config.Config) (*RabbitMQService, error) {
 	dsn := cfg.GetRabbitMQDSN()
 	utils.Logger.Infof("use RabbitMQ DSN:%s", dsn)
 
@@ -19,351 +72,474 @@ func connectRabbitMQ(cfg *config.Config) (*amqp.Connection, error) {
 		return nil, err
 	}
 
-	return conn, nil
+	service := &RabbitMQService{
+		dsn:        dsn,
+		management: NewRabbitMQManagement(cfg),
+		connected:  make(chan struct{}),
+		declared:   map[string]*amqp.Connection{},
+		done:       make(chan struct{}),
+		channels:   make(chan *publishChannel, RABBITMQ_CHANNEL_POOL_SIZE),
+	}
+	go service.keepConnected(conn)
+	return service, nil
 }
 
-// GetChannel creates and returns a new channel from the connection
-func GetChannel() (*amqp.Channel, error) {
-	if RabbitMQConn == nil {
-		return nil, fmt.Errorf("RabbitMQ connection is not initialized")
+// keepConnected makes conn the connection in use and dials the broker again whenever it is lost, until Close
+func (s *RabbitMQService) keepConnected(conn *amqp.Connection) {
+	for {
+		lost := conn.NotifyClose(make(chan *amqp.Error, 1))
+		s.restoreTopology(conn)
+
+		select {
+		case <-s.done:
+			return
+		case amqpErr := <-lost:
+			if s.isClosed() {
+				return
+			}
+			utils.Logger.Warnf("RabbitMQ connection lost, reconnecting: %v", amqpErr)
+		}
+		s.mu.Lock()
+		s.conn = nil
+		s.connected = make(chan struct{})
+		s.mu.Unlock()
+
+		if conn = s.redial(); conn == nil {
+			return
+		}
+		utils.Logger.Info("success to reconnect to RabbitMQ")
 	}
-	return RabbitMQConn.Channel()
 }
 
-// WorkQueue represents a work queue pattern implementation
-type WorkQueue struct {
-	Channel *amqp.Channel
-	Queue   amqp.Queue
+// redial dials the broker with backoff until it answers, nil once the service is closed
+func (s *RabbitMQService) redial() *amqp.Connection {
+	for attempts := 1; ; attempts++ {
+		delay := utils.Backoff(RABBITMQ_RECONNECT_BASE_DELAY, RABBITMQ_RECONNECT_MAX_DELAY, attempts)
+		select {
+		case <-s.done:
+			return nil
+		case <-time.After(delay + rand.N(delay/5+1)):
+		}
+		conn, err := amqp.Dial(s.dsn)
+		if err == nil {
+			return conn
+		}
+		utils.Logger.Warnf("Failed to reconnect to RabbitMQ, attempt %d: %v", attempts, err)
+	}
 }
 
-// ========== Work Queue Pattern ==========
-//
-// NewWorkQueue creates a new work queue
-func NewWorkQueue(queueName string) (*WorkQueue, error) {
-	ch, err := GetChannel()
-	if err != nil {
-		return nil, err
+// restoreTopology declares the recorded topology on a new connection, then makes it the connection in use.
+// Declarations recorded meanwhile are declared too before anyone gets the connection.
+func (s *RabbitMQService) restoreTopology(conn *amqp.Connection) {
+	declared := 0
+	for {
+		s.mu.Lock()
+		pending := slices.Clone(s.topology[declared:])
+		if len(pending) == 0 {
+			s.conn = conn
+			close(s.connected)
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		for _, declaration := range pending {
+			if err := declareOn(conn, declaration.declare); err != nil {
+				utils.Logger.Errorf("Failed to declare RabbitMQ %s again: %v", declaration.key, err)
+				continue
+			}
+			s.mu.Lock()
+			s.declared[declaration.key] = conn
+			s.mu.Unlock()
+		}
+		declared += len(pending)
 	}
+}
 
-	q, err := ch.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
+func declareOn(conn *amqp.Connection, declare func(ch *amqp.Channel) error) error {
+	ch, err := conn.Channel()
 	if err != nil {
-		ch.Close()
-		return nil, err
+		return err
 	}
+	defer ch.Close()
+	return declare(ch)
+}
 
-	return &WorkQueue{
-		Channel: ch,
-		Queue:   q,
-	}, nil
-}
-
-// Publish publishes a message to the work queue
-func (wq *WorkQueue) Publish(message []byte) error {
-	return wq.Channel.Publish(
-		"",            // exchange
-		wq.Queue.Name, // routing key
-		false,         // mandatory
-		false,         // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         message,
-		})
-}
-
-// Consume starts consuming messages from the work queue
-func (wq *WorkQueue) Consume() (<-chan amqp.Delivery, error) {
-	return wq.Channel.Consume(
-		wq.Queue.Name, // queue
-		"",            // consumer
-		false,         // auto-ack
-		false,         // exclusive
-		false,         // no-local
-		false,         // no-wait
-		nil,           // args
-	)
+// declare declares an exchange, queue or binding, and records it so that it is declared again on every new
+// connection. While reconnecting, it is only recorded. A key already declared on the connection in use is skipped.
+func (s *RabbitMQService) declare(key string, declare func(ch *amqp.Channel) error) error {
+	s.mu.Lock()
+	if !slices.ContainsFunc(s.topology, func(d topologyDeclaration) bool { return d.key == key }) {
+		s.topology = append(s.topology, topologyDeclaration{key: key, declare: declare})
+	}
+	conn := s.conn
+	if conn == nil || s.declared[key] == conn {
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	if err := declareOn(conn, declare); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.declared[key] = conn
+	s.mu.Unlock()
+	return nil
 }
 
-// Close closes the work queue channel
-func (wq *WorkQueue) Close() error {
-	return wq.Channel.Close()
+// DeclareExchange declares a durable exchange of a kind: "fanout", "direct" or "topic"
+func (s *RabbitMQService) DeclareExchange(exchangeName string, kind string) error {
+	return s.declare("exchange "+exchangeName, func(ch *amqp.Channel) error {
+		return ch.ExchangeDeclare(
+			exchangeName, // name
+			kind,         // type
+			true,         // durable
+			false,        // auto-deleted
+			false,        // internal
+			false,        // no-wait
+			nil,          // arguments
+		)
+	})
 }
 
-// PublishSubscribe represents a publish/subscribe pattern implementation
-type PublishSubscribe struct {
-	Channel  *amqp.Channel
-	Exchange string
+// connection waits for the connection in use
+func (s *RabbitMQService) connection(ctx context.Context) (*amqp.Connection, error) {
+	for {
+		s.mu.Lock()
+		conn, connected, closed := s.conn, s.connected, s.closed
+		s.mu.Unlock()
+		if closed {
+			return nil, ErrRabbitMQClosed
+		}
+		if conn != nil {
+			return conn, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.done:
+		case <-connected:
+		}
+	}
 }
 
-// ========== Publish/Subscribe Pattern ==========
-//
-// NewPublishSubscribe creates a new publish/subscribe setup
-func NewPublishSubscribe(exchangeName string) (*PublishSubscribe, error) {
-	ch, err := GetChannel()
+// acquire takes an idle publishing channel, or opens one
+func (s *RabbitMQService) acquire(ctx context.Context) (*publishChannel, error) {
+	for {
+		select {
+		case ch := <-s.channels:
+			if ch.IsClosed() {
+				continue
+			}
+			return ch, nil
+		default:
+		}
+
+		conn, err := s.connection(ctx)
+		if err != nil {
+			return nil, err
+		}
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+		if err := ch.Confirm(false); err != nil {
+			ch.Close()
+			return nil, err
+		}
+		// A single message is in flight on a channel, its return comes before its confirmation
+		return &publishChannel{Channel: ch, returns: ch.NotifyReturn(make(chan amqp.Return, 1))}, nil
+	}
+}
+
+// release hands a publishing channel back to the pool
+func (s *RabbitMQService) release(ch *publishChannel) {
+	if ch.IsClosed() {
+		return
+	}
+	select {
+	case s.channels <- ch:
+	default:
+		ch.Close()
+	}
+}
+
+// Publish publishes a persistent message and waits for the broker to confirm it, waiting for the connection
+// while it is being recovered. A mandatory message no queue takes fails with ErrMessageReturned.
+func (s *RabbitMQService) Publish(ctx context.Context, exchange string, routingKey string, mandatory bool, message amqp.Publishing) error {
+	ch, err := s.acquire(ctx)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	message.DeliveryMode = amqp.Persistent
+	if message.ContentType == "" {
+		message.ContentType = "text/plain"
 	}
 
-	err = ch.ExchangeDeclare(
-		exchangeName, // name
-		"fanout",     // type
-		true,         // durable
-		false,        // auto-deleted
-		false,        // internal
-		false,        // no-wait
-		nil,          // arguments
-	)
+	confirmation, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, routingKey, mandatory, false, message)
 	if err != nil {
 		ch.Close()
-		return nil, err
+		return err
 	}
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		// The outcome is unknown, a late return must not be taken for the next message's
+		ch.Close()
+		return err
+	}
+	// The return is taken before anyone else may publish on the channel
+	err = PublishOutcome(acked, ch.returns, exchange, routingKey)
+	s.release(ch)
+	return err
+}
 
-	return &PublishSubscribe{
-		Channel:  ch,
-		Exchange: exchangeName,
-	}, nil
+// PublishOutcome tells how a confirmed message fared: returned when the broker handed it back on returns before
+// confirming it, as it does with a mandatory message no queue takes, else acknowledged or rejected
+func PublishOutcome(acked bool, returns <-chan amqp.Return, exchange string, routingKey string) error {
+	select {
+	case returned := <-returns:
+		return fmt.Errorf("%w to %q/%q: %s", ErrMessageReturned, exchange, routingKey, returned.ReplyText)
+	default:
+	}
+	if !acked {
+		return ErrMessageNacked
+	}
+	return nil
 }
 
-// Publish broadcasts a message to all bound queues
-func (ps *PublishSubscribe) Publish(message []byte) error {
-	return ps.Channel.Publish(
-		ps.Exchange, // exchange
-		"",          // routing key (ignored for fanout)
-		false,       // mandatory
-		false,       // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         message,
-		})
-}
-
-// Subscribe creates a temporary queue and binds it to the exchange
-func (ps *PublishSubscribe) Subscribe() (<-chan amqp.Delivery, error) {
-	// Create an exclusive queue with a random name
-	q, err := ps.Channel.QueueDeclare(
-		"",    // name (empty = auto-generated)
-		false, // durable
-		false, // delete when unused
-		true,  // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
+// Consume consumes a queue into the returned channel until ctx is done. setup declares and binds the queue
+// on a channel of every connection and returns its name. When the connection is lost, the consumer resumes
+// on the next one: deliveries of the lost connection can no longer be acknowledged, the broker hands them over again.
+func (s *RabbitMQService) Consume(ctx context.Context, autoAck bool, setup func(ch *amqp.Channel) (string, error)) (<-chan amqp.Delivery, error) {
+	ch, deliveries, err := s.consume(ctx, autoAck, setup)
 	if err != nil {
 		return nil, err
 	}
 
-	// Bind the queue to the exchange
-	err = ps.Channel.QueueBind(
-		q.Name,      // queue name
-		"",          // routing key (ignored for fanout)
-		ps.Exchange, // exchange
-		false,       // no-wait
-		nil,         // arguments
+	out := make(chan amqp.Delivery)
+	go func() {
+		defer close(out)
+		for {
+			forwardDeliveries(ctx, deliveries, out)
+			ch.Close()
+			for attempts := 1; ; attempts++ {
+				if ctx.Err() != nil || s.isClosed() {
+					return
+				}
+				if ch, deliveries, err = s.consume(ctx, autoAck, setup); err == nil {
+					break
+				}
+				utils.Logger.Warnf("Failed to resume RabbitMQ consumer, attempt %d: %v", attempts, err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(utils.Backoff(RABBITMQ_RECONNECT_BASE_DELAY, RABBITMQ_RECONNECT_MAX_DELAY, attempts)):
+				}
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *RabbitMQService) consume(ctx context.Context, autoAck bool, setup func(ch *amqp.Channel) (string, error)) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	conn, err := s.connection(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+	queueName, err := setup(ch)
+	if err != nil {
+		ch.Close()
+		return nil, nil, err
+	}
+	deliveries, err := ch.Consume(
+		queueName, // queue
+		"",        // consumer
+		autoAck,   // auto-ack
+		false,     // exclusive
+		false,     // no-local
+		false,     // no-wait
+		nil,       // args
 	)
 	if err != nil {
-		return nil, err
+		ch.Close()
+		return nil, nil, err
 	}
+	return ch, deliveries, nil
+}
 
-	return ps.Channel.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
+// forwardDeliveries forwards deliveries until their channel is closed or ctx is done
+func forwardDeliveries(ctx context.Context, deliveries <-chan amqp.Delivery, out chan<- amqp.Delivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case delivery, ok := <-deliveries:
+			if !ok {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case out <- delivery:
+			}
+		}
+	}
 }
 
-// Close closes the publish/subscribe channel
-func (ps *PublishSubscribe) Close() error {
-	return ps.Channel.Close()
+func (s *RabbitMQService) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
 }
 
-// Routing represents a routing pattern implementation
-type Routing struct {
-	Channel  *amqp.Channel
-	Exchange string
+// Close closes the connection for good, consumers stop and publishing fails with ErrRabbitMQClosed
+func (s *RabbitMQService) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	conn := s.conn
+	s.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
 }
 
-// ========== Routing Pattern ==========
+// WorkQueue represents a work queue pattern implementation
+type WorkQueue struct {
+	Client   *RabbitMQService
+	Topology RetryTopology
+}
+
+// ========== Work Queue Pattern ==========
 //
-// NewRouting creates a new routing setup
-func NewRouting(exchangeName string) (*Routing, error) {
-	ch, err := GetChannel()
-	if err != nil {
+// NewWorkQueue declares a durable work queue with its retry topology
+func NewWorkQueue(queueName string) (*WorkQueue, error) {
+	topology := NewRetryTopology(queueName)
+	if err := RabbitMQClient.DeclareRetryTopology(topology); err != nil {
 		return nil, err
 	}
+	return &WorkQueue{Client: RabbitMQClient, Topology: topology}, nil
+}
 
-	err = ch.ExchangeDeclare(
-		exchangeName, // name
-		"direct",     // type
-		true,         // durable
-		false,        // auto-deleted
-		false,        // internal
-		false,        // no-wait
-		nil,          // arguments
-	)
+// Publish publishes a message to the work queue, returned when the queue is gone
+func (wq *WorkQueue) Publish(ctx context.Context, message []byte) error {
+	id, err := utils.GenerateSnowID()
 	if err != nil {
-		ch.Close()
-		return nil, err
+		return err
 	}
+	return wq.Client.Publish(ctx, "", wq.Topology.Queue, true, amqp.Publishing{
+		MessageId: strconv.FormatUint(id, 10),
+		Body:      message,
+	})
+}
 
-	return &Routing{
-		Channel:  ch,
-		Exchange: exchangeName,
-	}, nil
+// Consume starts consuming messages from the work queue.
+// Messages must be acknowledged, or rejected without requeueing them to be retried later.
+func (wq *WorkQueue) Consume(ctx context.Context) (<-chan amqp.Delivery, error) {
+	return wq.Client.consumeWithRetry(ctx, wq.Topology, "")
 }
 
-// PublishWithKey publishes a message with a specific routing key
-func (r *Routing) PublishWithKey(routingKey string, message []byte) error {
-	return r.Channel.Publish(
-		r.Exchange, // exchange
-		routingKey, // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         message,
-		})
-}
-
-// SubscribeWithKey subscribes to messages with a specific routing key
-func (r *Routing) SubscribeWithKey(routingKey, queueName string) (<-chan amqp.Delivery, error) {
-	q, err := r.Channel.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
-	if err != nil {
+// PublishSubscribe represents a publish/subscribe pattern implementation
+type PublishSubscribe struct {
+	Client   *RabbitMQService
+	Exchange string
+}
+
+// ========== Publish/Subscribe Pattern ==========
+//
+// NewPublishSubscribe declares a fanout exchange
+func NewPublishSubscribe(exchangeName string) (*PublishSubscribe, error) {
+	if err := RabbitMQClient.DeclareExchange(exchangeName, "fanout"); err != nil {
 		return nil, err
 	}
+	return &PublishSubscribe{Client: RabbitMQClient, Exchange: exchangeName}, nil
+}
 
-	err = r.Channel.QueueBind(
-		q.Name,     // queue name
-		routingKey, // routing key
-		r.Exchange, // exchange
-		false,      // no-wait
-		nil,        // arguments
-	)
-	if err != nil {
+// Publish broadcasts a message to all bound queues
+func (ps *PublishSubscribe) Publish(ctx context.Context, message []byte) error {
+	return ps.Client.Publish(ctx, ps.Exchange, "", false, amqp.Publishing{Body: message})
+}
+
+// Subscribe consumes an exclusive queue bound to the exchange, created again when the consumer resumes.
+// Messages are acknowledged on delivery, a subscriber only gets those broadcast while it is connected.
+func (ps *PublishSubscribe) Subscribe(ctx context.Context) (<-chan amqp.Delivery, error) {
+	return ps.Client.Consume(ctx, true, func(ch *amqp.Channel) (string, error) {
+		// An exclusive queue with a random name
+		q, err := ch.QueueDeclare(
+			"",    // name (empty = auto-generated)
+			false, // durable
+			false, // delete when unused
+			true,  // exclusive
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return "", err
+		}
+		return q.Name, ch.QueueBind(q.Name, "", ps.Exchange, false, nil)
+	})
+}
+
+// Routing represents a routing pattern implementation
+type Routing struct {
+	Client   *RabbitMQService
+	Exchange string
+}
+
+// ========== Routing Pattern ==========
+//
+// NewRouting declares a direct exchange
+func NewRouting(exchangeName string) (*Routing, error) {
+	if err := RabbitMQClient.DeclareExchange(exchangeName, "direct"); err != nil {
 		return nil, err
 	}
+	return &Routing{Client: RabbitMQClient, Exchange: exchangeName}, nil
+}
 
-	return r.Channel.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
+// PublishWithKey publishes a message with a specific routing key
+func (r *Routing) PublishWithKey(ctx context.Context, routingKey string, message []byte) error {
+	return r.Client.Publish(ctx, r.Exchange, routingKey, false, amqp.Publishing{Body: message})
 }
 
-// Close closes the routing channel
-func (r *Routing) Close() error {
-	return r.Channel.Close()
+// SubscribeWithKey subscribes a durable queue with its retry topology to messages with a specific routing key.
+// Messages must be acknowledged, or rejected without requeueing them to be retried later.
+func (r *Routing) SubscribeWithKey(ctx context.Context, routingKey, queueName string) (<-chan amqp.Delivery, error) {
+	return r.Client.consumeWithRetry(ctx, NewRetryTopology(queueName), r.Exchange, routingKey)
 }
 
 // Topic represents a topic pattern implementation
 type Topic struct {
-	Channel  *amqp.Channel
+	Client   *RabbitMQService
 	Exchange string
 }
 
 // ========== Topic Pattern ==========
-
-// NewTopic creates a new topic setup
+//
+// NewTopic declares a topic exchange
 func NewTopic(exchangeName string) (*Topic, error) {
-	ch, err := GetChannel()
-	if err != nil {
-		return nil, err
-	}
-
-	err = ch.ExchangeDeclare(
-		exchangeName, // name
-		"topic",      // type
-		true,         // durable
-		false,        // auto-deleted
-		false,        // internal
-		false,        // no-wait
-		nil,          // arguments
-	)
-	if err != nil {
-		ch.Close()
-		return nil, err
-	}
-
-	return &Topic{
-		Channel:  ch,
-		Exchange: exchangeName,
-	}, nil
-}
-
-// PublishWithPattern publishes a message with a topic pattern
-func (t *Topic) PublishWithPattern(pattern string, message []byte) error {
-	return t.Channel.Publish(
-		t.Exchange, // exchange
-		pattern,    // routing key (topic pattern)
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         message,
-		})
-}
-
-// SubscribeWithPattern subscribes to messages matching a topic pattern
-func (t *Topic) SubscribeWithPattern(pattern, queueName string) (<-chan amqp.Delivery, error) {
-	q, err := t.Channel.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
-	if err != nil {
-		return nil, err
-	}
-
-	err = t.Channel.QueueBind(
-		q.Name,     // queue name
-		pattern,    // routing key (topic pattern)
-		t.Exchange, // exchange
-		false,      // no-wait
-		nil,        // arguments
-	)
-	if err != nil {
+	if err := RabbitMQClient.DeclareExchange(exchangeName, "topic"); err != nil {
 		return nil, err
 	}
+	return &Topic{Client: RabbitMQClient, Exchange: exchangeName}, nil
+}
 
-	return t.Channel.Consume(
-		q.Name, // queue
-		"",     // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
-	)
+// PublishWithPattern publishes a message with a topic routing key
+func (t *Topic) PublishWithPattern(ctx context.Context, routingKey string, message []byte) error {
+	return t.Client.Publish(ctx, t.Exchange, routingKey, false, amqp.Publishing{Body: message})
 }
 
-// Close closes the topic channel
-func (t *Topic) Close() error {
-	return t.Channel.Close()
+// SubscribeWithPattern subscribes a durable queue with its retry topology to messages matching topic patterns.
+// Messages must be acknowledged, or rejected without requeueing them to be retried later.
+func (t *Topic) SubscribeWithPattern(ctx context.Context, queueName string, patterns ...string) (<-chan amqp.Delivery, error) {
+	return t.Client.consumeWithRetry(ctx, NewRetryTopology(queueName), t.Exchange, patterns...)
 }
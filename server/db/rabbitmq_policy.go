@@ -0,0 +1,72 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"server/config"
+	"time"
+)
+
+// Priority of the policies set by the server, above the default 0 of policies matching every queue
+const RABBITMQ_POLICY_PRIORITY = 1
+
+// RabbitMQPolicy applies a definition, like a dead-letter exchange, to the queues or exchanges matching a pattern.
+// Unlike the arguments of a queue, it can be set and changed once the queue exists.
+type RabbitMQPolicy struct {
+	Pattern    string         `json:"pattern"`
+	ApplyTo    string         `json:"apply-to"` // "queues", "exchanges" or "all"
+	Definition map[string]any `json:"definition"`
+	Priority   int            `json:"priority"`
+}
+
+// RabbitMQManagement is the HTTP API of the RabbitMQ management plugin, setting the policies AMQP cannot
+type RabbitMQManagement struct {
+	URL      string
+	VHost    string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+func NewRabbitMQManagement(cfg *config.Config) *RabbitMQManagement {
+	vhost := cfg.RABBITMQ_VHOST
+	if vhost == "" {
+		vhost = "/"
+	}
+	return &RabbitMQManagement{
+		URL:      cfg.GetRabbitMQManagementURL(),
+		VHost:    vhost,
+		Username: cfg.RABBITMQ_USERNAME,
+		Password: cfg.RABBITMQ_PASSWORD,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// PutPolicy creates or replaces a policy of the virtual host
+func (m *RabbitMQManagement) PutPolicy(ctx context.Context, name string, policy RabbitMQPolicy) error {
+	body, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/api/policies/%s/%s", m.URL, url.PathEscape(m.VHost), url.PathEscape(name))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(m.Username, m.Password)
+
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("RabbitMQ management API answered %s to policy %s", resp.Status, name)
+	}
+	return nil
+}
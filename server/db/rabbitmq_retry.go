@@ -0,0 +1,325 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"server/config"
+	"server/utils"
+	"slices"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// Retries a message went through, set by the router on the messages it sends to a retry queue
+	RETRY_COUNT_HEADER = "x-retry-count"
+	// Why and when a message was dead-lettered
+	DEAD_LETTER_REASON_HEADER = "x-dead-letter-reason"
+	DEAD_LETTERED_AT_HEADER   = "x-dead-lettered-at"
+)
+
+// Publisher publishes a message and waits for the broker to confirm it, like RabbitMQService
+type Publisher interface {
+	Publish(ctx context.Context, exchange string, routingKey string, mandatory bool, message amqp.Publishing) error
+}
+
+// RetryTopology is a durable queue whose rejected messages are retried after a growing delay, then dead-lettered.
+// Consumers only reject a message they failed to handle, without requeueing it.
+// The dead-letter exchange of the queue is set by a policy, as the queue may exist already without one:
+//
+//	<queue> --reject--> <queue>.dlx --> <queue>.rejected --router--> <queue>.retry.<delay> --TTL--> <queue>
+//	                                                           \--> <queue>.dead once the retries are used up
+type RetryTopology struct {
+	Queue  string
+	Delays []time.Duration // Wait before each retry
+}
+
+// NewRetryTopology returns the topology of a queue with the retry delays of RABBITMQ_RETRY_DELAYS
+func NewRetryTopology(queueName string) RetryTopology {
+	return RetryTopology{Queue: queueName, Delays: config.Settings.GetRabbitMQRetryDelays()}
+}
+
+// DeadLetterExchange is the exchange the queue's rejected messages are dead-lettered to
+func (t RetryTopology) DeadLetterExchange() string {
+	return t.Queue + ".dlx"
+}
+
+// RejectedQueue collects the rejected messages for the router
+func (t RetryTopology) RejectedQueue() string {
+	return t.Queue + ".rejected"
+}
+
+// RetryQueue holds messages for a retry delay, then hands them back to the queue.
+// It is named after its delay, as the delay of a declared queue cannot change.
+func (t RetryTopology) RetryQueue(delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", t.Queue, delay.Milliseconds())
+}
+
+// DeadLetterPolicy returns the name of the policy dead-lettering the messages rejected from the queue, and the policy
+func (t RetryTopology) DeadLetterPolicy() (string, RabbitMQPolicy) {
+	return t.Queue + ".dead-letter", RabbitMQPolicy{
+		Pattern:    "^" + regexp.QuoteMeta(t.Queue) + "$",
+		ApplyTo:    "queues",
+		Definition: map[string]any{"dead-letter-exchange": t.DeadLetterExchange()},
+		Priority:   RABBITMQ_POLICY_PRIORITY,
+	}
+}
+
+// DeadQueue keeps the messages rejected after their last retry, until requeued or purged
+func (t RetryTopology) DeadQueue() string {
+	return t.Queue + ".dead"
+}
+
+// RetryCount returns the retries a message went through, from its x-retry-count header
+func RetryCount(headers amqp.Table) int {
+	switch count := headers[RETRY_COUNT_HEADER].(type) {
+	case int:
+		return count
+	case int8:
+		return int(count)
+	case int16:
+		return int(count)
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	default:
+		return 0
+	}
+}
+
+// DeclareRetryTopology declares the queue of a topology with its retry queues, dead-letter exchange and queues,
+// then sets the policy dead-lettering the queue through the management API. Without the policy, for instance when the
+// management plugin is disabled, the messages rejected from the queue would be dropped, so its failure is returned
+// for the queue not to be consumed. The queues are declared by then, messages may still be published to them.
+func (s *RabbitMQService) DeclareRetryTopology(t RetryTopology) error {
+	return s.declare("retry topology "+t.Queue, func(ch *amqp.Channel) error {
+		if err := ch.ExchangeDeclare(t.DeadLetterExchange(), "fanout", true, false, false, false, nil); err != nil {
+			return err
+		}
+		// The queue keeps the arguments it was first declared with, so that the ingestion worker may declare it too
+		if _, err := ch.QueueDeclare(t.Queue, true, false, false, false, nil); err != nil {
+			return err
+		}
+		if _, err := ch.QueueDeclare(t.RejectedQueue(), true, false, false, false, nil); err != nil {
+			return err
+		}
+		if err := ch.QueueBind(t.RejectedQueue(), "", t.DeadLetterExchange(), false, nil); err != nil {
+			return err
+		}
+		for _, delay := range t.Delays {
+			// Expired messages go back to the queue through the default exchange
+			if _, err := ch.QueueDeclare(t.RetryQueue(delay), true, false, false, false, amqp.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": t.Queue,
+			}); err != nil {
+				return err
+			}
+		}
+		if _, err := ch.QueueDeclare(t.DeadQueue(), true, false, false, false, nil); err != nil {
+			return err
+		}
+		name, policy := t.DeadLetterPolicy()
+		if err := s.management.PutPolicy(context.Background(), name, policy); err != nil {
+			return fmt.Errorf("failed to set the dead-letter policy of %s: %w", t.Queue, err)
+		}
+		return nil
+	})
+}
+
+// consumeWithRetry consumes the queue of a topology, bound to an exchange with routing keys or patterns,
+// with manual acknowledgements. The messages its consumer rejects are routed until ctx is done.
+func (s *RabbitMQService) consumeWithRetry(ctx context.Context, t RetryTopology, exchange string, keys ...string) (<-chan amqp.Delivery, error) {
+	if err := s.DeclareRetryTopology(t); err != nil {
+		return nil, err
+	}
+	deliveries, err := s.Consume(ctx, false, func(ch *amqp.Channel) (string, error) {
+		for _, key := range keys {
+			if err := ch.QueueBind(t.Queue, key, exchange, false, nil); err != nil {
+				return "", err
+			}
+		}
+		return t.Queue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	go s.RouteRejected(ctx, t)
+	return deliveries, nil
+}
+
+// RouteRejected sends the messages rejected from the queue of a topology to the retry queue of their next retry,
+// or to the dead queue once the retries are used up, until ctx is done. Every replica may run it.
+func (s *RabbitMQService) RouteRejected(ctx context.Context, t RetryTopology) {
+	if err := s.DeclareRetryTopology(t); err != nil {
+		utils.Logger.Errorf("Rejected messages of %s will not be retried, failed to declare its topology: %v", t.Queue, err)
+		return
+	}
+	deliveries, err := s.Consume(ctx, false, func(ch *amqp.Channel) (string, error) {
+		return t.RejectedQueue(), nil
+	})
+	if err != nil {
+		utils.Logger.Errorf("Rejected messages of %s will not be retried, failed to consume %s: %v", t.Queue, t.RejectedQueue(), err)
+		return
+	}
+
+	for delivery := range deliveries {
+		if err := RouteRejectedMessage(ctx, s, t, delivery); err != nil {
+			utils.Logger.Errorf("Failed to route rejected message %s of %s: %v", delivery.MessageId, t.Queue, err)
+			// Handed back for another try once the broker takes messages again
+			select {
+			case <-ctx.Done():
+			case <-time.After(RABBITMQ_RECONNECT_BASE_DELAY):
+			}
+			delivery.Nack(false, true)
+			continue
+		}
+		delivery.Ack(false)
+	}
+}
+
+// RouteRejectedMessage publishes a message rejected from the queue of a topology to the retry queue of its next retry,
+// or to the dead queue once the retries are used up
+func RouteRejectedMessage(ctx context.Context, publisher Publisher, t RetryTopology, delivery amqp.Delivery) error {
+	retries := RetryCount(delivery.Headers)
+	headers := maps.Clone(delivery.Headers)
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	if retries < len(t.Delays) {
+		headers[RETRY_COUNT_HEADER] = int32(retries + 1)
+		return publisher.Publish(ctx, "", t.RetryQueue(t.Delays[retries]), true, republished(delivery, headers))
+	}
+
+	utils.Logger.Warnf("Dead-lettering message %s of %s, rejected after %d retries", delivery.MessageId, t.Queue, retries)
+	headers[DEAD_LETTER_REASON_HEADER] = fmt.Sprintf("rejected after %d retries", retries)
+	headers[DEAD_LETTERED_AT_HEADER] = time.Now()
+	return publisher.Publish(ctx, "", t.DeadQueue(), true, republished(delivery, headers))
+}
+
+// DeadLetter sends a message its consumer cannot ever handle, like a malformed one, straight to the dead queue
+// of its topology and acknowledges it
+func (s *RabbitMQService) DeadLetter(ctx context.Context, t RetryTopology, delivery amqp.Delivery, reason string) error {
+	headers := maps.Clone(delivery.Headers)
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[DEAD_LETTER_REASON_HEADER] = reason
+	headers[DEAD_LETTERED_AT_HEADER] = time.Now()
+	if err := s.Publish(ctx, "", t.DeadQueue(), true, republished(delivery, headers)); err != nil {
+		return err
+	}
+	return delivery.Ack(false)
+}
+
+// PeekDeadLetters returns up to limit messages of the dead queue of a topology, oldest first, without taking them,
+// and how many it holds
+func (s *RabbitMQService) PeekDeadLetters(ctx context.Context, t RetryTopology, limit int) (total int, messages []amqp.Delivery, err error) {
+	err = s.withChannel(ctx, func(ch *amqp.Channel) error {
+		queue, err := ch.QueueDeclarePassive(t.DeadQueue(), true, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		total = queue.Messages
+		for len(messages) < min(limit, total) {
+			delivery, ok, err := ch.Get(t.DeadQueue(), false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			messages = append(messages, delivery)
+		}
+		// Closing the channel hands the messages back unacknowledged
+		return nil
+	})
+	return total, messages, err
+}
+
+// RequeueDeadLetters moves messages of the dead queue of a topology back to its queue, their retries reset:
+// those with the given message IDs, or all of them when none is given
+func (s *RabbitMQService) RequeueDeadLetters(ctx context.Context, t RetryTopology, messageIDs []string) (requeued int, err error) {
+	err = s.withChannel(ctx, func(ch *amqp.Channel) error {
+		queue, err := ch.QueueDeclarePassive(t.DeadQueue(), true, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+		// Messages left unacknowledged are handed back when the channel closes, so each one is seen once
+		for range queue.Messages {
+			delivery, ok, err := ch.Get(t.DeadQueue(), false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			switch ok, err := RequeueDeadLetter(ctx, s, t, delivery, messageIDs); {
+			case err != nil:
+				return err
+			case ok:
+				requeued++
+			}
+		}
+		return nil
+	})
+	return requeued, err
+}
+
+// RequeueDeadLetter publishes a message got from the dead queue of a topology back to its queue, its retries reset,
+// and acknowledges it. When message IDs are given, a message with none of them is left alone.
+func RequeueDeadLetter(ctx context.Context, publisher Publisher, t RetryTopology, delivery amqp.Delivery, messageIDs []string) (requeued bool, err error) {
+	if len(messageIDs) > 0 && !slices.Contains(messageIDs, delivery.MessageId) {
+		return false, nil
+	}
+	headers := maps.Clone(delivery.Headers)
+	delete(headers, RETRY_COUNT_HEADER)
+	delete(headers, DEAD_LETTER_REASON_HEADER)
+	delete(headers, DEAD_LETTERED_AT_HEADER)
+	if err := publisher.Publish(ctx, "", t.Queue, true, republished(delivery, headers)); err != nil {
+		return false, err
+	}
+	return true, delivery.Ack(false)
+}
+
+// PurgeDeadLetters deletes the messages of the dead queue of a topology
+func (s *RabbitMQService) PurgeDeadLetters(ctx context.Context, t RetryTopology) (purged int, err error) {
+	err = s.withChannel(ctx, func(ch *amqp.Channel) error {
+		purged, err = ch.QueuePurge(t.DeadQueue(), false)
+		return err
+	})
+	return purged, err
+}
+
+// withChannel runs fn on a channel of its own, closed afterwards
+func (s *RabbitMQService) withChannel(ctx context.Context, fn func(ch *amqp.Channel) error) error {
+	conn, err := s.connection(ctx)
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+	return fn(ch)
+}
+
+// republished copies a delivered message to publish it again with other headers
+func republished(delivery amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		CorrelationId:   delivery.CorrelationId,
+		MessageId:       delivery.MessageId,
+		Timestamp:       delivery.Timestamp,
+		Type:            delivery.Type,
+		AppId:           delivery.AppId,
+		Body:            delivery.Body,
+	}
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"server/models/common/response"
+	"server/utils"
+
+	"github.com/labstack/echo/v5"
+)
+
+// AdminMiddleware only lets administrators through, it must run after TokenMiddleware
+func AdminMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx *echo.Context) error {
+			currentUser, err := utils.GetCurrentUser(ctx)
+			if err != nil {
+				return response.ErrInvalidToken()
+			}
+			if !currentUser.IsAdmin {
+				return response.ErrAdminRequired()
+			}
+			return next(ctx)
+		}
+	}
+}
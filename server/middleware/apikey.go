@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v5"
+)
+
+// Context key of the SHA-256 hash of the API key authenticating the request
+const API_KEY_HASH_CONTEXT_KEY = "api_key_hash"
+
+// APIKeyMiddleware authenticates OpenAI-compatible API requests with a user API key sent as a bearer token.
+// The key's owner is stored in the context like TokenMiddleware does, so utils.GetCurrentUser works the same.
+func APIKeyMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx *echo.Context) error {
+			key, ok := strings.CutPrefix(ctx.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if key = strings.TrimSpace(key); !ok || key == "" {
+				return response.OpenAIFail(ctx, http.StatusUnauthorized, models.OPENAI_ERROR_AUTHENTICATION, "missing_api_key",
+					"You didn't provide an API key. Send it in the Authorization header as 'Bearer YOUR_KEY'.")
+			}
+
+			user, err := service.APIKeyServiceApp.AuthenticateAPIKey(ctx.Request().Context(), key)
+			switch {
+			case errors.Is(err, service.ErrNotFound):
+				return response.OpenAIFail(ctx, http.StatusUnauthorized, models.OPENAI_ERROR_AUTHENTICATION, "invalid_api_key", "Incorrect API key provided.")
+			case err != nil:
+				utils.Logger.Error(err)
+				return response.OpenAIFail(ctx, http.StatusInternalServerError, models.OPENAI_ERROR_SERVER, "", "Failed to verify the API key.")
+			}
+
+			ctx.Set("user", &jwt.Token{
+				Valid:  true,
+				Claims: &utils.JwtCustomClaims{ID: user.ID, IsAdmin: user.Role == "admin"},
+			})
+			ctx.Set(API_KEY_HASH_CONTEXT_KEY, utils.HashUserAPIKey(key))
+			return next(ctx)
+		}
+	}
+}
@@ -7,6 +7,9 @@ import (
 
 func InitMiddleWares(e *echo.Echo) {
 	e.HTTPErrorHandler = CustomHTTPErrorHandler()
+	// X-Forwarded-For is only believed when sent by proxies on private networks, clients cannot forge their IP
+	// address to escape the rate limits keyed by it
+	e.IPExtractor = echo.ExtractIPFromXFFHeader()
 	e.Use(LoggerMiddleware())
 	e.Use(middleware.Recover())
 }
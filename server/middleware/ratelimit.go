@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"server/config"
+	"server/models"
+	"server/models/common/response"
+	"server/service"
+	"server/utils"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v5"
+)
+
+// RateLimitMiddleware limits the requests each client makes to a route group within a sliding window.
+// Clients are told their budget in the RateLimit-* headers, and when to come back in Retry-After once it is spent.
+// The client is the API key, else the authenticated user, else the IP address, so it must run after the
+// authentication middleware of the group to limit users rather than addresses.
+func RateLimitMiddleware(group string, limit config.RateLimit) echo.MiddlewareFunc {
+	return rateLimit(group, limit, func(ctx *echo.Context) error {
+		return response.ErrTooManyRequests()
+	})
+}
+
+// OpenAIRateLimitMiddleware is RateLimitMiddleware answering with the error body OpenAI SDK clients parse
+func OpenAIRateLimitMiddleware(group string, limit config.RateLimit) echo.MiddlewareFunc {
+	return rateLimit(group, limit, func(ctx *echo.Context) error {
+		return response.OpenAIFail(ctx, http.StatusTooManyRequests, models.OPENAI_ERROR_RATE_LIMIT, models.OPENAI_ERROR_RATE_LIMIT,
+			"Rate limit reached for requests, please retry later.")
+	})
+}
+
+func rateLimit(group string, limit config.RateLimit, onLimited func(ctx *echo.Context) error) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		if limit.Requests <= 0 {
+			return next
+		}
+		return func(ctx *echo.Context) error {
+			result, err := service.RateLimitServiceApp.Allow(ctx.Request().Context(), group, rateLimitClient(ctx), limit)
+			if err != nil {
+				// Redis being down should not take the API down with it
+				utils.Logger.Warnf("Rate limiter of %s unavailable, letting the request through: %v", group, err)
+				return next(ctx)
+			}
+
+			header := ctx.Response().Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, ceilSeconds(limit.Window)))
+			if !result.Allowed {
+				header.Set("Retry-After", strconv.Itoa(max(ceilSeconds(result.Reset), 1)))
+				return onLimited(ctx)
+			}
+			return next(ctx)
+		}
+	}
+}
+
+// rateLimitClient identifies who the request counts against
+func rateLimitClient(ctx *echo.Context) string {
+	if keyHash, err := echo.ContextGet[string](ctx, API_KEY_HASH_CONTEXT_KEY); err == nil && keyHash != "" {
+		return "key:" + keyHash
+	}
+	// Looked up directly, utils.GetCurrentUser logs an error on anonymous requests
+	if token, err := echo.ContextGet[*jwt.Token](ctx, "user"); err == nil {
+		if claims, ok := token.Claims.(*utils.JwtCustomClaims); ok {
+			return fmt.Sprintf("user:%d", claims.ID)
+		}
+	}
+	return "ip:" + ctx.RealIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
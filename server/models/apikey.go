@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+type APIKeyCreateReq struct {
+	Name string `json:"name" validate:"required,min=1,max=50"`
+}
+
+type APIKeyReq struct {
+	ID uint `param:"key_id" validate:"required"`
+}
+
+type APIKeyInfo struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// APIKeyCreateResp carries the plaintext key, which is only returned once
+type APIKeyCreateResp struct {
+	APIKeyInfo
+	Key string `json:"key"`
+}
+
+type APIKeyListResp struct {
+	Total int64        `json:"total"`
+	Keys  []APIKeyInfo `json:"keys"`
+}
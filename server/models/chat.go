@@ -0,0 +1,146 @@
+package models
+
+import "time"
+
+const (
+	DEFAULT_CHAT_PAGE_SIZE = 20
+)
+
+type ChatSessionCreateReq struct {
+	Title     string `json:"title" validate:"required,min=1,max=200"`
+	DatasetID uint   `json:"dataset_id" validate:"required"`
+}
+
+type ChatSessionRenameReq struct {
+	ID    uint   `json:"id" validate:"required"`
+	Title string `json:"title" validate:"required,min=1,max=200"`
+}
+
+type ChatSessionReq struct {
+	ID uint `param:"session_id" validate:"required"`
+}
+
+type ChatSessionListReq struct {
+	DatasetID uint `query:"dataset_id" validate:"omitempty"` // Empty means sessions of all datasets
+}
+
+type ChatSessionInfo struct {
+	ID           uint           `json:"id"`
+	Title        string         `json:"title"`
+	DatasetID    uint           `json:"dataset_id"`
+	ChatFallback *FallbackChain `json:"chat_fallback" gorm:"serializer:json"` // Null uses the chat chain of the dataset
+	OwnerID      uint           `json:"owner_id"`
+	CreatedAt    time.Time      `json:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+type ChatSessionListResp struct {
+	Total    int64             `json:"total"`
+	Sessions []ChatSessionInfo `json:"sessions"`
+}
+
+type ChatMessageListReq struct {
+	ID       uint `param:"session_id" validate:"required"`
+	Page     int  `query:"page" validate:"omitempty,min=1"`
+	PageSize int  `query:"page_size" validate:"omitempty,min=1,max=100"`
+}
+
+// ChatSource is a chunk cited by an answer
+type ChatSource struct {
+	ChunkID   uint   `json:"chunk_id"`
+	Content   string `json:"content"`
+	FileID    uint   `json:"file_id"`
+	FileName  string `json:"file_name"`
+	DatasetID uint   `json:"dataset_id"`
+}
+
+// ChatMessageInfo is a question of the user with its answer and cited sources
+type ChatMessageInfo struct {
+	ID        uint          `json:"id"`
+	Question  string        `json:"question"`
+	Answer    string        `json:"answer"`
+	Sources   []ChatSource  `json:"sources"`
+	Feedback  *FeedbackInfo `json:"feedback"` // Rating given to the answer, if any
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type ChatMessageListResp struct {
+	Total    int64             `json:"total"`
+	Messages []ChatMessageInfo `json:"messages"`
+}
+
+const (
+	CHAT_EVENT_THINKING = "thinking"
+	CHAT_EVENT_TEXT     = "text"
+	CHAT_EVENT_SOURCE   = "source"
+	CHAT_EVENT_ERROR    = "error"
+	CHAT_EVENT_USAGE    = "usage" // Tokens of the backend's LLM calls, kept by the gateway for the usage ledger
+	CHAT_EVENT_DONE     = "done"  // Sent by the gateway once the answer is persisted
+)
+
+// ChatStreamReq asks a question in a chat session, answered with the session's dataset as knowledge base
+type ChatStreamReq struct {
+	ID           uint   `param:"session_id" validate:"required"`
+	Query        string `json:"query" validate:"required,min=1,max=8000"`
+	ProviderID   uint   `json:"provider_id" validate:"omitempty"` // LLM provider, defaults to the dataset's provider
+	Model        string `json:"model" validate:"required,max=100"`
+	TopK         int    `json:"top_k" validate:"omitempty,min=1,max=100"`
+	SystemPrompt string `json:"system_prompt" validate:"omitempty,max=8000"`
+}
+
+// ChatStreamEvent is a line of the RAG backend stream, relayed to the client as a server-sent event
+type ChatStreamEvent struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+// ChatStreamDone is the payload of the final event, sent once the answer is saved in the session
+type ChatStreamDone struct {
+	MessageID uint      `json:"message_id"`
+	ChunkIDs  []uint    `json:"chunk_ids"` // Chunks cited by the answer
+	ServedBy  *ServedBy `json:"served_by"`
+}
+
+// ChatBackendSource is an entry of a "source" event, ID is the Milvus entity ID of the chunk
+type ChatBackendSource struct {
+	ID      int64   `json:"id"`
+	Content string  `json:"content"`
+	Score   float32 `json:"score"`
+}
+
+// ChatBackendUsage is the content of a "usage" event, summing the LLM calls made for the answer
+type ChatBackendUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// ChatBackendReq is the request of the RAG backend streaming chat endpoint
+type ChatBackendReq struct {
+	Query           string                     `json:"query"`
+	DatasetID       uint                       `json:"dataset_id"`
+	SessionID       uint                       `json:"session_id"`
+	LLMConfig       ChatBackendModelConfig     `json:"llm_config"`
+	EmbeddingConfig ChatBackendEmbeddingConfig `json:"embedding_config"`
+	RetrievalConfig ChatBackendRetrievalConfig `json:"retrieval_config"`
+	SystemPrompt    string                     `json:"system_prompt,omitempty"`
+}
+
+type ChatBackendModelConfig struct {
+	ModelName    string `json:"model_name"`
+	APIKey       string `json:"api_key"`
+	BaseURL      string `json:"base_url"`
+	ProviderType string `json:"provider_type"`
+}
+
+type ChatBackendEmbeddingConfig struct {
+	ModelName    string `json:"model_name"`
+	BaseURL      string `json:"base_url"`
+	APIKey       string `json:"api_key,omitempty"`
+	ProviderType string `json:"provider_type"` // "openai" or "ollama"
+	EmbedType    string `json:"embed_type"`    // "dense", "sparse" or "hybrid"
+}
+
+type ChatBackendRetrievalConfig struct {
+	TopK           int    `json:"top_k"`
+	CollectionName string `json:"collection_name,omitempty"` // Milvus collection of the dataset, the backend's default when empty
+}
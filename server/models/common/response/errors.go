@@ -110,3 +110,165 @@ func ErrFileNotFound() error {
 		Message: "File not found",
 	}
 }
+
+func ErrVectorStoreUnavailable() error {
+	return &echo.HTTPError{
+		Code:    http.StatusServiceUnavailable,
+		Message: "Vector store is unavailable",
+	}
+}
+
+func ErrRerankConfigIncomplete() error {
+	return &echo.HTTPError{
+		Code:    http.StatusBadRequest,
+		Message: "rerank_provider_id and rerank_model are required for remote rerankers",
+	}
+}
+
+func ErrReindexRequired() error {
+	return &echo.HTTPError{
+		Code:    http.StatusConflict,
+		Message: "Changing the embedding model or provider requires re-embedding the dataset, resend with reindex=true to start a reindex job",
+	}
+}
+
+func ErrReindexJobRunning() error {
+	return &echo.HTTPError{
+		Code:    http.StatusConflict,
+		Message: "A reindex job is already running for this dataset",
+	}
+}
+
+func ErrReindexJobNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "Reindex job not found",
+	}
+}
+
+func ErrNoEmbeddingModel() error {
+	return &echo.HTTPError{
+		Code:    http.StatusBadRequest,
+		Message: "The dataset has no embedding model, only keyword search works without one",
+	}
+}
+
+func ErrChatModeUnsupported() error {
+	return &echo.HTTPError{
+		Code:    http.StatusBadRequest,
+		Message: "Session chat cannot use Azure OpenAI providers, pick a provider of another mode",
+	}
+}
+
+func ErrEmbeddingModelUnavailable() error {
+	return &echo.HTTPError{
+		Code:    http.StatusBadRequest,
+		Message: "Embedding model is unavailable with the selected provider",
+	}
+}
+
+func ErrChatSessionNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "Chat session not found",
+	}
+}
+
+func ErrChatBackendUnavailable() error {
+	return &echo.HTTPError{
+		Code:    http.StatusBadGateway,
+		Message: "RAG backend is unavailable",
+	}
+}
+
+func ErrAPIKeyNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "API key not found",
+	}
+}
+
+func ErrAdminRequired() error {
+	return &echo.HTTPError{
+		Code:    http.StatusForbidden,
+		Message: "Administrator permission required",
+	}
+}
+
+func ErrChatMessageNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "Chat message not found",
+	}
+}
+
+func ErrFeedbackNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "Feedback not found",
+	}
+}
+
+func ErrNotEmbeddingModel() error {
+	return &echo.HTTPError{
+		Code:    http.StatusBadRequest,
+		Message: "Model is not an embedding model",
+	}
+}
+
+func ErrQuotaPlanNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "Quota plan not found",
+	}
+}
+
+func ErrQuotaPlanNameAlreadyExists() error {
+	return &echo.HTTPError{
+		Code:    http.StatusForbidden,
+		Message: "quota plan with the same name already exists",
+	}
+}
+
+func ErrProviderGrantNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "Provider grant not found",
+	}
+}
+
+func ErrModelNotGranted() error {
+	return &echo.HTTPError{
+		Code:    http.StatusForbidden,
+		Message: "Model of the shared provider is not granted to the user",
+	}
+}
+
+// ErrQuotaExceeded tells which limit of the user's plan a request would exceed
+func ErrQuotaExceeded(msg string) error {
+	return &echo.HTTPError{
+		Code:    http.StatusForbidden,
+		Message: msg,
+	}
+}
+
+func ErrWebhookNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "Webhook not found",
+	}
+}
+
+func ErrWebhookDeliveryNotFound() error {
+	return &echo.HTTPError{
+		Code:    http.StatusNotFound,
+		Message: "Webhook delivery not found",
+	}
+}
+
+func ErrTooManyRequests() error {
+	return &echo.HTTPError{
+		Code:    http.StatusTooManyRequests,
+		Message: "Too many requests, please retry later",
+	}
+}
@@ -0,0 +1,18 @@
+package response
+
+import (
+	"server/models"
+
+	"github.com/labstack/echo/v5"
+)
+
+// OpenAIFail writes an error in the format of the OpenAI API, which OpenAI SDK clients parse
+func OpenAIFail(ctx *echo.Context, status int, errType string, code string, message string) error {
+	return ctx.JSON(status, models.OpenAIErrorResp{
+		Error: models.OpenAIError{
+			Message: message,
+			Type:    errType,
+			Code:    code,
+		},
+	})
+}
@@ -1,35 +1,53 @@
 package models
 
 type DatasetCreateReq struct {
-	Icon           string `json:"icon" validate:"required,emoji"`
-	Name           string `json:"name" validate:"required,min=1,max=100"`
-	Description    string `json:"description" validate:"max=500"`
-	SearchType     string `json:"search_type" validate:"required,oneof=sparse dense hybrid"`
-	EmbeddingModel string `json:"embedding_model" validate:"required"`
-	ProviderID     uint   `json:"provider_id" validate:"required"`
+	Icon             string   `json:"icon" validate:"required,emoji"`
+	Name             string   `json:"name" validate:"required,min=1,max=100"`
+	Description      string   `json:"description" validate:"max=500"`
+	SearchType       string   `json:"search_type" validate:"required,oneof=sparse dense hybrid keyword"`
+	EmbeddingModel   string   `json:"embedding_model" validate:"required_unless=SearchType keyword"` // Keyword datasets may go without, but cannot be chatted with
+	ProviderID       uint     `json:"provider_id" validate:"required"`
+	Tags             []string `json:"tags" validate:"omitempty,max=20,dive,min=1,max=30"`
+	RerankType       string   `json:"rerank_type" validate:"omitempty,oneof=none lexical cohere jina"`
+	RerankProviderID uint     `json:"rerank_provider_id" validate:"omitempty"`
+	RerankModel      string   `json:"rerank_model" validate:"omitempty,max=100"`
+	TextSearchConfig string   `json:"text_search_config" validate:"omitempty,oneof=simple danish dutch english finnish french german hungarian italian norwegian portuguese romanian russian spanish swedish turkish"`
 }
 
 type DatasetUpdateReq struct {
-	ID             uint   `json:"id" validate:"required"`
-	Icon           string `json:"icon" validate:"emoji"`
-	Name           string `json:"name" validate:"required,min=1,max=100"`
-	Description    string `json:"description" validate:"max=500"`
-	SearchType     string `json:"search_type" validate:"omitempty,oneof=sparse dense hybrid"`
-	EmbeddingModel string `json:"embedding_model" validate:"omitempty"`
-	ProviderID     uint   `json:"provider_id" validate:"omitempty"`
+	ID               uint     `json:"id" validate:"required"`
+	Icon             string   `json:"icon" validate:"emoji"`
+	Name             string   `json:"name" validate:"required,min=1,max=100"`
+	Description      string   `json:"description" validate:"max=500"`
+	SearchType       string   `json:"search_type" validate:"omitempty,oneof=sparse dense hybrid keyword"`
+	EmbeddingModel   string   `json:"embedding_model" validate:"omitempty"`
+	ProviderID       uint     `json:"provider_id" validate:"omitempty"`
+	Tags             []string `json:"tags" validate:"omitempty,max=20,dive,min=1,max=30"`
+	RerankType       string   `json:"rerank_type" validate:"omitempty,oneof=none lexical cohere jina"` // Replaces the rerank provider and model too, "none" turns reranking off
+	RerankProviderID uint     `json:"rerank_provider_id" validate:"omitempty"`
+	RerankModel      string   `json:"rerank_model" validate:"omitempty,max=100"`
+	TextSearchConfig string   `json:"text_search_config" validate:"omitempty,oneof=simple danish dutch english finnish french german hungarian italian norwegian portuguese romanian russian spanish swedish turkish"`
+	Reindex          bool     `json:"reindex"` // Confirms re-embedding the dataset when the embedding model or provider changes
 }
 
 type DatasetInfo struct {
-	ID             uint   `json:"id"`
-	Icon           string `json:"icon"`
-	Name           string `json:"name"`
-	Description    string `json:"description"`
-	SearchType     string `json:"search_type"`
-	EmbeddingModel string `json:"embedding_model"`
-	ProviderID     uint   `json:"provider_id"`
-	OwnerID        uint   `json:"owner_id"`
-	CreatedAt      string `json:"created_at"`
-	UpdatedAt      string `json:"updated_at"`
+	ID                uint          `json:"id"`
+	Icon              string        `json:"icon"`
+	Name              string        `json:"name"`
+	Description       string        `json:"description"`
+	SearchType        string        `json:"search_type"`
+	EmbeddingModel    string        `json:"embedding_model"`
+	ProviderID        uint          `json:"provider_id"`
+	Tags              []string      `json:"tags" gorm:"serializer:json"`
+	RerankType        string        `json:"rerank_type"`
+	RerankProviderID  *uint         `json:"rerank_provider_id"`
+	RerankModel       string        `json:"rerank_model"`
+	TextSearchConfig  string        `json:"text_search_config"`
+	ChatFallback      FallbackChain `json:"chat_fallback" gorm:"serializer:json"`
+	EmbeddingFallback FallbackChain `json:"embedding_fallback" gorm:"serializer:json"`
+	OwnerID           uint          `json:"owner_id"`
+	CreatedAt         string        `json:"created_at"`
+	UpdatedAt         string        `json:"updated_at"`
 }
 
 type DatasetListResp struct {
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	DEFAULT_DEAD_LETTER_LIMIT = 20
+
+	// Queues whose messages are dead-lettered after their last retry
+	DEAD_LETTER_QUEUE_INGESTION      = "ingestion"      // Files to process by the ingestion worker, by default
+	DEAD_LETTER_QUEUE_WEBHOOK_EVENTS = "webhook_events" // Lifecycle events to turn into webhook deliveries
+)
+
+// DeadLetterListReq inspects the oldest dead-lettered messages of a queue, which stay queued
+type DeadLetterListReq struct {
+	Queue string `query:"queue" validate:"omitempty,oneof=ingestion webhook_events"`
+	Limit int    `query:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+// DeadLetterInfo is a message dead-lettered after its last retry, or at once when malformed
+type DeadLetterInfo struct {
+	MessageID      string          `json:"message_id"`
+	Retries        int             `json:"retries"`
+	Reason         string          `json:"reason"`
+	DeadLetteredAt *time.Time      `json:"dead_lettered_at"`
+	Payload        json.RawMessage `json:"payload" swaggertype:"object"` // The message body, as a JSON string when it is no JSON
+}
+
+type DeadLetterListResp struct {
+	Total    int              `json:"total"` // Messages in the dead-letter queue
+	Messages []DeadLetterInfo `json:"messages"`
+}
+
+// DeadLetterRequeueReq sends dead-lettered messages back to their queue, all of them when no ID is given
+type DeadLetterRequeueReq struct {
+	Queue      string   `json:"queue" validate:"omitempty,oneof=ingestion webhook_events"`
+	MessageIDs []string `json:"message_ids" validate:"omitempty,max=100"`
+}
+
+type DeadLetterPurgeReq struct {
+	Queue string `json:"queue" validate:"omitempty,oneof=ingestion webhook_events"`
+}
+
+type DeadLetterCountResp struct {
+	Count int `json:"count"`
+}
@@ -0,0 +1,40 @@
+package models
+
+// Error classes failing over to the next provider of a chain that sets no failover_on
+var DEFAULT_FAILOVER_ON = []string{PROVIDER_ERROR_TIMEOUT, PROVIDER_ERROR_SERVER, PROVIDER_ERROR_RATE_LIMIT, PROVIDER_ERROR_CONNECTION}
+
+// FallbackTarget is a provider and model a call fails over to
+type FallbackTarget struct {
+	ProviderID uint   `json:"provider_id" validate:"required"`
+	Model      string `json:"model" validate:"required,max=100"`
+}
+
+// FallbackChain lists the providers and models tried in order when the primary one fails
+// with an error of one of the FailoverOn classes (see PROVIDER_ERROR_*)
+type FallbackChain struct {
+	Targets    []FallbackTarget `json:"targets" validate:"max=5,dive"`
+	FailoverOn []string         `json:"failover_on" validate:"omitempty,max=10,dive,oneof=timeout server rate_limit connection auth not_found dns tls bad_request unknown"`
+	// Embedding chains only: dimension shared by the dataset's model and every target, checked when the chain is set
+	Dimension int `json:"dimension,omitempty"`
+}
+
+// DatasetFallbackReq sets the chains of a dataset, empty targets turn failover off
+type DatasetFallbackReq struct {
+	ID        uint          `json:"id" validate:"required"`
+	Chat      FallbackChain `json:"chat"`
+	Embedding FallbackChain `json:"embedding"` // Targets must embed with the dimension of the dataset's model
+}
+
+// ChatSessionFallbackReq sets the chat chain of a session, a null chain uses the chain of the session's dataset
+type ChatSessionFallbackReq struct {
+	ID   uint           `json:"session_id" validate:"required"`
+	Chat *FallbackChain `json:"chat"`
+}
+
+// ServedBy tells which provider and model answered, the primary one or a fallback target
+type ServedBy struct {
+	ProviderID   uint   `json:"provider_id"`
+	ProviderName string `json:"provider_name"`
+	Model        string `json:"model"`
+	Fallback     bool   `json:"fallback"`
+}
@@ -0,0 +1,82 @@
+package models
+
+import "time"
+
+const (
+	FEEDBACK_RATING_UP   = 1
+	FEEDBACK_RATING_DOWN = -1
+
+	DEFAULT_FEEDBACK_REPORT_LIMIT = 20
+	// Length of the chunk content preview in feedback reports
+	FEEDBACK_REPORT_PREVIEW_LEN = 200
+)
+
+// FeedbackReq rates an answer. Wrong chunks can only be flagged on a thumbs down and must be cited by the answer.
+type FeedbackReq struct {
+	ID            uint   `param:"message_id" validate:"required"`
+	Rating        int    `json:"rating" validate:"required,oneof=1 -1"`
+	Reason        string `json:"reason" validate:"omitempty,max=2000"`
+	WrongChunkIDs []uint `json:"wrong_chunk_ids" validate:"omitempty,max=100"`
+}
+
+type FeedbackMessageReq struct {
+	ID uint `param:"message_id" validate:"required"`
+}
+
+type FeedbackInfo struct {
+	ID            uint      `json:"id"`
+	MessageID     uint      `json:"message_id"`
+	Rating        int       `json:"rating"`
+	Reason        string    `json:"reason"`
+	WrongChunkIDs []uint    `json:"wrong_chunk_ids"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type DatasetFeedbackReportReq struct {
+	DatasetID uint `param:"dataset_id" validate:"required"`
+	Days      int  `query:"days" validate:"omitempty,min=1,max=365"` // Empty means all time
+	Limit     int  `query:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+type FeedbackReportReq struct {
+	DatasetID uint `query:"dataset_id" validate:"omitempty"` // Empty means all datasets
+	Days      int  `query:"days" validate:"omitempty,min=1,max=365"`
+	Limit     int  `query:"limit" validate:"omitempty,min=1,max=100"`
+}
+
+// DatasetFeedbackStat counts the rated answers of a dataset
+type DatasetFeedbackStat struct {
+	DatasetID        uint    `json:"dataset_id"`
+	DatasetName      string  `json:"dataset_name"`
+	TotalFeedback    int64   `json:"total_feedback"`
+	NegativeFeedback int64   `json:"negative_feedback"`
+	NegativeRate     float64 `json:"negative_rate"`
+}
+
+// FileFeedbackStat counts the thumbs down given to answers citing a file, and its chunks flagged as wrong
+type FileFeedbackStat struct {
+	FileID           uint   `json:"file_id"`
+	FileName         string `json:"file_name"`
+	DatasetID        uint   `json:"dataset_id"`
+	NegativeFeedback int64  `json:"negative_feedback"`
+	WrongFlags       int64  `json:"wrong_flags"`
+}
+
+// ChunkFeedbackStat counts the thumbs down given to answers citing a chunk, and how often it was flagged as wrong
+type ChunkFeedbackStat struct {
+	ChunkID          uint   `json:"chunk_id"`
+	Content          string `json:"content"` // Leading characters of the chunk
+	FileID           uint   `json:"file_id"`
+	FileName         string `json:"file_name"`
+	DatasetID        uint   `json:"dataset_id"`
+	NegativeFeedback int64  `json:"negative_feedback"`
+	WrongFlags       int64  `json:"wrong_flags"`
+}
+
+// FeedbackReport ranks datasets, files and chunks by negative feedback, worst first
+type FeedbackReport struct {
+	Datasets []DatasetFeedbackStat `json:"datasets"`
+	Files    []FileFeedbackStat    `json:"files"`
+	Chunks   []ChunkFeedbackStat   `json:"chunks"`
+}
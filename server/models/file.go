@@ -69,4 +69,6 @@ type FileUploadMessage struct {
 	MinioPath string    `json:"minio_path"`
 	Timestamp time.Time `json:"timestamp"`
 	DatasetID uint      `json:"dataset_id"`
+	// Milvus collection the file's vectors are written to
+	CollectionName string `json:"collection_name"`
 }
@@ -0,0 +1,36 @@
+package models
+
+const (
+	LLM_ROLE_SYSTEM    = "system"
+	LLM_ROLE_USER      = "user"
+	LLM_ROLE_ASSISTANT = "assistant"
+
+	// Finish reasons use the OpenAI vocabulary whatever the provider mode
+	LLM_FINISH_STOP   = "stop"
+	LLM_FINISH_LENGTH = "length"
+
+	// Output limit sent to providers that require one (Anthropic) when the caller sets none
+	DEFAULT_LLM_MAX_TOKENS = 4096
+)
+
+// LLMMessage is a provider independent chat message
+type LLMMessage struct {
+	Role    string // "system", "user" or "assistant"
+	Content string
+}
+
+// LLMParams are the optional sampling parameters of a chat completion
+type LLMParams struct {
+	Temperature *float64
+	TopP        *float64
+	MaxTokens   int64 // Zero means the provider default
+	Stop        []string
+}
+
+// LLMResult is the complete answer of a chat completion
+type LLMResult struct {
+	Content          string
+	FinishReason     string // LLM_FINISH_STOP or LLM_FINISH_LENGTH
+	PromptTokens     int64
+	CompletionTokens int64
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	OPENAI_OBJECT_LIST                  = "list"
+	OPENAI_OBJECT_MODEL                 = "model"
+	OPENAI_OBJECT_CHAT_COMPLETION       = "chat.completion"
+	OPENAI_OBJECT_CHAT_COMPLETION_CHUNK = "chat.completion.chunk"
+
+	// Completion model IDs look like "dataset-3/5/gpt-4o-mini": the dataset answering from,
+	// then the provider and model generating the answer. The model name may contain slashes.
+	OPENAI_MODEL_DATASET_PREFIX = "dataset-"
+
+	OPENAI_ERROR_INVALID_REQUEST = "invalid_request_error"
+	OPENAI_ERROR_AUTHENTICATION  = "authentication_error"
+	OPENAI_ERROR_UPSTREAM        = "upstream_error"
+	OPENAI_ERROR_SERVER          = "server_error"
+	OPENAI_ERROR_QUOTA           = "insufficient_quota"
+	OPENAI_ERROR_RATE_LIMIT      = "rate_limit_exceeded"
+)
+
+// OpenAIChatCompletionReq follows the OpenAI chat completions request, unsupported fields (tools, n, ...) are ignored
+type OpenAIChatCompletionReq struct {
+	Model               string              `json:"model" validate:"required"`
+	Messages            []OpenAIChatMessage `json:"messages" validate:"required,min=1,max=200,dive"`
+	Stream              bool                `json:"stream"`
+	StreamOptions       *OpenAIStreamOption `json:"stream_options"`
+	Temperature         *float64            `json:"temperature" validate:"omitempty,min=0,max=2"`
+	TopP                *float64            `json:"top_p" validate:"omitempty,min=0,max=1"`
+	MaxTokens           int64               `json:"max_tokens" validate:"omitempty,min=1"`
+	MaxCompletionTokens int64               `json:"max_completion_tokens" validate:"omitempty,min=1"`
+	Stop                OpenAIStop          `json:"stop" validate:"omitempty,max=4"`
+	// Extension: number of passages retrieved from the dataset
+	TopK int `json:"top_k" validate:"omitempty,min=1,max=50"`
+}
+
+type OpenAIChatMessage struct {
+	Role    string               `json:"role" validate:"required,oneof=system developer user assistant"`
+	Content OpenAIMessageContent `json:"content"`
+}
+
+// OpenAIMessageContent accepts both a plain string and an array of content parts, only text parts are kept
+type OpenAIMessageContent string
+
+func (this *OpenAIMessageContent) UnmarshalJSON(data []byte) error {
+	var text *string
+	if err := json.Unmarshal(data, &text); err == nil {
+		if text != nil {
+			*this = OpenAIMessageContent(*text)
+		}
+		return nil
+	}
+
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	*this = OpenAIMessageContent(strings.Join(texts, "\n"))
+	return nil
+}
+
+// OpenAIStop accepts both a single stop sequence and an array of them
+type OpenAIStop []string
+
+func (this *OpenAIStop) UnmarshalJSON(data []byte) error {
+	var stop *string
+	if err := json.Unmarshal(data, &stop); err == nil {
+		if stop != nil {
+			*this = OpenAIStop{*stop}
+		}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(this))
+}
+
+type OpenAIStreamOption struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAICitation is a passage of the dataset given to the model, numbered as the answer cites it ([1], [2], ...)
+type OpenAICitation struct {
+	Index     int     `json:"index"`
+	ChunkID   uint    `json:"chunk_id"`
+	FileID    uint    `json:"file_id"`
+	FileName  string  `json:"file_name"`
+	DatasetID uint    `json:"dataset_id"`
+	Content   string  `json:"content"`
+	Score     float32 `json:"score"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+type OpenAIResponseMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type OpenAIChatCompletionChoice struct {
+	Index        int                   `json:"index"`
+	Message      OpenAIResponseMessage `json:"message"`
+	FinishReason string                `json:"finish_reason"`
+}
+
+// OpenAIChatCompletionResp is a "chat.completion" object, citations and served_by are extension fields
+type OpenAIChatCompletionResp struct {
+	ID        string                       `json:"id"`
+	Object    string                       `json:"object"`
+	Created   int64                        `json:"created"`
+	Model     string                       `json:"model"`
+	Choices   []OpenAIChatCompletionChoice `json:"choices"`
+	Usage     OpenAIUsage                  `json:"usage"`
+	Citations []OpenAICitation             `json:"citations"`
+	ServedBy  *ServedBy                    `json:"served_by,omitempty"`
+}
+
+type OpenAIChunkDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type OpenAIChatCompletionChunkChoice struct {
+	Index        int              `json:"index"`
+	Delta        OpenAIChunkDelta `json:"delta"`
+	FinishReason *string          `json:"finish_reason"`
+}
+
+// OpenAIChatCompletionChunk is a "chat.completion.chunk" object.
+// Citations and served_by are sent once, in the first chunk; usage only in the last one when requested.
+type OpenAIChatCompletionChunk struct {
+	ID        string                            `json:"id"`
+	Object    string                            `json:"object"`
+	Created   int64                             `json:"created"`
+	Model     string                            `json:"model"`
+	Choices   []OpenAIChatCompletionChunkChoice `json:"choices"`
+	Usage     *OpenAIUsage                      `json:"usage,omitempty"`
+	Citations []OpenAICitation                  `json:"citations,omitempty"`
+	ServedBy  *ServedBy                         `json:"served_by,omitempty"`
+}
+
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type OpenAIModelList struct {
+	Object string        `json:"object"`
+	Data   []OpenAIModel `json:"data"`
+}
+
+type OpenAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"`
+}
+
+// OpenAIErrorResp is the error body OpenAI SDK clients parse
+type OpenAIErrorResp struct {
+	Error OpenAIError `json:"error"`
+}
@@ -3,13 +3,41 @@ package models
 import "time"
 
 const (
-	PROVIDER_MODE_OPENAI      = "openai"
-	PROVIDER_MODE_OPENAI_RESP = "openai_response"
-	PROVIDER_MODE_GEMINI      = "gemini"
-	PROVIDER_MODE_ANTHROPIC   = "anthropic"
-	PROVIDER_MODE_OLLAMA      = "ollama"
+	PROVIDER_MODE_OPENAI            = "openai"
+	PROVIDER_MODE_OPENAI_RESP       = "openai_response"
+	PROVIDER_MODE_OPENAI_COMPATIBLE = "openai_compatible" // Gateways and self-hosted servers speaking the OpenAI API
+	PROVIDER_MODE_AZURE_OPENAI      = "azure_openai"
+	PROVIDER_MODE_MISTRAL           = "mistral"
+	PROVIDER_MODE_GEMINI            = "gemini"
+	PROVIDER_MODE_ANTHROPIC         = "anthropic"
+	PROVIDER_MODE_OLLAMA            = "ollama"
+
+	// GA version used when an Azure OpenAI provider sets none
+	AZURE_OPENAI_DEFAULT_API_VERSION = "2024-10-21"
+
+	// Retries of a failed provider call when the provider sets none
+	PROVIDER_DEFAULT_MAX_RETRIES = 2
 )
 
+// ProviderSettings holds the settings some modes need beyond the base URL and the API key,
+// and how requests of this server reach the provider. The RAG backend answering session chat does not apply
+// the headers, proxy, TLS, timeout, retry and limit settings.
+type ProviderSettings struct {
+	APIVersion         string            `json:"api_version" validate:"omitempty,max=30"`                                      // azure_openai: api-version query parameter
+	PathPrefix         string            `json:"path_prefix" validate:"omitempty,startswith=/,max=100"`                        // openai_compatible: path appended to the base URL, like "/v1"
+	Deployments        []string          `json:"deployments" validate:"omitempty,max=100,dive,min=1,max=100"`                  // azure_openai: deployment names, listed instead of asking Azure
+	Headers            map[string]string `json:"headers" validate:"omitempty,max=20,dive,keys,min=1,max=100,endkeys,max=2000"` // Extra request headers, stored encrypted
+	ProxyURL           string            `json:"proxy_url" validate:"omitempty,url,max=500"`                                   // HTTP(S) proxy, the environment's proxy when empty, stored encrypted
+	CACert             string            `json:"ca_cert" validate:"omitempty,max=65536"`                                       // PEM bundle trusted besides the system CAs
+	InsecureSkipVerify bool              `json:"insecure_skip_verify"`                                                         // Accepts any certificate, for tests only
+	TimeoutSeconds     int               `json:"timeout_seconds" validate:"omitempty,min=1,max=600"`                           // Wait for the provider to start answering, streamed answers may last longer
+	MaxRetries         *int              `json:"max_retries" validate:"omitempty,min=0,max=10"`                                // Retries of failed attempts, PROVIDER_DEFAULT_MAX_RETRIES when empty
+	// Limits shared by every call to the provider across replicas, 0 for no limit
+	RequestsPerMinute int `json:"requests_per_minute" validate:"omitempty,min=0,max=1000000"`
+	TokensPerMinute   int `json:"tokens_per_minute" validate:"omitempty,min=0,max=100000000"` // Counted from the size of the requests
+	MaxConcurrency    int `json:"max_concurrency" validate:"omitempty,min=0,max=1000"`
+}
+
 // ProviderInfo represents the configuration for a provider
 type ProviderInfo struct {
 	ID        uint      `json:"id"`
@@ -17,6 +45,22 @@ type ProviderInfo struct {
 	Name      string    `json:"name"`
 	Mode      string    `json:"mode"`
 	BaseURL   string    `json:"base_url"`
+	// Headers are not returned, like the API key
+	APIVersion         string   `json:"api_version,omitempty"`
+	PathPrefix         string   `json:"path_prefix,omitempty"`
+	Deployments        []string `json:"deployments,omitempty" gorm:"serializer:json"`
+	ProxyURL           string   `json:"proxy_url,omitempty" gorm:"column:redacted_proxy_url"` // Password redacted
+	CACert             string   `json:"ca_cert,omitempty"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify"`
+	TimeoutSeconds     int      `json:"timeout_seconds,omitempty"`
+	MaxRetries         *int     `json:"max_retries"`
+	RequestsPerMinute  int      `json:"requests_per_minute"`
+	TokensPerMinute    int      `json:"tokens_per_minute"`
+	MaxConcurrency     int      `json:"max_concurrency"`
+	// Defined by an administrator, the key and connection settings of a provider granted to the user are not returned
+	Shared  bool     `json:"shared"`
+	Models  []string `json:"models,omitempty" gorm:"-"` // Models granted to the user, empty for all
+	OwnerID uint     `json:"-"`
 }
 
 type ProviderInfoReq struct {
@@ -34,7 +78,8 @@ type ProviderCreateReq struct {
 	Name    string `json:"name" validate:"required,min=1,max=50"`
 	BaseURL string `json:"base_url" validate:"required,url"`
 	APIKey  string `json:"api_key" validate:"required,min=1"`
-	Mode    string `json:"mode" validate:"required,oneof=openai openai_response gemini anthropic ollama"`
+	Mode    string `json:"mode" validate:"required,oneof=openai openai_response openai_compatible azure_openai mistral gemini anthropic ollama"`
+	ProviderSettings
 }
 
 // ProviderUpdateReq represents a request to update a provider
@@ -43,22 +88,86 @@ type ProviderUpdateReq struct {
 	Name    string `json:"name" validate:"required,min=1,max=50"`
 	BaseURL string `json:"base_url" validate:"required,url"`
 	APIKey  string `json:"api_key" validate:"required,min=1"`
-	Mode    string `json:"mode" validate:"required,oneof=openai openai_response gemini anthropic ollama"`
+	Mode    string `json:"mode" validate:"required,oneof=openai openai_response openai_compatible azure_openai mistral gemini anthropic ollama"`
+	ProviderSettings
 }
 
 // ProviderModelsReq represents a request to list models from a provider
 type ProviderModelsReq struct {
-	ID uint `param:"provider_id" validate:"required"`
+	ID      uint `param:"provider_id" validate:"required"`
+	Refresh bool `query:"refresh"` // Bypasses the cached model catalog
 }
 
+const (
+	MODEL_TYPE_CHAT      = "chat"
+	MODEL_TYPE_EMBEDDING = "embedding"
+	MODEL_TYPE_RERANK    = "rerank"
+	MODEL_TYPE_VISION    = "vision" // Chat model accepting images
+)
+
 // ModelInfo represents a model from a provider
 type ModelInfo struct {
-	ID      string `json:"id"`       // Model identifier (e.g., "gpt-4", "text-embedding-3-small")
-	Object  string `json:"object"`   // Object type (usually "model")
-	OwnedBy string `json:"owned_by"` // Owner of the model (e.g., "openai")
+	ID            string   `json:"id"`                       // Model identifier (e.g., "gpt-4", "text-embedding-3-small")
+	Object        string   `json:"object"`                   // Object type (usually "model")
+	OwnedBy       string   `json:"owned_by"`                 // Owner of the model (e.g., "openai")
+	Types         []string `json:"types"`                    // "chat", "embedding", "rerank" and/or "vision", empty for other models (speech, images...)
+	Dimension     int      `json:"dimension,omitempty"`      // Vector size of an embedding model
+	ContextLength int      `json:"context_length,omitempty"` // Input tokens the model accepts
 }
 
 // ProviderModelsResp represents a list of models from a provider
 type ProviderModelsResp struct {
-	Models []ModelInfo `json:"models"`
+	Models   []ModelInfo `json:"models"`
+	CachedAt time.Time   `json:"cached_at"` // When the catalog was fetched from the provider
+}
+
+const (
+	PROVIDER_CAPABILITY_LIST_MODELS = "list_models"
+	PROVIDER_CAPABILITY_EMBEDDING   = "embedding"
+	PROVIDER_CAPABILITY_CHAT        = "chat"
+
+	PROVIDER_TEST_STATUS_OK      = "ok"
+	PROVIDER_TEST_STATUS_FAILED  = "failed"
+	PROVIDER_TEST_STATUS_SKIPPED = "skipped"
+
+	// Normalized causes of a failed provider call
+	PROVIDER_ERROR_AUTH        = "auth"        // Key rejected (401, 403)
+	PROVIDER_ERROR_DNS         = "dns"         // Host of the base URL does not resolve
+	PROVIDER_ERROR_TLS         = "tls"         // Certificate or handshake failure
+	PROVIDER_ERROR_NOT_FOUND   = "not_found"   // Wrong base URL path or unknown model (404)
+	PROVIDER_ERROR_RATE_LIMIT  = "rate_limit"  // 429
+	PROVIDER_ERROR_TIMEOUT     = "timeout"     // No answer in time
+	PROVIDER_ERROR_CONNECTION  = "connection"  // Connection refused or reset
+	PROVIDER_ERROR_BAD_REQUEST = "bad_request" // Other 4xx
+	PROVIDER_ERROR_SERVER      = "server"      // 5xx
+	PROVIDER_ERROR_UNKNOWN     = "unknown"
+)
+
+// ProviderTestReq tests a saved provider, or provider settings before they are saved.
+// Models to probe are picked from the listed models when not given.
+type ProviderTestReq struct {
+	ID             uint   `json:"id" validate:"required_without=BaseURL"`
+	BaseURL        string `json:"base_url" validate:"required_without=ID,omitempty,url"`
+	APIKey         string `json:"api_key" validate:"omitempty,min=1"`
+	Mode           string `json:"mode" validate:"required_without=ID,omitempty,oneof=openai openai_response openai_compatible azure_openai mistral gemini anthropic ollama"`
+	EmbeddingModel string `json:"embedding_model" validate:"omitempty,max=100"`
+	ChatModel      string `json:"chat_model" validate:"omitempty,max=100"`
+	ProviderSettings
+}
+
+// ProviderCapabilityResult is the outcome of one probe call
+type ProviderCapabilityResult struct {
+	Capability string `json:"capability"` // "list_models", "embedding" or "chat"
+	Status     string `json:"status"`     // "ok", "failed" or "skipped"
+	Model      string `json:"model,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	ModelCount int    `json:"model_count,omitempty"` // Listed models
+	Dimension  int    `json:"dimension,omitempty"`   // Embedding dimension
+	ErrorType  string `json:"error_type,omitempty"`  // Normalized cause, see PROVIDER_ERROR_*
+	Error      string `json:"error,omitempty"`
+}
+
+type ProviderTestResp struct {
+	Success bool                       `json:"success"` // Every probe that ran succeeded
+	Results []ProviderCapabilityResult `json:"results"`
 }
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// ProviderGrantReq lets a user, or every user when UserID is zero, use a shared provider.
+// Granting the same grantee again replaces its models.
+type ProviderGrantReq struct {
+	ProviderID uint     `json:"provider_id" validate:"required"`
+	UserID     uint     `json:"user_id"`
+	Models     []string `json:"models" validate:"omitempty,max=100,dive,min=1,max=100"` // Empty for all models
+}
+
+// ProviderRevokeReq takes a grant of a shared provider back, a zero UserID the grant to every user
+type ProviderRevokeReq struct {
+	ProviderID uint `json:"provider_id" validate:"required"`
+	UserID     uint `json:"user_id"`
+}
+
+type ProviderGrantInfo struct {
+	ID        uint      `json:"id"`
+	UserID    *uint     `json:"user_id"` // Null for every user
+	Models    []string  `json:"models"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SharedProviderInfo is a shared provider with its grants, as administrators see it
+type SharedProviderInfo struct {
+	ProviderInfo
+	Grants []ProviderGrantInfo `json:"grants"`
+}
+
+type SharedProviderListResp struct {
+	Total     int64                `json:"total"`
+	Providers []SharedProviderInfo `json:"providers"`
+}
@@ -0,0 +1,77 @@
+package models
+
+import "time"
+
+// QuotaPlanReq describes the limits of a quota plan, zero meaning unlimited
+type QuotaPlanReq struct {
+	Name               string `json:"name" validate:"required,max=50"`
+	MaxStorageBytes    int64  `json:"max_storage_bytes" validate:"min=0"`
+	MaxFilesPerDataset int    `json:"max_files_per_dataset" validate:"min=0"`
+	MaxDatasets        int    `json:"max_datasets" validate:"min=0"`
+	MaxProviders       int    `json:"max_providers" validate:"min=0"`
+	MonthlyTokens      int64  `json:"monthly_tokens" validate:"min=0"`
+	// Plan of the users assigned none, at most one plan is the default
+	IsDefault bool `json:"is_default"`
+}
+
+type QuotaPlanUpdateReq struct {
+	ID uint `json:"id" validate:"required"`
+	QuotaPlanReq
+}
+
+type QuotaPlanIDReq struct {
+	ID uint `param:"plan_id" validate:"required"`
+}
+
+// QuotaAssignReq puts a user on a plan, a zero plan ID returns the user to the default plan
+type QuotaAssignReq struct {
+	UserID uint `json:"user_id" validate:"required"`
+	PlanID uint `json:"plan_id"`
+}
+
+type QuotaUserReq struct {
+	UserID uint `param:"user_id" validate:"required"`
+}
+
+type QuotaPlanInfo struct {
+	ID                 uint      `json:"id"`
+	Name               string    `json:"name"`
+	MaxStorageBytes    int64     `json:"max_storage_bytes"`
+	MaxFilesPerDataset int       `json:"max_files_per_dataset"`
+	MaxDatasets        int       `json:"max_datasets"`
+	MaxProviders       int       `json:"max_providers"`
+	MonthlyTokens      int64     `json:"monthly_tokens"`
+	IsDefault          bool      `json:"is_default"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+type QuotaPlanListResp struct {
+	Total int64           `json:"total"`
+	Plans []QuotaPlanInfo `json:"plans"`
+}
+
+// QuotaCounter is the consumption of a resource against its limit, zero meaning unlimited
+type QuotaCounter struct {
+	Used  int64 `json:"used"`
+	Limit int64 `json:"limit"`
+}
+
+// DatasetFileQuota is the file count of a dataset against the per dataset limit
+type DatasetFileQuota struct {
+	DatasetID   uint   `json:"dataset_id"`
+	DatasetName string `json:"dataset_name"`
+	QuotaCounter
+}
+
+// QuotaUsage is the consumption of a user against the limits of their plan.
+// Tokens count the embedding, chat and rerank calls of the current calendar month (UTC).
+type QuotaUsage struct {
+	Plan            *QuotaPlanInfo     `json:"plan"` // Null when the user has no plan and there is no default plan
+	StorageBytes    QuotaCounter       `json:"storage_bytes"`
+	Datasets        QuotaCounter       `json:"datasets"`
+	Providers       QuotaCounter       `json:"providers"`
+	MonthlyTokens   QuotaCounter       `json:"monthly_tokens"`
+	FilesPerDataset []DatasetFileQuota `json:"files_per_dataset"`
+	PeriodStart     time.Time          `json:"period_start"`
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+const (
+	REINDEX_STATUS_PENDING   = "pending"
+	REINDEX_STATUS_RUNNING   = "running"
+	REINDEX_STATUS_COMPLETED = "completed"
+	REINDEX_STATUS_FAILED    = "failed"
+	REINDEX_STATUS_CANCELLED = "cancelled"
+)
+
+// ReindexJobReq identifies the dataset whose reindex job is queried or cancelled
+type ReindexJobReq struct {
+	ID uint `param:"dataset_id" validate:"required"`
+}
+
+// ReindexJobInfo represents the progress of a reindex job
+type ReindexJobInfo struct {
+	ID             uint      `json:"id"`
+	DatasetID      uint      `json:"dataset_id"`
+	Status         string    `json:"status"`
+	ProviderID     uint      `json:"provider_id"`
+	EmbeddingModel string    `json:"embedding_model"`
+	TotalFiles     int       `json:"total_files"`
+	ProcessedFiles int       `json:"processed_files"`
+	Error          string    `json:"error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
@@ -0,0 +1,48 @@
+package models
+
+const (
+	RERANK_TYPE_NONE    = "none"
+	RERANK_TYPE_LEXICAL = "lexical"
+	RERANK_TYPE_COHERE  = "cohere"
+	RERANK_TYPE_JINA    = "jina"
+)
+
+// DatasetRerankConfig describes the optional rerank stage of a dataset
+type DatasetRerankConfig struct {
+	Type       string // "none", "lexical", "cohere", "jina"
+	ProviderID uint   // Provider holding the base URL and API key of the rerank API
+	Model      string // Rerank model name
+}
+
+// RerankResult represents the relevance of one document to the query
+type RerankResult struct {
+	Index int     // Index of the document in the reranked input
+	Score float32 // Relevance score, higher is better
+}
+
+// RerankAPIReq is the request body shared by Cohere- and Jina-style /rerank APIs
+type RerankAPIReq struct {
+	Model           string   `json:"model"`
+	Query           string   `json:"query"`
+	Documents       []string `json:"documents"`
+	TopN            int      `json:"top_n,omitempty"`
+	ReturnDocuments *bool    `json:"return_documents,omitempty"`
+}
+
+// RerankAPIResp is the response body shared by Cohere- and Jina-style /rerank APIs
+type RerankAPIResp struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float32 `json:"relevance_score"`
+	} `json:"results"`
+	// Tokens billed by Jina
+	Usage struct {
+		TotalTokens int64 `json:"total_tokens"`
+	} `json:"usage"`
+	// Tokens billed by Cohere-style APIs that report them, Cohere itself bills search units
+	Meta struct {
+		BilledUnits struct {
+			InputTokens int64 `json:"input_tokens"`
+		} `json:"billed_units"`
+	} `json:"meta"`
+}
@@ -0,0 +1,54 @@
+package models
+
+const (
+	SEARCH_TYPE_SPARSE  = "sparse"
+	SEARCH_TYPE_DENSE   = "dense"
+	SEARCH_TYPE_HYBRID  = "hybrid"
+	SEARCH_TYPE_KEYWORD = "keyword" // PostgreSQL full-text search, no embeddings required
+)
+
+// DatasetSearchReq represents a semantic search request against a single dataset
+type DatasetSearchReq struct {
+	ID    uint   `param:"dataset_id" validate:"required"`
+	Query string `json:"query" validate:"required,min=1,max=2000"`
+	TopK  int    `json:"top_k" validate:"omitempty,min=1,max=100"`
+}
+
+// SearchResultItem represents a ranked passage with its source file
+type SearchResultItem struct {
+	ChunkID     uint           `json:"chunk_id"`
+	Content     string         `json:"content"`
+	Score       float32        `json:"score"`
+	Metadata    map[string]any `json:"metadata"`
+	FileID      uint           `json:"file_id"`
+	FileName    string         `json:"file_name"`
+	FileType    string         `json:"file_type"`
+	DatasetID   uint           `json:"dataset_id"`
+	DatasetName string         `json:"dataset_name"`
+	Highlight   string         `json:"highlight,omitempty"` // Matched terms wrapped in <mark></mark>, keyword search only
+}
+
+// SearchResp represents the ranked passages returned by a search
+type SearchResp struct {
+	Total   int                `json:"total"`
+	Results []SearchResultItem `json:"results"`
+}
+
+// CrossDatasetSearchReq represents a search across several (or all) datasets of the caller
+type CrossDatasetSearchReq struct {
+	Query      string   `json:"query" validate:"required,min=1,max=2000"`
+	TopK       int      `json:"top_k" validate:"omitempty,min=1,max=100"`
+	DatasetIDs []uint   `json:"dataset_ids" validate:"omitempty,max=50"` // Empty means all datasets
+	Tags       []string `json:"tags" validate:"omitempty,max=20,dive,min=1,max=30"`
+	FileTypes  []string `json:"file_types" validate:"omitempty,max=20,dive,min=1,max=255"` // MIME types
+}
+
+// SparseEmbeddingReq is sent to the AI service to compute sparse query vectors
+type SparseEmbeddingReq struct {
+	Texts []string `json:"texts"`
+}
+
+// SparseEmbeddingResp holds sparse vectors as token index -> weight maps
+type SparseEmbeddingResp struct {
+	Embeddings []map[uint32]float32 `json:"embeddings"`
+}
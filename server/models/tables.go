@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -12,6 +14,32 @@ type (
 		Email    string `gorm:"unique;not null"`
 		Password string `gorm:"not null"`
 		Role     string `gorm:"default:user"` // "user" or "admin"
+		// Plan limiting the user's resources, NULL for the default plan
+		QuotaPlanID *uint
+		QuotaPlan   *QuotaPlan `gorm:"foreignKey:QuotaPlanID;constraint:OnDelete:SET NULL"`
+	}
+
+	// QuotaPlan limits the storage, files, datasets, providers and monthly tokens of its users. Zero limits are unlimited.
+	QuotaPlan struct {
+		gorm.Model
+		Name               string `gorm:"uniqueIndex;not null"`
+		MaxStorageBytes    int64  `gorm:"not null;default:0"` // Total size of the user's files in MinIO
+		MaxFilesPerDataset int    `gorm:"not null;default:0"`
+		MaxDatasets        int    `gorm:"not null;default:0"`
+		MaxProviders       int    `gorm:"not null;default:0"`
+		MonthlyTokens      int64  `gorm:"not null;default:0"`     // Input and output tokens of the calendar month in the usage ledger
+		IsDefault          bool   `gorm:"not null;default:false"` // Plan of users assigned none
+	}
+
+	// UserAPIKey authenticates a user on the OpenAI-compatible API, only the SHA-256 hash of the key is stored
+	UserAPIKey struct {
+		gorm.Model
+		Name       string     `gorm:"not null"`
+		Prefix     string     `gorm:"not null"`             // Leading characters of the key, shown in listings
+		KeyHash    string     `gorm:"uniqueIndex;not null"` // Hex encoded SHA-256 of the key
+		LastUsedAt *time.Time // Updated at most once a minute
+		UserID     uint       `gorm:"not null;index"`
+		User       User       `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
 	}
 
 	// File represents uploaded files stored in MinIO
@@ -30,42 +58,194 @@ type (
 	// Chunk represents a knowledge document for RAG system
 	Chunk struct {
 		gorm.Model
-		Content  string         `gorm:"type:text;not null"` // Document content
-		Metadata map[string]any `gorm:"type:jsonb"`         // Additional metadata (source, type, etc.)
-		VectorID string         `gorm:"unique;not null"`    // Reference to Milvus vector ID
-		FileID   uint           `gorm:"not null"`           // Source file ID
-		File     File           `gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE"`
+		Content  string         `gorm:"type:text;not null"`         // Document content
+		Metadata map[string]any `gorm:"type:jsonb;serializer:json"` // Additional metadata (source, type, etc.)
+		VectorID string         `gorm:"unique;not null"`            // Reference to Milvus vector ID
+		// Vector ID in the collection being built by a running reindex job, swapped into VectorID when the job completes
+		PendingVectorID *string `gorm:"index"`
+		FileID          uint    `gorm:"not null"` // Source file ID
+		File            File    `gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE"`
+		// Full-text index of Content, maintained by a database trigger using the dataset's text search config
+		SearchVector string `gorm:"type:tsvector;index:idx_chunks_search_vector,type:gin;->:false;<-:false"`
 	}
 	// Memory stores user interaction history and retrieval results
 	Memory struct {
 		gorm.Model
-		Question string `gorm:"type:text;not null"`
-		Answer   string `gorm:"type:text"` // Generated answer
+		SessionID uint        `gorm:"not null;index"` // Chat session the exchange belongs to, ordered by ID
+		Question  string      `gorm:"type:text;not null"`
+		Answer    string      `gorm:"type:text"` // Generated answer
+		Session   ChatSession `gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE"`
 		// Many-to-Many relationship with RetrievedDocuments
 		RetrievedDocuments []Chunk `gorm:"many2many:memory_documents;"`
 	}
 
+	// Feedback is the rating a user gives to an answer, with the cited chunks the user flagged as wrong
+	Feedback struct {
+		gorm.Model
+		MemoryID    uint    `gorm:"not null;uniqueIndex"`
+		UserID      uint    `gorm:"not null"`
+		Rating      int     `gorm:"not null"` // 1 thumbs up, -1 thumbs down
+		Reason      string  `gorm:"type:text"`
+		Memory      Memory  `gorm:"foreignKey:MemoryID;constraint:OnDelete:CASCADE"`
+		User        User    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+		WrongChunks []Chunk `gorm:"many2many:feedback_wrong_chunks;"`
+	}
+
+	// ChatSession represents a conversation of a user grounded in a dataset
+	ChatSession struct {
+		gorm.Model
+		Title        string         `gorm:"not null"`
+		OwnerID      uint           `gorm:"not null;index"`
+		DatasetID    uint           `gorm:"not null"`
+		ChatFallback *FallbackChain `gorm:"type:jsonb;serializer:json"` // Overrides the chat chain of the dataset when set
+		User         User           `gorm:"foreignKey:OwnerID;constraint:OnDelete:CASCADE"`
+		Dataset      Dataset        `gorm:"foreignKey:DatasetID;constraint:OnDelete:CASCADE"`
+	}
+
 	// Dataset represents a collection of files owned by a user
 	Dataset struct {
 		gorm.Model
-		Name           string `gorm:"not null"`
-		Icon           string // Icon is an emoji (e.g., 🚀, ❤️).
-		Description    string
-		SearchType     string   `gorm:"not null;default:'dense'"` // "sparse", "dense", "hybrid"
-		EmbeddingModel string   `gorm:"not null"`                 // Embedding model name (required)
-		ProviderID     uint     `gorm:"not null"`                 // Associated provider ID for API access
-		OwnerID        uint     `gorm:"not null"`
-		User           User     `gorm:"foreignKey:OwnerID;constraint:OnDelete:CASCADE"`
-		Provider       Provider `gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+		Name              string `gorm:"not null"`
+		Icon              string // Icon is an emoji (e.g., 🚀, ❤️).
+		Description       string
+		SearchType        string        `gorm:"not null;default:'dense'"`   // "sparse", "dense", "hybrid", "keyword"
+		TextSearchConfig  string        `gorm:"not null;default:'simple'"`  // PostgreSQL text search config used for keyword search
+		EmbeddingModel    string        `gorm:"not null"`                   // Embedding model name (required)
+		ProviderID        uint          `gorm:"not null"`                   // Associated provider ID for API access
+		Tags              []string      `gorm:"type:jsonb;serializer:json"` // Free-form labels used to filter searches
+		RerankType        string        `gorm:"not null;default:'none'"`    // "none", "lexical", "cohere", "jina"
+		RerankProviderID  *uint         // Provider whose base URL and API key are used by the rerank API
+		RerankModel       string        // Rerank model name
+		CollectionName    string        // Milvus collection holding the vectors, empty means the default collection
+		ChatFallback      FallbackChain `gorm:"type:jsonb;serializer:json"` // Providers answering chats when the chosen one fails
+		EmbeddingFallback FallbackChain `gorm:"type:jsonb;serializer:json"` // Providers embedding queries when the dataset's one fails
+		OwnerID           uint          `gorm:"not null"`
+		User              User          `gorm:"foreignKey:OwnerID;constraint:OnDelete:CASCADE"`
+		Provider          Provider      `gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+		RerankProvider    *Provider     `gorm:"foreignKey:RerankProviderID;constraint:OnDelete:SET NULL"`
+	}
+	// ReindexJob re-embeds every file of a dataset into a new Milvus collection
+	// and switches the dataset to it once all vectors are rebuilt
+	ReindexJob struct {
+		gorm.Model
+		DatasetID        uint       `gorm:"not null;index;uniqueIndex:idx_reindex_jobs_active,where:status = 'pending' OR status = 'running'"` // At most one unfinished job per dataset
+		OwnerID          uint       `gorm:"not null"`
+		Status           string     `gorm:"not null;default:'pending'"` // "pending", "running", "completed", "failed", "cancelled"
+		ProviderID       uint       `gorm:"not null"`                   // Provider the dataset switches to
+		EmbeddingModel   string     `gorm:"not null"`                   // Embedding model the dataset switches to
+		SourceCollection string     `gorm:"not null"`                   // Collection serving searches until the switch
+		TargetCollection string     `gorm:"not null"`                   // Collection the vectors are rebuilt into
+		TotalFiles       int        `gorm:"not null;default:0"`
+		ProcessedFiles   int        `gorm:"not null;default:0"` // Files whose chunks all have a vector in the target collection
+		LeaseUntil       *time.Time // Held by the replica re-embedding the chunks, NULL when none does
+		Error            string
+		Dataset          Dataset  `gorm:"foreignKey:DatasetID;constraint:OnDelete:CASCADE"`
+		Provider         Provider `gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
 	}
 	// Provider represents an AI model provider (OpenAI, Gemini, Anthropic, Ollama, etc.)
 	Provider struct {
 		gorm.Model
-		Name    string `gorm:"not null;"` // Provider Name
-		Mode    string `gorm:"not null"`  // Provider mode: "openai","openai response", "gemini", "anthropic", "ollama"
-		BaseURL string `gorm:"not null"`  // Base URL for API requests
-		APIKey  string `gorm:"not null"`  // API key for authentication
-		OwnerID uint   `gorm:"not null"`
-		User    User   `gorm:"foreignKey:OwnerID;constraint:OnDelete:CASCADE"`
+		Name               string   `gorm:"not null;"` // Provider Name
+		Mode               string   `gorm:"not null"`  // Provider mode: "openai", "openai_response", "openai_compatible", "azure_openai", "mistral", "gemini", "anthropic", "ollama"
+		BaseURL            string   `gorm:"not null"`  // Base URL for API requests
+		APIKey             string   `gorm:"not null"`  // Reference to the API key in the secrets backend, the encrypted key for the database backend
+		APIVersion         string   // Azure OpenAI api-version
+		PathPrefix         string   // Path appended to BaseURL by OpenAI-compatible gateways
+		Headers            string   `gorm:"type:text"`                  // Reference to the extra request headers of every call, stored as JSON
+		Deployments        []string `gorm:"type:jsonb;serializer:json"` // Azure OpenAI deployment names
+		ProxyURL           string   `gorm:"type:text"`                  // Reference to the proxy URL in the secrets backend, it may carry a password
+		RedactedProxyURL   string   // Proxy URL with its password hidden, shown to the owner
+		CACert             string   `gorm:"type:text"` // PEM bundle trusted besides the system CAs
+		InsecureSkipVerify bool     `gorm:"not null;default:false"`
+		TimeoutSeconds     int      `gorm:"not null;default:0"` // Wait for responses to start, 0 for no limit
+		MaxRetries         *int     // NULL for PROVIDER_DEFAULT_MAX_RETRIES
+		RequestsPerMinute  int      `gorm:"not null;default:0"` // Limits enforced by the provider governor, 0 for no limit
+		TokensPerMinute    int      `gorm:"not null;default:0"`
+		MaxConcurrency     int      `gorm:"not null;default:0"`
+		// Defined by an administrator for the users it is granted to, who never see its key
+		Shared  bool `gorm:"not null;default:false"`
+		OwnerID uint `gorm:"not null"`
+		User    User `gorm:"foreignKey:OwnerID;constraint:OnDelete:CASCADE"`
+	}
+
+	// ProviderGrant lets a user, or every user, use a shared provider
+	ProviderGrant struct {
+		ID         uint      `gorm:"primarykey"`
+		CreatedAt  time.Time `gorm:"not null"`
+		UpdatedAt  time.Time `gorm:"not null"`
+		ProviderID uint      `gorm:"not null;index;uniqueIndex:idx_provider_grants_user,where:user_id IS NOT NULL;uniqueIndex:idx_provider_grants_everyone,where:user_id IS NULL"`
+		UserID     *uint     `gorm:"index;uniqueIndex:idx_provider_grants_user,where:user_id IS NOT NULL"` // NULL grants every user, at most one grant per grantee
+		Models     []string  `gorm:"type:jsonb;serializer:json"`                                           // Models the grantees may use, empty for all
+		Provider   Provider  `gorm:"foreignKey:ProviderID;constraint:OnDelete:CASCADE"`
+		User       *User     `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	}
+
+	// Webhook is an endpoint of a user receiving the lifecycle events matching its filters
+	Webhook struct {
+		gorm.Model
+		URL         string   `gorm:"not null"`
+		Events      []string `gorm:"type:jsonb;serializer:json;not null"` // Event names, or "file.*" and "dataset.*"
+		Description string
+		Secret      string `gorm:"not null"` // Key signing the deliveries, encrypted like provider API keys
+		Active      bool   `gorm:"not null;default:true"`
+		UserID      uint   `gorm:"not null;index"`
+		User        User   `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+	}
+
+	// WebhookDelivery is an event to deliver to a webhook, and the log of its attempts
+	WebhookDelivery struct {
+		ID             uint       `gorm:"primarykey"`
+		CreatedAt      time.Time  `gorm:"not null;index"`
+		UpdatedAt      time.Time  `gorm:"not null"`
+		WebhookID      uint       `gorm:"not null;index;uniqueIndex:idx_webhook_deliveries_event,where:redelivery_of IS NULL"`
+		EventID        string     `gorm:"not null;uniqueIndex:idx_webhook_deliveries_event,where:redelivery_of IS NULL"` // Delivered once however often the broker hands the event over
+		Event          string     `gorm:"not null"`
+		Payload        string     `gorm:"type:jsonb;not null"`
+		Status         string     `gorm:"not null;default:'pending'"` // "pending", "succeeded" or "dead"
+		Attempts       int        `gorm:"not null;default:0"`
+		NextAttemptAt  *time.Time `gorm:"index"` // NULL once the delivery succeeded or is dead
+		LastStatusCode int        `gorm:"not null;default:0"`
+		LastError      string     `gorm:"type:text"`
+		DeliveredAt    *time.Time
+		RedeliveryOf   *uint   // Delivery redelivered on demand
+		Webhook        Webhook `gorm:"foreignKey:WebhookID;constraint:OnDelete:CASCADE"`
+	}
+
+	// OutboxEvent is a RabbitMQ message written in the transaction of the change it reports,
+	// then published by the outbox relay until the broker confirms it
+	OutboxEvent struct {
+		ID         uint      `gorm:"primarykey"`
+		CreatedAt  time.Time `gorm:"not null"`
+		Exchange   string    `gorm:"not null;default:''"` // Empty for the default exchange, routing to the queue named by the key
+		RoutingKey string    `gorm:"not null"`
+		Payload    string    `gorm:"type:jsonb;not null"`
+		// File whose upload holds the event back, the event goes with the record of a failed upload
+		FileID      *uint      `gorm:"index"`
+		AvailableAt *time.Time `gorm:"index:idx_outbox_events_due,where:sent_at IS NULL"` // NULL while held back
+		Attempts    int        `gorm:"not null;default:0"`
+		LastError   string     `gorm:"type:text"`
+		SentAt      *time.Time `gorm:"index"` // NULL until the broker confirmed the message
+		File        *File      `gorm:"foreignKey:FileID;constraint:OnDelete:CASCADE"`
+	}
+
+	// UsageRecord is an entry of the append-only ledger of outbound provider calls.
+	// It has no foreign keys so that the spending of deleted users, datasets and providers stays accounted for.
+	UsageRecord struct {
+		ID           uint      `gorm:"primarykey"`
+		CreatedAt    time.Time `gorm:"not null;index"`
+		UserID       uint      `gorm:"not null;index"`
+		DatasetID    *uint     `gorm:"index"` // NULL for calls made outside of a dataset, like provider tests
+		ProviderID   uint      `gorm:"not null;index"`
+		ProviderName string    `gorm:"not null"` // Name at the time of the call
+		ProviderMode string    `gorm:"not null"`
+		CallType     string    `gorm:"not null"` // "embedding", "chat" or "rerank"
+		Model        string    `gorm:"not null"`
+		InputTokens  int64     `gorm:"not null;default:0"`
+		OutputTokens int64     `gorm:"not null;default:0"`
+		Estimated    bool      `gorm:"not null;default:false"` // Token counts estimated from the text length
+		LatencyMs    int64     `gorm:"not null;default:0"`
+		Cost         float64   `gorm:"not null;default:0"` // Estimated cost in USD from the price table at the time of the call
+		Success      bool      `gorm:"not null"`
+		Error        string    `gorm:"type:text"`
 	}
 )
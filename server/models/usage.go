@@ -0,0 +1,92 @@
+package models
+
+import "time"
+
+const (
+	USAGE_CALL_EMBEDDING = "embedding"
+	USAGE_CALL_CHAT      = "chat"
+	USAGE_CALL_RERANK    = "rerank"
+
+	USAGE_GROUP_DAY      = "day"
+	USAGE_GROUP_USER     = "user"
+	USAGE_GROUP_DATASET  = "dataset"
+	USAGE_GROUP_PROVIDER = "provider"
+
+	// Period covered by usage reports and exports when the request sets none
+	DEFAULT_USAGE_REPORT_DAYS = 30
+)
+
+// UsageReportReq aggregates the usage of the authenticated user
+type UsageReportReq struct {
+	GroupBy    string `query:"group_by" validate:"required,oneof=day dataset provider"`
+	Days       int    `query:"days" validate:"omitempty,min=1,max=365"`
+	DatasetID  uint   `query:"dataset_id" validate:"omitempty"`
+	ProviderID uint   `query:"provider_id" validate:"omitempty"`
+	CallType   string `query:"call_type" validate:"omitempty,oneof=embedding chat rerank"`
+}
+
+// AdminUsageReportReq aggregates the usage of all users, or a single one
+type AdminUsageReportReq struct {
+	GroupBy    string `query:"group_by" validate:"required,oneof=day user dataset provider"`
+	Days       int    `query:"days" validate:"omitempty,min=1,max=365"`
+	UserID     uint   `query:"user_id" validate:"omitempty"`
+	DatasetID  uint   `query:"dataset_id" validate:"omitempty"`
+	ProviderID uint   `query:"provider_id" validate:"omitempty"`
+	CallType   string `query:"call_type" validate:"omitempty,oneof=embedding chat rerank"`
+}
+
+// UsageExportReq selects the ledger entries exported as CSV
+type UsageExportReq struct {
+	Days       int    `query:"days" validate:"omitempty,min=1,max=365"`
+	UserID     uint   `query:"user_id" validate:"omitempty"`
+	DatasetID  uint   `query:"dataset_id" validate:"omitempty"`
+	ProviderID uint   `query:"provider_id" validate:"omitempty"`
+	CallType   string `query:"call_type" validate:"omitempty,oneof=embedding chat rerank"`
+}
+
+// UsageFilter restricts the ledger entries read by reports and exports, zero values match everything
+type UsageFilter struct {
+	Since      time.Time
+	UserID     uint
+	DatasetID  uint
+	ProviderID uint
+	CallType   string
+}
+
+// UsageEvent is a provider call as measured by the caller, before it is priced and queued
+type UsageEvent struct {
+	CallType     string // "embedding", "chat" or "rerank"
+	Model        string
+	InputTokens  int64
+	OutputTokens int64
+	Estimated    bool // Token counts were estimated from the text, the provider reported none
+	Latency      time.Duration
+	Err          error
+}
+
+// UsagePrice is the price of a model in USD. Token prices are per million tokens.
+type UsagePrice struct {
+	Input   float64 `json:"input"`
+	Output  float64 `json:"output"`
+	Request float64 `json:"request"` // Charged per call, e.g. rerank search units
+}
+
+// UsageStat sums the provider calls of a group. Key is the day (YYYY-MM-DD) or the user, dataset or provider ID.
+type UsageStat struct {
+	Key          string  `json:"key"`
+	Name         string  `json:"name"`
+	Calls        int64   `json:"calls"`
+	FailedCalls  int64   `json:"failed_calls"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	Cost         float64 `json:"cost"` // Estimated, in USD
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// UsageReport aggregates the ledger over a period, groups ordered by day or by descending cost
+type UsageReport struct {
+	GroupBy string      `json:"group_by"`
+	Since   time.Time   `json:"since"`
+	Total   UsageStat   `json:"total"`
+	Groups  []UsageStat `json:"groups"`
+}
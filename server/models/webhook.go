@@ -0,0 +1,109 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	// Lifecycle events, published to the RABBITMQ_EXCHANGE topic exchange with the event as routing key
+	EVENT_FILE_UPLOADED   = "file.uploaded"
+	EVENT_FILE_PROCESSED  = "file.processed" // Written to the outbox by the AI service once every chunk of the file is embedded
+	EVENT_FILE_FAILED     = "file.failed"    // Written to the outbox by the AI service when a chunk of the file fails to embed
+	EVENT_FILE_DELETED    = "file.deleted"
+	EVENT_DATASET_CREATED = "dataset.created"
+	EVENT_DATASET_UPDATED = "dataset.updated"
+	EVENT_DATASET_DELETED = "dataset.deleted"
+
+	WEBHOOK_DELIVERY_PENDING   = "pending"
+	WEBHOOK_DELIVERY_SUCCEEDED = "succeeded"
+	WEBHOOK_DELIVERY_DEAD      = "dead" // Every attempt failed, only redelivered on demand
+
+	DEFAULT_WEBHOOK_PAGE_SIZE = 20
+)
+
+// LifecycleEvent is the message of a file or dataset lifecycle event, and the body POSTed to the webhooks subscribed to it
+type LifecycleEvent struct {
+	ID        string         `json:"id"` // Unique, receivers may use it to drop duplicates
+	Event     string         `json:"event"`
+	UserID    uint           `json:"user_id"`
+	DatasetID uint           `json:"dataset_id"`
+	FileID    uint           `json:"file_id,omitempty"`
+	Data      map[string]any `json:"data,omitempty"` // e.g. the name of the file or dataset, or the error of a failed file
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// WebhookCreateReq registers an endpoint. Events are event names or "file.*" and "dataset.*".
+type WebhookCreateReq struct {
+	URL         string   `json:"url" validate:"required,url,max=2000"`
+	Events      []string `json:"events" validate:"required,min=1,max=10,dive,oneof=file.* file.uploaded file.processed file.failed file.deleted dataset.* dataset.created dataset.updated dataset.deleted"`
+	Description string   `json:"description" validate:"max=200"`
+	Secret      string   `json:"secret" validate:"omitempty,min=16,max=200"` // Generated when empty
+}
+
+type WebhookUpdateReq struct {
+	ID          uint     `json:"id" validate:"required"`
+	URL         string   `json:"url" validate:"required,url,max=2000"`
+	Events      []string `json:"events" validate:"required,min=1,max=10,dive,oneof=file.* file.uploaded file.processed file.failed file.deleted dataset.* dataset.created dataset.updated dataset.deleted"`
+	Description string   `json:"description" validate:"max=200"`
+	Active      bool     `json:"active"`
+}
+
+type WebhookReq struct {
+	ID uint `param:"webhook_id" validate:"required"`
+}
+
+type WebhookInfo struct {
+	ID          uint      `json:"id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events" gorm:"serializer:json"`
+	Description string    `json:"description"`
+	Active      bool      `json:"active"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WebhookCreateResp carries the signing secret, which is only returned once
+type WebhookCreateResp struct {
+	WebhookInfo
+	Secret string `json:"secret"`
+}
+
+type WebhookListResp struct {
+	Total    int64         `json:"total"`
+	Webhooks []WebhookInfo `json:"webhooks"`
+}
+
+// WebhookDeliveryListReq pages through the delivery log of a webhook, newest first
+type WebhookDeliveryListReq struct {
+	ID       uint   `param:"webhook_id" validate:"required"`
+	Status   string `query:"status" validate:"omitempty,oneof=pending succeeded dead"`
+	Page     int    `query:"page" validate:"omitempty,min=1"`
+	PageSize int    `query:"page_size" validate:"omitempty,min=1,max=100"`
+}
+
+type WebhookDeliveryReq struct {
+	ID uint `param:"delivery_id" validate:"required"`
+}
+
+type WebhookDeliveryInfo struct {
+	ID             uint            `json:"id"`
+	WebhookID      uint            `json:"webhook_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload" swaggertype:"object"`
+	Status         string          `json:"status"` // "pending", "succeeded" or "dead"
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	LastStatusCode int             `json:"last_status_code"`
+	LastError      string          `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	RedeliveryOf   *uint           `json:"redelivery_of"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+type WebhookDeliveryListResp struct {
+	Total      int64                 `json:"total"`
+	Deliveries []WebhookDeliveryInfo `json:"deliveries"`
+}
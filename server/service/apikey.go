@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// LastUsedAt is refreshed at most this often, so requests do not each cost a write
+	API_KEY_LAST_USED_INTERVAL = time.Minute
+)
+
+var APIKeyServiceApp = new(APIKeyService)
+
+type APIKeyService struct{}
+
+// CreateAPIKey creates an API key for the user. The plaintext key is only part of this response.
+func (this *APIKeyService) CreateAPIKey(ctx context.Context, userID uint, name string) (*models.APIKeyCreateResp, error) {
+	key, err := utils.GenerateUserAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	apiKey := models.UserAPIKey{
+		Name:    name,
+		Prefix:  key[:utils.USER_API_KEY_DISPLAY_LEN],
+		KeyHash: utils.HashUserAPIKey(key),
+		UserID:  userID,
+	}
+	if err := gorm.G[models.UserAPIKey](db.PgSqlDB).Create(ctx, &apiKey); err != nil {
+		return nil, err
+	}
+	return &models.APIKeyCreateResp{
+		APIKeyInfo: models.APIKeyInfo{
+			ID:        apiKey.ID,
+			Name:      apiKey.Name,
+			Prefix:    apiKey.Prefix,
+			CreatedAt: apiKey.CreatedAt,
+		},
+		Key: key,
+	}, nil
+}
+
+func (this *APIKeyService) ListAPIKeys(ctx context.Context, userID uint) (total int64, keys []models.APIKeyInfo, err error) {
+	result := db.PgSqlDB.WithContext(ctx).Model(&models.UserAPIKey{}).
+		Where("user_id = ?", userID).
+		Order("id").
+		Find(&keys)
+	return result.RowsAffected, keys, result.Error
+}
+
+func (this *APIKeyService) DeleteAPIKey(ctx context.Context, keyID uint, userID uint) error {
+	rowsAffected, err := gorm.G[models.UserAPIKey](db.PgSqlDB).
+		Where("id = ? AND user_id = ?", keyID, userID).
+		Delete(ctx)
+	// id not found
+	if rowsAffected == 0 && err == nil {
+		return ErrNotFound
+	}
+	return err
+}
+
+// AuthenticateAPIKey returns the owner of an API key, or ErrNotFound if the key does not exist
+func (this *APIKeyService) AuthenticateAPIKey(ctx context.Context, key string) (*models.User, error) {
+	apiKey, err := gorm.G[models.UserAPIKey](db.PgSqlDB).
+		Preload("User", nil).
+		Where("key_hash = ?", utils.HashUserAPIKey(key)).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The owner was deleted
+	if apiKey.User.ID == 0 {
+		return nil, ErrNotFound
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= API_KEY_LAST_USED_INTERVAL {
+		if _, err := gorm.G[models.UserAPIKey](db.PgSqlDB).
+			Where("id = ?", apiKey.ID).
+			Update(ctx, "last_used_at", now); err != nil {
+			utils.Logger.Warnf("Failed to record use of API key %d: %v", apiKey.ID, err)
+		}
+	}
+	return &apiKey.User, nil
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"server/db"
+	"server/models"
+
+	"gorm.io/gorm"
+)
+
+var ChatServiceApp = new(ChatService)
+
+type ChatService struct{}
+
+// CreateSession creates a chat session bound to a dataset of the owner
+func (this *ChatService) CreateSession(ctx context.Context, ownerID uint, datasetID uint, title string) (*models.ChatSessionInfo, error) {
+	if _, err := gorm.G[models.Dataset](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", datasetID, ownerID).
+		First(ctx); err != nil {
+		return nil, err
+	}
+
+	session := models.ChatSession{
+		Title:     title,
+		OwnerID:   ownerID,
+		DatasetID: datasetID,
+	}
+	if err := gorm.G[models.ChatSession](db.PgSqlDB).Create(ctx, &session); err != nil {
+		return nil, err
+	}
+	return &models.ChatSessionInfo{
+		ID:        session.ID,
+		Title:     session.Title,
+		DatasetID: session.DatasetID,
+		OwnerID:   session.OwnerID,
+		CreatedAt: session.CreatedAt,
+		UpdatedAt: session.UpdatedAt,
+	}, nil
+}
+
+// GetSession retrieves a chat session of the owner
+func (this *ChatService) GetSession(ctx context.Context, sessionID uint, ownerID uint) (*models.ChatSession, error) {
+	session, err := gorm.G[models.ChatSession](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", sessionID, ownerID).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SetSessionFallback sets the chat chain of a session, nil falls back to the chain of the session's dataset
+func (this *ChatService) SetSessionFallback(ctx context.Context, sessionID uint, ownerID uint, chain *models.FallbackChain) error {
+	if chain != nil {
+		chain.Dimension = 0
+	}
+	rowsAffected, err := gorm.G[models.ChatSession](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", sessionID, ownerID).
+		Select("chat_fallback").
+		Updates(ctx, models.ChatSession{ChatFallback: chain})
+	// id not found
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return err
+}
+
+// ListSessions retrieves the owner's sessions, most recently active first.
+// If datasetID is not zero, only sessions of that dataset are returned.
+func (this *ChatService) ListSessions(ctx context.Context, ownerID uint, datasetID uint) (total int64, sessions []models.ChatSessionInfo, err error) {
+	query := db.PgSqlDB.WithContext(ctx).Model(&models.ChatSession{}).
+		Where("owner_id = ?", ownerID)
+	if datasetID != 0 {
+		query = query.Where("dataset_id = ?", datasetID)
+	}
+	result := query.Order("updated_at DESC").Find(&sessions)
+	return result.RowsAffected, sessions, result.Error
+}
+
+func (this *ChatService) RenameSession(ctx context.Context, sessionID uint, ownerID uint, title string) error {
+	rowsAffected, err := gorm.G[models.ChatSession](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", sessionID, ownerID).
+		Update(ctx, "title", title)
+	// id not found
+	if rowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return err
+}
+
+// DeleteSession deletes a session together with its messages
+func (this *ChatService) DeleteSession(ctx context.Context, sessionID uint, ownerID uint) error {
+	return db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rowsAffected, err := gorm.G[models.ChatSession](tx).
+			Where("id = ? AND owner_id = ?", sessionID, ownerID).
+			Delete(ctx)
+		if err != nil {
+			return err
+		}
+		// id not found
+		if rowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		_, err = gorm.G[models.Memory](tx).
+			Where("session_id = ?", sessionID).
+			Delete(ctx)
+		return err
+	})
+}
+
+// ListMessages pages through the messages of a session in conversation order, with the chunks cited by each answer
+func (this *ChatService) ListMessages(ctx context.Context, sessionID uint, ownerID uint, page int, pageSize int) (total int64, messages []models.ChatMessageInfo, err error) {
+	if _, err := this.GetSession(ctx, sessionID, ownerID); err != nil {
+		return 0, nil, err
+	}
+
+	page = max(page, 1)
+	if pageSize <= 0 {
+		pageSize = models.DEFAULT_CHAT_PAGE_SIZE
+	}
+
+	total, err = gorm.G[models.Memory](db.PgSqlDB).
+		Where("session_id = ?", sessionID).
+		Count(ctx, "*")
+	if err != nil {
+		return 0, nil, err
+	}
+
+	memories, err := gorm.G[models.Memory](db.PgSqlDB).
+		Preload("RetrievedDocuments", nil).
+		Preload("RetrievedDocuments.File", nil).
+		Where("session_id = ?", sessionID).
+		Order("id").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	memoryIDs := make([]uint, 0, len(memories))
+	for _, memory := range memories {
+		memoryIDs = append(memoryIDs, memory.ID)
+	}
+	feedbackByMemory, err := FeedbackServiceApp.ListFeedback(ctx, memoryIDs)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	messages = make([]models.ChatMessageInfo, 0, len(memories))
+	for _, memory := range memories {
+		sources := make([]models.ChatSource, 0, len(memory.RetrievedDocuments))
+		for _, chunk := range memory.RetrievedDocuments {
+			sources = append(sources, models.ChatSource{
+				ChunkID:   chunk.ID,
+				Content:   chunk.Content,
+				FileID:    chunk.FileID,
+				FileName:  chunk.File.Name,
+				DatasetID: chunk.File.DatasetID,
+			})
+		}
+		messages = append(messages, models.ChatMessageInfo{
+			ID:        memory.ID,
+			Question:  memory.Question,
+			Answer:    memory.Answer,
+			Sources:   sources,
+			Feedback:  feedbackByMemory[memory.ID],
+			CreatedAt: memory.CreatedAt,
+		})
+	}
+	return total, messages, nil
+}
+
+// SaveMemory appends a question and its answer to a session and records the cited chunks in memory_documents.
+// Chunks deleted in the meantime are skipped.
+func (this *ChatService) SaveMemory(ctx context.Context, sessionID uint, question string, answer string, chunkIDs []uint) (*models.Memory, error) {
+	memory := models.Memory{
+		SessionID: sessionID,
+		Question:  question,
+		Answer:    answer,
+	}
+	err := db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := gorm.G[models.Memory](tx).Create(ctx, &memory); err != nil {
+			return err
+		}
+		if len(chunkIDs) > 0 {
+			// Insert join rows directly, association appends would upsert the chunks themselves
+			if err := tx.Exec(`INSERT INTO memory_documents (memory_id, chunk_id)
+				SELECT ?, id FROM chunks WHERE id IN ? AND deleted_at IS NULL
+				ON CONFLICT DO NOTHING`, memory.ID, chunkIDs).Error; err != nil {
+				return err
+			}
+		}
+		// Keep the session on top of the recently active list
+		_, err := gorm.G[models.ChatSession](tx).
+			Where("id = ?", sessionID).
+			Update(ctx, "updated_at", memory.CreatedAt)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &memory, nil
+}
@@ -0,0 +1,363 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Longest line accepted from the RAG backend, source events carry chunk previews
+	CHAT_STREAM_MAX_LINE = 4 * 1024 * 1024
+)
+
+// ChatStreamResult is what the RAG backend streamed for a question
+type ChatStreamResult struct {
+	Answer  string
+	Sources []models.ChatBackendSource
+	Error   string                   // Content of the last error event, if any
+	Usage   *models.ChatBackendUsage // Tokens reported by the backend, nil when it reported none
+}
+
+// StreamChat answers a question of a chat session through the RAG backend.
+// Every backend event is passed to emit as soon as it arrives; emit blocks while the client is slow,
+// which in turn stops reading the backend. When the provider fails before answering, the question goes to the next
+// provider of the session's (else the dataset's) chat chain. Once the stream ends, the answer and its cited chunks are saved,
+// as is the part already answered when the stream breaks off.
+func (this *ChatService) StreamChat(ctx context.Context, sessionID uint, ownerID uint, req models.ChatStreamReq, emit func(models.ChatStreamEvent) error) (*models.ChatStreamDone, error) {
+	session, err := this.GetSession(ctx, sessionID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := QuotaServiceApp.CheckTokenBudget(ctx, ownerID); err != nil {
+		return nil, err
+	}
+	dataset, err := gorm.G[models.Dataset](db.PgSqlDB).
+		Preload("Provider", nil).
+		Where("id = ? AND owner_id = ?", session.DatasetID, ownerID).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The RAG backend embeds the question with the dataset's provider
+	if dataset.EmbeddingModel == "" {
+		return nil, ErrNoEmbeddingModel
+	}
+	if err := ProviderServiceApp.AuthorizeProviderModel(ctx, &dataset.Provider, ownerID, dataset.EmbeddingModel); err != nil {
+		return nil, err
+	}
+	llmProvider := &dataset.Provider
+	if req.ProviderID != 0 && req.ProviderID != dataset.ProviderID {
+		if llmProvider, err = ProviderServiceApp.GetProviderRawByID(ctx, req.ProviderID, ownerID); err != nil {
+			return nil, err
+		}
+	}
+
+	// The session's chain overrides the dataset's one
+	chain := &dataset.ChatFallback
+	if session.ChatFallback != nil {
+		chain = session.ChatFallback
+	}
+	// With somewhere to fail over to, events are held back until the model starts answering,
+	// so the client never sees the output of a provider that is then replaced
+	var pending []models.ChatStreamEvent
+	answering := false
+	relay := emit
+	if len(chain.Targets) > 0 {
+		relay = func(event models.ChatStreamEvent) error {
+			if !answering && event.Type != models.CHAT_EVENT_TEXT && event.Type != models.CHAT_EVENT_THINKING {
+				pending = append(pending, event)
+				return nil
+			}
+			answering = true
+			for _, held := range pending {
+				if err := emit(held); err != nil {
+					return err
+				}
+			}
+			pending = nil
+			return emit(event)
+		}
+	}
+
+	result, servedBy, err := withFallback(ctx, ownerID, llmProvider, req.Model, chain, func(err error) bool {
+		return errors.Is(err, ErrChatBackendFailed) && !answering
+	}, func(provider *models.Provider, served *models.ServedBy) (*ChatStreamResult, error) {
+		pending = nil
+		backendReq, err := this.newChatBackendReq(ctx, &dataset, provider, served.Model, session.ID, req)
+		if err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		result, err := RelayChatStream(ctx, http.DefaultClient, config.Settings.GetRAGChatStreamURL(), backendReq, relay)
+		recordChatStreamUsage(WithUsageScope(ctx, ownerID, dataset.ID), provider, served.Model, req.Query, result, time.Since(start), err)
+		if err != nil {
+			return result, err
+		}
+		if result.Error != "" && result.Answer == "" {
+			return nil, fmt.Errorf("%w: %s", ErrChatBackendFailed, result.Error)
+		}
+		return result, nil
+	})
+	// Events still held back come from the last provider tried, like its error or the sources of an empty answer
+	for _, held := range pending {
+		if emitErr := emit(held); emitErr != nil {
+			if err == nil {
+				err = emitErr
+			}
+			break
+		}
+	}
+	if err != nil {
+		// The part of the answer the client already received stays in the session's history,
+		// saved even though the request context is gone when the client disconnected
+		if result != nil && result.Answer != "" {
+			if _, _, saveErr := this.saveAnswer(context.WithoutCancel(ctx), session.ID, req.Query, result); saveErr != nil {
+				utils.Logger.Errorf("Failed to save the partial answer of session %d: %v", session.ID, saveErr)
+			}
+		}
+		return nil, err
+	}
+
+	memory, chunkIDs, err := this.saveAnswer(ctx, session.ID, req.Query, result)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ChatStreamDone{MessageID: memory.ID, ChunkIDs: chunkIDs, ServedBy: servedBy}, nil
+}
+
+// recordChatStreamUsage records the answer of the RAG backend in the usage ledger, once it started streaming.
+// Without a usage event, tokens are estimated from the question and the answer, leaving out the retrieved chunks
+// and the tool calls the backend added to the prompt.
+func recordChatStreamUsage(ctx context.Context, provider *models.Provider, model string, query string, result *ChatStreamResult, latency time.Duration, err error) {
+	if result == nil {
+		return
+	}
+	event := models.UsageEvent{CallType: models.USAGE_CALL_CHAT, Model: model, Latency: latency, Err: err}
+	if event.Err == nil && result.Error != "" {
+		event.Err = fmt.Errorf("%w: %s", ErrChatBackendFailed, result.Error)
+	}
+	if result.Usage != nil {
+		event.InputTokens = result.Usage.InputTokens
+		event.OutputTokens = result.Usage.OutputTokens
+	} else {
+		event.InputTokens = EstimateTokens(query)
+		event.OutputTokens = EstimateTokens(result.Answer)
+		event.Estimated = true
+	}
+	UsageServiceApp.Record(ctx, provider, event)
+}
+
+// saveAnswer stores a streamed answer with the chunks it cites in the session's history
+func (this *ChatService) saveAnswer(ctx context.Context, sessionID uint, question string, result *ChatStreamResult) (*models.Memory, []uint, error) {
+	chunkIDs, err := this.resolveSourceChunks(ctx, result.Sources)
+	if err != nil {
+		return nil, nil, err
+	}
+	memory, err := this.SaveMemory(ctx, sessionID, question, result.Answer, chunkIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return memory, chunkIDs, nil
+}
+
+func (this *ChatService) newChatBackendReq(ctx context.Context, dataset *models.Dataset, llmProvider *models.Provider, model string, sessionID uint, req models.ChatStreamReq) (models.ChatBackendReq, error) {
+	llmAPIKey, err := ProviderServiceApp.ResolveAPIKey(ctx, llmProvider)
+	if err != nil {
+		return models.ChatBackendReq{}, err
+	}
+	embeddingAPIKey, err := ProviderServiceApp.ResolveAPIKey(ctx, &dataset.Provider)
+	if err != nil {
+		return models.ChatBackendReq{}, err
+	}
+
+	llmProviderType, err := chatBackendProviderType(llmProvider)
+	if err != nil {
+		return models.ChatBackendReq{}, err
+	}
+	// The backend embeds queries with OpenAI-compatible or Ollama clients only.
+	// It sends no custom headers and cannot address Azure OpenAI deployments.
+	if dataset.Provider.Mode == models.PROVIDER_MODE_AZURE_OPENAI {
+		return models.ChatBackendReq{}, fmt.Errorf("%w: embedding provider %d", ErrChatModeUnsupported, dataset.Provider.ID)
+	}
+	embeddingProviderType := models.PROVIDER_MODE_OPENAI
+	if dataset.Provider.Mode == models.PROVIDER_MODE_OLLAMA {
+		embeddingProviderType = models.PROVIDER_MODE_OLLAMA
+	}
+	embedType := dataset.SearchType
+	if embedType == models.SEARCH_TYPE_KEYWORD {
+		embedType = models.SEARCH_TYPE_HYBRID
+	}
+	topK := req.TopK
+	if topK <= 0 {
+		topK = DEFAULT_SEARCH_TOP_K
+	}
+
+	return models.ChatBackendReq{
+		Query:     req.Query,
+		DatasetID: dataset.ID,
+		SessionID: sessionID,
+		LLMConfig: models.ChatBackendModelConfig{
+			ModelName:    model,
+			APIKey:       llmAPIKey,
+			BaseURL:      providerAPIBaseURL(llmProvider),
+			ProviderType: llmProviderType,
+		},
+		EmbeddingConfig: models.ChatBackendEmbeddingConfig{
+			ModelName:    dataset.EmbeddingModel,
+			BaseURL:      providerAPIBaseURL(&dataset.Provider),
+			APIKey:       embeddingAPIKey,
+			ProviderType: embeddingProviderType,
+			EmbedType:    embedType,
+		},
+		RetrievalConfig: models.ChatBackendRetrievalConfig{TopK: topK, CollectionName: DatasetCollection(dataset)},
+		SystemPrompt:    req.SystemPrompt,
+	}, nil
+}
+
+// chatBackendProviderType tells the RAG backend which client answers with the provider.
+// The backend talks to every provider through an OpenAI chat completions client, so the modes serving that API
+// at their base URL all pass as openai. Azure OpenAI puts the deployment in the path and wants an api-version,
+// which the backend cannot send.
+func chatBackendProviderType(provider *models.Provider) (string, error) {
+	switch provider.Mode {
+	case models.PROVIDER_MODE_OPENAI, models.PROVIDER_MODE_OPENAI_RESP, models.PROVIDER_MODE_OPENAI_COMPATIBLE, models.PROVIDER_MODE_MISTRAL:
+		return models.PROVIDER_MODE_OPENAI, nil
+	case models.PROVIDER_MODE_AZURE_OPENAI:
+		return "", fmt.Errorf("%w: chat provider %d", ErrChatModeUnsupported, provider.ID)
+	default:
+		return provider.Mode, nil
+	}
+}
+
+// resolveSourceChunks maps the Milvus entity IDs of cited sources to chunk IDs, keeping citation order
+func (this *ChatService) resolveSourceChunks(ctx context.Context, sources []models.ChatBackendSource) ([]uint, error) {
+	chunkIDs := []uint{}
+	if len(sources) == 0 {
+		return chunkIDs, nil
+	}
+
+	vectorIDs := make([]string, 0, len(sources))
+	for _, source := range sources {
+		vectorIDs = append(vectorIDs, fmt.Sprint(source.ID))
+	}
+	chunks, err := gorm.G[models.Chunk](db.PgSqlDB).
+		Select("id", "vector_id").
+		Where("vector_id IN ?", vectorIDs).
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	chunkByVectorID := make(map[string]uint, len(chunks))
+	for _, chunk := range chunks {
+		chunkByVectorID[chunk.VectorID] = chunk.ID
+	}
+
+	seen := map[uint]bool{}
+	for _, vectorID := range vectorIDs {
+		if chunkID, ok := chunkByVectorID[vectorID]; ok && !seen[chunkID] {
+			seen[chunkID] = true
+			chunkIDs = append(chunkIDs, chunkID)
+		}
+	}
+	return chunkIDs, nil
+}
+
+// RelayChatStream posts a chat request to the RAG backend and passes each streamed event to emit.
+// The backend answers with one JSON event per line; SSE framed lines ("data: {...}") are accepted too.
+// The next line is only read after emit returns, and cancelling ctx aborts the backend request.
+// When the stream breaks off, the part of the answer relayed so far is returned along with the error.
+// Usage events are kept in the result rather than relayed.
+func RelayChatStream(ctx context.Context, client *http.Client, url string, req models.ChatBackendReq, emit func(models.ChatStreamEvent) error) (*ChatStreamResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %v", ErrChatBackendUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%w: status %d: %s", ErrChatBackendUnavailable, resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+
+	result := &ChatStreamResult{}
+	var answer strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), CHAT_STREAM_MAX_LINE)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "data:") {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		} else if line == "" || strings.HasPrefix(line, ":") || strings.HasPrefix(line, "event:") {
+			continue
+		}
+
+		var event models.ChatStreamEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			utils.Logger.Warnf("Skipping malformed chat stream line: %v", err)
+			continue
+		}
+		switch event.Type {
+		case models.CHAT_EVENT_TEXT:
+			answer.WriteString(event.Content)
+		case models.CHAT_EVENT_SOURCE:
+			var sources []models.ChatBackendSource
+			if err := json.Unmarshal([]byte(event.Content), &sources); err != nil {
+				utils.Logger.Warnf("Skipping malformed chat sources: %v", err)
+			}
+			result.Sources = append(result.Sources, sources...)
+		case models.CHAT_EVENT_ERROR:
+			result.Error = event.Content
+		case models.CHAT_EVENT_USAGE:
+			var usage models.ChatBackendUsage
+			if err := json.Unmarshal([]byte(event.Content), &usage); err != nil {
+				utils.Logger.Warnf("Skipping malformed chat usage: %v", err)
+				continue
+			}
+			if result.Usage == nil {
+				result.Usage = &models.ChatBackendUsage{}
+			}
+			result.Usage.InputTokens += usage.InputTokens
+			result.Usage.OutputTokens += usage.OutputTokens
+			continue
+		}
+		if err := emit(event); err != nil {
+			result.Answer = answer.String()
+			return result, err
+		}
+	}
+	result.Answer = answer.String()
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		return result, fmt.Errorf("%w: %v", ErrChatBackendUnavailable, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"server/db"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DueClaim describes rows the replicas take turns handling, like outbox events or webhook deliveries.
+// A replica locks the due rows no other replica holds, then pushes back the time they are due by a lease,
+// so the others skip them until it reports back or the lease runs out.
+type DueClaim struct {
+	Due         string // Condition of the due rows, with its Args
+	Args        []any
+	Order       string
+	Limit       int    // Rows claimed at once, all of them when zero
+	LeaseColumn string // Column of the time a row is due
+	Lease       time.Duration
+}
+
+// Lock returns the query locking the due rows, skipping those another replica locked
+func (this DueClaim) Lock(tx *gorm.DB) *gorm.DB {
+	query := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate, Options: clause.LockingOptionsSkipLocked}).
+		Where(this.Due, this.Args...).
+		Order(this.Order)
+	if this.Limit > 0 {
+		query = query.Limit(this.Limit)
+	}
+	return query
+}
+
+// claimDue claims the due rows of T for a lease
+func claimDue[T any](ctx context.Context, claim DueClaim) (rows []T, err error) {
+	err = db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := claim.Lock(tx).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.Model(&rows).Update(claim.LeaseColumn, time.Now().Add(claim.Lease)).Error
+	})
+	return rows, err
+}
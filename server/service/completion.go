@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Passages of the dataset given to the model when the request sets no top_k
+	DEFAULT_COMPLETION_TOP_K = 5
+
+	COMPLETION_CONTEXT_PROMPT = `Answer the user's question using the numbered passages from the knowledge base below.
+Cite the passages you use with their number, like [1]. If the passages do not contain the answer, say that you do not know.
+
+%s`
+)
+
+var CompletionServiceApp = new(CompletionService)
+
+type CompletionService struct{}
+
+// ChatCompletion is a chat completion request resolved to a provider, a model and the retrieved passages
+type ChatCompletion struct {
+	Citations []models.OpenAICitation
+	ServedBy  *models.ServedBy // Provider and model the answer is generated with, set before each provider call
+	ownerID   uint
+	datasetID uint
+	provider  *models.Provider
+	model     string
+	chain     *models.FallbackChain
+	messages  []models.LLMMessage
+	params    models.LLMParams
+}
+
+// Stream generates the answer, passing each piece of text to onDelta as the provider streams it.
+// Until the first piece is passed on, a failing provider is replaced by the next one of the dataset's chat chain.
+func (this *ChatCompletion) Stream(ctx context.Context, onDelta func(string) error) (*models.LLMResult, error) {
+	ctx = WithUsageScope(ctx, this.ownerID, this.datasetID)
+	streamed := false
+	result, _, err := withFallback(ctx, this.ownerID, this.provider, this.model, this.chain, func(err error) bool {
+		return !streamed
+	}, func(provider *models.Provider, served *models.ServedBy) (*models.LLMResult, error) {
+		this.ServedBy = served
+		return ProviderServiceApp.StreamChatCompletion(ctx, provider, served.Model, this.messages, this.params, func(delta string) error {
+			streamed = true
+			return onDelta(delta)
+		})
+	})
+	return result, err
+}
+
+// CompletionModelID builds the model ID selecting a dataset and the model answering from it
+func CompletionModelID(datasetID uint, providerID uint, model string) string {
+	return fmt.Sprintf("%s%d/%d/%s", models.OPENAI_MODEL_DATASET_PREFIX, datasetID, providerID, model)
+}
+
+// ParseCompletionModelID splits a model ID built by CompletionModelID
+func ParseCompletionModelID(id string) (datasetID uint, providerID uint, model string, err error) {
+	rest, ok := strings.CutPrefix(id, models.OPENAI_MODEL_DATASET_PREFIX)
+	if !ok {
+		return 0, 0, "", ErrCompletionModelInvalid
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return 0, 0, "", ErrCompletionModelInvalid
+	}
+	dataset, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil || dataset == 0 {
+		return 0, 0, "", ErrCompletionModelInvalid
+	}
+	provider, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || provider == 0 {
+		return 0, 0, "", ErrCompletionModelInvalid
+	}
+	return uint(dataset), uint(provider), parts[2], nil
+}
+
+// ListCompletionModels lists every dataset of the owner combined with every chat model of the owner's providers
+// and of the shared providers granted to them.
+// Providers whose models cannot be listed are skipped.
+func (this *CompletionService) ListCompletionModels(ctx context.Context, ownerID uint) ([]models.OpenAIModel, error) {
+	datasets, err := gorm.G[models.Dataset](db.PgSqlDB).
+		Select("id", "created_at").
+		Where("owner_id = ?", ownerID).
+		Order("id").
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	providers, err := gorm.G[models.Provider](db.PgSqlDB).
+		Select("id", "name").
+		Where(PROVIDER_ACCESS_CONDITION, ownerID, ownerID).
+		Order("id").
+		Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	completionModels := []models.OpenAIModel{}
+	for _, provider := range providers {
+		providerModels, err := ProviderServiceApp.ListModels(ctx, provider.ID, ownerID, false)
+		if err != nil {
+			utils.Logger.Warnf("Skipping models of provider %d: %v", provider.ID, err)
+			continue
+		}
+		for _, model := range providerModels.Models {
+			if !HasModelType(&model, models.MODEL_TYPE_CHAT) {
+				continue
+			}
+			for _, dataset := range datasets {
+				completionModels = append(completionModels, models.OpenAIModel{
+					ID:      CompletionModelID(dataset.ID, provider.ID, model.ID),
+					Object:  models.OPENAI_OBJECT_MODEL,
+					Created: dataset.CreatedAt.Unix(),
+					OwnedBy: provider.Name,
+				})
+			}
+		}
+	}
+	return completionModels, nil
+}
+
+// PrepareChatCompletion resolves the dataset and provider selected by the request's model,
+// retrieves passages of the dataset for the last user message and puts them in front of the conversation.
+func (this *CompletionService) PrepareChatCompletion(ctx context.Context, ownerID uint, req models.OpenAIChatCompletionReq) (*ChatCompletion, error) {
+	datasetID, providerID, model, err := ParseCompletionModelID(req.Model)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := ProviderServiceApp.GetProviderRawByID(ctx, providerID, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ProviderServiceApp.AuthorizeProviderModel(ctx, provider, ownerID, model); err != nil {
+		return nil, err
+	}
+
+	query := ""
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == models.LLM_ROLE_USER {
+			query = strings.TrimSpace(string(req.Messages[i].Content))
+			break
+		}
+	}
+	if query == "" {
+		return nil, ErrNoUserMessage
+	}
+
+	topK := req.TopK
+	if topK <= 0 {
+		topK = DEFAULT_COMPLETION_TOP_K
+	}
+	results, err := SearchServiceApp.SearchDataset(ctx, datasetID, ownerID, query, topK)
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := gorm.G[models.Dataset](db.PgSqlDB).
+		Select("id", "chat_fallback").
+		Where("id = ? AND owner_id = ?", datasetID, ownerID).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	citations := make([]models.OpenAICitation, 0, len(results))
+	var passages strings.Builder
+	for i, result := range results {
+		citations = append(citations, models.OpenAICitation{
+			Index:     i + 1,
+			ChunkID:   result.ChunkID,
+			FileID:    result.FileID,
+			FileName:  result.FileName,
+			DatasetID: result.DatasetID,
+			Content:   result.Content,
+			Score:     result.Score,
+		})
+		fmt.Fprintf(&passages, "[%d] (%s)\n%s\n\n", i+1, result.FileName, result.Content)
+	}
+
+	// The retrieved passages come first, the client's own system prompt follows
+	messages := make([]models.LLMMessage, 0, len(req.Messages)+1)
+	messages = append(messages, models.LLMMessage{
+		Role:    models.LLM_ROLE_SYSTEM,
+		Content: fmt.Sprintf(COMPLETION_CONTEXT_PROMPT, strings.TrimSpace(passages.String())),
+	})
+	for _, message := range req.Messages {
+		role := message.Role
+		// "developer" is the newer OpenAI name of the system role
+		if role == "developer" {
+			role = models.LLM_ROLE_SYSTEM
+		}
+		messages = append(messages, models.LLMMessage{Role: role, Content: string(message.Content)})
+	}
+
+	maxTokens := req.MaxCompletionTokens
+	if maxTokens == 0 {
+		maxTokens = req.MaxTokens
+	}
+	return &ChatCompletion{
+		Citations: citations,
+		ownerID:   ownerID,
+		datasetID: datasetID,
+		provider:  provider,
+		model:     model,
+		chain:     &dataset.ChatFallback,
+		messages:  messages,
+		params: models.LLMParams{
+			Temperature: req.Temperature,
+			TopP:        req.TopP,
+			MaxTokens:   maxTokens,
+			Stop:        req.Stop,
+		},
+	}, nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"server/db"
 	"server/models"
 
@@ -19,19 +20,34 @@ func (this *DatasetService) CheckDatasetExistsByName(ctx context.Context, ownerI
 	return cnt > 0, err
 }
 
-func (this *DatasetService) CreateNewDataset(ctx context.Context, icon string, datasetName string, description string, searchType string, embeddingModel string, providerID uint, ownerID uint) error {
+func (this *DatasetService) CreateNewDataset(ctx context.Context, icon string, datasetName string, description string, searchType string, embeddingModel string, providerID uint, tags []string, textSearchConfig string, rerank models.DatasetRerankConfig, ownerID uint) error {
 
 	dbDataset := models.Dataset{
-		Name:           datasetName,
-		Icon:           icon,
-		Description:    description,
-		SearchType:     searchType,
-		EmbeddingModel: embeddingModel,
-		ProviderID:     providerID,
-		OwnerID:        ownerID,
+		Name:             datasetName,
+		Icon:             icon,
+		Description:      description,
+		SearchType:       searchType,
+		EmbeddingModel:   embeddingModel,
+		ProviderID:       providerID,
+		Tags:             tags,
+		TextSearchConfig: textSearchConfig,
+		RerankType:       rerank.Type,
+		RerankModel:      rerank.Model,
+		OwnerID:          ownerID,
 	}
-	return gorm.G[models.Dataset](db.PgSqlDB).Create(ctx, &dbDataset)
-
+	if rerank.ProviderID != 0 {
+		dbDataset.RerankProviderID = &rerank.ProviderID
+	}
+	err := QuotaServiceApp.WithinQuota(ctx, ownerID, func(tx *gorm.DB, plan *models.QuotaPlan) error {
+		if err := QuotaServiceApp.CheckDataset(ctx, tx, plan, ownerID); err != nil {
+			return err
+		}
+		if err := gorm.G[models.Dataset](tx).Create(ctx, &dbDataset); err != nil {
+			return err
+		}
+		return EventServiceApp.Publish(ctx, tx, datasetEvent(models.EVENT_DATASET_CREATED, &dbDataset))
+	})
+	return err
 }
 
 func (this *DatasetService) GetDatasetInfoByID(ctx context.Context, id uint, ownerID uint) (dbDataset *models.DatasetInfo, err error) {
@@ -54,36 +70,156 @@ func (this *DatasetService) ListDatasetsByOwnerID(ctx context.Context, ownerID u
 	return result.RowsAffected, datasets, result.Error
 }
 
-func (this *DatasetService) UpdateDataset(ctx context.Context, id uint, ownerID uint, icon string, name string, description string, searchType string, embeddingModel string, providerID uint) error {
+func (this *DatasetService) UpdateDataset(ctx context.Context, id uint, ownerID uint, icon string, name string, description string, searchType string, embeddingModel string, providerID uint, tags []string, textSearchConfig string, rerank models.DatasetRerankConfig) error {
 
 	newDatasetInfo := models.Dataset{
-		Icon:           icon,
-		Name:           name,
-		Description:    description,
-		SearchType:     searchType,
-		EmbeddingModel: embeddingModel,
-		ProviderID:     providerID,
+		Icon:             icon,
+		Name:             name,
+		Description:      description,
+		SearchType:       searchType,
+		EmbeddingModel:   embeddingModel,
+		ProviderID:       providerID,
+		Tags:             tags,
+		TextSearchConfig: textSearchConfig,
 	}
 
-	rowsAffected, err := gorm.G[models.Dataset](db.PgSqlDB).
+	// Chunks are only reindexed when the text search config actually changes,
+	// and the embedding chain only holds while the embedding stays the same
+	reindex, embeddingChanged := false, false
+	if textSearchConfig != "" || providerID != 0 || embeddingModel != "" {
+		dataset, err := gorm.G[models.Dataset](db.PgSqlDB).
+			Where("id = ? AND owner_id = ?", id, ownerID).
+			First(ctx)
+		if err != nil {
+			return err
+		}
+		reindex = textSearchConfig != "" && dataset.TextSearchConfig != textSearchConfig
+		embeddingChanged = (providerID != 0 && providerID != dataset.ProviderID) ||
+			(embeddingModel != "" && embeddingModel != dataset.EmbeddingModel)
+	}
+
+	if err := db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rowsAffected, err := gorm.G[models.Dataset](tx).
+			Where("id = ? AND owner_id = ?", id, ownerID).
+			Updates(ctx, newDatasetInfo)
+		// id not found
+		if rowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+		// A rerank type replaces the whole rerank config, zero values included, so reranking can be turned off
+		if rerank.Type != "" {
+			rerankInfo := models.Dataset{RerankType: rerank.Type, RerankModel: rerank.Model}
+			if rerank.ProviderID != 0 {
+				rerankInfo.RerankProviderID = &rerank.ProviderID
+			}
+			if _, err := gorm.G[models.Dataset](tx).
+				Where("id = ?", id).
+				Select("rerank_type", "rerank_provider_id", "rerank_model").
+				Updates(ctx, rerankInfo); err != nil {
+				return err
+			}
+		}
+		if embeddingChanged {
+			if _, err := gorm.G[models.Dataset](tx).
+				Where("id = ?", id).
+				Select("embedding_fallback").
+				Updates(ctx, models.Dataset{}); err != nil {
+				return err
+			}
+		}
+		return this.publishDatasetEvent(ctx, tx, models.EVENT_DATASET_UPDATED, id)
+	}); err != nil {
+		return err
+	}
+	if !reindex {
+		return nil
+	}
+	return db.ReindexDatasetChunks(ctx, db.PgSqlDB, id)
+}
+
+// SetDatasetFallback sets the chat and embedding chains of a dataset.
+// Every model of the embedding chain is probed, and the chain is rejected unless all embed with the dimension of the dataset's model.
+func (this *DatasetService) SetDatasetFallback(ctx context.Context, id uint, ownerID uint, chat models.FallbackChain, embedding models.FallbackChain) error {
+	dataset, err := gorm.G[models.Dataset](db.PgSqlDB).
+		Preload("Provider", nil).
 		Where("id = ? AND owner_id = ?", id, ownerID).
-		Updates(ctx, newDatasetInfo)
-	// id not found
-	if rowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+		First(ctx)
+	if err != nil {
+		return err
+	}
+
+	embedding.Dimension = 0
+	if len(embedding.Targets) > 0 {
+		if dataset.EmbeddingModel == "" {
+			return ErrNoEmbeddingModel
+		}
+		ctx := WithUsageScope(ctx, ownerID, id)
+		dimension, err := this.probeEmbeddingDimension(ctx, &dataset.Provider, dataset.EmbeddingModel)
+		if err != nil {
+			return err
+		}
+		for _, target := range embedding.Targets {
+			provider, err := ProviderServiceApp.GetProviderRawByID(ctx, target.ProviderID, ownerID)
+			if err != nil {
+				return err
+			}
+			targetDimension, err := this.probeEmbeddingDimension(ctx, provider, target.Model)
+			if err != nil {
+				return err
+			}
+			if targetDimension != dimension {
+				return fmt.Errorf("%w: %s of provider %d embeds with %d dimensions, the dataset with %d", ErrEmbeddingDimensionMismatch,
+					target.Model, target.ProviderID, targetDimension, dimension)
+			}
+		}
+		embedding.Dimension = dimension
 	}
+	chat.Dimension = 0
+
+	_, err = gorm.G[models.Dataset](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", id, ownerID).
+		Select("chat_fallback", "embedding_fallback").
+		Updates(ctx, models.Dataset{ChatFallback: chat, EmbeddingFallback: embedding})
 	return err
 }
 
+func (this *DatasetService) probeEmbeddingDimension(ctx context.Context, provider *models.Provider, model string) (int, error) {
+	embeddings, err := ProviderServiceApp.EmbedTexts(ctx, provider, model, []string{REINDEX_DIMENSION_PROBE})
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrEmbeddingProbeFailed, err)
+	}
+	if len(embeddings) == 0 || len(embeddings[0]) == 0 {
+		return 0, fmt.Errorf("%w: %v", ErrEmbeddingProbeFailed, ErrEmptyEmbedding)
+	}
+	return len(embeddings[0]), nil
+}
+
 func (this *DatasetService) DeleteDataset(ctx context.Context, id uint, ownerID uint) error {
-	rowsAffected, err := gorm.G[models.Dataset](db.PgSqlDB).
-		Where("id = ? AND owner_id = ?", id, ownerID).
-		Delete(ctx)
-	// id not found
-	if rowsAffected == 0 {
-		return gorm.ErrRecordNotFound
+	return db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rowsAffected, err := gorm.G[models.Dataset](tx).
+			Where("id = ? AND owner_id = ?", id, ownerID).
+			Delete(ctx)
+		// id not found
+		if rowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return this.publishDatasetEvent(ctx, tx, models.EVENT_DATASET_DELETED, id)
+	})
+}
+
+// publishDatasetEvent writes a lifecycle event of a dataset to the outbox in tx, deleted datasets included
+func (this *DatasetService) publishDatasetEvent(ctx context.Context, tx *gorm.DB, name string, id uint) error {
+	dataset, err := gorm.G[models.Dataset](tx.Unscoped()).Where("id = ?", id).First(ctx)
+	if err != nil {
+		return err
 	}
-	return err
+	return EventServiceApp.Publish(ctx, tx, datasetEvent(name, &dataset))
 }
 
 // GetDatasetInfoByName retrieves a dataset by its name and owner ID using fuzzy matching (contains)
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"server/config"
+	"server/db"
+	"server/models"
+	"time"
+)
+
+// Wait for the broker when inspecting, requeueing or purging dead letters
+const DEAD_LETTER_TIMEOUT = 30 * time.Second
+
+var DeadLetterServiceApp = new(DeadLetterService)
+
+// DeadLetterService retries the ingestion messages the worker rejects, and lets administrators inspect, requeue
+// or purge the ingestion messages and lifecycle events dead-lettered after their last retry
+type DeadLetterService struct{}
+
+func ingestionTopology() db.RetryTopology {
+	return db.NewRetryTopology(config.Settings.GetRabbitMQQueue())
+}
+
+// deadLetterTopology returns the topology of a queue of the admin API, the ingestion queue when empty
+func deadLetterTopology(queue string) db.RetryTopology {
+	if queue == models.DEAD_LETTER_QUEUE_WEBHOOK_EVENTS {
+		return db.NewRetryTopology(WEBHOOK_EVENTS_QUEUE)
+	}
+	return ingestionTopology()
+}
+
+// Start routes the rejected ingestion messages to their retry until ctx is done
+func (this *DeadLetterService) Start(ctx context.Context) {
+	go db.RabbitMQClient.RouteRejected(ctx, ingestionTopology())
+}
+
+// ListDeadLetters returns the oldest dead-lettered messages of a queue without taking them off the queue
+func (this *DeadLetterService) ListDeadLetters(ctx context.Context, queue string, limit int) (total int, messages []models.DeadLetterInfo, err error) {
+	if limit <= 0 {
+		limit = models.DEFAULT_DEAD_LETTER_LIMIT
+	}
+	ctx, cancel := context.WithTimeout(ctx, DEAD_LETTER_TIMEOUT)
+	defer cancel()
+	total, deliveries, err := db.RabbitMQClient.PeekDeadLetters(ctx, deadLetterTopology(queue), limit)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	messages = make([]models.DeadLetterInfo, len(deliveries))
+	for i, delivery := range deliveries {
+		payload := json.RawMessage(delivery.Body)
+		if !json.Valid(payload) {
+			payload, _ = json.Marshal(string(delivery.Body))
+		}
+		messages[i] = models.DeadLetterInfo{
+			MessageID: delivery.MessageId,
+			Retries:   db.RetryCount(delivery.Headers),
+			Payload:   payload,
+		}
+		messages[i].Reason, _ = delivery.Headers[db.DEAD_LETTER_REASON_HEADER].(string)
+		if deadLetteredAt, ok := delivery.Headers[db.DEAD_LETTERED_AT_HEADER].(time.Time); ok {
+			messages[i].DeadLetteredAt = &deadLetteredAt
+		}
+	}
+	return total, messages, nil
+}
+
+// RequeueDeadLetters sends dead-lettered messages back to their queue with their retries reset,
+// those with the given IDs or all of them
+func (this *DeadLetterService) RequeueDeadLetters(ctx context.Context, queue string, messageIDs []string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, DEAD_LETTER_TIMEOUT)
+	defer cancel()
+	return db.RabbitMQClient.RequeueDeadLetters(ctx, deadLetterTopology(queue), messageIDs)
+}
+
+// PurgeDeadLetters deletes every dead-lettered message of a queue
+func (this *DeadLetterService) PurgeDeadLetters(ctx context.Context, queue string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, DEAD_LETTER_TIMEOUT)
+	defer cancel()
+	return db.RabbitMQClient.PurgeDeadLetters(ctx, deadLetterTopology(queue))
+}
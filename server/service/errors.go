@@ -13,4 +13,40 @@ var (
 	ErrOpenFile      = errors.New("Failed to open file")
 	ErrUploadFile    = errors.New("Failed to upload file to MinIO")
 	ErrSaveFileInfo  = errors.New("Failed to save file record to database")
+
+	ErrVectorStoreUnavailable = errors.New("Vector store is unavailable")
+	ErrEmptyEmbedding         = errors.New("Embedding provider returned no vectors")
+
+	ErrReindexJobRunning    = errors.New("A reindex job is already running for this dataset")
+	ErrReindexJobNotRunning = errors.New("Reindex job is not running")
+	ErrEmbeddingProbeFailed = errors.New("Embedding model is unavailable")
+
+	ErrEmbeddingDimensionMismatch = errors.New("Embedding model has another dimension than the dataset")
+	ErrNoEmbeddingModel           = errors.New("Dataset has no embedding model")
+
+	ErrChatBackendUnavailable = errors.New("RAG backend is unavailable")
+	ErrChatBackendFailed      = errors.New("RAG backend failed to answer")
+	ErrChatModeUnsupported    = errors.New("Session chat does not support Azure OpenAI providers")
+
+	ErrLLMRequestFailed       = errors.New("LLM provider request failed")
+	ErrCompletionModelInvalid = errors.New("Model is not a dataset completion model")
+	ErrNoUserMessage          = errors.New("Messages contain no user message")
+
+	ErrWrongChunksOnPositiveFeedback = errors.New("Wrong chunks can only be flagged on negative feedback")
+	ErrChunkNotCited                 = errors.New("Flagged chunk is not cited by the answer")
+
+	ErrNotEmbeddingModel      = errors.New("Model is not an embedding model")
+	ErrInvalidCACert          = errors.New("CA bundle contains no PEM certificate")
+	ErrProviderAddressRefused = errors.New("Provider address is not public")
+	ErrWebhookAddressRefused  = errors.New("Webhook address is not public")
+
+	ErrQuotaExceeded     = errors.New("Quota exceeded")
+	ErrQuotaPlanNotFound = errors.New("Quota plan not found")
+
+	ErrModelNotGranted = errors.New("Model of the shared provider is not granted to the user")
+	ErrUserNotFound    = errors.New("User not found")
+
+	ErrSecretNotFound           = errors.New("Secret not found in the secrets backend")
+	ErrUnknownSecretsBackend    = errors.New("Unknown secrets backend")
+	ErrSecretsBackendNotEnabled = errors.New("Secrets backend is not configured")
 )
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"server/config"
+	"server/models"
+	"server/utils"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var EventServiceApp = new(EventService)
+
+// EventService publishes the lifecycle events of files and datasets through the outbox to the RABBITMQ_EXCHANGE
+// topic exchange, routed by event name, where webhook delivery and other consumers pick them up
+type EventService struct{}
+
+// Outbox returns the outbox event publishing a lifecycle event, filling its ID and timestamp
+func (this *EventService) Outbox(event models.LifecycleEvent) (models.OutboxEvent, error) {
+	if event.ID == "" {
+		id, err := utils.GenerateSnowID()
+		if err != nil {
+			return models.OutboxEvent{}, err
+		}
+		event.ID = strconv.FormatUint(id, 10)
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	return outboxMessage(config.Settings.GetRabbitMQExchange(), event.Event, event)
+}
+
+// Publish writes a lifecycle event to the outbox in tx, so it is published once the change it reports is committed
+func (this *EventService) Publish(ctx context.Context, tx *gorm.DB, event models.LifecycleEvent) error {
+	outboxEvent, err := this.Outbox(event)
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s event: %w", event.Event, err)
+	}
+	return OutboxServiceApp.Add(ctx, tx, outboxEvent)
+}
+
+func fileEvent(name string, file *models.File) models.LifecycleEvent {
+	return models.LifecycleEvent{
+		Event:     name,
+		UserID:    file.UserID,
+		DatasetID: file.DatasetID,
+		FileID:    file.ID,
+		Data:      map[string]any{"name": file.Name, "type": file.Type, "size": file.Size},
+	}
+}
+
+func datasetEvent(name string, dataset *models.Dataset) models.LifecycleEvent {
+	return models.LifecycleEvent{
+		Event:     name,
+		UserID:    dataset.OwnerID,
+		DatasetID: dataset.ID,
+		Data:      map[string]any{"name": dataset.Name},
+	}
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"server/models"
+	"server/utils"
+	"slices"
+)
+
+// ShouldFailover tells whether an error of a provider call moves on to the next target of the chain
+func ShouldFailover(chain *models.FallbackChain, err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	failoverOn := chain.FailoverOn
+	if len(failoverOn) == 0 {
+		failoverOn = models.DEFAULT_FAILOVER_ON
+	}
+	return slices.Contains(failoverOn, ClassifyProviderError(err))
+}
+
+// withFallback calls the primary provider and model, then the targets of the chain in order for as long as
+// the call fails with an error the chain fails over on and canFailover allows it, e.g. while nothing was streamed yet.
+// Targets are loaded lazily and skipped when their provider is gone or no longer granted. served is filled before each call.
+func withFallback[T any](ctx context.Context, ownerID uint, primary *models.Provider, model string, chain *models.FallbackChain,
+	canFailover func(err error) bool, call func(provider *models.Provider, served *models.ServedBy) (T, error)) (T, *models.ServedBy, error) {
+	served := &models.ServedBy{ProviderID: primary.ID, ProviderName: primary.Name, Model: model}
+	if err := ProviderServiceApp.AuthorizeProviderModel(ctx, primary, ownerID, model); err != nil {
+		var zero T
+		return zero, served, err
+	}
+	result, err := call(primary, served)
+	if err == nil || chain == nil {
+		return result, served, err
+	}
+
+	for _, target := range chain.Targets {
+		if ctx.Err() != nil || !ShouldFailover(chain, err) || !canFailover(err) {
+			break
+		}
+		provider, loadErr := ProviderServiceApp.GetProviderRawByID(ctx, target.ProviderID, ownerID)
+		if loadErr == nil {
+			loadErr = ProviderServiceApp.AuthorizeProviderModel(ctx, provider, ownerID, target.Model)
+		}
+		if loadErr != nil {
+			utils.Logger.Warnf("Skipping fallback provider %d: %v", target.ProviderID, loadErr)
+			continue
+		}
+		utils.Logger.Warnf("Provider %d (%s) failed with %s error, failing over to provider %d (%s): %v",
+			served.ProviderID, served.Model, ClassifyProviderError(err), provider.ID, target.Model, err)
+		served = &models.ServedBy{ProviderID: provider.ID, ProviderName: provider.Name, Model: target.Model, Fallback: true}
+		result, err = call(provider, served)
+	}
+	return result, served, err
+}
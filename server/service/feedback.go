@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"server/db"
+	"server/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var FeedbackServiceApp = new(FeedbackService)
+
+type FeedbackService struct{}
+
+// SubmitFeedback rates an answer of one of the user's sessions, replacing the previous rating if any
+func (this *FeedbackService) SubmitFeedback(ctx context.Context, memoryID uint, userID uint, rating int, reason string, wrongChunkIDs []uint) (*models.FeedbackInfo, error) {
+	if rating != models.FEEDBACK_RATING_DOWN && len(wrongChunkIDs) > 0 {
+		return nil, ErrWrongChunksOnPositiveFeedback
+	}
+	if err := this.checkMemoryOwnership(ctx, memoryID, userID); err != nil {
+		return nil, err
+	}
+	slices.Sort(wrongChunkIDs)
+	wrongChunkIDs = slices.Compact(wrongChunkIDs)
+
+	feedback := models.Feedback{
+		MemoryID: memoryID,
+		UserID:   userID,
+		Rating:   rating,
+		Reason:   reason,
+	}
+	err := db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// A rating submitted twice at once replaces the other one instead of failing on the unique memory_id.
+		// The stored row is read back, so a replaced rating keeps its ID and creation time.
+		upsert := clause.OnConflict{
+			Columns:   []clause.Column{{Name: "memory_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"rating", "reason", "updated_at"}),
+		}
+		if err := gorm.G[models.Feedback](tx, upsert, clause.Returning{}).Create(ctx, &feedback); err != nil {
+			return err
+		}
+
+		if err := tx.Exec("DELETE FROM feedback_wrong_chunks WHERE feedback_id = ?", feedback.ID).Error; err != nil {
+			return err
+		}
+		if len(wrongChunkIDs) == 0 {
+			return nil
+		}
+		// Only chunks cited by the answer can be flagged
+		result := tx.Exec(`INSERT INTO feedback_wrong_chunks (feedback_id, chunk_id)
+			SELECT ?, chunk_id FROM memory_documents WHERE memory_id = ? AND chunk_id IN ?`,
+			feedback.ID, memoryID, wrongChunkIDs)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != int64(len(wrongChunkIDs)) {
+			return ErrChunkNotCited
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toFeedbackInfo(&feedback, wrongChunkIDs), nil
+}
+
+// DeleteFeedback withdraws the rating of an answer
+func (this *FeedbackService) DeleteFeedback(ctx context.Context, memoryID uint, userID uint) error {
+	if err := this.checkMemoryOwnership(ctx, memoryID, userID); err != nil {
+		return err
+	}
+	return db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		feedback, err := gorm.G[models.Feedback](tx).
+			Where("memory_id = ?", memoryID).
+			First(ctx)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		// The flagged chunks reference the feedback, which is deleted for good below
+		if err := tx.Model(&feedback).Association("WrongChunks").Clear(); err != nil {
+			return err
+		}
+		// Deleted for good, so the answer can be rated again despite the unique memory_id
+		_, err = gorm.G[models.Feedback](tx.Unscoped()).
+			Where("id = ?", feedback.ID).
+			Delete(ctx)
+		return err
+	})
+}
+
+// ListFeedback returns the ratings of the given answers by answer ID
+func (this *FeedbackService) ListFeedback(ctx context.Context, memoryIDs []uint) (map[uint]*models.FeedbackInfo, error) {
+	feedbackByMemory := make(map[uint]*models.FeedbackInfo, len(memoryIDs))
+	if len(memoryIDs) == 0 {
+		return feedbackByMemory, nil
+	}
+	feedbacks, err := gorm.G[models.Feedback](db.PgSqlDB).
+		Where("memory_id IN ?", memoryIDs).
+		Find(ctx)
+	if err != nil || len(feedbacks) == 0 {
+		return feedbackByMemory, err
+	}
+
+	feedbackIDs := make([]uint, 0, len(feedbacks))
+	for _, feedback := range feedbacks {
+		feedbackIDs = append(feedbackIDs, feedback.ID)
+	}
+	var flags []struct {
+		FeedbackID uint
+		ChunkID    uint
+	}
+	if err := db.PgSqlDB.WithContext(ctx).
+		Raw("SELECT feedback_id, chunk_id FROM feedback_wrong_chunks WHERE feedback_id IN ? ORDER BY chunk_id", feedbackIDs).
+		Scan(&flags).Error; err != nil {
+		return nil, err
+	}
+	wrongChunkIDs := map[uint][]uint{}
+	for _, flag := range flags {
+		wrongChunkIDs[flag.FeedbackID] = append(wrongChunkIDs[flag.FeedbackID], flag.ChunkID)
+	}
+
+	for i := range feedbacks {
+		feedbackByMemory[feedbacks[i].MemoryID] = toFeedbackInfo(&feedbacks[i], wrongChunkIDs[feedbacks[i].ID])
+	}
+	return feedbackByMemory, nil
+}
+
+// checkMemoryOwnership verifies that the answer belongs to a session of the user
+func (this *FeedbackService) checkMemoryOwnership(ctx context.Context, memoryID uint, userID uint) error {
+	cnt, err := gorm.G[models.Memory](db.PgSqlDB).
+		Where("id = ? AND session_id IN (?)", memoryID,
+			db.PgSqlDB.Model(&models.ChatSession{}).Select("id").Where("owner_id = ?", userID)).
+		Count(ctx, "*")
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func toFeedbackInfo(feedback *models.Feedback, wrongChunkIDs []uint) *models.FeedbackInfo {
+	if wrongChunkIDs == nil {
+		wrongChunkIDs = []uint{}
+	}
+	return &models.FeedbackInfo{
+		ID:            feedback.ID,
+		MessageID:     feedback.MemoryID,
+		Rating:        feedback.Rating,
+		Reason:        feedback.Reason,
+		WrongChunkIDs: wrongChunkIDs,
+		CreatedAt:     feedback.CreatedAt,
+		UpdatedAt:     feedback.UpdatedAt,
+	}
+}
+
+// DatasetFeedbackReport builds the feedback report of a dataset for its owner
+func (this *FeedbackService) DatasetFeedbackReport(ctx context.Context, datasetID uint, ownerID uint, days int, limit int) (*models.FeedbackReport, error) {
+	if _, err := gorm.G[models.Dataset](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", datasetID, ownerID).
+		First(ctx); err != nil {
+		return nil, err
+	}
+	return this.FeedbackReport(ctx, datasetID, days, limit)
+}
+
+// FeedbackReport aggregates feedback per dataset, and negative feedback per file and per chunk.
+// A datasetID of zero covers all datasets, days of zero all time. Files and chunks are limited to the worst ones.
+// Feedback stays counted when the user deletes the chat session, the documents are what is being judged.
+func (this *FeedbackService) FeedbackReport(ctx context.Context, datasetID uint, days int, limit int) (*models.FeedbackReport, error) {
+	if limit <= 0 {
+		limit = models.DEFAULT_FEEDBACK_REPORT_LIMIT
+	}
+	feedbackFilter := []string{"f.deleted_at IS NULL"}
+	feedbackArgs := []any{}
+	if days > 0 {
+		feedbackFilter = append(feedbackFilter, "f.updated_at >= ?")
+		feedbackArgs = append(feedbackArgs, time.Now().AddDate(0, 0, -days))
+	}
+	datasetFilter := ""
+	datasetArgs := []any{}
+	if datasetID != 0 {
+		datasetFilter = " AND d.id = ?"
+		datasetArgs = append(datasetArgs, datasetID)
+	}
+	feedbackWhere := strings.Join(feedbackFilter, " AND ")
+
+	report := &models.FeedbackReport{
+		Datasets: []models.DatasetFeedbackStat{},
+		Files:    []models.FileFeedbackStat{},
+		Chunks:   []models.ChunkFeedbackStat{},
+	}
+
+	if err := db.PgSqlDB.WithContext(ctx).Raw(`SELECT d.id AS dataset_id, d.name AS dataset_name,
+			COUNT(*) AS total_feedback,
+			COUNT(*) FILTER (WHERE f.rating < 0) AS negative_feedback
+		FROM feedbacks f
+		JOIN memories m ON m.id = f.memory_id
+		JOIN chat_sessions s ON s.id = m.session_id
+		JOIN datasets d ON d.id = s.dataset_id AND d.deleted_at IS NULL`+datasetFilter+`
+		WHERE `+feedbackWhere+`
+		GROUP BY d.id, d.name
+		ORDER BY negative_feedback DESC, d.id`,
+		append(slices.Clone(datasetArgs), feedbackArgs...)...).
+		Scan(&report.Datasets).Error; err != nil {
+		return nil, err
+	}
+	for i := range report.Datasets {
+		report.Datasets[i].NegativeRate = float64(report.Datasets[i].NegativeFeedback) / float64(report.Datasets[i].TotalFeedback)
+	}
+
+	// Answers rated down, with the chunks they cited and the chunks flagged in them
+	negative := `WITH negative AS (
+			SELECT f.id, f.memory_id FROM feedbacks f WHERE ` + feedbackWhere + ` AND f.rating < 0
+		), cited AS (
+			SELECT n.id AS feedback_id, md.chunk_id FROM negative n JOIN memory_documents md ON md.memory_id = n.memory_id
+		), flagged AS (
+			SELECT fw.feedback_id, fw.chunk_id FROM negative n JOIN feedback_wrong_chunks fw ON fw.feedback_id = n.id
+		)`
+
+	if err := db.PgSqlDB.WithContext(ctx).Raw(negative+`
+		SELECT fl.id AS file_id, fl.name AS file_name, fl.dataset_id,
+			(SELECT COUNT(DISTINCT ci.feedback_id) FROM cited ci JOIN chunks c ON c.id = ci.chunk_id WHERE c.file_id = fl.id) AS negative_feedback,
+			(SELECT COUNT(*) FROM flagged fg JOIN chunks c ON c.id = fg.chunk_id WHERE c.file_id = fl.id) AS wrong_flags
+		FROM files fl
+		JOIN datasets d ON d.id = fl.dataset_id`+datasetFilter+`
+		WHERE fl.deleted_at IS NULL AND fl.id IN (SELECT c.file_id FROM cited ci JOIN chunks c ON c.id = ci.chunk_id)
+		ORDER BY wrong_flags DESC, negative_feedback DESC, fl.id
+		LIMIT ?`,
+		append(append(slices.Clone(feedbackArgs), datasetArgs...), limit)...).
+		Scan(&report.Files).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.PgSqlDB.WithContext(ctx).Raw(negative+`
+		SELECT c.id AS chunk_id, LEFT(c.content, ?) AS content, c.file_id, fl.name AS file_name, fl.dataset_id,
+			(SELECT COUNT(*) FROM cited ci WHERE ci.chunk_id = c.id) AS negative_feedback,
+			(SELECT COUNT(*) FROM flagged fg WHERE fg.chunk_id = c.id) AS wrong_flags
+		FROM chunks c
+		JOIN files fl ON fl.id = c.file_id AND fl.deleted_at IS NULL
+		JOIN datasets d ON d.id = fl.dataset_id`+datasetFilter+`
+		WHERE c.deleted_at IS NULL AND c.id IN (SELECT chunk_id FROM cited)
+		ORDER BY wrong_flags DESC, negative_feedback DESC, c.id
+		LIMIT ?`,
+		append(append(append(slices.Clone(feedbackArgs), models.FEEDBACK_REPORT_PREVIEW_LEN), datasetArgs...), limit)...).
+		Scan(&report.Chunks).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
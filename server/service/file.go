@@ -2,7 +2,6 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -20,91 +19,108 @@ var FileServiceApp = new(FileService)
 
 type FileService struct{}
 
-func (this *FileService) UploadFile(ctx context.Context, fileHeaders []*multipart.FileHeader, fileNumber int, ownerID uint, datasetID uint) (uploadedFiles []models.FileUploadInfo, errs []error) {
-	// Process files in parallel
-	var wg sync.WaitGroup
-	resultChan := make(chan models.FileUploadInfo, fileNumber)
-	errChan := make(chan error, fileNumber)
-
-	for _, fileHeader := range fileHeaders {
-		wg.Go(func() {
-			fh := fileHeader
-
-			// Open the uploaded file
-			src, err := fh.Open()
-			if err != nil {
-				utils.Logger.Errorf("Failed to open uploaded file %s: %v", fh.Filename, err)
-				errChan <- ErrOpenFile
-				return
-			}
-			defer src.Close()
+// ReserveFiles creates the records of files about to be uploaded, once the owner's quota is checked for all of them.
+// The records count against the quota while the files are uploaded. Files whose record cannot be created,
+// like a name already used in the dataset, get a nil entry.
+func (this *FileService) ReserveFiles(ctx context.Context, fileHeaders []*multipart.FileHeader, ownerID uint, datasetID uint) ([]*models.File, error) {
+	var totalSize int64
+	for _, fh := range fileHeaders {
+		totalSize += fh.Size
+	}
 
+	dbFiles := make([]*models.File, len(fileHeaders))
+	err := QuotaServiceApp.WithinQuota(ctx, ownerID, func(tx *gorm.DB, plan *models.QuotaPlan) error {
+		if err := QuotaServiceApp.CheckFiles(ctx, tx, plan, ownerID, datasetID, len(fileHeaders), totalSize); err != nil {
+			return err
+		}
+		dataset, err := gorm.G[models.Dataset](tx).Where("id = ?", datasetID).First(ctx)
+		if err != nil {
+			return err
+		}
+		for i, fh := range fileHeaders {
 			// Get file type/MIME type
 			fileType := fh.Header.Get("Content-Type")
 			if fileType == "" {
 				fileType = "application/octet-stream"
 			}
-
-			// Use channels to coordinate parallel MinIO upload and DB record creation
-			type uploadResult struct {
-				file *models.File
-				err  error
-			}
-			minioResult := make(chan error, 1)
-			dbResult := make(chan uploadResult, 1)
-
-			var fileWg sync.WaitGroup
-
-			// Goroutine 1: Upload to MinIO
-			fileWg.Go(func() {
-				err := this.UploadFileToMinio(ctx, ownerID, datasetID, fh.Filename, src, fh.Size)
+			// A savepoint per file, so one failing record does not abort the others
+			err := tx.Transaction(func(tx *gorm.DB) error {
+				dbFile, err := this.CreateFileInfo(ctx, tx, ownerID, datasetID, fh.Filename, fileType, fh.Size)
 				if err != nil {
-					utils.Logger.Errorf("Failed to upload file %s to Minio: %v", fh.Filename, err)
-					minioResult <- ErrUploadFile
-					return
+					return err
 				}
-				minioResult <- nil
-			})
-
-			// Goroutine 2: Create database record
-			fileWg.Go(func() {
-				dbFile, err := this.CreateFileInfo(ctx, ownerID, datasetID, fh.Filename, fileType, fh.Size)
+				events, err := this.fileUploadEvents(dbFile, &dataset)
 				if err != nil {
-					utils.Logger.Errorf("Failed to create file record %s: %v", fh.Filename, err)
-					dbResult <- uploadResult{nil, ErrSaveFileInfo}
-					return
+					return err
 				}
-				dbResult <- uploadResult{dbFile, nil}
+				if err := OutboxServiceApp.Add(ctx, tx, events...); err != nil {
+					return err
+				}
+				dbFiles[i] = dbFile
+				return nil
 			})
+			if err != nil {
+				utils.Logger.Errorf("Failed to create file record %s: %v", fh.Filename, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dbFiles, nil
+}
 
-			fileWg.Wait()
+// UploadFile uploads the files reserved by ReserveFiles to MinIO in parallel.
+// The record of a file that fails to upload is deleted, releasing its quota.
+func (this *FileService) UploadFile(ctx context.Context, fileHeaders []*multipart.FileHeader, dbFiles []*models.File) (uploadedFiles []models.FileUploadInfo, errs []error) {
+	// Process files in parallel
+	var wg sync.WaitGroup
+	resultChan := make(chan models.FileUploadInfo, len(fileHeaders))
+	errChan := make(chan error, len(fileHeaders))
 
-			// Check for MinIO upload errors
-			if minioErr := <-minioResult; minioErr != nil {
-				errChan <- minioErr
+	for i, fileHeader := range fileHeaders {
+		wg.Go(func() {
+			fh := fileHeader
+			dbFile := dbFiles[i]
+			if dbFile == nil {
+				errChan <- ErrSaveFileInfo
 				return
 			}
 
-			// Check for database errors and get dbFile
-			dbRes := <-dbResult
-			if dbRes.err != nil {
-				errChan <- dbRes.err
+			// Open the uploaded file
+			src, err := fh.Open()
+			if err != nil {
+				utils.Logger.Errorf("Failed to open uploaded file %s: %v", fh.Filename, err)
+				this.releaseFile(ctx, dbFile)
+				errChan <- ErrOpenFile
+				return
+			}
+			defer src.Close()
+
+			if err := this.UploadFileToMinio(ctx, dbFile.UserID, dbFile.DatasetID, fh.Filename, src, fh.Size); err != nil {
+				utils.Logger.Errorf("Failed to upload file %s to Minio: %v", fh.Filename, err)
+				this.releaseFile(ctx, dbFile)
+				errChan <- ErrUploadFile
 				return
 			}
 
-			// Publish file upload event
-			if err := this.PublishFileUploadEvent(ctx, dbRes.file); err != nil {
-				utils.Logger.Errorf("Failed to publish file upload event for %s: %v", fh.Filename, err)
-				// Continue even if event publishing fails
+			// The object is stored, its events may go out. The request may be cancelled by now.
+			if err := OutboxServiceApp.ReleaseFileEvents(context.WithoutCancel(ctx), dbFile.ID); err != nil {
+				utils.Logger.Errorf("Failed to release upload events of file %s: %v", fh.Filename, err)
+				// The file would never be processed, it is uploaded again instead
+				this.releaseFile(ctx, dbFile)
+				errChan <- ErrUploadFile
+				return
 			}
 
 			utils.Logger.Infof("File uploaded successfully: %s", fh.Filename)
 			resultChan <- models.FileUploadInfo{
-				OwnerID:   ownerID,
-				DatasetID: datasetID,
-				Name:      fh.Filename,
-				Type:      fileType,
-				Size:      fh.Size,
+				OwnerID:   dbFile.UserID,
+				DatasetID: dbFile.DatasetID,
+				Name:      dbFile.Name,
+				Type:      dbFile.Type,
+				Size:      dbFile.Size,
 			}
 		})
 	}
@@ -123,6 +139,17 @@ func (this *FileService) UploadFile(ctx context.Context, fileHeaders []*multipar
 	return uploadedFiles, errs
 }
 
+// releaseFile deletes the record of a file that could not be uploaded, for good so the name can be uploaded again.
+// Its held back events go with it.
+func (this *FileService) releaseFile(ctx context.Context, dbFile *models.File) {
+	// The request may be cancelled, the reservation must go anyway
+	if _, err := gorm.G[models.File](db.PgSqlDB.Unscoped()).
+		Where("id = ?", dbFile.ID).
+		Delete(context.WithoutCancel(ctx)); err != nil {
+		utils.Logger.Errorf("Failed to release file record %d: %v", dbFile.ID, err)
+	}
+}
+
 // CreateFile uploads a file to Minio
 func (this *FileService) UploadFileToMinio(ctx context.Context, ownerID uint, datasetID uint, filename string, fileReader io.Reader, fileSize int64) error {
 	objectName := fmt.Sprintf("%d/%d/%s", ownerID, datasetID, filename)
@@ -135,7 +162,7 @@ func (this *FileService) UploadFileToMinio(ctx context.Context, ownerID uint, da
 }
 
 // CreateFileInfo creates a database record
-func (this *FileService) CreateFileInfo(ctx context.Context, ownerID uint, datasetID uint, filename string, fileType string, fileSize int64) (dbFile *models.File, err error) {
+func (this *FileService) CreateFileInfo(ctx context.Context, tx *gorm.DB, ownerID uint, datasetID uint, filename string, fileType string, fileSize int64) (dbFile *models.File, err error) {
 
 	objectName := fmt.Sprintf("%d/%d/%s", ownerID, datasetID, filename)
 
@@ -148,8 +175,7 @@ func (this *FileService) CreateFileInfo(ctx context.Context, ownerID uint, datas
 		Type:      fileType,
 		DatasetID: datasetID,
 	}
-	// Save file record to database in parallel
-	if err := gorm.G[models.File](db.PgSqlDB).Create(ctx, dbFile); err != nil {
+	if err := gorm.G[models.File](tx).Create(ctx, dbFile); err != nil {
 		return nil, err
 	}
 
@@ -206,6 +232,10 @@ func (this *FileService) GetDownloadURLByFilePath(ctx context.Context, filePath
 
 // DeleteFileByFileID deletes a file from both Minio and database
 func (this *FileService) DeleteFileByFileID(ctx context.Context, fileID uint, filePath string) error {
+	dbFile, err := gorm.G[models.File](db.PgSqlDB).Where("id = ?", fileID).First(ctx)
+	if err != nil {
+		return err
+	}
 
 	var wg sync.WaitGroup
 	errChan := make(chan error, 2)
@@ -220,9 +250,14 @@ func (this *FileService) DeleteFileByFileID(ctx context.Context, fileID uint, fi
 
 	// Delete from database in parallel
 	wg.Go(func() {
-		if _, err := gorm.G[models.File](db.PgSqlDB).
-			Where("ID = ?", fileID).
-			Delete(ctx); err != nil {
+		if err := db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if _, err := gorm.G[models.File](tx).
+				Where("ID = ?", fileID).
+				Delete(ctx); err != nil {
+				return err
+			}
+			return EventServiceApp.Publish(ctx, tx, fileEvent(models.EVENT_FILE_DELETED, &dbFile))
+		}); err != nil {
 			utils.Logger.Errorf("Failed to delete file record from database: %v", err)
 			errChan <- err
 		}
@@ -280,39 +315,29 @@ func (this *FileService) CheckFileExistsByFileID(ctx context.Context, fileID uin
 	return exists, nil
 }
 
-// PublishFileUploadEvent publishes a file upload event to RabbitMQ
-// This function is designed to be called in a goroutine for concurrent execution
-func (this *FileService) PublishFileUploadEvent(ctx context.Context, fileInfo *models.File) error {
-	// Create the message payload
-	message := models.FileUploadMessage{
-		Event:     "file.uploaded",
-		FileID:    fileInfo.ID,
-		MinioPath: fileInfo.MinioPath,
-		Timestamp: time.Now(),
-		DatasetID: fileInfo.DatasetID,
-	}
-
-	// Marshal the message to JSON
-	messageBytes, err := json.Marshal(message)
+// fileUploadEvents returns the events of a file being uploaded, held back until the upload completes:
+// the message asking the ingestion worker to process the file, and its file.uploaded lifecycle event
+func (this *FileService) fileUploadEvents(fileInfo *models.File, dataset *models.Dataset) ([]models.OutboxEvent, error) {
+	ingestion, err := outboxMessage("", config.Settings.GetRabbitMQQueue(), models.FileUploadMessage{
+		Event:          models.EVENT_FILE_UPLOADED,
+		FileID:         fileInfo.ID,
+		MinioPath:      fileInfo.MinioPath,
+		Timestamp:      time.Now(),
+		DatasetID:      fileInfo.DatasetID,
+		CollectionName: DatasetCollection(dataset),
+	})
 	if err != nil {
-		utils.Logger.Errorf("Failed to marshal file upload message: %v", err)
-		return err
+		return nil, err
 	}
-
-	// Create a work queue for file upload events
-	fileUploadQueue, err := db.NewWorkQueue(config.Settings.RABBITMQ_QUEUE)
+	lifecycle, err := EventServiceApp.Outbox(fileEvent(models.EVENT_FILE_UPLOADED, fileInfo))
 	if err != nil {
-		utils.Logger.Errorf("Failed to create file upload queue: %v", err)
-		return err
+		return nil, err
 	}
-	defer fileUploadQueue.Close()
 
-	// Publish the message
-	if err := fileUploadQueue.Publish(messageBytes); err != nil {
-		utils.Logger.Errorf("Failed to publish file upload event: %v", err)
-		return err
+	events := []models.OutboxEvent{ingestion, lifecycle}
+	for i := range events {
+		events[i].FileID = &fileInfo.ID
+		events[i].AvailableAt = nil
 	}
-
-	utils.Logger.Infof("File upload event published to RabbitMQ: %s (ID: %d)", fileInfo.MinioPath, fileInfo.ID)
-	return nil
+	return events, nil
 }
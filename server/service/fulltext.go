@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"server/db"
+	"server/models"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Options of ts_headline, matched terms are wrapped in <mark></mark>
+	KEYWORD_HIGHLIGHT_OPTIONS = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2"
+)
+
+// keywordSearchRow is a row of the full-text search query
+type keywordSearchRow struct {
+	ChunkID     uint
+	Content     string
+	Metadata    map[string]any `gorm:"serializer:json"`
+	FileID      uint
+	FileName    string
+	FileType    string
+	DatasetID   uint
+	DatasetName string
+	Score       float32
+	Highlight   string
+}
+
+// KeywordSearch ranks chunks of the given datasets with PostgreSQL full-text search.
+// Each dataset's query is parsed with its own text search config, so stemming matches the indexed content.
+// If fileTypes is not empty, only chunks of files with one of these MIME types are kept.
+func (this *SearchService) KeywordSearch(ctx context.Context, datasetIDs []uint, fileTypes []string, query string, limit int) ([]models.SearchResultItem, error) {
+	results := []models.SearchResultItem{}
+	tsQuery := BuildTSQuery(query)
+	if tsQuery == "" || len(datasetIDs) == 0 {
+		return results, nil
+	}
+
+	fileTypeFilter := ""
+	args := []any{tsQuery, datasetIDs}
+	if len(fileTypes) > 0 {
+		fileTypeFilter = "AND f.type IN ?"
+		args = append(args, fileTypes)
+	}
+	args = append(args, limit)
+
+	// Headlines are expensive, so they are only computed for the top ranked chunks
+	sql := `
+SELECT ranked.*, ts_headline(ranked.text_search_config, ranked.content, ranked.query, '` + KEYWORD_HIGHLIGHT_OPTIONS + `') AS highlight
+FROM (
+	SELECT c.id AS chunk_id, c.content, c.metadata,
+		f.id AS file_id, f.name AS file_name, f.type AS file_type,
+		d.id AS dataset_id, d.name AS dataset_name,
+		d.text_search_config::regconfig AS text_search_config, q.query,
+		ts_rank_cd(c.search_vector, q.query) AS score
+	FROM chunks c
+	JOIN files f ON f.id = c.file_id AND f.deleted_at IS NULL
+	JOIN datasets d ON d.id = f.dataset_id AND d.deleted_at IS NULL
+	CROSS JOIN LATERAL to_tsquery(d.text_search_config::regconfig, ?) AS q(query)
+	WHERE c.deleted_at IS NULL AND d.id IN ? AND c.search_vector @@ q.query ` + fileTypeFilter + `
+	ORDER BY score DESC, c.id
+	LIMIT ?
+) AS ranked
+ORDER BY ranked.score DESC, ranked.chunk_id`
+
+	rows, err := gorm.G[keywordSearchRow](db.PgSqlDB).Raw(sql, args...).Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		results = append(results, models.SearchResultItem{
+			ChunkID:     row.ChunkID,
+			Content:     row.Content,
+			Score:       row.Score,
+			Metadata:    row.Metadata,
+			FileID:      row.FileID,
+			FileName:    row.FileName,
+			FileType:    row.FileType,
+			DatasetID:   row.DatasetID,
+			DatasetName: row.DatasetName,
+			Highlight:   row.Highlight,
+		})
+	}
+	return results, nil
+}
+
+// BuildTSQuery converts a user search string into a to_tsquery expression.
+// Terms are ANDed together and the following syntax is supported:
+//
+//	"exact phrase"   terms must appear next to each other
+//	prefix*          matches words starting with prefix
+//	-term            excludes chunks containing term
+//	a OR b           matches either term
+//
+// Punctuation is stripped from terms, so user input never reaches the tsquery parser unescaped.
+// An empty string is returned when the query has no searchable terms.
+func BuildTSQuery(query string) string {
+	// Groups are ANDed, terms inside a group are ORed
+	var groups [][]string
+	pendingOr := false
+	appendTerm := func(term string) {
+		if pendingOr && len(groups) > 0 {
+			groups[len(groups)-1] = append(groups[len(groups)-1], term)
+		} else {
+			groups = append(groups, []string{term})
+		}
+		pendingOr = false
+	}
+
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		negate := false
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			negate = true
+			i++
+		}
+
+		var term string
+		if runes[i] == '"' {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			term = tsPhrase(tsWords(string(runes[i+1 : end])))
+			i = end + 1
+		} else {
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			token := string(runes[i:end])
+			i = end
+			if token == "OR" && !negate {
+				pendingOr = len(groups) > 0
+				continue
+			}
+			prefix := strings.HasSuffix(token, "*")
+			words := tsWords(token)
+			if prefix && len(words) > 0 {
+				words[len(words)-1] += ":*"
+			}
+			term = tsPhrase(words)
+		}
+
+		if term == "" {
+			continue
+		}
+		if negate {
+			term = "!" + term
+		}
+		appendTerm(term)
+	}
+
+	parts := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if len(group) == 1 {
+			parts = append(parts, group[0])
+		} else {
+			parts = append(parts, "("+strings.Join(group, " | ")+")")
+		}
+	}
+	return strings.Join(parts, " & ")
+}
+
+// tsWords splits text into words made of letters and digits only
+func tsWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// tsPhrase joins words with the followed-by operator
+func tsPhrase(words []string) string {
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		return words[0]
+	default:
+		return "(" + strings.Join(words, " <-> ") + ")"
+	}
+}
@@ -0,0 +1,405 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"server/models"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/responses"
+	"google.golang.org/genai"
+)
+
+// StreamChatCompletion generates the answer to a conversation with the given model through the provider's API.
+// Every piece of text is passed to onDelta as soon as the provider streams it; the complete answer is returned at the end.
+// The call is recorded in the usage ledger.
+func (this *ProviderService) StreamChatCompletion(ctx context.Context, provider *models.Provider, model string, messages []models.LLMMessage, params models.LLMParams, onDelta func(string) error) (*models.LLMResult, error) {
+	apiKey, err := this.ResolveAPIKey(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *models.LLMResult
+	start := time.Now()
+	switch provider.Mode {
+	case models.PROVIDER_MODE_OPENAI, models.PROVIDER_MODE_OPENAI_COMPATIBLE, models.PROVIDER_MODE_AZURE_OPENAI, models.PROVIDER_MODE_MISTRAL:
+		result, err = this.chatOpenAI(ctx, provider, apiKey, model, messages, params, onDelta)
+	case models.PROVIDER_MODE_OPENAI_RESP:
+		result, err = this.chatOpenAIResponses(ctx, provider, apiKey, model, messages, params, onDelta)
+	case models.PROVIDER_MODE_ANTHROPIC:
+		result, err = this.chatAnthropic(ctx, provider, apiKey, model, messages, params, onDelta)
+	case models.PROVIDER_MODE_GEMINI:
+		result, err = this.chatGemini(ctx, provider, apiKey, model, messages, params, onDelta)
+	case models.PROVIDER_MODE_OLLAMA:
+		result, err = this.chatOllama(ctx, provider, model, messages, params, onDelta)
+	default:
+		return nil, fmt.Errorf("%w: provider mode %s does not support chat", ErrLLMRequestFailed, provider.Mode)
+	}
+	this.recordChatUsage(ctx, provider, model, messages, result, time.Since(start), err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// recordChatUsage records a chat completion in the usage ledger, estimating the prompt tokens of answers the provider gave no usage for
+func (this *ProviderService) recordChatUsage(ctx context.Context, provider *models.Provider, model string, messages []models.LLMMessage, result *models.LLMResult, latency time.Duration, err error) {
+	event := models.UsageEvent{CallType: models.USAGE_CALL_CHAT, Model: model, Latency: latency, Err: err}
+	if result != nil {
+		event.InputTokens = result.PromptTokens
+		event.OutputTokens = result.CompletionTokens
+		if event.InputTokens == 0 && event.OutputTokens == 0 {
+			prompt := make([]string, 0, len(messages))
+			for _, message := range messages {
+				prompt = append(prompt, message.Content)
+			}
+			event.InputTokens = EstimateTokens(prompt...)
+			event.OutputTokens = EstimateTokens(result.Content)
+			event.Estimated = true
+		}
+	}
+	UsageServiceApp.Record(ctx, provider, event)
+}
+
+// splitSystemMessages joins the system messages for APIs taking them apart from the conversation
+func splitSystemMessages(messages []models.LLMMessage) (system string, conversation []models.LLMMessage) {
+	var instructions []string
+	for _, message := range messages {
+		if message.Role == models.LLM_ROLE_SYSTEM {
+			instructions = append(instructions, message.Content)
+		} else {
+			conversation = append(conversation, message)
+		}
+	}
+	return strings.Join(instructions, "\n\n"), conversation
+}
+
+// chatOpenAI uses the OpenAI SDK chat completions API, also spoken by Azure OpenAI, Mistral and OpenAI-compatible servers
+func (this *ProviderService) chatOpenAI(ctx context.Context, provider *models.Provider, apiKey string, model string, messages []models.LLMMessage, params models.LLMParams, onDelta func(string) error) (*models.LLMResult, error) {
+	opts, err := openAIClientOptions(ctx, provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	client := openai.NewClient(opts...)
+
+	chatMessages := make([]openai.ChatCompletionMessageParamUnion, 0, len(messages))
+	for _, message := range messages {
+		switch message.Role {
+		case models.LLM_ROLE_SYSTEM:
+			chatMessages = append(chatMessages, openai.SystemMessage(message.Content))
+		case models.LLM_ROLE_ASSISTANT:
+			chatMessages = append(chatMessages, openai.AssistantMessage(message.Content))
+		default:
+			chatMessages = append(chatMessages, openai.UserMessage(message.Content))
+		}
+	}
+	body := openai.ChatCompletionNewParams{
+		Model:    model,
+		Messages: chatMessages,
+	}
+	// Mistral rejects stream_options, its last chunk carries the usage anyway
+	if provider.Mode != models.PROVIDER_MODE_MISTRAL {
+		body.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+	}
+	if params.Temperature != nil {
+		body.Temperature = openai.Float(*params.Temperature)
+	}
+	if params.TopP != nil {
+		body.TopP = openai.Float(*params.TopP)
+	}
+	if params.MaxTokens > 0 {
+		// Mistral and most OpenAI-compatible servers only know the older max_tokens
+		if provider.Mode == models.PROVIDER_MODE_OPENAI || provider.Mode == models.PROVIDER_MODE_AZURE_OPENAI {
+			body.MaxCompletionTokens = openai.Int(params.MaxTokens)
+		} else {
+			body.MaxTokens = openai.Int(params.MaxTokens)
+		}
+	}
+	if len(params.Stop) > 0 {
+		body.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: params.Stop}
+	}
+
+	result := &models.LLMResult{FinishReason: models.LLM_FINISH_STOP}
+	var answer strings.Builder
+	stream := client.Chat.Completions.NewStreaming(ctx, body)
+	defer stream.Close()
+	for stream.Next() {
+		chunk := stream.Current()
+		if chunk.Usage.TotalTokens > 0 {
+			result.PromptTokens = chunk.Usage.PromptTokens
+			result.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if chunk.Choices[0].FinishReason == models.LLM_FINISH_LENGTH {
+			result.FinishReason = models.LLM_FINISH_LENGTH
+		}
+		if delta := chunk.Choices[0].Delta.Content; delta != "" {
+			answer.WriteString(delta)
+			if err := onDelta(delta); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLLMRequestFailed, err)
+	}
+
+	result.Content = answer.String()
+	return result, nil
+}
+
+// chatOpenAIResponses uses the OpenAI SDK responses API
+func (this *ProviderService) chatOpenAIResponses(ctx context.Context, provider *models.Provider, apiKey string, model string, messages []models.LLMMessage, params models.LLMParams, onDelta func(string) error) (*models.LLMResult, error) {
+	opts, err := openAIClientOptions(ctx, provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	client := openai.NewClient(opts...)
+
+	system, conversation := splitSystemMessages(messages)
+	input := make(responses.ResponseInputParam, 0, len(conversation))
+	for _, message := range conversation {
+		role := responses.EasyInputMessageRoleUser
+		if message.Role == models.LLM_ROLE_ASSISTANT {
+			role = responses.EasyInputMessageRoleAssistant
+		}
+		input = append(input, responses.ResponseInputItemParamOfMessage(message.Content, role))
+	}
+	// The responses API has no stop sequences
+	body := responses.ResponseNewParams{
+		Model: model,
+		Input: responses.ResponseNewParamsInputUnion{OfInputItemList: input},
+	}
+	if system != "" {
+		body.Instructions = openai.String(system)
+	}
+	if params.Temperature != nil {
+		body.Temperature = openai.Float(*params.Temperature)
+	}
+	if params.TopP != nil {
+		body.TopP = openai.Float(*params.TopP)
+	}
+	if params.MaxTokens > 0 {
+		body.MaxOutputTokens = openai.Int(params.MaxTokens)
+	}
+
+	result := &models.LLMResult{FinishReason: models.LLM_FINISH_STOP}
+	var answer strings.Builder
+	stream := client.Responses.NewStreaming(ctx, body)
+	defer stream.Close()
+	for stream.Next() {
+		event := stream.Current()
+		switch event.Type {
+		case "response.output_text.delta":
+			answer.WriteString(event.Delta)
+			if err := onDelta(event.Delta); err != nil {
+				return nil, err
+			}
+		case "response.completed", "response.incomplete":
+			result.PromptTokens = event.Response.Usage.InputTokens
+			result.CompletionTokens = event.Response.Usage.OutputTokens
+			if event.Type == "response.incomplete" {
+				result.FinishReason = models.LLM_FINISH_LENGTH
+			}
+		case "response.failed":
+			return nil, fmt.Errorf("%w: %s", ErrLLMRequestFailed, event.Response.Error.Message)
+		case "error":
+			return nil, fmt.Errorf("%w: %s", ErrLLMRequestFailed, event.Message)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLLMRequestFailed, err)
+	}
+
+	result.Content = answer.String()
+	return result, nil
+}
+
+// chatAnthropic uses the Anthropic SDK messages API
+func (this *ProviderService) chatAnthropic(ctx context.Context, provider *models.Provider, apiKey string, model string, messages []models.LLMMessage, params models.LLMParams, onDelta func(string) error) (*models.LLMResult, error) {
+	client, err := newAnthropicClient(ctx, provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	system, conversation := splitSystemMessages(messages)
+	messageParams := make([]anthropic.MessageParam, 0, len(conversation))
+	for _, message := range conversation {
+		if message.Role == models.LLM_ROLE_ASSISTANT {
+			messageParams = append(messageParams, anthropic.NewAssistantMessage(anthropic.NewTextBlock(message.Content)))
+		} else {
+			messageParams = append(messageParams, anthropic.NewUserMessage(anthropic.NewTextBlock(message.Content)))
+		}
+	}
+	// Anthropic requires an output limit
+	maxTokens := params.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = models.DEFAULT_LLM_MAX_TOKENS
+	}
+	body := anthropic.MessageNewParams{
+		Model:         model,
+		MaxTokens:     maxTokens,
+		Messages:      messageParams,
+		StopSequences: params.Stop,
+	}
+	if system != "" {
+		body.System = []anthropic.TextBlockParam{{Text: system}}
+	}
+	if params.Temperature != nil {
+		// Anthropic accepts temperatures up to 1
+		body.Temperature = anthropic.Float(min(*params.Temperature, 1))
+	}
+	if params.TopP != nil {
+		body.TopP = anthropic.Float(*params.TopP)
+	}
+
+	result := &models.LLMResult{FinishReason: models.LLM_FINISH_STOP}
+	var answer strings.Builder
+	stream := client.Messages.NewStreaming(ctx, body)
+	defer stream.Close()
+	for stream.Next() {
+		switch event := stream.Current().AsAny().(type) {
+		case anthropic.MessageStartEvent:
+			result.PromptTokens = event.Message.Usage.InputTokens
+		case anthropic.ContentBlockDeltaEvent:
+			if delta, ok := event.Delta.AsAny().(anthropic.TextDelta); ok && delta.Text != "" {
+				answer.WriteString(delta.Text)
+				if err := onDelta(delta.Text); err != nil {
+					return nil, err
+				}
+			}
+		case anthropic.MessageDeltaEvent:
+			result.CompletionTokens = event.Usage.OutputTokens
+			if event.Delta.StopReason == anthropic.StopReasonMaxTokens {
+				result.FinishReason = models.LLM_FINISH_LENGTH
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLLMRequestFailed, err)
+	}
+
+	result.Content = answer.String()
+	return result, nil
+}
+
+// chatGemini uses the Google Genai SDK to generate content
+func (this *ProviderService) chatGemini(ctx context.Context, provider *models.Provider, apiKey string, model string, messages []models.LLMMessage, params models.LLMParams, onDelta func(string) error) (*models.LLMResult, error) {
+	client, err := newGeminiClient(ctx, provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	system, conversation := splitSystemMessages(messages)
+	contents := make([]*genai.Content, 0, len(conversation))
+	for _, message := range conversation {
+		var role genai.Role = genai.RoleUser
+		if message.Role == models.LLM_ROLE_ASSISTANT {
+			role = genai.RoleModel
+		}
+		contents = append(contents, genai.NewContentFromText(message.Content, role))
+	}
+	config := &genai.GenerateContentConfig{
+		MaxOutputTokens: int32(params.MaxTokens),
+		StopSequences:   params.Stop,
+	}
+	if system != "" {
+		config.SystemInstruction = genai.NewContentFromText(system, genai.RoleUser)
+	}
+	if params.Temperature != nil {
+		config.Temperature = genai.Ptr(float32(*params.Temperature))
+	}
+	if params.TopP != nil {
+		config.TopP = genai.Ptr(float32(*params.TopP))
+	}
+
+	result := &models.LLMResult{FinishReason: models.LLM_FINISH_STOP}
+	var answer strings.Builder
+	for resp, err := range client.Models.GenerateContentStream(ctx, model, contents, config) {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrLLMRequestFailed, err)
+		}
+		if resp.UsageMetadata != nil {
+			result.PromptTokens = int64(resp.UsageMetadata.PromptTokenCount)
+			result.CompletionTokens = int64(resp.UsageMetadata.CandidatesTokenCount)
+		}
+		if len(resp.Candidates) > 0 && resp.Candidates[0].FinishReason == genai.FinishReasonMaxTokens {
+			result.FinishReason = models.LLM_FINISH_LENGTH
+		}
+		if delta := resp.Text(); delta != "" {
+			answer.WriteString(delta)
+			if err := onDelta(delta); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	result.Content = answer.String()
+	return result, nil
+}
+
+// chatOllama uses the Ollama SDK chat API
+func (this *ProviderService) chatOllama(ctx context.Context, provider *models.Provider, model string, messages []models.LLMMessage, params models.LLMParams, onDelta func(string) error) (*models.LLMResult, error) {
+	client, err := newOllamaClient(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	chatMessages := make([]api.Message, 0, len(messages))
+	for _, message := range messages {
+		chatMessages = append(chatMessages, api.Message{Role: message.Role, Content: message.Content})
+	}
+	options := map[string]any{}
+	if params.Temperature != nil {
+		options["temperature"] = *params.Temperature
+	}
+	if params.TopP != nil {
+		options["top_p"] = *params.TopP
+	}
+	if params.MaxTokens > 0 {
+		options["num_predict"] = params.MaxTokens
+	}
+	if len(params.Stop) > 0 {
+		options["stop"] = params.Stop
+	}
+	stream := true
+
+	result := &models.LLMResult{FinishReason: models.LLM_FINISH_STOP}
+	var answer strings.Builder
+	err = client.Chat(ctx, &api.ChatRequest{
+		Model:    model,
+		Messages: chatMessages,
+		Stream:   &stream,
+		Options:  options,
+	}, func(resp api.ChatResponse) error {
+		if resp.Message.Content != "" {
+			answer.WriteString(resp.Message.Content)
+			if err := onDelta(resp.Message.Content); err != nil {
+				return err
+			}
+		}
+		if resp.Done {
+			result.PromptTokens = int64(resp.PromptEvalCount)
+			result.CompletionTokens = int64(resp.EvalCount)
+			if resp.DoneReason == models.LLM_FINISH_LENGTH {
+				result.FinishReason = models.LLM_FINISH_LENGTH
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLLMRequestFailed, err)
+	}
+
+	result.Content = answer.String()
+	return result, nil
+}
@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"slices"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	MODEL_CATALOG_CACHE_KEY = "model_catalog:provider:%d"
+)
+
+// knownModel describes the models whose normalized name starts with Prefix
+type knownModel struct {
+	Prefix        string
+	Types         []string
+	Dimension     int
+	ContextLength int
+}
+
+var (
+	chatTypes       = []string{models.MODEL_TYPE_CHAT}
+	visionChatTypes = []string{models.MODEL_TYPE_CHAT, models.MODEL_TYPE_VISION}
+	embeddingTypes  = []string{models.MODEL_TYPE_EMBEDDING}
+	rerankTypes     = []string{models.MODEL_TYPE_RERANK}
+)
+
+// Built-in table of well-known models, for providers whose model listing tells nothing but the name.
+// The longest matching prefix wins.
+var knownModels = []knownModel{
+	// Embedding
+	{"text-embedding-3-small", embeddingTypes, 1536, 8191},
+	{"text-embedding-3-large", embeddingTypes, 3072, 8191},
+	{"text-embedding-ada-002", embeddingTypes, 1536, 8191},
+	{"text-embedding-004", embeddingTypes, 768, 2048},
+	{"text-embedding-005", embeddingTypes, 768, 2048},
+	{"text-multilingual-embedding-002", embeddingTypes, 768, 2048},
+	{"gemini-embedding-001", embeddingTypes, 3072, 2048},
+	{"embedding-001", embeddingTypes, 768, 2048},
+	{"nomic-embed-text", embeddingTypes, 768, 8192},
+	{"mxbai-embed-large", embeddingTypes, 1024, 512},
+	{"all-minilm", embeddingTypes, 384, 256},
+	{"bge-m3", embeddingTypes, 1024, 8192},
+	{"bge-large-en-v1.5", embeddingTypes, 1024, 512},
+	{"bge-base-en-v1.5", embeddingTypes, 768, 512},
+	{"bge-small-en-v1.5", embeddingTypes, 384, 512},
+	{"mistral-embed", embeddingTypes, 1024, 8192},
+	{"jina-embeddings-v2-base", embeddingTypes, 768, 8192},
+	{"jina-embeddings-v3", embeddingTypes, 1024, 8192},
+	{"embed-english-v3.0", embeddingTypes, 1024, 512},
+	{"embed-multilingual-v3.0", embeddingTypes, 1024, 512},
+	{"voyage-3", embeddingTypes, 1024, 32000},
+
+	// Rerank
+	{"rerank-english-v3.0", rerankTypes, 0, 4096},
+	{"rerank-multilingual-v3.0", rerankTypes, 0, 4096},
+	{"rerank-v3.5", rerankTypes, 0, 4096},
+	{"jina-reranker-v2-base-multilingual", rerankTypes, 0, 1024},
+	{"bge-reranker-v2-m3", rerankTypes, 0, 8192},
+
+	// Chat
+	{"gpt-3.5-turbo", chatTypes, 0, 16385},
+	{"gpt-4", chatTypes, 0, 8192},
+	{"gpt-4-turbo", visionChatTypes, 0, 128000},
+	{"gpt-4o", visionChatTypes, 0, 128000},
+	{"gpt-4.1", visionChatTypes, 0, 1047576},
+	{"gpt-5", visionChatTypes, 0, 400000},
+	{"o1", visionChatTypes, 0, 200000},
+	{"o3", visionChatTypes, 0, 200000},
+	{"o3-mini", chatTypes, 0, 200000},
+	{"o4-mini", visionChatTypes, 0, 200000},
+	{"claude", visionChatTypes, 0, 200000},
+	{"gemini", visionChatTypes, 0, 1048576},
+	{"gemini-1.5-pro", visionChatTypes, 0, 2097152},
+	{"llama3", chatTypes, 0, 8192},
+	{"llama3.1", chatTypes, 0, 131072},
+	{"llama3.2", chatTypes, 0, 131072},
+	{"llama3.2-vision", visionChatTypes, 0, 131072},
+	{"llava", visionChatTypes, 0, 4096},
+	{"qwen2.5", chatTypes, 0, 32768},
+	{"mistral", chatTypes, 0, 32768},
+}
+
+var (
+	// Name fragments of speech, image and moderation models, which providers list among chat models
+	otherModelMarkers = []string{"whisper", "tts", "dall-e", "moderation", "transcribe", "gpt-image", "imagen"}
+	// Name fragments of chat models accepting images
+	visionModelMarkers = []string{"vision", "-vl", "llava", "pixtral"}
+)
+
+// normalizeModelName strips what varies between providers serving the same model:
+// the case, an organization path like "BAAI/" and an Ollama tag like ":latest"
+func normalizeModelName(model string) string {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// lookupKnownModel finds the built-in entry with the longest prefix of the model name
+func lookupKnownModel(model string) *knownModel {
+	name := normalizeModelName(model)
+	var found *knownModel
+	for i := range knownModels {
+		if strings.HasPrefix(name, knownModels[i].Prefix) && (found == nil || len(knownModels[i].Prefix) > len(found.Prefix)) {
+			found = &knownModels[i]
+		}
+	}
+	return found
+}
+
+// ClassifyModel completes what the provider did not tell about a model, from the built-in table or else from its name.
+// Types left nil by the provider are filled in, as are a missing context length and embedding dimension.
+func ClassifyModel(info *models.ModelInfo) {
+	known := lookupKnownModel(info.ID)
+	if info.Types == nil {
+		// Markers in the name beat a shorter table prefix, "gemini-embedding-exp" is no Gemini chat model
+		switch types := markedModelTypes(info.ID); {
+		case types != nil:
+			info.Types = types
+		case known != nil:
+			info.Types = slices.Clone(known.Types)
+		default:
+			info.Types = []string{models.MODEL_TYPE_CHAT}
+		}
+	}
+	// The table only describes models of its own kind
+	if known == nil || !HasModelType(info, known.Types[0]) {
+		return
+	}
+	if info.ContextLength == 0 {
+		info.ContextLength = known.ContextLength
+	}
+	if info.Dimension == 0 && HasModelType(info, models.MODEL_TYPE_EMBEDDING) {
+		info.Dimension = known.Dimension
+	}
+}
+
+// markedModelTypes recognizes the kind of a model from fragments of its name, nil when none matches
+func markedModelTypes(model string) []string {
+	name := strings.ToLower(model)
+	switch {
+	case strings.Contains(name, "rerank"):
+		return []string{models.MODEL_TYPE_RERANK}
+	case strings.Contains(name, "embed"):
+		return []string{models.MODEL_TYPE_EMBEDDING}
+	}
+	for _, marker := range otherModelMarkers {
+		if strings.Contains(name, marker) {
+			return []string{}
+		}
+	}
+	for _, marker := range visionModelMarkers {
+		if strings.Contains(name, marker) {
+			return []string{models.MODEL_TYPE_CHAT, models.MODEL_TYPE_VISION}
+		}
+	}
+	return nil
+}
+
+// HasModelType tells whether the model is of the type (MODEL_TYPE_*)
+func HasModelType(info *models.ModelInfo, modelType string) bool {
+	return slices.Contains(info.Types, modelType)
+}
+
+// ListModels returns the model catalog of a provider. It is served from Redis unless refresh is set
+// or it expired, otherwise the provider lists its models. Listing calls no model, so the dimension of
+// an embedding model unknown to the provider and the built-in table stays 0 until describeModel learns it.
+func (this *ProviderService) ListModels(ctx context.Context, providerID uint, ownerID uint, refresh bool) (*models.ProviderModelsResp, error) {
+	// Get provider with encrypted API key
+	provider, err := this.GetProviderRawByID(ctx, providerID, ownerID)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	grantedModels, _, err := this.grantedModels(ctx, provider, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if !refresh {
+		if catalog := loadModelCatalog(ctx, providerID); catalog != nil {
+			return filterGrantedModels(catalog, grantedModels), nil
+		}
+	}
+
+	catalog, err := this.ListProviderModels(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	storeModelCatalog(ctx, providerID, catalog)
+	return filterGrantedModels(catalog, grantedModels), nil
+}
+
+// filterGrantedModels keeps the models of the catalog granted to the user of a shared provider, nil granting all
+func filterGrantedModels(catalog *models.ProviderModelsResp, grantedModels []string) *models.ProviderModelsResp {
+	if grantedModels == nil {
+		return catalog
+	}
+	filtered := &models.ProviderModelsResp{Models: []models.ModelInfo{}, CachedAt: catalog.CachedAt}
+	for _, model := range catalog.Models {
+		if slices.Contains(grantedModels, model.ID) {
+			filtered.Models = append(filtered.Models, model)
+		}
+	}
+	return filtered
+}
+
+// CheckEmbeddingModel verifies that the provider's catalog classifies the model as an embedding model.
+// Models the provider does not list, or all models when the provider cannot be reached, are classified by name.
+// A name telling nothing about the model's kind is not enough to refuse it, such a model is accepted when it embeds a probe text.
+func (this *ProviderService) CheckEmbeddingModel(ctx context.Context, providerID uint, ownerID uint, model string) error {
+	switch allowed, err := this.CheckProviderModel(ctx, providerID, ownerID, model); {
+	case err != nil:
+		return err
+	case !allowed:
+		return ErrModelNotGranted
+	}
+	info := &models.ModelInfo{ID: model}
+	catalog, err := this.ListModels(ctx, providerID, ownerID, false)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return err
+	case err != nil:
+		utils.Logger.Warnf("Classifying model %s by name, models of provider %d cannot be listed: %v", model, providerID, err)
+	default:
+		if i := slices.IndexFunc(catalog.Models, func(listed models.ModelInfo) bool { return listed.ID == model }); i >= 0 {
+			info = &catalog.Models[i]
+			if provider, err := this.GetProviderRawByID(ctx, providerID, ownerID); err == nil {
+				this.describeModel(ctx, provider, info)
+			}
+		}
+	}
+	if info.Types == nil {
+		ClassifyModel(info)
+	}
+	if HasModelType(info, models.MODEL_TYPE_EMBEDDING) {
+		return nil
+	}
+	if recognizedModelName(model) {
+		return ErrNotEmbeddingModel
+	}
+	// Unknown names are taken for chat models only by default, so the model is asked to embed a word
+	provider, err := this.GetProviderRawByID(ctx, providerID, ownerID)
+	if err != nil {
+		return err
+	}
+	if result := this.probeEmbedding(ctx, provider, model); result.Status != models.PROVIDER_TEST_STATUS_OK {
+		utils.Logger.Infof("Model %s of provider %d failed to embed: %s", model, providerID, result.Error)
+		return ErrNotEmbeddingModel
+	}
+	return nil
+}
+
+// recognizedModelName reports whether the kind of a model follows from its name rather than the default of ClassifyModel
+func recognizedModelName(model string) bool {
+	return markedModelTypes(model) != nil || lookupKnownModel(model) != nil
+}
+
+// describeModel completes the catalog entry of the one model picked by a user with what only calling it tells:
+// the capabilities reported by an Ollama server and the dimension of an embedding model, from a tiny embedding.
+// The completed entry is cached with the catalog, so the model is not called again until the catalog expires.
+func (this *ProviderService) describeModel(ctx context.Context, provider *models.Provider, info *models.ModelInfo) {
+	described := false
+	if provider.Mode == models.PROVIDER_MODE_OLLAMA && info.Dimension == 0 {
+		if err := this.showOllamaModel(ctx, provider, info); err != nil {
+			utils.Logger.Warnf("Keeping the name-based classification of model %s: %v", info.ID, err)
+		} else {
+			described = true
+		}
+	}
+	if info.Dimension == 0 && HasModelType(info, models.MODEL_TYPE_EMBEDDING) {
+		if result := this.probeEmbedding(ctx, provider, info.ID); result.Status == models.PROVIDER_TEST_STATUS_OK {
+			info.Dimension = result.Dimension
+			described = true
+		} else {
+			utils.Logger.Warnf("Failed to probe the dimension of embedding model %s: %s", info.ID, result.Error)
+		}
+	}
+	if !described {
+		return
+	}
+	// The catalog of a shared provider was filtered for the user, the cached one lists every model
+	if cached := loadModelCatalog(ctx, provider.ID); cached != nil {
+		if i := slices.IndexFunc(cached.Models, func(listed models.ModelInfo) bool { return listed.ID == info.ID }); i >= 0 {
+			cached.Models[i] = *info
+			storeModelCatalog(ctx, provider.ID, cached)
+		}
+	}
+}
+
+// invalidateModelCatalog drops the cached catalog, so the next listing asks the provider again
+func (this *ProviderService) invalidateModelCatalog(ctx context.Context, providerID uint) {
+	if db.RedisClient == nil {
+		return
+	}
+	if err := db.RedisClient.Del(ctx, fmt.Sprintf(MODEL_CATALOG_CACHE_KEY, providerID)).Err(); err != nil {
+		utils.Logger.Warnf("Failed to invalidate model catalog of provider %d: %v", providerID, err)
+	}
+}
+
+// loadModelCatalog reads a cached catalog, nil when there is none. Redis failures only cost a cache miss.
+func loadModelCatalog(ctx context.Context, providerID uint) *models.ProviderModelsResp {
+	if db.RedisClient == nil {
+		return nil
+	}
+	data, err := db.RedisClient.Get(ctx, fmt.Sprintf(MODEL_CATALOG_CACHE_KEY, providerID)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			utils.Logger.Warnf("Failed to read model catalog of provider %d: %v", providerID, err)
+		}
+		return nil
+	}
+	var catalog models.ProviderModelsResp
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		utils.Logger.Warnf("Dropping unreadable model catalog of provider %d: %v", providerID, err)
+		return nil
+	}
+	return &catalog
+}
+
+func storeModelCatalog(ctx context.Context, providerID uint, catalog *models.ProviderModelsResp) {
+	if db.RedisClient == nil {
+		return
+	}
+	data, err := json.Marshal(catalog)
+	if err != nil {
+		utils.Logger.Warnf("Failed to encode model catalog of provider %d: %v", providerID, err)
+		return
+	}
+	if err := db.RedisClient.Set(ctx, fmt.Sprintf(MODEL_CATALOG_CACHE_KEY, providerID), data, config.Settings.GetModelCatalogTTL()).Err(); err != nil {
+		utils.Logger.Warnf("Failed to cache model catalog of provider %d: %v", providerID, err)
+	}
+}
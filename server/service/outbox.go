@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"strconv"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gorm.io/gorm"
+)
+
+const (
+	// Wait between two looks for events that are due
+	OUTBOX_POLL_INTERVAL = time.Second
+	// Events published at once by a replica
+	OUTBOX_BATCH_SIZE = 50
+	// Wait for the broker to confirm a message, or for the connection to be recovered
+	OUTBOX_CONFIRM_TIMEOUT = 10 * time.Second
+	// A claimed event whose replica did not report back in this time is published again
+	OUTBOX_CLAIM_LEASE = 2 * time.Minute
+
+	// Backoff between attempts to publish an event, doubled after each failure
+	OUTBOX_RETRY_BASE_DELAY = 5 * time.Second
+	OUTBOX_RETRY_MAX_DELAY  = 5 * time.Minute
+	// Sent events are kept this long for troubleshooting, then pruned
+	OUTBOX_RETENTION      = 7 * 24 * time.Hour
+	OUTBOX_PRUNE_INTERVAL = time.Hour
+	// Characters of a publishing error kept on the event
+	OUTBOX_MAX_ERROR_LEN = 500
+)
+
+var OutboxServiceApp = new(OutboxService)
+
+// OutboxService relays the outbox table to RabbitMQ. Events are written in the transaction of the change
+// they report, then published until the broker confirms them, so they are delivered at least once
+// even when the broker is down at the time of the change. Consumers must handle duplicates.
+type OutboxService struct{}
+
+// outboxMessage marshals a message into an outbox event, due right away.
+// An empty exchange sends the message to the queue named by the routing key.
+func outboxMessage(exchange string, routingKey string, message any) (models.OutboxEvent, error) {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return models.OutboxEvent{}, err
+	}
+	now := time.Now()
+	return models.OutboxEvent{
+		Exchange:    exchange,
+		RoutingKey:  routingKey,
+		Payload:     string(payload),
+		AvailableAt: &now,
+	}, nil
+}
+
+// Add writes events to the outbox in tx, the relay publishes them once tx is committed
+func (this *OutboxService) Add(ctx context.Context, tx *gorm.DB, events ...models.OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return tx.WithContext(ctx).Create(&events).Error
+}
+
+// ReleaseFileEvents makes the events held back by the upload of a file due, once the upload completed
+func (this *OutboxService) ReleaseFileEvents(ctx context.Context, fileID uint) error {
+	_, err := gorm.G[models.OutboxEvent](db.PgSqlDB).
+		Where("file_id = ? AND available_at IS NULL AND sent_at IS NULL", fileID).
+		Update(ctx, "available_at", time.Now())
+	return err
+}
+
+// Start runs the relay publishing the outbox until ctx is done
+func (this *OutboxService) Start(ctx context.Context) {
+	go this.relay(ctx)
+}
+
+func (this *OutboxService) relay(ctx context.Context) {
+	// Declared again by the client whenever it reconnects
+	if err := db.RabbitMQClient.DeclareRetryTopology(db.NewRetryTopology(config.Settings.GetRabbitMQQueue())); err != nil {
+		utils.Logger.Errorf("Failed to declare queue %s: %v", config.Settings.GetRabbitMQQueue(), err)
+	}
+	if err := db.RabbitMQClient.DeclareExchange(config.Settings.GetRabbitMQExchange(), "topic"); err != nil {
+		utils.Logger.Errorf("Failed to declare events exchange: %v", err)
+	}
+
+	ticker := time.NewTicker(OUTBOX_POLL_INTERVAL)
+	defer ticker.Stop()
+	pruneTicker := time.NewTicker(OUTBOX_PRUNE_INTERVAL)
+	defer pruneTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-pruneTicker.C:
+			this.prune(ctx)
+		case <-ticker.C:
+			events, err := this.claimDueEvents(ctx)
+			if err != nil {
+				utils.Logger.Errorf("Failed to claim outbox events: %v", err)
+				continue
+			}
+			this.publishEvents(ctx, events)
+		}
+	}
+}
+
+// claimDueEvents takes the events that are due, in order, for a lease
+func (this *OutboxService) claimDueEvents(ctx context.Context) ([]models.OutboxEvent, error) {
+	return claimDue[models.OutboxEvent](ctx, DueClaim{
+		Due:         "sent_at IS NULL AND available_at <= ?",
+		Args:        []any{time.Now()},
+		Order:       "id",
+		Limit:       OUTBOX_BATCH_SIZE,
+		LeaseColumn: "available_at",
+		Lease:       OUTBOX_CLAIM_LEASE,
+	})
+}
+
+// publishEvents publishes claimed events and records their outcome
+func (this *OutboxService) publishEvents(ctx context.Context, events []models.OutboxEvent) {
+	outcomes, handedBack := PublishOutboxEvents(ctx, events, func(ctx context.Context, event *models.OutboxEvent) error {
+		publishCtx, cancel := context.WithTimeout(ctx, OUTBOX_CONFIRM_TIMEOUT)
+		defer cancel()
+		// Messages to a queue must reach it, events on the exchange may have no subscriber
+		return db.RabbitMQClient.Publish(publishCtx, event.Exchange, event.RoutingKey, event.Exchange == "", amqp.Publishing{
+			MessageId: strconv.FormatUint(uint64(event.ID), 10),
+			Body:      []byte(event.Payload),
+		})
+	})
+	for _, outcome := range outcomes {
+		if _, err := gorm.G[models.OutboxEvent](db.PgSqlDB).
+			Where("id = ?", outcome.ID).
+			Select("attempts", "last_error", "sent_at", "available_at").
+			Updates(context.WithoutCancel(ctx), outcome); err != nil {
+			utils.Logger.Errorf("Failed to record outcome of outbox event %d: %v", outcome.ID, err)
+		}
+	}
+	if len(handedBack) > 0 {
+		if _, err := gorm.G[models.OutboxEvent](db.PgSqlDB).
+			Where("id IN ?", handedBack).
+			Update(context.WithoutCancel(ctx), "available_at", time.Now()); err != nil {
+			utils.Logger.Errorf("Failed to hand back outbox events: %v", err)
+		}
+	}
+}
+
+// PublishOutboxEvents publishes claimed events in order, returning the outcome to record of each event attempted
+// and the IDs of the events to hand back unattempted. The first failure hands the rest back, as the broker is likely
+// down for them too. An event cut off by the end of ctx gets no outcome: its lease runs out and another replica publishes it.
+func PublishOutboxEvents(ctx context.Context, events []models.OutboxEvent, publish func(ctx context.Context, event *models.OutboxEvent) error) (outcomes []models.OutboxEvent, handedBack []uint) {
+	for i := range events {
+		event := &events[i]
+		err := publish(ctx, event)
+		if ctx.Err() != nil {
+			return outcomes, nil
+		}
+
+		now := time.Now()
+		outcome := models.OutboxEvent{ID: event.ID, Attempts: event.Attempts + 1}
+		if err == nil {
+			outcome.SentAt = &now
+			outcomes = append(outcomes, outcome)
+			continue
+		}
+		next := now.Add(utils.Backoff(OUTBOX_RETRY_BASE_DELAY, OUTBOX_RETRY_MAX_DELAY, outcome.Attempts))
+		outcome.AvailableAt = &next
+		outcome.LastError = truncateRunes(err.Error(), OUTBOX_MAX_ERROR_LEN)
+		utils.Logger.Warnf("Failed to publish outbox event %d to %q/%q, attempt %d: %v", event.ID, event.Exchange, event.RoutingKey, outcome.Attempts, err)
+		return append(outcomes, outcome), outboxEventIDs(events[i+1:])
+	}
+	return outcomes, nil
+}
+
+// prune deletes the events sent longer than the retention ago
+func (this *OutboxService) prune(ctx context.Context) {
+	rows, err := gorm.G[models.OutboxEvent](db.PgSqlDB).
+		Where("sent_at < ?", time.Now().Add(-OUTBOX_RETENTION)).
+		Delete(ctx)
+	if err != nil {
+		utils.Logger.Errorf("Failed to prune outbox events: %v", err)
+	} else if rows > 0 {
+		utils.Logger.Infof("Pruned %d sent outbox events", rows)
+	}
+}
+
+func outboxEventIDs(events []models.OutboxEvent) []uint {
+	ids := make([]uint, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
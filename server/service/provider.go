@@ -2,18 +2,20 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"server/db"
 	"server/models"
-	"server/utils"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/anthropics/anthropic-sdk-go"
-	anthropicOption "github.com/anthropics/anthropic-sdk-go/option"
 	openaiOption "github.com/openai/openai-go/v3/option"
 
 	"github.com/ollama/ollama/api"
+	ollamaModel "github.com/ollama/ollama/types/model"
 	"github.com/openai/openai-go/v3"
 	"google.golang.org/genai"
 
@@ -24,53 +26,130 @@ var ProviderServiceApp = new(ProviderService)
 
 type ProviderService struct{}
 
-func (this *ProviderService) CreateProvider(ctx context.Context, ownerID uint, name string, baseURL string, apiKey string, mode string) error {
-	// Encrypt API key using AES instead of bcrypt hash
-	encryptedKey, err := utils.EncryptAPIKey(apiKey)
+func (this *ProviderService) CreateProvider(ctx context.Context, ownerID uint, name string, baseURL string, apiKey string, mode string, settings models.ProviderSettings) error {
+	if err := checkOwnerProviderAddresses(ctx, ownerID, baseURL, settings.ProxyURL); err != nil {
+		return err
+	}
+	store, err := defaultSecretStore()
 	if err != nil {
-		return fmt.Errorf("failed to encrypt API key: %w", err)
+		return err
 	}
-
-	dbProvider := &models.Provider{
-		OwnerID: ownerID,
-		Name:    name,
-		BaseURL: baseURL,
-		APIKey:  encryptedKey,
-		Mode:    mode,
+	dbProvider, err := newProviderRecord(ctx, store, baseURL, apiKey, mode, settings)
+	if err != nil {
+		return err
 	}
-	return gorm.G[models.Provider](db.PgSqlDB).Create(ctx, dbProvider)
+	dbProvider.OwnerID = ownerID
+	dbProvider.Name = name
+	err = QuotaServiceApp.WithinQuota(ctx, ownerID, func(tx *gorm.DB, plan *models.QuotaPlan) error {
+		if err := QuotaServiceApp.CheckProvider(ctx, tx, plan, ownerID); err != nil {
+			return err
+		}
+		return gorm.G[models.Provider](tx).Create(ctx, dbProvider)
+	})
+	if err != nil {
+		deleteProviderSecrets(ctx, dbProvider)
+	}
+	return err
 }
 
-func (this *ProviderService) UpdateProvider(ctx context.Context, providerID uint, ownerID uint, name string, baseURL string, apiKey string, mode string) error {
-	// Encrypt API key using AES instead of bcrypt hash
-	encryptedKey, err := utils.EncryptAPIKey(apiKey)
+func (this *ProviderService) UpdateProvider(ctx context.Context, providerID uint, ownerID uint, name string, baseURL string, apiKey string, mode string, settings models.ProviderSettings) error {
+	oldProvider, err := gorm.G[models.Provider](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", providerID, ownerID).
+		First(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt API key: %w", err)
+		return err
 	}
-
-	newProvider := models.Provider{
-		Name:    name,
-		BaseURL: baseURL,
-		APIKey:  encryptedKey,
-		Mode:    mode,
+	if err := checkOwnerProviderAddresses(ctx, ownerID, baseURL, settings.ProxyURL); err != nil {
+		return err
 	}
+	store, err := defaultSecretStore()
+	if err != nil {
+		return err
+	}
+	newProvider, err := newProviderRecord(ctx, store, baseURL, apiKey, mode, settings)
+	if err != nil {
+		return err
+	}
+	newProvider.Name = name
+	// Settings of the previous mode are cleared, so every column is written even when empty
 	rows, err := gorm.G[models.Provider](db.PgSqlDB).
 		Where("id = ? AND owner_id = ?", providerID, ownerID).
-		Updates(ctx, newProvider)
-	if rows == 0 {
-		return ErrNotFound
+		Select("name", "base_url", "api_key", "mode", "api_version", "path_prefix", "headers", "deployments",
+			"proxy_url", "redacted_proxy_url", "ca_cert", "insecure_skip_verify", "timeout_seconds", "max_retries",
+			"requests_per_minute", "tokens_per_minute", "max_concurrency").
+		Updates(ctx, *newProvider)
+	if err == nil && rows == 0 {
+		err = ErrNotFound
 	}
-	return err
+	if err != nil {
+		deleteProviderSecrets(ctx, newProvider)
+		return err
+	}
+	deleteProviderSecrets(ctx, &oldProvider)
+	// Another base URL or key may serve other models
+	this.invalidateModelCatalog(ctx, providerID)
+	return nil
 }
+
+// checkOwnerProviderAddresses keeps the providers of users, which no administrator reviews, out of the server's own network
+func checkOwnerProviderAddresses(ctx context.Context, ownerID uint, baseURL string, proxyURL string) error {
+	admin, err := ownerIsAdmin(ctx, ownerID)
+	if err != nil || admin {
+		return err
+	}
+	return checkProviderAddresses(ctx, baseURL, proxyURL)
+}
+
+// newProviderRecord builds the stored form of provider settings, with the API key, headers and proxy URL kept in the secret store
+// and only referenced by the record
+func newProviderRecord(ctx context.Context, store SecretStore, baseURL string, apiKey string, mode string, settings models.ProviderSettings) (*models.Provider, error) {
+	if settings.CACert != "" {
+		if _, err := providerCertPool(settings.CACert); err != nil {
+			return nil, err
+		}
+	}
+	provider := &models.Provider{
+		BaseURL:            baseURL,
+		Mode:               mode,
+		APIVersion:         settings.APIVersion,
+		PathPrefix:         settings.PathPrefix,
+		Deployments:        settings.Deployments,
+		CACert:             settings.CACert,
+		InsecureSkipVerify: settings.InsecureSkipVerify,
+		TimeoutSeconds:     settings.TimeoutSeconds,
+		MaxRetries:         settings.MaxRetries,
+		RequestsPerMinute:  settings.RequestsPerMinute,
+		TokensPerMinute:    settings.TokensPerMinute,
+		MaxConcurrency:     settings.MaxConcurrency,
+	}
+	if settings.ProxyURL != "" {
+		proxyURL, err := url.Parse(settings.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+		}
+		provider.RedactedProxyURL = proxyURL.Redacted()
+	}
+	if err := putProviderSecrets(ctx, store, provider, apiKey, settings.Headers, settings.ProxyURL); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// GetProviderByID retrieves a provider of the owner or a shared provider granted to them
 func (this *ProviderService) GetProviderByID(ctx context.Context, providerID uint, ownerID uint) (*models.ProviderInfo, error) {
 	var dbProvider models.ProviderInfo
 	result := db.PgSqlDB.Model(&models.Provider{}).
-		Where("id = ? AND owner_id = ?", providerID, ownerID).
+		Where("id = ?", providerID).
+		Where(PROVIDER_ACCESS_CONDITION, ownerID, ownerID).
 		First(&dbProvider)
 	if result.Error != nil {
 		return nil, result.Error
 	}
-	return &dbProvider, nil
+	infos := []models.ProviderInfo{dbProvider}
+	if err := this.describeProviderAccess(ctx, ownerID, infos); err != nil {
+		return nil, err
+	}
+	return &infos[0], nil
 }
 
 func (this *ProviderService) GetProviderByName(ctx context.Context, name string, ownerID uint) (*models.ProviderInfo, error) {
@@ -91,35 +170,57 @@ func (this *ProviderService) CheckProviderExistsByName(ctx context.Context, owne
 	return cnt > 0, err
 }
 
-// CheckProviderOwnership verifies that the provider belongs to the specified owner
+// CheckProviderOwnership verifies that the provider belongs to the specified owner or is a shared provider granted to them
 func (this *ProviderService) CheckProviderOwnership(ctx context.Context, providerID uint, ownerID uint) (belongs bool, err error) {
 	cnt, err := gorm.G[models.Provider](db.PgSqlDB).
-		Where("id = ? AND owner_id = ?", providerID, ownerID).
+		Where("id = ?", providerID).
+		Where(PROVIDER_ACCESS_CONDITION, ownerID, ownerID).
 		Count(ctx, "*")
 	return cnt > 0, err
 }
 
+// GetAllProviders lists the owner's providers and the shared providers granted to them
 func (this *ProviderService) GetAllProviders(ctx context.Context, ownerID uint) (cows int64, dbProviders []models.ProviderInfo, err error) {
 
 	result := db.PgSqlDB.Model(&models.Provider{}).
-		Where("owner_id = ?", ownerID).
+		Where(PROVIDER_ACCESS_CONDITION, ownerID, ownerID).
 		Find(&dbProviders)
+	if result.Error != nil {
+		return 0, nil, result.Error
+	}
+	if err := this.describeProviderAccess(ctx, ownerID, dbProviders); err != nil {
+		return 0, nil, err
+	}
 
-	return result.RowsAffected, dbProviders, result.Error
+	return result.RowsAffected, dbProviders, nil
 }
 
 func (this *ProviderService) DeleteProvider(ctx context.Context, providerID uint, ownerID uint) error {
-	_, err := gorm.G[models.Provider](db.PgSqlDB).
+	provider, err := gorm.G[models.Provider](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", providerID, ownerID).
+		First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	rows, err := gorm.G[models.Provider](db.PgSqlDB).
 		Where("id = ? AND owner_id = ?", providerID, ownerID).
 		Delete(ctx)
+	if err == nil && rows > 0 {
+		deleteProviderSecrets(ctx, &provider)
+		this.invalidateModelCatalog(ctx, providerID)
+	}
 	return err
 }
 
-// GetProviderRawByID retrieves the full provider record (including encrypted API key)
+// GetProviderRawByID retrieves the full provider record (including encrypted API key) of a provider
+// the owner owns or was granted. Callers choosing a model check it with AuthorizeProviderModel.
 func (this *ProviderService) GetProviderRawByID(ctx context.Context, providerID uint, ownerID uint) (*models.Provider, error) {
 	var provider models.Provider
 	result := db.PgSqlDB.Model(&models.Provider{}).
-		Where("id = ? AND owner_id = ?", providerID, ownerID).
+		Where("id = ?", providerID).
+		Where(PROVIDER_ACCESS_CONDITION, ownerID, ownerID).
 		First(&provider)
 	if result.Error != nil {
 		return nil, result.Error
@@ -127,48 +228,55 @@ func (this *ProviderService) GetProviderRawByID(ctx context.Context, providerID
 	return &provider, nil
 }
 
-// ListModels fetches available embedding models from the provider's API
-func (this *ProviderService) ListModels(ctx context.Context, providerID uint, ownerID uint) (*models.ProviderModelsResp, error) {
-	// Get provider with encrypted API key
-	provider, err := this.GetProviderRawByID(ctx, providerID, ownerID)
+// ListProviderModels calls the model listing API of the provider's mode,
+// then classifies the models the provider gave no metadata about. Unlike ListModels it bypasses the catalog cache.
+func (this *ProviderService) ListProviderModels(ctx context.Context, provider *models.Provider) (*models.ProviderModelsResp, error) {
+	apiKey, err := this.ResolveAPIKey(ctx, provider)
 	if err != nil {
-		return nil, ErrNotFound
-	}
-
-	// Decrypt API key
-	apiKey, err := utils.DecryptAPIKey(provider.APIKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt API key: %w", err)
+		return nil, err
 	}
 
 	// Call external API based on provider mode
+	var resp *models.ProviderModelsResp
 	switch provider.Mode {
 	case models.PROVIDER_MODE_OPENAI, models.PROVIDER_MODE_OPENAI_RESP:
-		return this.listOpenAIModels(ctx, provider.BaseURL, apiKey)
+		// Validate that baseURL ends with /v1, other paths need the openai_compatible mode
+		if !strings.HasSuffix(strings.TrimSuffix(provider.BaseURL, "/"), "/v1") {
+			return nil, fmt.Errorf("OpenAI base URL must end with /v1, got: %s", provider.BaseURL)
+		}
+		resp, err = this.listOpenAIModels(ctx, provider, apiKey)
+	case models.PROVIDER_MODE_OPENAI_COMPATIBLE:
+		resp, err = this.listOpenAIModels(ctx, provider, apiKey)
+	case models.PROVIDER_MODE_AZURE_OPENAI:
+		resp, err = this.listAzureDeployments(ctx, provider, apiKey)
+	case models.PROVIDER_MODE_MISTRAL:
+		resp, err = this.listMistralModels(ctx, provider, apiKey)
 	case models.PROVIDER_MODE_ANTHROPIC:
-		return this.listAnthropicModels(ctx, provider.BaseURL, apiKey)
+		resp, err = this.listAnthropicModels(ctx, provider, apiKey)
 	case models.PROVIDER_MODE_GEMINI:
-		return this.listGeminiModels(ctx, provider.BaseURL, apiKey)
+		resp, err = this.listGeminiModels(ctx, provider, apiKey)
 	case models.PROVIDER_MODE_OLLAMA:
-		return this.listOllamaModels(ctx, provider.BaseURL)
+		resp, err = this.listOllamaModels(ctx, provider)
 	default:
 		return nil, fmt.Errorf("unsupported provider mode: %s", provider.Mode)
 	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range resp.Models {
+		ClassifyModel(&resp.Models[i])
+	}
+	resp.CachedAt = time.Now()
+	return resp, nil
 }
 
 // listOpenAIModels uses OpenAI SDK to list embedding models
-func (this *ProviderService) listOpenAIModels(ctx context.Context, baseURL string, apiKey string) (*models.ProviderModelsResp, error) {
-	// Validate that baseURL ends with /v1
-	baseURL = strings.TrimSuffix(baseURL, "/")
-	if !strings.HasSuffix(baseURL, "/v1") {
-		return nil, fmt.Errorf("OpenAI base URL must end with /v1, got: %s", baseURL)
+func (this *ProviderService) listOpenAIModels(ctx context.Context, provider *models.Provider, apiKey string) (*models.ProviderModelsResp, error) {
+	opts, err := openAIClientOptions(ctx, provider, apiKey)
+	if err != nil {
+		return nil, err
 	}
-
-	// Create OpenAI client with custom base URL
-	client := openai.NewClient(
-		openaiOption.WithAPIKey(apiKey),
-		openaiOption.WithBaseURL(baseURL),
-	)
+	client := openai.NewClient(opts...)
 
 	// List all models
 	modelsList, err := client.Models.List(ctx)
@@ -189,11 +297,96 @@ func (this *ProviderService) listOpenAIModels(ctx context.Context, baseURL strin
 	return &models.ProviderModelsResp{Models: allModels}, nil
 }
 
+// listAzureDeployments lists the deployments of an Azure OpenAI resource, deployment names being what requests select.
+// The deployments set on the provider are used when given, since only old API versions can list them.
+func (this *ProviderService) listAzureDeployments(ctx context.Context, provider *models.Provider, apiKey string) (*models.ProviderModelsResp, error) {
+	allModels := []models.ModelInfo{}
+	if len(provider.Deployments) > 0 {
+		for _, deployment := range provider.Deployments {
+			allModels = append(allModels, models.ModelInfo{
+				ID:      deployment,
+				Object:  "deployment",
+				OwnedBy: "azure",
+			})
+		}
+		return &models.ProviderModelsResp{Models: allModels}, nil
+	}
+
+	opts, err := openAIClientOptions(ctx, provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	client := openai.NewClient(opts...)
+	var deployments struct {
+		Data []struct {
+			ID    string `json:"id"`    // Deployment name
+			Model string `json:"model"` // Deployed model
+		} `json:"data"`
+	}
+	if err := client.Get(ctx, "deployments", nil, &deployments,
+		openaiOption.WithQuery("api-version", AZURE_OPENAI_DEPLOYMENTS_API_VERSION)); err != nil {
+		return nil, fmt.Errorf("failed to list Azure OpenAI deployments: %w", err)
+	}
+	for _, deployment := range deployments.Data {
+		// Deployments are classified by the model they serve
+		info := models.ModelInfo{ID: deployment.Model}
+		ClassifyModel(&info)
+		info.ID = deployment.ID
+		info.Object = "deployment"
+		info.OwnedBy = "azure"
+		allModels = append(allModels, info)
+	}
+	return &models.ProviderModelsResp{Models: allModels}, nil
+}
+
+// listMistralModels lists Mistral models with the capabilities and context length Mistral reports
+func (this *ProviderService) listMistralModels(ctx context.Context, provider *models.Provider, apiKey string) (*models.ProviderModelsResp, error) {
+	opts, err := openAIClientOptions(ctx, provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
+	client := openai.NewClient(opts...)
+	var modelsList struct {
+		Data []struct {
+			ID           string `json:"id"`
+			OwnedBy      string `json:"owned_by"`
+			Capabilities struct {
+				CompletionChat bool `json:"completion_chat"`
+				Vision         bool `json:"vision"`
+			} `json:"capabilities"`
+			MaxContextLength int `json:"max_context_length"`
+		} `json:"data"`
+	}
+	if err := client.Get(ctx, "models", nil, &modelsList); err != nil {
+		return nil, fmt.Errorf("failed to list Mistral models: %w", err)
+	}
+
+	allModels := []models.ModelInfo{}
+	for _, model := range modelsList.Data {
+		info := models.ModelInfo{
+			ID:            model.ID,
+			Object:        "model",
+			OwnedBy:       model.OwnedBy,
+			ContextLength: model.MaxContextLength,
+		}
+		// Mistral flags no embedding capability, models that cannot chat are classified by name
+		if model.Capabilities.CompletionChat {
+			info.Types = []string{models.MODEL_TYPE_CHAT}
+			if model.Capabilities.Vision {
+				info.Types = append(info.Types, models.MODEL_TYPE_VISION)
+			}
+		}
+		allModels = append(allModels, info)
+	}
+	return &models.ProviderModelsResp{Models: allModels}, nil
+}
+
 // listAnthropicModels uses Anthropic SDK to list available models
-func (this *ProviderService) listAnthropicModels(ctx context.Context, baseURL string, apiKey string) (*models.ProviderModelsResp, error) {
-	client := anthropic.NewClient(
-		anthropicOption.WithAPIKey(apiKey),
-		anthropicOption.WithBaseURL(baseURL))
+func (this *ProviderService) listAnthropicModels(ctx context.Context, provider *models.Provider, apiKey string) (*models.ProviderModelsResp, error) {
+	client, err := newAnthropicClient(ctx, provider, apiKey)
+	if err != nil {
+		return nil, err
+	}
 
 	// List models
 	page, err := client.Models.List(ctx, anthropic.ModelListParams{})
@@ -204,10 +397,16 @@ func (this *ProviderService) listAnthropicModels(ctx context.Context, baseURL st
 	// Return all models
 	allModels := []models.ModelInfo{}
 	for _, model := range page.Data {
+		types := []string{models.MODEL_TYPE_CHAT}
+		if model.Capabilities.ImageInput.Supported {
+			types = append(types, models.MODEL_TYPE_VISION)
+		}
 		allModels = append(allModels, models.ModelInfo{
-			ID:      model.ID,
-			Object:  string(model.Type),
-			OwnedBy: "anthropic",
+			ID:            model.ID,
+			Object:        string(model.Type),
+			OwnedBy:       "anthropic",
+			Types:         types,
+			ContextLength: int(model.MaxInputTokens),
 		})
 	}
 
@@ -215,16 +414,11 @@ func (this *ProviderService) listAnthropicModels(ctx context.Context, baseURL st
 }
 
 // listGeminiModels uses Google Genai SDK to list embedding models
-func (this *ProviderService) listGeminiModels(ctx context.Context, baseURL, apiKey string) (*models.ProviderModelsResp, error) {
+func (this *ProviderService) listGeminiModels(ctx context.Context, provider *models.Provider, apiKey string) (*models.ProviderModelsResp, error) {
 	// Create Gemini client
-	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-		APIKey: apiKey,
-		HTTPOptions: genai.HTTPOptions{
-			BaseURL: baseURL,
-		},
-	})
+	client, err := newGeminiClient(ctx, provider, apiKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+		return nil, err
 	}
 
 	// List models - returns Page[Model] with Items array
@@ -239,26 +433,26 @@ func (this *ProviderService) listGeminiModels(ctx context.Context, baseURL, apiK
 		// Extract model name from full path (e.g., "models/gemini-pro")
 		name := strings.TrimPrefix(model.Name, "models/")
 		allModels = append(allModels, models.ModelInfo{
-			ID:      name,
-			Object:  "model",
-			OwnedBy: "google",
+			ID:            name,
+			Object:        "model",
+			OwnedBy:       "google",
+			Types:         geminiModelTypes(name, model.SupportedActions),
+			ContextLength: int(model.InputTokenLimit),
 		})
 	}
 
 	return &models.ProviderModelsResp{Models: allModels}, nil
 }
 
-// listOllamaModels uses Ollama SDK to list embedding models
-func (this *ProviderService) listOllamaModels(ctx context.Context, baseURL string) (*models.ProviderModelsResp, error) {
-	// Parse base URL
-	ollamaURL, err := url.Parse(baseURL)
+// listOllamaModels uses Ollama SDK to list embedding models.
+// Models are classified by name, describeModel asks the server about the one picked for a dataset.
+func (this *ProviderService) listOllamaModels(ctx context.Context, provider *models.Provider) (*models.ProviderModelsResp, error) {
+	// Create Ollama client
+	client, err := newOllamaClient(ctx, provider)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse Ollama base URL: %w", err)
+		return nil, err
 	}
 
-	// Create Ollama client
-	client := api.NewClient(ollamaURL, nil)
-
 	// List models
 	modelsList, err := client.List(ctx)
 	if err != nil {
@@ -277,3 +471,175 @@ func (this *ProviderService) listOllamaModels(ctx context.Context, baseURL strin
 
 	return &models.ProviderModelsResp{Models: allModels}, nil
 }
+
+// showOllamaModel asks the local server for the capabilities and sizes of one model
+func (this *ProviderService) showOllamaModel(ctx context.Context, provider *models.Provider, info *models.ModelInfo) error {
+	client, err := newOllamaClient(ctx, provider)
+	if err != nil {
+		return err
+	}
+	show, err := client.Show(ctx, &api.ShowRequest{Model: info.ID})
+	if err != nil {
+		return fmt.Errorf("failed to show Ollama model %s: %w", info.ID, err)
+	}
+	setOllamaModelDetails(info, show)
+	return nil
+}
+
+// geminiModelTypes derives the types of a Gemini model from the API methods it supports.
+// Returns nil when the methods are unknown, so the model gets classified by name.
+func geminiModelTypes(name string, actions []string) []string {
+	if len(actions) == 0 {
+		return nil
+	}
+	types := []string{}
+	if slices.Contains(actions, "generateContent") {
+		types = append(types, models.MODEL_TYPE_CHAT)
+		// Gemini models are multimodal, Gemma and others are not all
+		if strings.HasPrefix(name, "gemini") {
+			types = append(types, models.MODEL_TYPE_VISION)
+		}
+	}
+	if slices.Contains(actions, "embedContent") || slices.Contains(actions, "embedText") {
+		types = append(types, models.MODEL_TYPE_EMBEDDING)
+	}
+	return types
+}
+
+// setOllamaModelDetails copies the capabilities, the context length and the embedding size of an Ollama model
+func setOllamaModelDetails(info *models.ModelInfo, show *api.ShowResponse) {
+	if len(show.Capabilities) > 0 {
+		info.Types = []string{}
+		for _, capability := range show.Capabilities {
+			switch capability {
+			case ollamaModel.CapabilityCompletion:
+				info.Types = append(info.Types, models.MODEL_TYPE_CHAT)
+			case ollamaModel.CapabilityVision:
+				info.Types = append(info.Types, models.MODEL_TYPE_VISION)
+			case ollamaModel.CapabilityEmbedding:
+				info.Types = append(info.Types, models.MODEL_TYPE_EMBEDDING)
+			}
+		}
+	}
+	// Keys are prefixed with the architecture, like "llama.context_length"
+	for key, value := range show.ModelInfo {
+		size, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(key, ".context_length"):
+			info.ContextLength = int(size)
+		case strings.HasSuffix(key, ".embedding_length") && slices.Contains(info.Types, models.MODEL_TYPE_EMBEDDING):
+			// Only the output of embedding models, chat models report their hidden size here
+			info.Dimension = int(size)
+		}
+	}
+}
+
+// EmbedTexts embeds texts with the given model through the provider's API and records the call in the usage ledger
+func (this *ProviderService) EmbedTexts(ctx context.Context, provider *models.Provider, model string, texts []string) ([][]float32, error) {
+	apiKey, err := this.ResolveAPIKey(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+
+	var embeddings [][]float32
+	var tokens int64
+	start := time.Now()
+	switch provider.Mode {
+	case models.PROVIDER_MODE_OPENAI, models.PROVIDER_MODE_OPENAI_RESP, models.PROVIDER_MODE_OPENAI_COMPATIBLE,
+		models.PROVIDER_MODE_AZURE_OPENAI, models.PROVIDER_MODE_MISTRAL:
+		embeddings, tokens, err = this.embedOpenAI(ctx, provider, apiKey, model, texts)
+	case models.PROVIDER_MODE_GEMINI:
+		embeddings, tokens, err = this.embedGemini(ctx, provider, apiKey, model, texts)
+	case models.PROVIDER_MODE_OLLAMA:
+		embeddings, tokens, err = this.embedOllama(ctx, provider, model, texts)
+	default:
+		return nil, fmt.Errorf("provider mode %s does not support embeddings", provider.Mode)
+	}
+
+	event := models.UsageEvent{
+		CallType:    models.USAGE_CALL_EMBEDDING,
+		Model:       model,
+		InputTokens: tokens,
+		Latency:     time.Since(start),
+		Err:         err,
+	}
+	if err == nil && tokens == 0 {
+		event.InputTokens = EstimateTokens(texts...)
+		event.Estimated = true
+	}
+	UsageServiceApp.Record(ctx, provider, event)
+	return embeddings, err
+}
+
+// embedOpenAI uses OpenAI SDK to create embeddings
+func (this *ProviderService) embedOpenAI(ctx context.Context, provider *models.Provider, apiKey string, model string, texts []string) ([][]float32, int64, error) {
+	opts, err := openAIClientOptions(ctx, provider, apiKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	client := openai.NewClient(opts...)
+
+	resp, err := client.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+		Model: model,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create OpenAI embeddings: %w", err)
+	}
+
+	embeddings := make([][]float32, len(resp.Data))
+	for _, data := range resp.Data {
+		if int(data.Index) >= len(embeddings) {
+			continue
+		}
+		vector := make([]float32, len(data.Embedding))
+		for i, value := range data.Embedding {
+			vector[i] = float32(value)
+		}
+		embeddings[data.Index] = vector
+	}
+	return embeddings, resp.Usage.PromptTokens, nil
+}
+
+// embedGemini uses Google Genai SDK to create embeddings. The Gemini API reports no token counts for them.
+func (this *ProviderService) embedGemini(ctx context.Context, provider *models.Provider, apiKey string, model string, texts []string) ([][]float32, int64, error) {
+	client, err := newGeminiClient(ctx, provider, apiKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	contents := make([]*genai.Content, 0, len(texts))
+	for _, text := range texts {
+		contents = append(contents, genai.NewContentFromText(text, genai.RoleUser))
+	}
+	resp, err := client.Models.EmbedContent(ctx, model, contents, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create Gemini embeddings: %w", err)
+	}
+
+	embeddings := make([][]float32, 0, len(resp.Embeddings))
+	for _, embedding := range resp.Embeddings {
+		embeddings = append(embeddings, embedding.Values)
+	}
+	return embeddings, 0, nil
+}
+
+// embedOllama uses Ollama SDK to create embeddings
+func (this *ProviderService) embedOllama(ctx context.Context, provider *models.Provider, model string, texts []string) ([][]float32, int64, error) {
+	client, err := newOllamaClient(ctx, provider)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := client.Embed(ctx, &api.EmbedRequest{
+		Model: model,
+		Input: texts,
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create Ollama embeddings: %w", err)
+	}
+	return resp.Embeddings, int64(resp.PromptEvalCount), nil
+}
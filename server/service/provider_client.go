@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	anthropicOption "github.com/anthropics/anthropic-sdk-go/option"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go/v3/azure"
+	openaiOption "github.com/openai/openai-go/v3/option"
+	"google.golang.org/genai"
+	"gorm.io/gorm"
+)
+
+const (
+	// Last Azure OpenAI API version able to list the deployments of a resource
+	AZURE_OPENAI_DEPLOYMENTS_API_VERSION = "2022-12-01"
+
+	// Backoff between retries of a failed provider call, doubled after each attempt and jittered
+	PROVIDER_RETRY_BASE_DELAY = 500 * time.Millisecond
+	PROVIDER_RETRY_MAX_DELAY  = 8 * time.Second
+
+	// Saved providers whose transports are kept
+	PROVIDER_TRANSPORT_CACHE_SIZE = 256
+)
+
+// Transports of the saved providers with their own proxy, TLS or timeout settings, by provider ID.
+// Sharing them keeps connections alive across calls, the least recently used ones are dropped with their idle connections.
+var (
+	providerTransports, _ = lru.NewWithEvict(PROVIDER_TRANSPORT_CACHE_SIZE, func(_ uint, cached *cachedTransport) {
+		cached.transport.CloseIdleConnections()
+	})
+	providerTransportsMu sync.Mutex
+)
+
+type cachedTransport struct {
+	settings  [sha256.Size]byte // Hash of the settings the transport was set up with
+	transport *http.Transport
+}
+
+// providerHTTPClient builds the HTTP client every SDK client of the provider goes through.
+// It applies the provider's proxy, TLS and timeout settings, adds its extra headers, holds attempts to the
+// provider's limits and retries failed attempts, so the SDKs' own retries are turned off.
+func providerHTTPClient(ctx context.Context, provider *models.Provider) (*http.Client, error) {
+	proxyURL, err := ProviderServiceApp.ResolveProxyURL(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	reviewed, err := providerReviewed(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := providerTransport(provider, proxyURL, !reviewed)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := ProviderServiceApp.ResolveHeaders(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) > 0 {
+		transport = &headerTransport{next: transport, headers: headers}
+	}
+	// Unsaved providers being tested have no shared budget
+	if provider.ID != 0 {
+		transport = NewGovernorTransport(transport, provider)
+	}
+	maxRetries := models.PROVIDER_DEFAULT_MAX_RETRIES
+	if provider.MaxRetries != nil {
+		maxRetries = *provider.MaxRetries
+	}
+	if maxRetries > 0 {
+		transport = &retryTransport{next: transport, maxRetries: maxRetries}
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// providerReviewed reports whether an administrator set up a saved provider, which may then reach any address.
+// Shared providers belong to administrators.
+func providerReviewed(ctx context.Context, provider *models.Provider) (bool, error) {
+	if provider.ID == 0 {
+		return false, nil
+	}
+	if provider.Shared {
+		return true, nil
+	}
+	return ownerIsAdmin(ctx, provider.OwnerID)
+}
+
+func ownerIsAdmin(ctx context.Context, ownerID uint) (bool, error) {
+	owner, err := gorm.G[models.User](db.PgSqlDB).Select("role").Where("id = ?", ownerID).First(ctx)
+	if err != nil {
+		return false, err
+	}
+	return owner.Role == "admin", nil
+}
+
+// checkProviderAddresses refuses a base or proxy URL resolving to the server's own network
+func checkProviderAddresses(ctx context.Context, baseURL string, proxyURL string) error {
+	allowed := config.Settings.GetOutboundAllowedNetworks()
+	if err := utils.CheckPublicURL(ctx, baseURL, allowed); err != nil {
+		return fmt.Errorf("%w: %v", ErrProviderAddressRefused, err)
+	}
+	if proxyURL != "" {
+		if err := utils.CheckPublicURL(ctx, proxyURL, allowed); err != nil {
+			return fmt.Errorf("%w: %v", ErrProviderAddressRefused, err)
+		}
+	}
+	return nil
+}
+
+// providerTransport returns the shared default transport, or one set up with the provider's proxy, TLS and timeout.
+// Guarded transports only connect to public addresses, even when the host resolves elsewhere on the connection.
+func providerTransport(provider *models.Provider, proxyURL string, guarded bool) (http.RoundTripper, error) {
+	if !guarded && proxyURL == "" && provider.CACert == "" && !provider.InsecureSkipVerify && provider.TimeoutSeconds == 0 {
+		return http.DefaultTransport, nil
+	}
+	// Unsaved settings being tested are used for a few calls, their connections are not kept
+	if provider.ID == 0 {
+		transport, err := newProviderTransport(provider, proxyURL, guarded)
+		if err != nil {
+			return nil, err
+		}
+		transport.DisableKeepAlives = true
+		return transport, nil
+	}
+
+	settings := sha256.Sum256(fmt.Appendf(nil, "%s|%t|%d|%t|%s", proxyURL, provider.InsecureSkipVerify, provider.TimeoutSeconds, guarded, provider.CACert))
+	providerTransportsMu.Lock()
+	defer providerTransportsMu.Unlock()
+	cached, ok := providerTransports.Get(provider.ID)
+	if ok && cached.settings == settings {
+		return cached.transport, nil
+	}
+	transport, err := newProviderTransport(provider, proxyURL, guarded)
+	if err != nil {
+		return nil, err
+	}
+	// Replacing an entry does not evict it, the transport of the former settings is closed here
+	if ok {
+		cached.transport.CloseIdleConnections()
+	}
+	providerTransports.Add(provider.ID, &cachedTransport{settings: settings, transport: transport})
+	return transport, nil
+}
+
+// newProviderTransport sets up a transport with the provider's proxy, TLS and timeout settings
+func newProviderTransport(provider *models.Provider, proxyURL string, guarded bool) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if guarded {
+		transport.DialContext = utils.PublicDialer(30*time.Second, config.Settings.GetOutboundAllowedNetworks()).DialContext
+	}
+	if proxyURL != "" {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(parsed)
+	}
+	if provider.CACert != "" || provider.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: provider.InsecureSkipVerify}
+		if provider.CACert != "" {
+			pool, err := providerCertPool(provider.CACert)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	if provider.TimeoutSeconds > 0 {
+		// Streamed answers keep flowing after the headers, so only the wait for them is limited
+		transport.ResponseHeaderTimeout = time.Duration(provider.TimeoutSeconds) * time.Second
+	}
+	return transport, nil
+}
+
+// providerCertPool adds a PEM bundle to the system CAs
+func providerCertPool(caCert string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM([]byte(caCert)) {
+		return nil, ErrInvalidCACert
+	}
+	return pool, nil
+}
+
+// headerTransport adds the provider's extra headers to every request, over the ones set by the SDK
+type headerTransport struct {
+	next    http.RoundTripper
+	headers map[string]string
+}
+
+func (this *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range this.headers {
+		req.Header.Set(name, value)
+	}
+	return this.next.RoundTrip(req)
+}
+
+// retryTransport retries attempts failing to connect or answered by a 408, 429 or 5xx status,
+// waiting at least as long as the provider's Retry-After asks
+type retryTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+}
+
+func (this *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := this.next.RoundTrip(req)
+		// Requests whose body cannot be replayed are not retried
+		if attempt >= this.maxRetries || !isRetryableAttempt(resp, err) || req.Context().Err() != nil ||
+			(req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+			return resp, err
+		}
+		backoff := min(PROVIDER_RETRY_BASE_DELAY<<attempt, PROVIDER_RETRY_MAX_DELAY)
+		// Half fixed, half random, so the callers failing together do not retry together
+		delay := backoff/2 + rand.N(backoff/2+1)
+		if resp != nil {
+			if retryAfter, ok := RetryAfterDelay(resp); ok {
+				delay = max(delay, min(retryAfter, PROVIDER_GOVERNOR_MAX_PAUSE))
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func isRetryableAttempt(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// providerAPIBaseURL returns the URL other OpenAI clients should use as API root of the provider
+func providerAPIBaseURL(provider *models.Provider) string {
+	if provider.Mode == models.PROVIDER_MODE_OPENAI_COMPATIBLE {
+		return strings.TrimSuffix(strings.TrimSuffix(provider.BaseURL, "/")+provider.PathPrefix, "/")
+	}
+	return provider.BaseURL
+}
+
+// openAIClientOptions points the OpenAI SDK at the API of a provider of an OpenAI-based mode
+func openAIClientOptions(ctx context.Context, provider *models.Provider, apiKey string) ([]openaiOption.RequestOption, error) {
+	httpClient, err := providerHTTPClient(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	opts := []openaiOption.RequestOption{
+		openaiOption.WithHTTPClient(httpClient),
+		openaiOption.WithMaxRetries(0),
+	}
+	switch provider.Mode {
+	case models.PROVIDER_MODE_AZURE_OPENAI:
+		apiVersion := provider.APIVersion
+		if apiVersion == "" {
+			apiVersion = models.AZURE_OPENAI_DEFAULT_API_VERSION
+		}
+		// Models are deployment names, the SDK moves them into the request path
+		return append(opts,
+			azure.WithEndpoint(provider.BaseURL, apiVersion),
+			azure.WithAPIKey(apiKey),
+		), nil
+	default:
+		return append(opts,
+			openaiOption.WithAPIKey(apiKey),
+			openaiOption.WithBaseURL(strings.TrimSuffix(providerAPIBaseURL(provider), "/")),
+		), nil
+	}
+}
+
+func newAnthropicClient(ctx context.Context, provider *models.Provider, apiKey string) (anthropic.Client, error) {
+	httpClient, err := providerHTTPClient(ctx, provider)
+	if err != nil {
+		return anthropic.Client{}, err
+	}
+	return anthropic.NewClient(
+		anthropicOption.WithAPIKey(apiKey),
+		anthropicOption.WithBaseURL(provider.BaseURL),
+		anthropicOption.WithHTTPClient(httpClient),
+		anthropicOption.WithMaxRetries(0),
+	), nil
+}
+
+func newGeminiClient(ctx context.Context, provider *models.Provider, apiKey string) (*genai.Client, error) {
+	httpClient, err := providerHTTPClient(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	client, err := genai.NewClient(ctx, &genai.ClientConfig{
+		APIKey:     apiKey,
+		HTTPClient: httpClient,
+		HTTPOptions: genai.HTTPOptions{
+			BaseURL: provider.BaseURL,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Gemini client: %w", err)
+	}
+	return client, nil
+}
+
+func newOllamaClient(ctx context.Context, provider *models.Provider) (*api.Client, error) {
+	ollamaURL, err := url.Parse(provider.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Ollama base URL: %w", err)
+	}
+	httpClient, err := providerHTTPClient(ctx, provider)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewClient(ollamaURL, httpClient), nil
+}
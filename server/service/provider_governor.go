@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Redis keys of the governor of a provider: the pause asked by its Retry-After, the calls in flight,
+	// and the buckets of requests and tokens of the minute
+	PROVIDER_GOVERNOR_PAUSE_KEY    = "provider_governor:%d:pause"
+	PROVIDER_GOVERNOR_SLOTS_KEY    = "provider_governor:%d:slots"
+	PROVIDER_GOVERNOR_REQUESTS_KEY = "provider_governor:%d:requests"
+	PROVIDER_GOVERNOR_TOKENS_KEY   = "provider_governor:%d:tokens"
+
+	// A call holding a concurrency slot longer is presumed lost with its replica, and the slot freed
+	PROVIDER_GOVERNOR_SLOT_LEASE = 10 * time.Minute
+	// Wait before asking again for a slot when all are taken
+	PROVIDER_GOVERNOR_POLL_INTERVAL = 200 * time.Millisecond
+	// Longest pause a Retry-After header imposes on every caller of the provider
+	PROVIDER_GOVERNOR_MAX_PAUSE = time.Minute
+	// Pause after a 429 that carries no Retry-After
+	PROVIDER_GOVERNOR_DEFAULT_PAUSE = time.Second
+)
+
+// governorAcquireScript takes a concurrency slot, a request and the tokens of a call from the provider's budget,
+// all or nothing. Buckets refill continuously and hold a minute of budget at most.
+//
+//	KEYS[1] pause, KEYS[2] sorted set of the slots scored by lease end, KEYS[3] request bucket, KEYS[4] token bucket
+//	ARGV[1] max concurrency, ARGV[2] requests per minute, ARGV[3] tokens per minute, ARGV[4] tokens of the call,
+//	ARGV[5] slot member, ARGV[6] slot lease in milliseconds
+//
+// It returns 0 when the call may start, the milliseconds to wait before asking again, or -1 when waiting for a slot.
+var governorAcquireScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local pause = redis.call('PTTL', KEYS[1])
+if pause > 0 then
+	return pause
+end
+
+local maxConcurrency = tonumber(ARGV[1])
+if maxConcurrency > 0 then
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', now)
+	if redis.call('ZCARD', KEYS[2]) >= maxConcurrency then
+		return -1
+	end
+end
+
+local function available(key, perMinute)
+	local bucket = redis.call('HMGET', key, 'level', 'at')
+	local level = tonumber(bucket[1])
+	local at = tonumber(bucket[2])
+	if level == nil or at == nil then
+		return perMinute
+	end
+	return math.min(perMinute, level + (now - at) * perMinute / 60000)
+end
+
+local function save(key, level)
+	redis.call('HSET', key, 'level', tostring(level), 'at', now)
+	redis.call('PEXPIRE', key, 60000)
+end
+
+local rpm = tonumber(ARGV[2])
+local tpm = tonumber(ARGV[3])
+local wait = 0
+local requests = 0
+local tokens = 0
+local cost = 0
+if rpm > 0 then
+	requests = available(KEYS[3], rpm)
+	if requests < 1 then
+		wait = math.max(wait, math.ceil((1 - requests) * 60000 / rpm))
+	end
+end
+if tpm > 0 then
+	-- A call larger than the budget of a minute waits for a full bucket
+	cost = math.min(tonumber(ARGV[4]), tpm)
+	tokens = available(KEYS[4], tpm)
+	if tokens < cost then
+		wait = math.max(wait, math.ceil((cost - tokens) * 60000 / tpm))
+	end
+end
+if wait > 0 then
+	return wait
+end
+
+if rpm > 0 then
+	save(KEYS[3], requests - 1)
+end
+if tpm > 0 then
+	save(KEYS[4], tokens - cost)
+end
+if maxConcurrency > 0 then
+	redis.call('ZADD', KEYS[2], now + tonumber(ARGV[6]), ARGV[5])
+	redis.call('PEXPIRE', KEYS[2], tonumber(ARGV[6]))
+end
+return 0
+`)
+
+// governorPauseScript pauses the calls to a provider, never shortening a longer pause already asked for.
+//
+//	KEYS[1] pause, ARGV[1] pause in milliseconds
+var governorPauseScript = redis.NewScript(`
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[1]) then
+	redis.call('SET', KEYS[1], '1', 'PX', ARGV[1])
+end
+return 0
+`)
+
+// governorTransport makes every attempt of a call to a provider wait for room in the provider's limits,
+// which are shared through Redis by all replicas so a bulk upload cannot flood the provider.
+// A 429 or 503 pauses all callers of the provider for the time its Retry-After asks.
+type governorTransport struct {
+	next     http.RoundTripper
+	provider *models.Provider
+}
+
+func NewGovernorTransport(next http.RoundTripper, provider *models.Provider) http.RoundTripper {
+	return &governorTransport{next: next, provider: provider}
+}
+
+func (this *governorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := this.acquire(req.Context(), max(req.ContentLength, 0)/4)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := this.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		pause, ok := RetryAfterDelay(resp)
+		if !ok && resp.StatusCode == http.StatusTooManyRequests {
+			pause, ok = PROVIDER_GOVERNOR_DEFAULT_PAUSE, true
+		}
+		if ok {
+			this.pause(req.Context(), min(pause, PROVIDER_GOVERNOR_MAX_PAUSE))
+		}
+	}
+	// Streamed answers hold their slot until they are read
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// acquire waits until the call fits in the provider's limits and returns the function giving its slot back.
+// Calls go through unchecked while Redis is unreachable.
+func (this *governorTransport) acquire(ctx context.Context, tokens int64) (release func(), err error) {
+	release = func() {}
+	if db.RedisClient == nil {
+		return release, nil
+	}
+	providerID := this.provider.ID
+	slot := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Uint64())
+	for {
+		wait, err := TryAcquireProviderBudget(ctx, this.provider, tokens, slot)
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return nil, ctx.Err()
+		case err != nil:
+			utils.Logger.Warnf("Governor of provider %d unavailable, calling without limits: %v", providerID, err)
+			return release, nil
+		case wait == 0:
+			if this.provider.MaxConcurrency > 0 {
+				release = func() {
+					key := fmt.Sprintf(PROVIDER_GOVERNOR_SLOTS_KEY, providerID)
+					if err := db.RedisClient.ZRem(context.WithoutCancel(ctx), key, slot).Err(); err != nil {
+						utils.Logger.Warnf("Failed to release slot of provider %d: %v", providerID, err)
+					}
+				}
+			}
+			return release, nil
+		}
+
+		delay := PROVIDER_GOVERNOR_POLL_INTERVAL
+		if wait > 0 {
+			delay = time.Duration(wait) * time.Millisecond
+		}
+		// Spread the waiting callers so they do not all come back at once
+		if err := sleepContext(ctx, delay+rand.N(delay/4+1)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// TryAcquireProviderBudget asks once for room in the provider's limits for a call of the given tokens, holding the slot
+// under the given member. It returns 0 when the call may start, the milliseconds to wait, or -1 when waiting for a slot.
+func TryAcquireProviderBudget(ctx context.Context, provider *models.Provider, tokens int64, slot string) (int64, error) {
+	keys := []string{
+		fmt.Sprintf(PROVIDER_GOVERNOR_PAUSE_KEY, provider.ID),
+		fmt.Sprintf(PROVIDER_GOVERNOR_SLOTS_KEY, provider.ID),
+		fmt.Sprintf(PROVIDER_GOVERNOR_REQUESTS_KEY, provider.ID),
+		fmt.Sprintf(PROVIDER_GOVERNOR_TOKENS_KEY, provider.ID),
+	}
+	return governorAcquireScript.Run(ctx, db.RedisClient, keys,
+		provider.MaxConcurrency, provider.RequestsPerMinute, provider.TokensPerMinute, tokens,
+		slot, PROVIDER_GOVERNOR_SLOT_LEASE.Milliseconds(),
+	).Int64()
+}
+
+func (this *governorTransport) pause(ctx context.Context, pause time.Duration) {
+	if db.RedisClient == nil || pause <= 0 {
+		return
+	}
+	key := fmt.Sprintf(PROVIDER_GOVERNOR_PAUSE_KEY, this.provider.ID)
+	if err := governorPauseScript.Run(context.WithoutCancel(ctx), db.RedisClient, []string{key}, pause.Milliseconds()).Err(); err != nil {
+		utils.Logger.Warnf("Failed to pause provider %d: %v", this.provider.ID, err)
+	}
+}
+
+// releasingBody gives the concurrency slot back once the response is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (this *releasingBody) Close() error {
+	err := this.ReadCloser.Close()
+	this.once.Do(this.release)
+	return err
+}
+
+// RetryAfterDelay reads how long a provider asks to wait, from retry-after-ms (OpenAI, Azure) or Retry-After in seconds or as a date
+func RetryAfterDelay(resp *http.Response) (time.Duration, bool) {
+	if ms, err := strconv.ParseFloat(strings.TrimSpace(resp.Header.Get("Retry-After-Ms")), 64); err == nil && ms >= 0 {
+		return time.Duration(ms * float64(time.Millisecond)), true
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"regexp"
+	"server/models"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go/v3"
+	"google.golang.org/genai"
+)
+
+const (
+	// Time limit of each probe call
+	PROVIDER_PROBE_TIMEOUT = 30 * time.Second
+	PROVIDER_PROBE_TEXT    = "ping"
+	// Enough output for reasoning models to answer at all
+	PROVIDER_PROBE_MAX_TOKENS = 16
+)
+
+// TestProvider probes a provider: it lists the models, then embeds and completes a tiny text.
+// With a zero providerID, the given unsaved settings are tested instead of a saved provider.
+func (this *ProviderService) TestProvider(ctx context.Context, ownerID uint, req models.ProviderTestReq) (*models.ProviderTestResp, error) {
+	var provider *models.Provider
+	if req.ID != 0 {
+		saved, err := this.GetProviderRawByID(ctx, req.ID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		// Shared providers are tested by the administrators owning them, probes would reach any model
+		if saved.OwnerID != ownerID {
+			return nil, ErrNotFound
+		}
+		provider = saved
+	} else {
+		// Settings not reviewed by anyone must not reach the server's own network
+		if err := checkProviderAddresses(ctx, req.BaseURL, req.ProxyURL); err != nil {
+			return nil, err
+		}
+		// Unsaved settings never reach the secrets backend
+		unsaved, err := newProviderRecord(ctx, &DatabaseSecretStore{}, req.BaseURL, req.APIKey, req.Mode, req.ProviderSettings)
+		if err != nil {
+			return nil, err
+		}
+		unsaved.OwnerID = ownerID
+		provider = unsaved
+	}
+
+	ctx = WithUsageScope(ctx, ownerID, 0)
+	listResult, listedModels := this.probeListModels(ctx, provider)
+	results := []models.ProviderCapabilityResult{
+		listResult,
+		this.probeEmbedding(ctx, provider, pickProbeModel(req.EmbeddingModel, listedModels, models.MODEL_TYPE_EMBEDDING)),
+		this.probeChat(ctx, provider, pickProbeModel(req.ChatModel, listedModels, models.MODEL_TYPE_CHAT)),
+	}
+
+	resp := &models.ProviderTestResp{Success: true, Results: results}
+	for _, result := range results {
+		if result.Status == models.PROVIDER_TEST_STATUS_FAILED {
+			resp.Success = false
+		}
+	}
+	return resp, nil
+}
+
+func (this *ProviderService) probeListModels(ctx context.Context, provider *models.Provider) (models.ProviderCapabilityResult, []models.ModelInfo) {
+	result := models.ProviderCapabilityResult{Capability: models.PROVIDER_CAPABILITY_LIST_MODELS}
+	ctx, cancel := context.WithTimeout(ctx, PROVIDER_PROBE_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := this.ListProviderModels(ctx, provider)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		setProbeError(&result, err)
+		return result, nil
+	}
+	result.Status = models.PROVIDER_TEST_STATUS_OK
+	result.ModelCount = len(resp.Models)
+	return result, resp.Models
+}
+
+func (this *ProviderService) probeEmbedding(ctx context.Context, provider *models.Provider, model string) models.ProviderCapabilityResult {
+	result := models.ProviderCapabilityResult{Capability: models.PROVIDER_CAPABILITY_EMBEDDING, Model: model}
+	if provider.Mode == models.PROVIDER_MODE_ANTHROPIC {
+		result.Status = models.PROVIDER_TEST_STATUS_SKIPPED
+		result.Error = "Anthropic provides no embedding API"
+		return result
+	}
+	if model == "" {
+		result.Status = models.PROVIDER_TEST_STATUS_SKIPPED
+		result.Error = "No embedding model given or listed"
+		return result
+	}
+	ctx, cancel := context.WithTimeout(ctx, PROVIDER_PROBE_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	embeddings, err := this.EmbedTexts(ctx, provider, model, []string{PROVIDER_PROBE_TEXT})
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err == nil && (len(embeddings) == 0 || len(embeddings[0]) == 0) {
+		err = ErrEmptyEmbedding
+	}
+	if err != nil {
+		setProbeError(&result, err)
+		return result
+	}
+	result.Status = models.PROVIDER_TEST_STATUS_OK
+	result.Dimension = len(embeddings[0])
+	return result
+}
+
+func (this *ProviderService) probeChat(ctx context.Context, provider *models.Provider, model string) models.ProviderCapabilityResult {
+	result := models.ProviderCapabilityResult{Capability: models.PROVIDER_CAPABILITY_CHAT, Model: model}
+	if model == "" {
+		result.Status = models.PROVIDER_TEST_STATUS_SKIPPED
+		result.Error = "No chat model given or listed"
+		return result
+	}
+	ctx, cancel := context.WithTimeout(ctx, PROVIDER_PROBE_TIMEOUT)
+	defer cancel()
+
+	start := time.Now()
+	_, err := this.StreamChatCompletion(ctx, provider, model,
+		[]models.LLMMessage{{Role: models.LLM_ROLE_USER, Content: PROVIDER_PROBE_TEXT}},
+		models.LLMParams{MaxTokens: PROVIDER_PROBE_MAX_TOKENS},
+		func(string) error { return nil })
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		setProbeError(&result, err)
+		return result
+	}
+	result.Status = models.PROVIDER_TEST_STATUS_OK
+	return result
+}
+
+func setProbeError(result *models.ProviderCapabilityResult, err error) {
+	result.Status = models.PROVIDER_TEST_STATUS_FAILED
+	result.ErrorType = ClassifyProviderError(err)
+	result.Error = err.Error()
+}
+
+// pickProbeModel returns the requested model, or the first listed model of the type
+func pickProbeModel(requested string, listed []models.ModelInfo, modelType string) string {
+	if requested != "" {
+		return requested
+	}
+	for i := range listed {
+		if HasModelType(&listed[i], modelType) {
+			return listed[i].ID
+		}
+	}
+	return ""
+}
+
+// ClassifyProviderError maps an error of a provider SDK call to a normalized cause (PROVIDER_ERROR_*).
+// Errors that only reach the gateway as text, like those relayed by the RAG backend, are classified by their message.
+func ClassifyProviderError(err error) string {
+	if status := providerErrorStatus(err); status != 0 {
+		if class := classifyProviderStatus(status); class != "" {
+			return class
+		}
+	}
+
+	var dnsErr *net.DNSError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateErr x509.CertificateInvalidError
+	var tlsRecordErr tls.RecordHeaderError
+	var tlsAlert tls.AlertError
+	var tlsVerifyErr *tls.CertificateVerificationError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &dnsErr):
+		return models.PROVIDER_ERROR_DNS
+	case errors.As(err, &unknownAuthorityErr), errors.As(err, &hostnameErr), errors.As(err, &certificateErr),
+		errors.As(err, &tlsRecordErr), errors.As(err, &tlsAlert), errors.As(err, &tlsVerifyErr):
+		return models.PROVIDER_ERROR_TLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return models.PROVIDER_ERROR_TIMEOUT
+	case errors.As(err, &opErr):
+		return models.PROVIDER_ERROR_CONNECTION
+	}
+	return classifyProviderErrorMessage(err.Error())
+}
+
+func classifyProviderStatus(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return models.PROVIDER_ERROR_AUTH
+	case status == 404:
+		return models.PROVIDER_ERROR_NOT_FOUND
+	case status == 408:
+		return models.PROVIDER_ERROR_TIMEOUT
+	case status == 429:
+		return models.PROVIDER_ERROR_RATE_LIMIT
+	case status >= 500:
+		return models.PROVIDER_ERROR_SERVER
+	case status >= 400:
+		return models.PROVIDER_ERROR_BAD_REQUEST
+	}
+	return ""
+}
+
+// Status code quoted in an error message, like "Error code: 429" of the Python SDKs or "status 503"
+var providerErrorStatusPattern = regexp.MustCompile(`(?i)\b(?:error code|status code|status)[:= ]+(\d{3})\b`)
+
+// classifyProviderErrorMessage guesses the cause of an error from its text
+func classifyProviderErrorMessage(message string) string {
+	if match := providerErrorStatusPattern.FindStringSubmatch(message); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		if class := classifyProviderStatus(status); class != "" {
+			return class
+		}
+	}
+	message = strings.ToLower(message)
+	switch {
+	case strings.Contains(message, "rate limit"), strings.Contains(message, "too many requests"):
+		return models.PROVIDER_ERROR_RATE_LIMIT
+	case strings.Contains(message, "timed out"), strings.Contains(message, "timeout"):
+		return models.PROVIDER_ERROR_TIMEOUT
+	case strings.Contains(message, "connection refused"), strings.Contains(message, "connection reset"),
+		strings.Contains(message, "connection error"):
+		return models.PROVIDER_ERROR_CONNECTION
+	case strings.Contains(message, "internal server error"), strings.Contains(message, "bad gateway"),
+		strings.Contains(message, "service unavailable"), strings.Contains(message, "overloaded"):
+		return models.PROVIDER_ERROR_SERVER
+	}
+	return models.PROVIDER_ERROR_UNKNOWN
+}
+
+// providerErrorStatus extracts the HTTP status of an API error returned by one of the provider SDKs
+func providerErrorStatus(err error) int {
+	var openaiErr *openai.Error
+	var anthropicErr *anthropic.Error
+	var geminiErr genai.APIError
+	var geminiPtrErr *genai.APIError
+	var ollamaErr api.StatusError
+	var ollamaAuthErr api.AuthorizationError
+	switch {
+	case errors.As(err, &openaiErr):
+		return openaiErr.StatusCode
+	case errors.As(err, &anthropicErr):
+		return anthropicErr.StatusCode
+	case errors.As(err, &geminiErr):
+		return geminiErr.Code
+	case errors.As(err, &geminiPtrErr):
+		return geminiPtrErr.Code
+	case errors.As(err, &ollamaErr):
+		return ollamaErr.StatusCode
+	case errors.As(err, &ollamaAuthErr):
+		return ollamaAuthErr.StatusCode
+	}
+	return 0
+}
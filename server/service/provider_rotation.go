@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"server/db"
+	"server/models"
+	"server/utils"
+)
+
+// Providers loaded at once when re-encrypting their secrets
+const PROVIDER_ROTATION_BATCH_SIZE = 100
+
+// RotateProviderSecrets encrypts again under the primary key of ENCRYPTION_KEYS every provider API key, headers and proxy URL
+// kept in the database, deleted providers included, that another key or the legacy key encrypted.
+// Secrets of other backends are left alone.
+// It may run while the API serves: every configured key still decrypts, and a provider updated meanwhile
+// is left as written by the update, which already used the primary key.
+func (this *ProviderService) RotateProviderSecrets(ctx context.Context) (rotated int, err error) {
+	primaryID, err := utils.CheckEncryptionKeys()
+	if err != nil {
+		return 0, err
+	}
+	var lastID uint
+	for {
+		var providers []models.Provider
+		if err := db.PgSqlDB.WithContext(ctx).Unscoped().
+			Select("id", "api_key", "headers", "proxy_url").
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(PROVIDER_ROTATION_BATCH_SIZE).
+			Find(&providers).Error; err != nil {
+			return rotated, err
+		}
+		if len(providers) == 0 {
+			return rotated, nil
+		}
+		lastID = providers[len(providers)-1].ID
+
+		for _, provider := range providers {
+			if !needsRotation(provider.APIKey, primaryID) && !needsRotation(provider.Headers, primaryID) && !needsRotation(provider.ProxyURL, primaryID) {
+				continue
+			}
+			apiKey, err := rotateSecretRef(provider.APIKey, primaryID)
+			if err != nil {
+				return rotated, fmt.Errorf("failed to re-encrypt API key of provider %d: %w", provider.ID, err)
+			}
+			headers, err := rotateSecretRef(provider.Headers, primaryID)
+			if err != nil {
+				return rotated, fmt.Errorf("failed to re-encrypt headers of provider %d: %w", provider.ID, err)
+			}
+			proxyURL, err := rotateSecretRef(provider.ProxyURL, primaryID)
+			if err != nil {
+				return rotated, fmt.Errorf("failed to re-encrypt proxy URL of provider %d: %w", provider.ID, err)
+			}
+			// Only written when unchanged since read, a concurrent update keeps its own values
+			result := db.PgSqlDB.WithContext(ctx).Unscoped().Model(&models.Provider{}).
+				Where("id = ? AND api_key = ? AND COALESCE(headers, '') = ? AND COALESCE(proxy_url, '') = ?",
+					provider.ID, provider.APIKey, provider.Headers, provider.ProxyURL).
+				UpdateColumns(map[string]any{"api_key": apiKey, "headers": headers, "proxy_url": proxyURL})
+			if result.Error != nil {
+				return rotated, result.Error
+			}
+			rotated += int(result.RowsAffected)
+		}
+	}
+}
+
+// needsRotation tells whether a secret reference is a database ciphertext of another key than the primary one
+func needsRotation(ref string, primaryID string) bool {
+	return ref != "" && secretBackendOf(ref) == SECRETS_BACKEND_DATABASE && utils.EncryptionKeyID(ref) != primaryID
+}
+
+func rotateSecretRef(ref string, primaryID string) (string, error) {
+	if !needsRotation(ref, primaryID) {
+		return ref, nil
+	}
+	rotated, _, err := utils.ReencryptAPIKey(ref)
+	return rotated, err
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"server/db"
+	"server/models"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Providers a user may use: their own ones, and the shared ones granted to them or to every user
+const PROVIDER_ACCESS_CONDITION = `(owner_id = ? OR (shared AND id IN (SELECT provider_id FROM provider_grants WHERE user_id = ? OR user_id IS NULL)))`
+
+// CreateSharedProvider creates a provider owned by the administrator that users may use once granted.
+// Shared providers do not count against the administrator's provider quota.
+func (this *ProviderService) CreateSharedProvider(ctx context.Context, adminID uint, name string, baseURL string, apiKey string, mode string, settings models.ProviderSettings) (*models.ProviderInfo, error) {
+	store, err := defaultSecretStore()
+	if err != nil {
+		return nil, err
+	}
+	dbProvider, err := newProviderRecord(ctx, store, baseURL, apiKey, mode, settings)
+	if err != nil {
+		return nil, err
+	}
+	dbProvider.OwnerID = adminID
+	dbProvider.Name = name
+	dbProvider.Shared = true
+	if err := gorm.G[models.Provider](db.PgSqlDB).Create(ctx, dbProvider); err != nil {
+		deleteProviderSecrets(ctx, dbProvider)
+		return nil, err
+	}
+	return this.GetProviderByID(ctx, dbProvider.ID, adminID)
+}
+
+// ListSharedProviders returns every shared provider with its grants
+func (this *ProviderService) ListSharedProviders(ctx context.Context) (total int64, providers []models.SharedProviderInfo, err error) {
+	var infos []models.ProviderInfo
+	if err := db.PgSqlDB.WithContext(ctx).Model(&models.Provider{}).
+		Where("shared").
+		Order("id").
+		Find(&infos).Error; err != nil {
+		return 0, nil, err
+	}
+	grants, err := gorm.G[models.ProviderGrant](db.PgSqlDB).
+		Where("provider_id IN (SELECT id FROM providers WHERE shared AND deleted_at IS NULL)").
+		Order("id").
+		Find(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	grantsByProvider := map[uint][]models.ProviderGrantInfo{}
+	for _, grant := range grants {
+		grantsByProvider[grant.ProviderID] = append(grantsByProvider[grant.ProviderID], models.ProviderGrantInfo{
+			ID:        grant.ID,
+			UserID:    grant.UserID,
+			Models:    grant.Models,
+			CreatedAt: grant.CreatedAt,
+			UpdatedAt: grant.UpdatedAt,
+		})
+	}
+
+	providers = make([]models.SharedProviderInfo, 0, len(infos))
+	for _, info := range infos {
+		providerGrants := grantsByProvider[info.ID]
+		if providerGrants == nil {
+			providerGrants = []models.ProviderGrantInfo{}
+		}
+		providers = append(providers, models.SharedProviderInfo{ProviderInfo: info, Grants: providerGrants})
+	}
+	return int64(len(providers)), providers, nil
+}
+
+// GrantProvider lets a user, or every user when userID is zero, use a shared provider with the given models (all when empty)
+func (this *ProviderService) GrantProvider(ctx context.Context, providerID uint, userID uint, grantedModels []string) error {
+	return db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if _, err := gorm.G[models.Provider](tx).Where("id = ? AND shared", providerID).First(ctx); err != nil {
+			return err
+		}
+		var grantee *uint
+		if userID != 0 {
+			switch _, err := gorm.G[models.User](tx).Where("id = ?", userID).First(ctx); {
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				return ErrUserNotFound
+			case err != nil:
+				return err
+			}
+			grantee = &userID
+		}
+
+		// A second grant to the same grantee replaces the models of the first
+		conflict := clause.OnConflict{
+			Columns:     []clause.Column{{Name: "provider_id"}, {Name: "user_id"}},
+			TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "user_id IS NOT NULL"}}},
+			DoUpdates:   clause.AssignmentColumns([]string{"models", "updated_at"}),
+		}
+		if grantee == nil {
+			conflict.Columns = []clause.Column{{Name: "provider_id"}}
+			conflict.TargetWhere = clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "user_id IS NULL"}}}
+		}
+		return gorm.G[models.ProviderGrant](tx, conflict).
+			Create(ctx, &models.ProviderGrant{ProviderID: providerID, UserID: grantee, Models: grantedModels})
+	})
+}
+
+// RevokeProvider takes back the grant of a shared provider to a user, or to every user when userID is zero.
+// Datasets of the user keep referencing the provider but can no longer call it.
+func (this *ProviderService) RevokeProvider(ctx context.Context, providerID uint, userID uint) error {
+	var grantee *uint
+	if userID != 0 {
+		grantee = &userID
+	}
+	rows, err := gorm.G[models.ProviderGrant](db.PgSqlDB).
+		Scopes(grantOf(providerID, grantee)).
+		Delete(ctx)
+	if err == nil && rows == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func grantOf(providerID uint, userID *uint) func(*gorm.Statement) {
+	return func(stmt *gorm.Statement) {
+		stmt.Where("provider_id = ?", providerID)
+		if userID == nil {
+			stmt.Where("user_id IS NULL")
+		} else {
+			stmt.Where("user_id = ?", *userID)
+		}
+	}
+}
+
+// grantedModels returns the models of the provider the user may use, nil meaning all of them.
+// ok is false when the provider is neither the user's nor granted to them.
+func (this *ProviderService) grantedModels(ctx context.Context, provider *models.Provider, userID uint) (grantedModels []string, ok bool, err error) {
+	var grants []models.ProviderGrant
+	if provider.Shared && provider.OwnerID != userID {
+		grants, err = gorm.G[models.ProviderGrant](db.PgSqlDB).
+			Where("provider_id = ? AND (user_id = ? OR user_id IS NULL)", provider.ID, userID).
+			Find(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	grantedModels, ok = GrantedModels(provider, userID, grants)
+	return grantedModels, ok, nil
+}
+
+// GrantedModels returns the models of the provider the grants let the user use, nil meaning all of them.
+// Grants to other users are ignored; ok is false when the provider is neither the user's nor granted to them.
+func GrantedModels(provider *models.Provider, userID uint, grants []models.ProviderGrant) (grantedModels []string, ok bool) {
+	if provider.OwnerID == userID {
+		return nil, true
+	}
+	if !provider.Shared {
+		return nil, false
+	}
+	for _, grant := range grants {
+		if grant.ProviderID != provider.ID || (grant.UserID != nil && *grant.UserID != userID) {
+			continue
+		}
+		if len(grant.Models) == 0 {
+			return nil, true
+		}
+		grantedModels = append(grantedModels, grant.Models...)
+		ok = true
+	}
+	return grantedModels, ok
+}
+
+// AuthorizeProviderModel verifies that the user may call the model of the provider
+func (this *ProviderService) AuthorizeProviderModel(ctx context.Context, provider *models.Provider, userID uint, model string) error {
+	grantedModels, ok, err := this.grantedModels(ctx, provider, userID)
+	if err != nil {
+		return err
+	}
+	return CheckGrantedModel(provider, grantedModels, ok, model)
+}
+
+// CheckGrantedModel tells whether model is among the granted models of the provider as returned by GrantedModels
+func CheckGrantedModel(provider *models.Provider, grantedModels []string, ok bool, model string) error {
+	switch {
+	case !ok && provider.Shared:
+		// The grant was revoked after the provider was chosen
+		return ErrModelNotGranted
+	case !ok:
+		return ErrNotFound
+	case grantedModels != nil && !slices.Contains(grantedModels, model):
+		return ErrModelNotGranted
+	}
+	return nil
+}
+
+// CheckProviderModel verifies that the provider belongs or is granted to the user, with the model when it is not empty
+func (this *ProviderService) CheckProviderModel(ctx context.Context, providerID uint, userID uint, model string) (allowed bool, err error) {
+	provider, err := this.GetProviderRawByID(ctx, providerID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	grantedModels, ok, err := this.grantedModels(ctx, provider, userID)
+	if err != nil || !ok {
+		return false, err
+	}
+	return model == "" || grantedModels == nil || slices.Contains(grantedModels, model), nil
+}
+
+// describeProviderAccess hides the connection settings of the shared providers granted to the user
+// and lists the models they may use
+func (this *ProviderService) describeProviderAccess(ctx context.Context, userID uint, infos []models.ProviderInfo) error {
+	for i := range infos {
+		info := &infos[i]
+		if !info.Shared || info.OwnerID == userID {
+			continue
+		}
+		provider := &models.Provider{Model: gorm.Model{ID: info.ID}, Shared: true, OwnerID: info.OwnerID}
+		grantedModels, _, err := this.grantedModels(ctx, provider, userID)
+		if err != nil {
+			return err
+		}
+		info.Models = grantedModels
+		info.BaseURL, info.ProxyURL, info.CACert = "", "", ""
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"server/db"
+	"server/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var QuotaServiceApp = new(QuotaService)
+
+type QuotaService struct{}
+
+// CreatePlan creates a quota plan. A new default plan replaces the previous one.
+func (this *QuotaService) CreatePlan(ctx context.Context, req models.QuotaPlanReq) (*models.QuotaPlanInfo, error) {
+	plan := newQuotaPlan(req)
+	err := db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := clearDefaultPlan(tx, req.IsDefault, 0); err != nil {
+			return err
+		}
+		return gorm.G[models.QuotaPlan](tx).Create(ctx, plan)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toQuotaPlanInfo(plan), nil
+}
+
+// UpdatePlan changes the limits of a plan, which apply to its users from their next request
+func (this *QuotaService) UpdatePlan(ctx context.Context, planID uint, req models.QuotaPlanReq) error {
+	plan := newQuotaPlan(req)
+	return db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := clearDefaultPlan(tx, req.IsDefault, planID); err != nil {
+			return err
+		}
+		rowsAffected, err := gorm.G[models.QuotaPlan](tx).
+			Where("id = ?", planID).
+			Select("name", "max_storage_bytes", "max_files_per_dataset", "max_datasets", "max_providers", "monthly_tokens", "is_default").
+			Updates(ctx, *plan)
+		// id not found
+		if rowsAffected == 0 && err == nil {
+			return ErrNotFound
+		}
+		return err
+	})
+}
+
+// DeletePlan deletes a plan for good, its users fall back to the default plan
+func (this *QuotaService) DeletePlan(ctx context.Context, planID uint) error {
+	rowsAffected, err := gorm.G[models.QuotaPlan](db.PgSqlDB.Unscoped()).
+		Where("id = ?", planID).
+		Delete(ctx)
+	// id not found
+	if rowsAffected == 0 && err == nil {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (this *QuotaService) ListPlans(ctx context.Context) (total int64, plans []models.QuotaPlanInfo, err error) {
+	result := db.PgSqlDB.WithContext(ctx).
+		Model(&models.QuotaPlan{}).
+		Order("id").
+		Find(&plans)
+	return result.RowsAffected, plans, result.Error
+}
+
+// AssignPlan puts a user on a plan, or back on the default plan with a zero planID
+func (this *QuotaService) AssignPlan(ctx context.Context, userID uint, planID uint) error {
+	var quotaPlanID *uint
+	if planID != 0 {
+		switch _, err := gorm.G[models.QuotaPlan](db.PgSqlDB).Where("id = ?", planID).First(ctx); {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return ErrQuotaPlanNotFound
+		case err != nil:
+			return err
+		}
+		quotaPlanID = &planID
+	}
+	result := db.PgSqlDB.WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", userID).
+		Update("quota_plan_id", quotaPlanID)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return result.Error
+}
+
+// GetUsage returns the consumption of a user against the limits of their plan
+func (this *QuotaService) GetUsage(ctx context.Context, userID uint) (*models.QuotaUsage, error) {
+	tx := db.PgSqlDB.WithContext(ctx)
+	user, err := gorm.G[models.User](tx).Where("id = ?", userID).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := userQuotaPlan(ctx, tx, &user)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &models.QuotaUsage{PeriodStart: quotaPeriodStart(), FilesPerDataset: []models.DatasetFileQuota{}}
+	if plan != nil {
+		usage.Plan = toQuotaPlanInfo(plan)
+		usage.StorageBytes.Limit = plan.MaxStorageBytes
+		usage.Datasets.Limit = int64(plan.MaxDatasets)
+		usage.Providers.Limit = int64(plan.MaxProviders)
+		usage.MonthlyTokens.Limit = plan.MonthlyTokens
+	}
+	if usage.StorageBytes.Used, err = storageUsed(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	if usage.Datasets.Used, err = gorm.G[models.Dataset](tx).Where("owner_id = ?", userID).Count(ctx, "*"); err != nil {
+		return nil, err
+	}
+	if usage.Providers.Used, err = gorm.G[models.Provider](tx).Where("owner_id = ? AND NOT shared", userID).Count(ctx, "*"); err != nil {
+		return nil, err
+	}
+	if usage.MonthlyTokens.Used, err = monthlyTokensUsed(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Raw(`SELECT d.id AS dataset_id, d.name AS dataset_name, COUNT(f.id) AS used
+		FROM datasets d
+		LEFT JOIN files f ON f.dataset_id = d.id AND f.deleted_at IS NULL
+		WHERE d.owner_id = ? AND d.deleted_at IS NULL
+		GROUP BY d.id, d.name
+		ORDER BY d.id`, userID).
+		Scan(&usage.FilesPerDataset).Error; err != nil {
+		return nil, err
+	}
+	if plan != nil {
+		for i := range usage.FilesPerDataset {
+			usage.FilesPerDataset[i].Limit = int64(plan.MaxFilesPerDataset)
+		}
+	}
+	return usage, nil
+}
+
+// WithinQuota runs fn in a transaction holding a lock on the user, with the user's plan (nil if unlimited).
+// Requests checking and consuming the same user's quota are serialized, so their checks cannot both pass.
+func (this *QuotaService) WithinQuota(ctx context.Context, userID uint, fn func(tx *gorm.DB, plan *models.QuotaPlan) error) error {
+	return db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		user, err := gorm.G[models.User](tx, clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			Where("id = ?", userID).
+			First(ctx)
+		if err != nil {
+			return err
+		}
+		plan, err := userQuotaPlan(ctx, tx, &user)
+		if err != nil {
+			return err
+		}
+		return fn(tx, plan)
+	})
+}
+
+// CheckFiles verifies that files of the given total size fit in the user's storage and in the dataset
+func (this *QuotaService) CheckFiles(ctx context.Context, tx *gorm.DB, plan *models.QuotaPlan, userID uint, datasetID uint, count int, size int64) error {
+	if plan == nil {
+		return nil
+	}
+	if plan.MaxStorageBytes > 0 {
+		used, err := storageUsed(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		if used+size > plan.MaxStorageBytes {
+			return fmt.Errorf("%w: uploading %d bytes would use %d of the %d bytes of storage allowed", ErrQuotaExceeded, size, used+size, plan.MaxStorageBytes)
+		}
+	}
+	if plan.MaxFilesPerDataset > 0 {
+		files, err := gorm.G[models.File](tx).Where("dataset_id = ?", datasetID).Count(ctx, "*")
+		if err != nil {
+			return err
+		}
+		if files+int64(count) > int64(plan.MaxFilesPerDataset) {
+			return fmt.Errorf("%w: the dataset would hold %d files, %d allowed", ErrQuotaExceeded, files+int64(count), plan.MaxFilesPerDataset)
+		}
+	}
+	return nil
+}
+
+// CheckDataset verifies that the user can create one more dataset
+func (this *QuotaService) CheckDataset(ctx context.Context, tx *gorm.DB, plan *models.QuotaPlan, userID uint) error {
+	if plan == nil || plan.MaxDatasets == 0 {
+		return nil
+	}
+	datasets, err := gorm.G[models.Dataset](tx).Where("owner_id = ?", userID).Count(ctx, "*")
+	if err != nil {
+		return err
+	}
+	if datasets >= int64(plan.MaxDatasets) {
+		return fmt.Errorf("%w: %d datasets allowed", ErrQuotaExceeded, plan.MaxDatasets)
+	}
+	return nil
+}
+
+// CheckProvider verifies that the user can create one more provider
+func (this *QuotaService) CheckProvider(ctx context.Context, tx *gorm.DB, plan *models.QuotaPlan, userID uint) error {
+	if plan == nil || plan.MaxProviders == 0 {
+		return nil
+	}
+	providers, err := gorm.G[models.Provider](tx).Where("owner_id = ? AND NOT shared", userID).Count(ctx, "*")
+	if err != nil {
+		return err
+	}
+	if providers >= int64(plan.MaxProviders) {
+		return fmt.Errorf("%w: %d providers allowed", ErrQuotaExceeded, plan.MaxProviders)
+	}
+	return nil
+}
+
+// CheckTokenBudget verifies that the user has tokens left this month, session chat included since the tokens
+// reported by the RAG backend go to the ledger. The ledger is written asynchronously,
+// so requests made while the budget runs out may overshoot it slightly.
+func (this *QuotaService) CheckTokenBudget(ctx context.Context, userID uint) error {
+	tx := db.PgSqlDB.WithContext(ctx)
+	user, err := gorm.G[models.User](tx).Where("id = ?", userID).First(ctx)
+	if err != nil {
+		return err
+	}
+	plan, err := userQuotaPlan(ctx, tx, &user)
+	if err != nil || plan == nil || plan.MonthlyTokens == 0 {
+		return err
+	}
+	used, err := monthlyTokensUsed(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	return CheckTokensLeft(plan, used)
+}
+
+// CheckTokensLeft fails with ErrQuotaExceeded when the tokens used this month reach the plan's monthly tokens,
+// a nil plan or a zero limit never does
+func CheckTokensLeft(plan *models.QuotaPlan, used int64) error {
+	if plan == nil || plan.MonthlyTokens == 0 || used < plan.MonthlyTokens {
+		return nil
+	}
+	return fmt.Errorf("%w: the %d tokens of this month are used up", ErrQuotaExceeded, plan.MonthlyTokens)
+}
+
+// userQuotaPlan returns the plan of the user, else the default plan, else nil for unlimited
+func userQuotaPlan(ctx context.Context, tx *gorm.DB, user *models.User) (*models.QuotaPlan, error) {
+	query := gorm.G[models.QuotaPlan](tx).Where("is_default = ?", true)
+	if user.QuotaPlanID != nil {
+		query = gorm.G[models.QuotaPlan](tx).Where("id = ? OR is_default = ?", *user.QuotaPlanID, true)
+	}
+	plans, err := query.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return ResolveQuotaPlan(user, plans), nil
+}
+
+// ResolveQuotaPlan picks the plan of the user among plans, else the default plan, else nil for unlimited
+func ResolveQuotaPlan(user *models.User, plans []models.QuotaPlan) *models.QuotaPlan {
+	var defaultPlan *models.QuotaPlan
+	for i := range plans {
+		if user.QuotaPlanID != nil && plans[i].ID == *user.QuotaPlanID {
+			return &plans[i]
+		}
+		if plans[i].IsDefault && defaultPlan == nil {
+			defaultPlan = &plans[i]
+		}
+	}
+	return defaultPlan
+}
+
+// clearDefaultPlan unsets the default flag of other plans when a plan becomes the default
+func clearDefaultPlan(tx *gorm.DB, isDefault bool, planID uint) error {
+	if !isDefault {
+		return nil
+	}
+	return tx.Model(&models.QuotaPlan{}).
+		Where("is_default = ? AND id <> ?", true, planID).
+		Update("is_default", false).Error
+}
+
+func storageUsed(ctx context.Context, tx *gorm.DB, userID uint) (int64, error) {
+	var used int64
+	err := tx.WithContext(ctx).Model(&models.File{}).
+		Select("COALESCE(SUM(size), 0)").
+		Where("user_id = ?", userID).
+		Scan(&used).Error
+	return used, err
+}
+
+func monthlyTokensUsed(ctx context.Context, tx *gorm.DB, userID uint) (int64, error) {
+	var used int64
+	err := tx.WithContext(ctx).Model(&models.UsageRecord{}).
+		Select("COALESCE(SUM(input_tokens + output_tokens), 0)").
+		Where("user_id = ? AND created_at >= ?", userID, quotaPeriodStart()).
+		Scan(&used).Error
+	return used, err
+}
+
+// quotaPeriodStart returns the start of the current calendar month in UTC
+func quotaPeriodStart() time.Time {
+	now := time.Now().UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func newQuotaPlan(req models.QuotaPlanReq) *models.QuotaPlan {
+	return &models.QuotaPlan{
+		Name:               req.Name,
+		MaxStorageBytes:    req.MaxStorageBytes,
+		MaxFilesPerDataset: req.MaxFilesPerDataset,
+		MaxDatasets:        req.MaxDatasets,
+		MaxProviders:       req.MaxProviders,
+		MonthlyTokens:      req.MonthlyTokens,
+		IsDefault:          req.IsDefault,
+	}
+}
+
+func toQuotaPlanInfo(plan *models.QuotaPlan) *models.QuotaPlanInfo {
+	return &models.QuotaPlanInfo{
+		ID:                 plan.ID,
+		Name:               plan.Name,
+		MaxStorageBytes:    plan.MaxStorageBytes,
+		MaxFilesPerDataset: plan.MaxFilesPerDataset,
+		MaxDatasets:        plan.MaxDatasets,
+		MaxProviders:       plan.MaxProviders,
+		MonthlyTokens:      plan.MonthlyTokens,
+		IsDefault:          plan.IsDefault,
+		CreatedAt:          plan.CreatedAt,
+		UpdatedAt:          plan.UpdatedAt,
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"server/config"
+	"server/db"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Sorted set of the requests a client made to a route group in the current window
+const RATE_LIMIT_KEY = "rate_limit:%s:%s"
+
+// slidingWindowScript records a request in a sliding window log if the window has room for it.
+// The clock of Redis is used so that replicas with drifting clocks share the same window.
+//
+//	KEYS[1] sorted set of the allowed requests, scored by their time in microseconds
+//	ARGV[1] requests allowed per window, ARGV[2] window in microseconds, ARGV[3] unique member of the request
+//
+// It returns whether the request is allowed, the requests left, and the microseconds until the oldest request leaves the window.
+var slidingWindowScript = redis.NewScript(`
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+
+local reset = 0
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+var RateLimitServiceApp = new(RateLimitService)
+
+type RateLimitService struct{}
+
+// RateLimitResult is the state of a client's window after a request
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Time until the oldest request leaves the window, which frees a request
+	Reset time.Duration
+}
+
+// Allow counts a request of the client to the route group against the limit.
+// Only allowed requests take room in the window, so a client retrying too fast is not locked out longer.
+func (this *RateLimitService) Allow(ctx context.Context, group string, client string, limit config.RateLimit) (*RateLimitResult, error) {
+	if db.RedisClient == nil {
+		return nil, fmt.Errorf("Redis is not connected")
+	}
+	member := fmt.Sprintf("%d-%d", time.Now().UnixNano(), rand.Uint64())
+	values, err := slidingWindowScript.Run(ctx, db.RedisClient,
+		[]string{fmt.Sprintf(RATE_LIMIT_KEY, group, client)},
+		limit.Requests, limit.Window.Microseconds(), member,
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("unexpected rate limit script result: %v", values)
+	}
+	return &RateLimitResult{
+		Allowed:   values[0] == 1,
+		Limit:     limit.Requests,
+		Remaining: int(values[1]),
+		Reset:     time.Duration(values[2]) * time.Microsecond,
+	}, nil
+}
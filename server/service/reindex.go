@@ -0,0 +1,481 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ReindexServiceApp = new(ReindexService)
+
+// errChunksLeftToEmbed stops a switch when chunks were written after the last batch was embedded
+var errChunksLeftToEmbed = errors.New("chunks left to embed")
+
+type ReindexService struct{}
+
+const (
+	// Wait between two looks for running jobs no replica works on
+	REINDEX_POLL_INTERVAL = 5 * time.Second
+	// Chunks embedded and inserted into the new collection at once
+	REINDEX_BATCH_SIZE = 64
+	// A claimed job whose replica did not report back in this time is taken over by another replica
+	REINDEX_CLAIM_LEASE = 5 * time.Minute
+	// Text embedded once to detect the vector dimension of the new embedding model
+	REINDEX_DIMENSION_PROBE = "dimension probe"
+)
+
+// DatasetCollection returns the Milvus collection holding the vectors of a dataset
+func DatasetCollection(dataset *models.Dataset) string {
+	if dataset.CollectionName != "" {
+		return dataset.CollectionName
+	}
+	return config.Settings.MILVUS_COLLECTION_NAME
+}
+
+// EmbeddingChangeNeedsReindex reports whether switching a dataset to the given provider and embedding model
+// invalidates its stored vectors, which is the case when the embedding differs and the dataset already has chunks.
+// Zero values keep the current provider or model.
+func (this *ReindexService) EmbeddingChangeNeedsReindex(ctx context.Context, datasetID uint, ownerID uint, providerID uint, embeddingModel string) (bool, error) {
+	dataset, err := gorm.G[models.Dataset](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", datasetID, ownerID).
+		First(ctx)
+	if err != nil {
+		return false, err
+	}
+	if (providerID == 0 || providerID == dataset.ProviderID) && (embeddingModel == "" || embeddingModel == dataset.EmbeddingModel) {
+		return false, nil
+	}
+	// A keyword dataset without an embedding model has no vectors
+	if embeddingModel == "" && dataset.EmbeddingModel == "" {
+		return false, nil
+	}
+
+	cnt, err := gorm.G[models.Chunk](db.PgSqlDB).
+		Where("file_id IN (SELECT id FROM files WHERE dataset_id = ? AND deleted_at IS NULL)", datasetID).
+		Count(ctx, "*")
+	return cnt > 0, err
+}
+
+// StartReindexJob creates a collection for the new embedding model, in which the worker started by Start
+// re-embeds every chunk of the dataset. Searches keep using the current collection until all vectors are rebuilt.
+// Zero values keep the current provider or model.
+func (this *ReindexService) StartReindexJob(ctx context.Context, datasetID uint, ownerID uint, providerID uint, embeddingModel string) (*models.ReindexJobInfo, error) {
+	if db.MilvusClient == nil {
+		return nil, ErrVectorStoreUnavailable
+	}
+
+	dataset, err := gorm.G[models.Dataset](db.PgSqlDB).
+		Where("id = ? AND owner_id = ?", datasetID, ownerID).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Checked first so the embedding model is not probed in vain, the unique index settles concurrent starts
+	if running, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+		Where("dataset_id = ? AND status IN ?", datasetID, []string{models.REINDEX_STATUS_PENDING, models.REINDEX_STATUS_RUNNING}).
+		Count(ctx, "*"); err != nil {
+		return nil, err
+	} else if running > 0 {
+		return nil, ErrReindexJobRunning
+	}
+
+	if providerID == 0 {
+		providerID = dataset.ProviderID
+	}
+	if embeddingModel == "" {
+		embeddingModel = dataset.EmbeddingModel
+	}
+	provider, err := gorm.G[models.Provider](db.PgSqlDB).Where("id = ?", providerID).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The new model must work before any vector is dropped, and its dimension sizes the new collection
+	embeddings, err := ProviderServiceApp.EmbedTexts(WithUsageScope(ctx, ownerID, datasetID), &provider, embeddingModel, []string{REINDEX_DIMENSION_PROBE})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrEmbeddingProbeFailed, err)
+	}
+	if len(embeddings) == 0 || len(embeddings[0]) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrEmbeddingProbeFailed, ErrEmptyEmbedding)
+	}
+
+	job := models.ReindexJob{
+		DatasetID:        datasetID,
+		OwnerID:          ownerID,
+		Status:           models.REINDEX_STATUS_PENDING,
+		ProviderID:       providerID,
+		EmbeddingModel:   embeddingModel,
+		SourceCollection: DatasetCollection(&dataset),
+	}
+	if err := gorm.G[models.ReindexJob](db.PgSqlDB).Create(ctx, &job); errors.Is(err, ErrDuplicatedKey) {
+		return nil, ErrReindexJobRunning
+	} else if err != nil {
+		return nil, err
+	}
+	job.TargetCollection = fmt.Sprintf("%s_dataset_%d_v%d", config.Settings.MILVUS_COLLECTION_NAME, datasetID, job.ID)
+
+	// Leftovers of an earlier cancelled or failed job must not be mistaken for rebuilt vectors
+	if err := db.PgSqlDB.WithContext(ctx).
+		Model(&models.Chunk{}).
+		Where("pending_vector_id IS NOT NULL AND file_id IN (SELECT id FROM files WHERE dataset_id = ?)", datasetID).
+		Update("pending_vector_id", nil).Error; err != nil {
+		this.failReindexJob(ctx, &job, err)
+		return nil, err
+	}
+	if err := db.CreateVectorCollection(ctx, job.TargetCollection, len(embeddings[0])); err != nil {
+		this.failReindexJob(ctx, &job, err)
+		return nil, err
+	}
+
+	job.Status = models.REINDEX_STATUS_RUNNING
+	if _, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+		Where("id = ?", job.ID).
+		Updates(ctx, models.ReindexJob{Status: job.Status, TargetCollection: job.TargetCollection}); err != nil {
+		this.failReindexJob(ctx, &job, err)
+		return nil, err
+	}
+
+	utils.Logger.Infof("Reindex job %d started for dataset %d into collection %s", job.ID, datasetID, job.TargetCollection)
+	return toReindexJobInfo(&job), nil
+}
+
+// GetLatestReindexJob retrieves the most recent reindex job of a dataset
+func (this *ReindexService) GetLatestReindexJob(ctx context.Context, datasetID uint, ownerID uint) (*models.ReindexJobInfo, error) {
+	job, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+		Where("dataset_id = ? AND owner_id = ?", datasetID, ownerID).
+		Order("id DESC").
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return toReindexJobInfo(&job), nil
+}
+
+// CancelReindexJob stops the running reindex job of a dataset and discards the partially built collection.
+// The dataset keeps its current embedding model and vectors.
+func (this *ReindexService) CancelReindexJob(ctx context.Context, datasetID uint, ownerID uint) (*models.ReindexJobInfo, error) {
+	job, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+		Where("dataset_id = ? AND owner_id = ? AND status IN ?", datasetID, ownerID, []string{models.REINDEX_STATUS_PENDING, models.REINDEX_STATUS_RUNNING}).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The status guard loses against a concurrent switch, in which case the job already completed
+	rowsAffected, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+		Where("id = ? AND status = ?", job.ID, job.Status).
+		Updates(ctx, models.ReindexJob{Status: models.REINDEX_STATUS_CANCELLED})
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, ErrReindexJobNotRunning
+	}
+	job.Status = models.REINDEX_STATUS_CANCELLED
+	this.discardTargetCollection(ctx, &job)
+
+	utils.Logger.Infof("Reindex job %d of dataset %d cancelled", job.ID, datasetID)
+	return toReindexJobInfo(&job), nil
+}
+
+// Start runs the worker embedding the chunks of running reindex jobs until ctx is done.
+// Every replica may run it, a job is claimed with a lease so only one replica works on it at a time.
+func (this *ReindexService) Start(ctx context.Context) {
+	go this.run(ctx)
+}
+
+func (this *ReindexService) run(ctx context.Context) {
+	ticker := time.NewTicker(REINDEX_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			this.failStalePendingJobs(ctx)
+			jobs, err := this.claimDueJobs(ctx)
+			if err != nil {
+				utils.Logger.Errorf("Failed to claim reindex jobs: %v", err)
+				continue
+			}
+			for _, job := range jobs {
+				go this.runReindexJob(ctx, job)
+			}
+		}
+	}
+}
+
+// failStalePendingJobs fails the jobs whose replica stopped before their collection was created
+func (this *ReindexService) failStalePendingJobs(ctx context.Context) {
+	jobs, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+		Where("status = ? AND created_at < ?", models.REINDEX_STATUS_PENDING, time.Now().Add(-REINDEX_CLAIM_LEASE)).
+		Find(ctx)
+	if err != nil {
+		utils.Logger.Errorf("Failed to load pending reindex jobs: %v", err)
+		return
+	}
+	for i := range jobs {
+		this.failReindexJob(ctx, &jobs[i], errors.New("server stopped before the job could start"))
+	}
+}
+
+// claimDueJobs takes the running jobs no replica works on, or whose replica stopped reporting back,
+// and leases them so other replicas skip them
+func (this *ReindexService) claimDueJobs(ctx context.Context) ([]models.ReindexJob, error) {
+	return claimDue[models.ReindexJob](ctx, DueClaim{
+		Due:         "status = ? AND (lease_until IS NULL OR lease_until <= ?)",
+		Args:        []any{models.REINDEX_STATUS_RUNNING, time.Now()},
+		Order:       "id",
+		LeaseColumn: "lease_until",
+		Lease:       REINDEX_CLAIM_LEASE,
+	})
+}
+
+// runReindexJob embeds the chunks of a claimed job batch by batch, then switches the dataset once every chunk
+// has a vector in the new collection. Chunks written meanwhile by the ingestion worker are embedded too.
+// The lease is released on return, so a job left running is picked up again by the next poll.
+func (this *ReindexService) runReindexJob(ctx context.Context, job models.ReindexJob) {
+	defer func() {
+		if _, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+			Where("id = ?", job.ID).
+			Update(context.WithoutCancel(ctx), "lease_until", nil); err != nil {
+			utils.Logger.Errorf("Failed to release reindex job %d: %v", job.ID, err)
+		}
+	}()
+
+	provider, err := gorm.G[models.Provider](db.PgSqlDB).Where("id = ?", job.ProviderID).First(ctx)
+	if err != nil {
+		this.failReindexJob(ctx, &job, err)
+		return
+	}
+	dataset, err := gorm.G[models.Dataset](db.PgSqlDB).Where("id = ?", job.DatasetID).First(ctx)
+	if err != nil {
+		this.failReindexJob(ctx, &job, err)
+		return
+	}
+	// Dense datasets never search sparse vectors, chats over keyword datasets search hybrid ones
+	withSparse := dataset.SearchType != models.SEARCH_TYPE_DENSE
+	for ctx.Err() == nil {
+		embedded, err := this.embedPendingChunks(ctx, &job, &provider, withSparse)
+		switch {
+		case errors.Is(err, ErrReindexJobNotRunning) || ctx.Err() != nil:
+			return
+		case err != nil:
+			this.failReindexJob(ctx, &job, err)
+			return
+		}
+		if running, err := this.recordProgress(ctx, &job); err != nil || !running {
+			if err != nil {
+				utils.Logger.Errorf("Failed to record progress of reindex job %d: %v", job.ID, err)
+			}
+			return
+		}
+		if embedded > 0 {
+			continue
+		}
+
+		switch err := this.switchDatasetCollection(ctx, &job); {
+		case err == nil:
+			this.discardSourceVectors(ctx, &job)
+			utils.Logger.Infof("Reindex job %d completed, dataset %d now uses collection %s", job.ID, job.DatasetID, job.TargetCollection)
+		case errors.Is(err, ErrReindexJobNotRunning), errors.Is(err, errChunksLeftToEmbed):
+			// Cancelled, or chunks written while switching are embedded on the next poll
+		default:
+			this.failReindexJob(ctx, &job, err)
+		}
+		return
+	}
+}
+
+// embedPendingChunks embeds the next batch of the dataset's chunks that have no vector in the new collection yet,
+// inserts their vectors into it and records their IDs. It returns how many chunks were embedded.
+// Without withSparse the chunks get empty sparse vectors, as the ingestion worker gives them.
+func (this *ReindexService) embedPendingChunks(ctx context.Context, job *models.ReindexJob, provider *models.Provider, withSparse bool) (int, error) {
+	chunks, err := gorm.G[models.Chunk](db.PgSqlDB).
+		Where("pending_vector_id IS NULL AND file_id IN (SELECT id FROM files WHERE dataset_id = ? AND deleted_at IS NULL)", job.DatasetID).
+		Order("id").
+		Limit(REINDEX_BATCH_SIZE).
+		Find(ctx)
+	if err != nil || len(chunks) == 0 {
+		return 0, err
+	}
+
+	contents := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		contents[i] = chunk.Content
+	}
+	dense, err := ProviderServiceApp.EmbedTexts(WithUsageScope(ctx, job.OwnerID, job.DatasetID), provider, job.EmbeddingModel, contents)
+	if err != nil {
+		return 0, err
+	}
+	sparse := make([]map[uint32]float32, len(contents))
+	if withSparse {
+		if sparse, err = SearchServiceApp.EmbedSparseTexts(ctx, contents); err != nil {
+			return 0, err
+		}
+	}
+	vectorIDs, err := db.InsertVectors(ctx, job.TargetCollection, job.DatasetID, contents, dense, sparse)
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Holds off a cancellation until the IDs are recorded, so its cleanup sees them
+		if _, err := gorm.G[models.ReindexJob](tx, clause.Locking{Strength: clause.LockingStrengthShare}).
+			Where("id = ? AND status = ?", job.ID, models.REINDEX_STATUS_RUNNING).
+			First(ctx); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrReindexJobNotRunning
+			}
+			return err
+		}
+		for i, chunk := range chunks {
+			if _, err := gorm.G[models.Chunk](tx).
+				Where("id = ?", chunk.ID).
+				Update(ctx, "pending_vector_id", strconv.FormatInt(vectorIDs[i], 10)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return len(chunks), err
+}
+
+// recordProgress counts the files whose chunks all have a vector in the new collection and renews the lease.
+// It reports false once the job is no longer running.
+func (this *ReindexService) recordProgress(ctx context.Context, job *models.ReindexJob) (bool, error) {
+	total, err := gorm.G[models.File](db.PgSqlDB).
+		Where("dataset_id = ?", job.DatasetID).
+		Count(ctx, "*")
+	if err != nil {
+		return false, err
+	}
+	processed, err := gorm.G[models.File](db.PgSqlDB).
+		Where("dataset_id = ?", job.DatasetID).
+		Where("NOT EXISTS (SELECT 1 FROM chunks WHERE chunks.file_id = files.id AND chunks.deleted_at IS NULL AND chunks.pending_vector_id IS NULL)").
+		Count(ctx, "*")
+	if err != nil {
+		return false, err
+	}
+	job.TotalFiles, job.ProcessedFiles = int(total), int(processed)
+
+	leaseUntil := time.Now().Add(REINDEX_CLAIM_LEASE)
+	rowsAffected, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+		Where("id = ? AND status = ?", job.ID, models.REINDEX_STATUS_RUNNING).
+		Select("total_files", "processed_files", "lease_until").
+		Updates(ctx, models.ReindexJob{TotalFiles: job.TotalFiles, ProcessedFiles: job.ProcessedFiles, LeaseUntil: &leaseUntil})
+	return rowsAffected > 0, err
+}
+
+// switchDatasetCollection atomically points the dataset and its chunks to the rebuilt vectors
+func (this *ReindexService) switchDatasetCollection(ctx context.Context, job *models.ReindexJob) error {
+	return db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rowsAffected, err := gorm.G[models.ReindexJob](tx).
+			Where("id = ? AND status = ?", job.ID, models.REINDEX_STATUS_RUNNING).
+			Select("status", "total_files", "processed_files").
+			Updates(ctx, models.ReindexJob{
+				Status:         models.REINDEX_STATUS_COMPLETED,
+				TotalFiles:     job.TotalFiles,
+				ProcessedFiles: job.ProcessedFiles,
+			})
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrReindexJobNotRunning
+		}
+
+		left, err := gorm.G[models.Chunk](tx).
+			Where("pending_vector_id IS NULL AND file_id IN (SELECT id FROM files WHERE dataset_id = ? AND deleted_at IS NULL)", job.DatasetID).
+			Count(ctx, "*")
+		if err != nil {
+			return err
+		}
+		if left > 0 {
+			return errChunksLeftToEmbed
+		}
+		if err := tx.Exec(`UPDATE chunks SET vector_id = pending_vector_id, pending_vector_id = NULL
+			WHERE pending_vector_id IS NOT NULL AND file_id IN (SELECT id FROM files WHERE dataset_id = ?)`, job.DatasetID).Error; err != nil {
+			return err
+		}
+
+		// The embedding chain was checked against the dimension of the previous model
+		_, err = gorm.G[models.Dataset](tx).
+			Where("id = ?", job.DatasetID).
+			Select("provider_id", "embedding_model", "collection_name", "embedding_fallback").
+			Updates(ctx, models.Dataset{
+				ProviderID:     job.ProviderID,
+				EmbeddingModel: job.EmbeddingModel,
+				CollectionName: job.TargetCollection,
+			})
+		return err
+	})
+}
+
+// failReindexJob marks a pending or running job as failed and discards its collection.
+// A job cancelled or completed meanwhile is left alone.
+func (this *ReindexService) failReindexJob(ctx context.Context, job *models.ReindexJob, cause error) {
+	rowsAffected, err := gorm.G[models.ReindexJob](db.PgSqlDB).
+		Where("id = ? AND status IN ?", job.ID, []string{models.REINDEX_STATUS_PENDING, models.REINDEX_STATUS_RUNNING}).
+		Updates(context.WithoutCancel(ctx), models.ReindexJob{Status: models.REINDEX_STATUS_FAILED, Error: cause.Error()})
+	if err != nil {
+		utils.Logger.Errorf("Failed to mark reindex job %d as failed: %v", job.ID, err)
+	}
+	if rowsAffected == 0 {
+		return
+	}
+	utils.Logger.Errorf("Reindex job %d of dataset %d failed: %v", job.ID, job.DatasetID, cause)
+	this.discardTargetCollection(context.WithoutCancel(ctx), job)
+}
+
+// discardTargetCollection drops the collection of an unfinished job and forgets the vector IDs written into it
+func (this *ReindexService) discardTargetCollection(ctx context.Context, job *models.ReindexJob) {
+	if job.TargetCollection != "" && db.MilvusClient != nil {
+		if err := db.DropVectorCollection(ctx, job.TargetCollection); err != nil {
+			utils.Logger.Errorf("Failed to drop collection %s of reindex job %d: %v", job.TargetCollection, job.ID, err)
+		}
+	}
+	if err := db.PgSqlDB.WithContext(ctx).
+		Model(&models.Chunk{}).
+		Where("pending_vector_id IS NOT NULL AND file_id IN (SELECT id FROM files WHERE dataset_id = ?)", job.DatasetID).
+		Update("pending_vector_id", nil).Error; err != nil {
+		utils.Logger.Errorf("Failed to clear pending vectors of reindex job %d: %v", job.ID, err)
+	}
+}
+
+// discardSourceVectors removes the vectors the dataset no longer uses.
+// The shared default collection only loses the dataset's entities, a dedicated collection is dropped.
+func (this *ReindexService) discardSourceVectors(ctx context.Context, job *models.ReindexJob) {
+	var err error
+	if job.SourceCollection == config.Settings.MILVUS_COLLECTION_NAME {
+		err = db.DeleteVectors(ctx, job.SourceCollection, fmt.Sprintf("dataset_id == %d", job.DatasetID))
+	} else {
+		err = db.DropVectorCollection(ctx, job.SourceCollection)
+	}
+	if err != nil {
+		utils.Logger.Errorf("Failed to remove old vectors of dataset %d from %s: %v", job.DatasetID, job.SourceCollection, err)
+	}
+}
+
+func toReindexJobInfo(job *models.ReindexJob) *models.ReindexJobInfo {
+	return &models.ReindexJobInfo{
+		ID:             job.ID,
+		DatasetID:      job.DatasetID,
+		Status:         job.Status,
+		ProviderID:     job.ProviderID,
+		EmbeddingModel: job.EmbeddingModel,
+		TotalFiles:     job.TotalFiles,
+		ProcessedFiles: job.ProcessedFiles,
+		Error:          job.Error,
+		CreatedAt:      job.CreatedAt,
+		UpdatedAt:      job.UpdatedAt,
+	}
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"server/models"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Time limit of a call to a remote rerank API
+const RERANK_REQUEST_TIMEOUT = 30 * time.Second
+
+// Reranker reorders documents by their relevance to a query
+type Reranker interface {
+	// Rerank returns at most topN results (all if topN <= 0) ordered by descending score
+	Rerank(ctx context.Context, query string, documents []string, topN int) ([]models.RerankResult, error)
+}
+
+// NewReranker builds the reranker of the given type. Remote rerankers call {baseURL}/rerank
+// with the API key as bearer token.
+func NewReranker(rerankType string, baseURL string, apiKey string, model string) (Reranker, error) {
+	switch rerankType {
+	case models.RERANK_TYPE_LEXICAL:
+		return &LexicalReranker{}, nil
+	case models.RERANK_TYPE_COHERE:
+		return &HTTPReranker{BaseURL: baseURL, APIKey: apiKey, Model: model}, nil
+	case models.RERANK_TYPE_JINA:
+		returnDocuments := false
+		return &HTTPReranker{BaseURL: baseURL, APIKey: apiKey, Model: model, ReturnDocuments: &returnDocuments}, nil
+	default:
+		return nil, fmt.Errorf("unsupported rerank type: %s", rerankType)
+	}
+}
+
+// HTTPReranker calls a Cohere- or Jina-style /rerank HTTP API
+type HTTPReranker struct {
+	BaseURL         string
+	APIKey          string
+	Model           string
+	ReturnDocuments *bool // Jina returns documents by default, which we never need
+	Client          *http.Client
+	OnUsage         func(inputTokens int64) // Called with the tokens the API reports billing, if any
+}
+
+func (this *HTTPReranker) Rerank(ctx context.Context, query string, documents []string, topN int) ([]models.RerankResult, error) {
+	if len(documents) == 0 {
+		return []models.RerankResult{}, nil
+	}
+
+	body, err := json.Marshal(models.RerankAPIReq{
+		Model:           this.Model,
+		Query:           query,
+		Documents:       documents,
+		TopN:            topN,
+		ReturnDocuments: this.ReturnDocuments,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rerankURL := strings.TrimSuffix(this.BaseURL, "/") + "/rerank"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rerankURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+this.APIKey)
+
+	client := this.Client
+	if client == nil {
+		client = &http.Client{Timeout: RERANK_REQUEST_TIMEOUT}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call rerank API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("rerank API returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	var rerankResp models.RerankAPIResp
+	if err := json.NewDecoder(resp.Body).Decode(&rerankResp); err != nil {
+		return nil, fmt.Errorf("failed to decode rerank response: %w", err)
+	}
+
+	if this.OnUsage != nil {
+		this.OnUsage(max(rerankResp.Usage.TotalTokens, rerankResp.Meta.BilledUnits.InputTokens))
+	}
+
+	results := make([]models.RerankResult, 0, len(rerankResp.Results))
+	for _, result := range rerankResp.Results {
+		if result.Index < 0 || result.Index >= len(documents) {
+			return nil, fmt.Errorf("rerank API returned out of range index %d", result.Index)
+		}
+		results = append(results, models.RerankResult{Index: result.Index, Score: result.RelevanceScore})
+	}
+	return sortRerankResults(results, topN), nil
+}
+
+// LexicalReranker scores documents by the share of query terms they contain.
+// It needs no model and is used as a fallback when a remote reranker fails.
+type LexicalReranker struct{}
+
+func (this *LexicalReranker) Rerank(ctx context.Context, query string, documents []string, topN int) ([]models.RerankResult, error) {
+	queryTerms := lexicalTerms(query)
+	results := make([]models.RerankResult, 0, len(documents))
+	for i, document := range documents {
+		var score float32
+		if len(queryTerms) > 0 {
+			documentTerms := lexicalTerms(document)
+			matched := 0
+			for term := range queryTerms {
+				if _, ok := documentTerms[term]; ok {
+					matched++
+				}
+			}
+			score = float32(matched) / float32(len(queryTerms))
+		}
+		results = append(results, models.RerankResult{Index: i, Score: score})
+	}
+	return sortRerankResults(results, topN), nil
+}
+
+// lexicalTerms lowercases text and splits it into words. Han, Hiragana, Katakana and Hangul
+// characters are taken one by one since those scripts do not separate words with spaces.
+func lexicalTerms(text string) map[string]struct{} {
+	terms := map[string]struct{}{}
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			terms[word.String()] = struct{}{}
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			terms[string(r)] = struct{}{}
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+// sortRerankResults orders results by descending score and keeps the first topN
+func sortRerankResults(results []models.RerankResult, topN int) []models.RerankResult {
+	slices.SortStableFunc(results, func(a, b models.RerankResult) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if topN > 0 && len(results) > topN {
+		results = results[:topN]
+	}
+	return results
+}
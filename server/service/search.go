@@ -0,0 +1,547 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var SearchServiceApp = new(SearchService)
+
+type SearchService struct{}
+
+const (
+	DEFAULT_SEARCH_TOP_K = 10
+	RRF_K                = 60 // Rank constant of reciprocal rank fusion
+	// Number of candidates retrieved per requested result when a rerank stage is configured
+	RERANK_CANDIDATE_FACTOR = 3
+	// Candidates retrieved per requested result when filtering by file type, which Milvus cannot do,
+	// multiplied again while filtered out chunks leave too few results
+	FILE_TYPE_OVERFETCH_FACTOR = 4
+	// Largest number of results Milvus returns for a search
+	MILVUS_MAX_TOP_K = 16384
+)
+
+// SearchDataset embeds the query with the dataset's provider and embedding model,
+// searches Milvus (or PostgreSQL for keyword search) according to the dataset's search type and joins hits back to chunks and files.
+// If the dataset has a rerank stage, more candidates are retrieved and reordered by the reranker.
+func (this *SearchService) SearchDataset(ctx context.Context, datasetID uint, ownerID uint, query string, topK int) ([]models.SearchResultItem, error) {
+	if topK <= 0 {
+		topK = DEFAULT_SEARCH_TOP_K
+	}
+	if err := QuotaServiceApp.CheckTokenBudget(ctx, ownerID); err != nil {
+		return nil, err
+	}
+
+	var dataset models.Dataset
+	result := db.PgSqlDB.WithContext(ctx).
+		Preload("Provider").
+		Preload("RerankProvider").
+		Where("id = ? AND owner_id = ?", datasetID, ownerID).
+		First(&dataset)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	ctx = WithUsageScope(ctx, ownerID, dataset.ID)
+
+	rerankEnabled := dataset.RerankType != "" && dataset.RerankType != models.RERANK_TYPE_NONE
+	candidates := topK
+	if rerankEnabled {
+		candidates = topK * RERANK_CANDIDATE_FACTOR
+	}
+
+	results, err := this.searchByType(ctx, dataset.SearchType, DatasetCollection(&dataset), []uint{dataset.ID}, nil, query, candidates, func() ([]float32, error) {
+		return this.embedDenseQuery(ctx, ownerID, &dataset.Provider, dataset.EmbeddingModel, &dataset.EmbeddingFallback, query)
+	}, func() (map[uint32]float32, error) {
+		return this.embedSparseQuery(ctx, query)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].DatasetName = dataset.Name
+	}
+
+	if rerankEnabled {
+		return this.rerankResults(ctx, &dataset, query, results, topK), nil
+	}
+	return results, nil
+}
+
+// rerankResults reorders results with the dataset's reranker. If the remote reranker
+// cannot be built or fails, the lexical reranker is used instead so search keeps working.
+func (this *SearchService) rerankResults(ctx context.Context, dataset *models.Dataset, query string, results []models.SearchResultItem, topK int) []models.SearchResultItem {
+	documents := make([]string, 0, len(results))
+	for _, result := range results {
+		documents = append(documents, result.Content)
+	}
+
+	var reranked []models.RerankResult
+	reranker, err := this.datasetReranker(ctx, dataset)
+	if err == nil {
+		reranked, err = this.rerank(ctx, dataset, reranker, query, documents, topK)
+	}
+	if err != nil {
+		utils.Logger.Warnf("Rerank failed for dataset %d, falling back to lexical reranker: %v", dataset.ID, err)
+		reranked, _ = (&LexicalReranker{}).Rerank(ctx, query, documents, topK)
+	}
+
+	rerankedResults := make([]models.SearchResultItem, 0, len(reranked))
+	for _, rerankResult := range reranked {
+		item := results[rerankResult.Index]
+		item.Score = rerankResult.Score
+		rerankedResults = append(rerankedResults, item)
+	}
+	return rerankedResults
+}
+
+// rerank calls the reranker, recording calls to a remote rerank API in the usage ledger
+func (this *SearchService) rerank(ctx context.Context, dataset *models.Dataset, reranker Reranker, query string, documents []string, topK int) ([]models.RerankResult, error) {
+	httpReranker, remote := reranker.(*HTTPReranker)
+	if !remote || len(documents) == 0 {
+		return reranker.Rerank(ctx, query, documents, topK)
+	}
+
+	var tokens int64
+	httpReranker.OnUsage = func(inputTokens int64) { tokens = inputTokens }
+	start := time.Now()
+	results, err := httpReranker.Rerank(ctx, query, documents, topK)
+	event := models.UsageEvent{
+		CallType:    models.USAGE_CALL_RERANK,
+		Model:       dataset.RerankModel,
+		InputTokens: tokens,
+		Latency:     time.Since(start),
+		Err:         err,
+	}
+	if err == nil && tokens == 0 {
+		event.InputTokens = EstimateTokens(append([]string{query}, documents...)...)
+		event.Estimated = true
+	}
+	UsageServiceApp.Record(ctx, dataset.RerankProvider, event)
+	return results, err
+}
+
+func (this *SearchService) datasetReranker(ctx context.Context, dataset *models.Dataset) (Reranker, error) {
+	if dataset.RerankType == models.RERANK_TYPE_LEXICAL {
+		return &LexicalReranker{}, nil
+	}
+	if dataset.RerankProvider == nil {
+		return nil, fmt.Errorf("dataset %d has no rerank provider", dataset.ID)
+	}
+	if err := ProviderServiceApp.AuthorizeProviderModel(ctx, dataset.RerankProvider, dataset.OwnerID, dataset.RerankModel); err != nil {
+		return nil, err
+	}
+	apiKey, err := ProviderServiceApp.ResolveAPIKey(ctx, dataset.RerankProvider)
+	if err != nil {
+		return nil, err
+	}
+	reranker, err := NewReranker(dataset.RerankType, dataset.RerankProvider.BaseURL, apiKey, dataset.RerankModel)
+	if err != nil {
+		return nil, err
+	}
+	// Reach the rerank API like the provider's other APIs
+	if httpReranker, ok := reranker.(*HTTPReranker); ok {
+		client, err := providerHTTPClient(ctx, dataset.RerankProvider)
+		if err != nil {
+			return nil, err
+		}
+		client.Timeout = RERANK_REQUEST_TIMEOUT
+		httpReranker.Client = client
+	}
+	return reranker, nil
+}
+
+// SearchDatasets fans a query out across several (or all) datasets of the owner.
+// Datasets sharing a provider, embedding model and search type are searched together, the query is
+// embedded once per distinct embedding model, and the ranked lists are merged with reciprocal rank fusion.
+func (this *SearchService) SearchDatasets(ctx context.Context, ownerID uint, datasetIDs []uint, tags []string, fileTypes []string, query string, topK int) ([]models.SearchResultItem, error) {
+	if topK <= 0 {
+		topK = DEFAULT_SEARCH_TOP_K
+	}
+
+	if err := QuotaServiceApp.CheckTokenBudget(ctx, ownerID); err != nil {
+		return nil, err
+	}
+	datasets, err := this.listSearchableDatasets(ctx, ownerID, datasetIDs, tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(datasets) == 0 {
+		return []models.SearchResultItem{}, nil
+	}
+
+	// Group datasets that can be searched with a single Milvus request
+	type searchGroup struct {
+		provider       *models.Provider
+		embeddingModel string
+		fallback       *models.FallbackChain // Embedding chain of the first dataset of the group
+		searchType     string
+		collection     string
+		datasetIDs     []uint
+	}
+	groups := map[string]*searchGroup{}
+	groupKeys := []string{}
+	datasetNames := map[uint]string{}
+	for i := range datasets {
+		dataset := &datasets[i]
+		datasetNames[dataset.ID] = dataset.Name
+		key := fmt.Sprintf("%d/%s/%s/%s", dataset.ProviderID, dataset.EmbeddingModel, dataset.SearchType, DatasetCollection(dataset))
+		group, ok := groups[key]
+		if !ok {
+			group = &searchGroup{
+				provider:       &dataset.Provider,
+				embeddingModel: dataset.EmbeddingModel,
+				fallback:       &dataset.EmbeddingFallback,
+				searchType:     dataset.SearchType,
+				collection:     DatasetCollection(dataset),
+			}
+			groups[key] = group
+			groupKeys = append(groupKeys, key)
+		}
+		group.datasetIDs = append(group.datasetIDs, dataset.ID)
+	}
+
+	// Calls are attributed to a dataset only when a single one is searched, embeddings are shared between datasets
+	var usageDatasetID uint
+	if len(datasets) == 1 {
+		usageDatasetID = datasets[0].ID
+	}
+	ctx = WithUsageScope(ctx, ownerID, usageDatasetID)
+
+	// Query embeddings are computed lazily and shared between groups
+	denseCache := map[string][]float32{}
+	var sparseCache map[uint32]float32
+	sparseQuery := func() (map[uint32]float32, error) {
+		if sparseCache != nil {
+			return sparseCache, nil
+		}
+		sparse, err := this.embedSparseQuery(ctx, query)
+		sparseCache = sparse
+		return sparse, err
+	}
+
+	rankedLists := make([][]models.SearchResultItem, 0, len(groups))
+	for _, key := range groupKeys {
+		group := groups[key]
+		denseQuery := func() ([]float32, error) {
+			embeddingKey := fmt.Sprintf("%d/%s", group.provider.ID, group.embeddingModel)
+			if dense, ok := denseCache[embeddingKey]; ok {
+				return dense, nil
+			}
+			dense, err := this.embedDenseQuery(ctx, ownerID, group.provider, group.embeddingModel, group.fallback, query)
+			if err != nil {
+				return nil, err
+			}
+			denseCache[embeddingKey] = dense
+			return dense, nil
+		}
+
+		items, err := this.searchByType(ctx, group.searchType, group.collection, group.datasetIDs, fileTypes, query, topK, denseQuery, sparseQuery)
+		if err != nil {
+			return nil, err
+		}
+		rankedLists = append(rankedLists, items)
+	}
+
+	results := FuseRankedResults(rankedLists, RRF_K, topK)
+	for i := range results {
+		results[i].DatasetName = datasetNames[results[i].DatasetID]
+	}
+	return results, nil
+}
+
+// listSearchableDatasets returns the owner's datasets restricted to the given IDs (all if empty)
+// and to datasets carrying at least one of the given tags (any if empty)
+func (this *SearchService) listSearchableDatasets(ctx context.Context, ownerID uint, datasetIDs []uint, tags []string) ([]models.Dataset, error) {
+	var datasets []models.Dataset
+	query := db.PgSqlDB.WithContext(ctx).
+		Preload("Provider").
+		Where("owner_id = ?", ownerID)
+	if len(datasetIDs) > 0 {
+		query = query.Where("id IN ?", datasetIDs)
+	}
+	if result := query.Find(&datasets); result.Error != nil {
+		return nil, result.Error
+	}
+	if len(tags) == 0 {
+		return datasets, nil
+	}
+
+	filtered := []models.Dataset{}
+	for _, dataset := range datasets {
+		for _, tag := range tags {
+			if slices.Contains(dataset.Tags, tag) {
+				filtered = append(filtered, dataset)
+				break
+			}
+		}
+	}
+	return filtered, nil
+}
+
+// searchByType runs a dense, sparse, hybrid or keyword search over datasets sharing an embedding model and collection.
+// Without Milvus, hybrid search degrades to keyword search; without the sparse embedding service,
+// its keyword side is served by PostgreSQL full-text search and fused with the dense results.
+func (this *SearchService) searchByType(ctx context.Context, searchType string, collection string, datasetIDs []uint, fileTypes []string, query string, topK int,
+	denseQuery func() ([]float32, error), sparseQuery func() (map[uint32]float32, error)) ([]models.SearchResultItem, error) {
+	if searchType == models.SEARCH_TYPE_KEYWORD {
+		return this.KeywordSearch(ctx, datasetIDs, fileTypes, query, topK)
+	}
+	if db.MilvusClient == nil {
+		if searchType == models.SEARCH_TYPE_HYBRID {
+			utils.Logger.Warn("Milvus is unavailable, hybrid search falls back to keyword search")
+			return this.KeywordSearch(ctx, datasetIDs, fileTypes, query, topK)
+		}
+		return nil, ErrVectorStoreUnavailable
+	}
+
+	filter := milvusDatasetFilter(datasetIDs)
+	switch searchType {
+	case models.SEARCH_TYPE_SPARSE:
+		sparse, err := sparseQuery()
+		if err != nil {
+			return nil, err
+		}
+		return this.searchVectors(ctx, fileTypes, topK, func(limit int) ([]db.VectorHit, error) {
+			return db.SearchSparseVectors(ctx, collection, sparse, filter, limit)
+		})
+	case models.SEARCH_TYPE_HYBRID:
+		dense, err := denseQuery()
+		if err != nil {
+			return nil, err
+		}
+		sparse, err := sparseQuery()
+		if err != nil {
+			utils.Logger.Warnf("Sparse embedding unavailable, using keyword search as the lexical side of hybrid search: %v", err)
+			return this.hybridKeywordSearch(ctx, collection, dense, datasetIDs, fileTypes, query, topK)
+		}
+		return this.searchVectors(ctx, fileTypes, topK, func(limit int) ([]db.VectorHit, error) {
+			return db.HybridSearchVectors(ctx, collection, dense, sparse, filter, limit)
+		})
+	default:
+		dense, err := denseQuery()
+		if err != nil {
+			return nil, err
+		}
+		return this.searchVectors(ctx, fileTypes, topK, func(limit int) ([]db.VectorHit, error) {
+			return db.SearchDenseVectors(ctx, collection, dense, filter, limit)
+		})
+	}
+}
+
+// searchVectors runs a vector search and joins its hits to chunks, keeping only chunks of the given file types
+func (this *SearchService) searchVectors(ctx context.Context, fileTypes []string, topK int, search func(limit int) ([]db.VectorHit, error)) ([]models.SearchResultItem, error) {
+	return OverfetchVectorSearch(fileTypes, topK, search, func(hits []db.VectorHit) ([]models.SearchResultItem, error) {
+		return this.joinChunks(ctx, hits, fileTypes)
+	})
+}
+
+// OverfetchVectorSearch runs search and joins its hits with join. Milvus knows nothing of file types,
+// so with a file type filter it fetches more candidates than requested, and fetches again with a larger limit
+// while filtered out chunks leave fewer than topK results and the collection has more to return.
+func OverfetchVectorSearch(fileTypes []string, topK int, search func(limit int) ([]db.VectorHit, error),
+	join func(hits []db.VectorHit) ([]models.SearchResultItem, error)) ([]models.SearchResultItem, error) {
+	limit := topK
+	if len(fileTypes) > 0 {
+		limit = min(topK*FILE_TYPE_OVERFETCH_FACTOR, MILVUS_MAX_TOP_K)
+	}
+	for {
+		hits, err := search(limit)
+		if err != nil {
+			return nil, err
+		}
+		results, err := join(hits)
+		if err != nil {
+			return nil, err
+		}
+		// Fewer hits than the limit means no more candidates are left
+		if len(fileTypes) == 0 || len(results) >= topK || len(hits) < limit || limit >= MILVUS_MAX_TOP_K {
+			return results[:min(len(results), topK)], nil
+		}
+		limit = min(limit*FILE_TYPE_OVERFETCH_FACTOR, MILVUS_MAX_TOP_K)
+	}
+}
+
+// hybridKeywordSearch fuses dense Milvus results with PostgreSQL full-text results
+func (this *SearchService) hybridKeywordSearch(ctx context.Context, collection string, dense []float32, datasetIDs []uint, fileTypes []string, query string, topK int) ([]models.SearchResultItem, error) {
+	denseResults, err := this.searchVectors(ctx, fileTypes, topK, func(limit int) ([]db.VectorHit, error) {
+		return db.SearchDenseVectors(ctx, collection, dense, milvusDatasetFilter(datasetIDs), limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	keywordResults, err := this.KeywordSearch(ctx, datasetIDs, fileTypes, query, topK)
+	if err != nil {
+		return nil, err
+	}
+	return FuseRankedResults([][]models.SearchResultItem{denseResults, keywordResults}, RRF_K, topK), nil
+}
+
+func milvusDatasetFilter(datasetIDs []uint) string {
+	ids := make([]string, 0, len(datasetIDs))
+	for _, id := range datasetIDs {
+		ids = append(ids, fmt.Sprint(id))
+	}
+	return fmt.Sprintf("dataset_id in [%s]", strings.Join(ids, ","))
+}
+
+// embedDenseQuery embeds the query with the embedding model of the dataset, failing over to the targets of its embedding chain.
+// A chain without a checked dimension is not used, and a target answering with another dimension fails, so vectors never mix.
+func (this *SearchService) embedDenseQuery(ctx context.Context, ownerID uint, provider *models.Provider, embeddingModel string, fallback *models.FallbackChain, query string) ([]float32, error) {
+	if fallback != nil && fallback.Dimension == 0 {
+		fallback = nil
+	}
+	embedding, _, err := withFallback(ctx, ownerID, provider, embeddingModel, fallback, func(err error) bool {
+		return !errors.Is(err, ErrEmbeddingDimensionMismatch)
+	}, func(provider *models.Provider, served *models.ServedBy) ([]float32, error) {
+		embeddings, err := ProviderServiceApp.EmbedTexts(ctx, provider, served.Model, []string{query})
+		if err != nil {
+			return nil, err
+		}
+		if len(embeddings) == 0 {
+			return nil, ErrEmptyEmbedding
+		}
+		if served.Fallback && len(embeddings[0]) != fallback.Dimension {
+			return nil, fmt.Errorf("%w: %s of provider %d returned %d, expected %d", ErrEmbeddingDimensionMismatch,
+				served.Model, served.ProviderID, len(embeddings[0]), fallback.Dimension)
+		}
+		return embeddings[0], nil
+	})
+	return embedding, err
+}
+
+// embedSparseQuery asks the AI service for the sparse (lexical weight) vector of the query,
+// since the sparse embedding model only runs there
+func (this *SearchService) embedSparseQuery(ctx context.Context, query string) (map[uint32]float32, error) {
+	embeddings, err := this.EmbedSparseTexts(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	return embeddings[0], nil
+}
+
+// EmbedSparseTexts asks the AI service for the sparse vectors of texts, in the order of texts
+func (this *SearchService) EmbedSparseTexts(ctx context.Context, texts []string) ([]map[uint32]float32, error) {
+	body, err := json.Marshal(models.SparseEmbeddingReq{Texts: texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Settings.GetAIServerURL()+"/search/sparse_embedding", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request sparse embedding: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sparse embedding request failed with status %d", resp.StatusCode)
+	}
+
+	var sparseResp models.SparseEmbeddingResp
+	if err := json.NewDecoder(resp.Body).Decode(&sparseResp); err != nil {
+		return nil, err
+	}
+	if len(sparseResp.Embeddings) != len(texts) {
+		return nil, ErrEmptyEmbedding
+	}
+	return sparseResp.Embeddings, nil
+}
+
+// joinChunks loads the chunks referenced by the hits, keeping the ranking order of Milvus.
+// If fileTypes is not empty, only chunks of files with one of these MIME types are kept.
+func (this *SearchService) joinChunks(ctx context.Context, hits []db.VectorHit, fileTypes []string) ([]models.SearchResultItem, error) {
+	results := []models.SearchResultItem{}
+	if len(hits) == 0 {
+		return results, nil
+	}
+
+	vectorIDs := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		vectorIDs = append(vectorIDs, fmt.Sprint(hit.ID))
+	}
+
+	query := gorm.G[models.Chunk](db.PgSqlDB).
+		Joins(clause.InnerJoin.Association("File"), nil).
+		Where("chunks.vector_id IN ?", vectorIDs)
+	if len(fileTypes) > 0 {
+		query = query.Where(`"File".type IN ?`, fileTypes)
+	}
+	chunks, err := query.Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	chunkByVectorID := make(map[string]models.Chunk, len(chunks))
+	for _, chunk := range chunks {
+		chunkByVectorID[chunk.VectorID] = chunk
+	}
+
+	for _, hit := range hits {
+		chunk, ok := chunkByVectorID[fmt.Sprint(hit.ID)]
+		if !ok {
+			// Vector exists in Milvus but its chunk was deleted or filtered out
+			continue
+		}
+		results = append(results, models.SearchResultItem{
+			ChunkID:   chunk.ID,
+			Content:   chunk.Content,
+			Score:     hit.Score,
+			Metadata:  chunk.Metadata,
+			FileID:    chunk.File.ID,
+			FileName:  chunk.File.Name,
+			FileType:  chunk.File.Type,
+			DatasetID: chunk.File.DatasetID,
+		})
+	}
+	return results, nil
+}
+
+// FuseRankedResults merges ranked result lists with reciprocal rank fusion:
+// every item scores the sum of 1/(k+rank) over the lists it appears in.
+// The returned items carry the fused score and are truncated to limit.
+func FuseRankedResults(rankedLists [][]models.SearchResultItem, k int, limit int) []models.SearchResultItem {
+	fused := map[uint]*models.SearchResultItem{}
+	order := []uint{}
+	for _, list := range rankedLists {
+		for rank, item := range list {
+			score := float32(1.0 / float64(k+rank+1))
+			if existing, ok := fused[item.ChunkID]; ok {
+				existing.Score += score
+				continue
+			}
+			item.Score = score
+			fused[item.ChunkID] = &item
+			order = append(order, item.ChunkID)
+		}
+	}
+
+	results := make([]models.SearchResultItem, 0, len(order))
+	for _, chunkID := range order {
+		results = append(results, *fused[chunkID])
+	}
+	slices.SortStableFunc(results, func(a, b models.SearchResultItem) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"server/config"
+	"server/models"
+	"server/utils"
+	"strings"
+)
+
+const (
+	// Backends keeping provider API keys and headers, chosen with SECRETS_BACKEND
+	SECRETS_BACKEND_DATABASE = "database" // Encrypted in the providers table
+	SECRETS_BACKEND_FILE     = "file"     // Files of a mounted secrets directory
+	SECRETS_BACKEND_VAULT    = "vault"    // HashiCorp Vault KV version 2 secrets engine
+)
+
+// SecretStore keeps the secrets of providers out of their records, which only hold the reference returned by Put.
+// References start with the name of the backend that wrote them followed by ':', except those of the database backend,
+// so changing SECRETS_BACKEND does not break the providers saved before.
+type SecretStore interface {
+	// Put stores a secret under a name unique to it and returns its reference
+	Put(ctx context.Context, name string, value string) (ref string, err error)
+	// Get returns the secret of a reference, or ErrSecretNotFound
+	Get(ctx context.Context, ref string) (string, error)
+	// Delete removes the secret of a reference, missing secrets are not an error
+	Delete(ctx context.Context, ref string) error
+}
+
+// DatabaseSecretStore keeps secrets in the reference itself, encrypted with utils.EncryptAPIKey
+type DatabaseSecretStore struct{}
+
+func (this *DatabaseSecretStore) Put(ctx context.Context, name string, value string) (string, error) {
+	return utils.EncryptAPIKey(value)
+}
+
+func (this *DatabaseSecretStore) Get(ctx context.Context, ref string) (string, error) {
+	return utils.DecryptAPIKey(ref)
+}
+
+func (this *DatabaseSecretStore) Delete(ctx context.Context, ref string) error {
+	return nil
+}
+
+// CheckSecretStore verifies that SECRETS_BACKEND names a backend whose settings are complete
+func CheckSecretStore() error {
+	_, err := defaultSecretStore()
+	return err
+}
+
+// defaultSecretStore returns the store of SECRETS_BACKEND, where new secrets are written
+func defaultSecretStore() (SecretStore, error) {
+	switch backend := config.Settings.GetSecretsBackend(); backend {
+	case SECRETS_BACKEND_DATABASE:
+		return &DatabaseSecretStore{}, nil
+	case SECRETS_BACKEND_FILE:
+		return configuredFileSecretStore()
+	case SECRETS_BACKEND_VAULT:
+		return configuredVaultSecretStore()
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownSecretsBackend, backend)
+	}
+}
+
+// secretBackendOf returns the backend that wrote a reference
+func secretBackendOf(ref string) string {
+	// Envelope ciphertexts start with their format version, legacy ones are base64 without ':'
+	if backend, _, ok := strings.Cut(ref, ":"); ok && (backend == SECRETS_BACKEND_FILE || backend == SECRETS_BACKEND_VAULT) {
+		return backend
+	}
+	return SECRETS_BACKEND_DATABASE
+}
+
+// secretStoreOf returns the store that wrote a reference, whatever the current SECRETS_BACKEND
+func secretStoreOf(ref string) (SecretStore, error) {
+	switch secretBackendOf(ref) {
+	case SECRETS_BACKEND_FILE:
+		return configuredFileSecretStore()
+	case SECRETS_BACKEND_VAULT:
+		return configuredVaultSecretStore()
+	default:
+		return &DatabaseSecretStore{}, nil
+	}
+}
+
+// ResolveAPIKey returns the API key of a provider from its secrets backend
+func (this *ProviderService) ResolveAPIKey(ctx context.Context, provider *models.Provider) (string, error) {
+	store, err := secretStoreOf(provider.APIKey)
+	if err != nil {
+		return "", err
+	}
+	apiKey, err := store.Get(ctx, provider.APIKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve API key: %w", err)
+	}
+	return apiKey, nil
+}
+
+// ResolveHeaders returns the extra request headers of a provider from its secrets backend
+func (this *ProviderService) ResolveHeaders(ctx context.Context, provider *models.Provider) (map[string]string, error) {
+	if provider.Headers == "" {
+		return nil, nil
+	}
+	store, err := secretStoreOf(provider.Headers)
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.Get(ctx, provider.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve headers: %w", err)
+	}
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(data), &headers); err != nil {
+		return nil, err
+	}
+	return headers, nil
+}
+
+// ResolveProxyURL returns the proxy URL of a provider from its secrets backend, empty when it has none
+func (this *ProviderService) ResolveProxyURL(ctx context.Context, provider *models.Provider) (string, error) {
+	if provider.ProxyURL == "" {
+		return "", nil
+	}
+	store, err := secretStoreOf(provider.ProxyURL)
+	if err != nil {
+		return "", err
+	}
+	proxyURL, err := store.Get(ctx, provider.ProxyURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve proxy URL: %w", err)
+	}
+	return proxyURL, nil
+}
+
+// putProviderSecrets stores the API key, headers and proxy URL of a provider record in the store, replacing them by their references
+func putProviderSecrets(ctx context.Context, store SecretStore, provider *models.Provider, apiKey string, headers map[string]string, proxyURL string) error {
+	id, err := utils.GenerateSnowID()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("provider-%d", id)
+	if provider.APIKey, err = store.Put(ctx, name+"-api-key", apiKey); err != nil {
+		return fmt.Errorf("failed to store API key: %w", err)
+	}
+	provider.Headers = ""
+	if len(headers) > 0 {
+		data, err := json.Marshal(headers)
+		if err != nil {
+			return err
+		}
+		if provider.Headers, err = store.Put(ctx, name+"-headers", string(data)); err != nil {
+			deleteProviderSecrets(ctx, provider)
+			return fmt.Errorf("failed to store headers: %w", err)
+		}
+	}
+	provider.ProxyURL = ""
+	if proxyURL != "" {
+		if provider.ProxyURL, err = store.Put(ctx, name+"-proxy-url", proxyURL); err != nil {
+			deleteProviderSecrets(ctx, provider)
+			return fmt.Errorf("failed to store proxy URL: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteProviderSecrets removes the secrets a provider record references, failures are only logged
+func deleteProviderSecrets(ctx context.Context, provider *models.Provider) {
+	for _, ref := range []string{provider.APIKey, provider.Headers, provider.ProxyURL} {
+		if ref == "" {
+			continue
+		}
+		store, err := secretStoreOf(ref)
+		if err == nil {
+			err = store.Delete(context.WithoutCancel(ctx), ref)
+		}
+		if err != nil {
+			utils.Logger.Warnf("Failed to delete secret of provider %d: %v", provider.ID, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"server/config"
+	"strings"
+)
+
+// FileSecretStore keeps each secret in a file of a directory, e.g. a volume shared by the API replicas.
+// References are "file:<name>", the name of the file in the directory.
+type FileSecretStore struct {
+	dir string
+}
+
+func NewFileSecretStore(dir string) *FileSecretStore {
+	return &FileSecretStore{dir: dir}
+}
+
+func configuredFileSecretStore() (SecretStore, error) {
+	dir := config.Settings.SECRETS_FILE_DIR
+	if dir == "" {
+		return nil, fmt.Errorf("%w: SECRETS_FILE_DIR is empty", ErrSecretsBackendNotEnabled)
+	}
+	return NewFileSecretStore(dir), nil
+}
+
+func (this *FileSecretStore) Put(ctx context.Context, name string, value string) (string, error) {
+	path, err := this.path(name)
+	if err != nil {
+		return "", err
+	}
+	// Written aside then renamed, so readers never see a partial secret
+	tmp, err := os.CreateTemp(this.dir, "."+name+".*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(value); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", err
+	}
+	return SECRETS_BACKEND_FILE + ":" + name, nil
+}
+
+func (this *FileSecretStore) Get(ctx context.Context, ref string) (string, error) {
+	path, err := this.refPath(ref)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", ErrSecretNotFound
+	} else if err != nil {
+		return "", err
+	}
+	// Secrets written by hand usually end with a newline
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func (this *FileSecretStore) Delete(ctx context.Context, ref string) error {
+	path, err := this.refPath(ref)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (this *FileSecretStore) refPath(ref string) (string, error) {
+	name, ok := strings.CutPrefix(ref, SECRETS_BACKEND_FILE+":")
+	if !ok {
+		return "", fmt.Errorf("not a file secret reference: %q", ref)
+	}
+	return this.path(name)
+}
+
+// path returns the file of a secret, names cannot leave the directory
+func (this *FileSecretStore) path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	return filepath.Join(this.dir, name), nil
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"server/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Wait for Vault to answer a request
+	VAULT_REQUEST_TIMEOUT = 10 * time.Second
+	// How long secrets read from Vault are kept in memory. References are never reused by
+	// another secret, so only deleted secrets may be served stale meanwhile.
+	VAULT_SECRET_CACHE_TTL = 5 * time.Minute
+)
+
+// VaultSecretStore keeps secrets in a HashiCorp Vault KV version 2 secrets engine, or any server speaking its HTTP API.
+// References are "vault:<path>", the path of the secret in the engine, whose "value" field holds the secret.
+type VaultSecretStore struct {
+	addr       string
+	token      string
+	namespace  string
+	mount      string
+	pathPrefix string
+	Client     *http.Client
+	cache      sync.Map
+}
+
+type vaultCachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// NewVaultSecretStore returns the store of the KV engine mounted at mount on the Vault server at addr,
+// writing new secrets under pathPrefix
+func NewVaultSecretStore(addr string, token string, namespace string, mount string, pathPrefix string) *VaultSecretStore {
+	return &VaultSecretStore{
+		addr:       strings.TrimSuffix(addr, "/"),
+		token:      token,
+		namespace:  namespace,
+		mount:      strings.Trim(mount, "/"),
+		pathPrefix: strings.Trim(pathPrefix, "/"),
+		Client:     &http.Client{Timeout: VAULT_REQUEST_TIMEOUT},
+	}
+}
+
+// One store for the configuration, sharing its cache
+var vaultSecretStore struct {
+	once  sync.Once
+	store *VaultSecretStore
+}
+
+func configuredVaultSecretStore() (SecretStore, error) {
+	if config.Settings.VAULT_ADDR == "" || config.Settings.VAULT_TOKEN == "" {
+		return nil, fmt.Errorf("%w: VAULT_ADDR and VAULT_TOKEN are required", ErrSecretsBackendNotEnabled)
+	}
+	vaultSecretStore.once.Do(func() {
+		vaultSecretStore.store = NewVaultSecretStore(config.Settings.VAULT_ADDR, config.Settings.VAULT_TOKEN,
+			config.Settings.VAULT_NAMESPACE, config.Settings.GetVaultKVMount(), config.Settings.GetVaultPathPrefix())
+	})
+	return vaultSecretStore.store, nil
+}
+
+func (this *VaultSecretStore) Put(ctx context.Context, name string, value string) (string, error) {
+	path := name
+	if this.pathPrefix != "" {
+		path = this.pathPrefix + "/" + name
+	}
+	body, err := json.Marshal(map[string]any{"data": map[string]string{"value": value}})
+	if err != nil {
+		return "", err
+	}
+	if _, err := this.do(ctx, http.MethodPost, "data", path, body); err != nil {
+		return "", err
+	}
+	return SECRETS_BACKEND_VAULT + ":" + path, nil
+}
+
+func (this *VaultSecretStore) Get(ctx context.Context, ref string) (string, error) {
+	path, err := vaultPath(ref)
+	if err != nil {
+		return "", err
+	}
+	if cached, ok := this.cache.Load(path); ok && time.Now().Before(cached.(vaultCachedSecret).expires) {
+		return cached.(vaultCachedSecret).value, nil
+	}
+
+	data, err := this.do(ctx, http.MethodGet, "data", path, nil)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return "", fmt.Errorf("failed to decode Vault secret: %w", err)
+	}
+	value, ok := resp.Data.Data["value"]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+	this.cache.Store(path, vaultCachedSecret{value: value, expires: time.Now().Add(VAULT_SECRET_CACHE_TTL)})
+	return value, nil
+}
+
+// Delete removes every version of the secret
+func (this *VaultSecretStore) Delete(ctx context.Context, ref string) error {
+	path, err := vaultPath(ref)
+	if err != nil {
+		return err
+	}
+	this.cache.Delete(path)
+	if _, err := this.do(ctx, http.MethodDelete, "metadata", path, nil); err != nil && !errors.Is(err, ErrSecretNotFound) {
+		return err
+	}
+	return nil
+}
+
+func vaultPath(ref string) (string, error) {
+	path, ok := strings.CutPrefix(ref, SECRETS_BACKEND_VAULT+":")
+	if !ok || path == "" {
+		return "", fmt.Errorf("not a Vault secret reference: %q", ref)
+	}
+	return path, nil
+}
+
+// do calls the KV engine API at /v1/<mount>/<kind>/<path> and returns the body of the response
+func (this *VaultSecretStore) do(ctx context.Context, method string, kind string, path string, body []byte) ([]byte, error) {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	endpoint := fmt.Sprintf("%s/v1/%s/%s/%s", this.addr, this.mount, kind, strings.Join(segments, "/"))
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", this.token)
+	if this.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", this.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := this.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Vault: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrSecretNotFound
+	case resp.StatusCode >= 300:
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.Unmarshal(data, &vaultErr)
+		return nil, fmt.Errorf("Vault returned %d: %s", resp.StatusCode, strings.Join(vaultErr.Errors, "; "))
+	}
+	return data, nil
+}
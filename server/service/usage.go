@@ -0,0 +1,359 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// Ledger entries waiting to be written, calls beyond it are logged and dropped rather than slowed down
+	USAGE_QUEUE_SIZE = 4096
+	// Entries written by a single insert, and the longest they wait for one
+	USAGE_BATCH_SIZE     = 200
+	USAGE_FLUSH_INTERVAL = 2 * time.Second
+	// Longest provider error kept in the ledger
+	USAGE_MAX_ERROR_LEN = 500
+	// Characters per token assumed when a provider reports no token counts
+	USAGE_CHARS_PER_TOKEN = 4
+)
+
+var UsageServiceApp = &UsageService{queue: make(chan models.UsageRecord, USAGE_QUEUE_SIZE), stopped: make(chan struct{})}
+
+// UsageService keeps the ledger of outbound provider calls. Calls are queued by Record
+// and written in batches by the worker started with Start, off the request path.
+type UsageService struct {
+	queue      chan models.UsageRecord
+	stopped    chan struct{} // Closed once the worker wrote the queue out and returned
+	pricesOnce sync.Once
+	prices     map[string]models.UsagePrice
+}
+
+type usageScopeKey struct{}
+
+// usageScope is who a provider call is made for
+type usageScope struct {
+	userID    uint
+	datasetID uint
+}
+
+// WithUsageScope attributes the provider calls made with the returned context to a user and, if not zero, a dataset.
+// Calls made without a scope are attributed to the owner of the provider.
+func WithUsageScope(ctx context.Context, userID uint, datasetID uint) context.Context {
+	return context.WithValue(ctx, usageScopeKey{}, usageScope{userID: userID, datasetID: datasetID})
+}
+
+// Start runs the worker writing queued ledger entries until ctx is done, when it writes out what is still queued
+func (this *UsageService) Start(ctx context.Context) {
+	this.loadPrices()
+	go this.runWriter(ctx)
+}
+
+// Wait blocks until the worker stopped by the end of its context wrote out the queue
+func (this *UsageService) Wait() {
+	<-this.stopped
+}
+
+// Record prices a provider call and queues it for the ledger without blocking
+func (this *UsageService) Record(ctx context.Context, provider *models.Provider, event models.UsageEvent) {
+	record := models.UsageRecord{
+		CreatedAt:    time.Now(),
+		UserID:       provider.OwnerID,
+		ProviderID:   provider.ID,
+		ProviderName: provider.Name,
+		ProviderMode: provider.Mode,
+		CallType:     event.CallType,
+		Model:        event.Model,
+		InputTokens:  event.InputTokens,
+		OutputTokens: event.OutputTokens,
+		Estimated:    event.Estimated,
+		LatencyMs:    event.Latency.Milliseconds(),
+		Cost:         UsageCost(this.loadPrices(), event.Model, event.InputTokens, event.OutputTokens),
+		Success:      event.Err == nil,
+	}
+	if scope, ok := ctx.Value(usageScopeKey{}).(usageScope); ok {
+		record.UserID = scope.userID
+		if scope.datasetID != 0 {
+			record.DatasetID = &scope.datasetID
+		}
+	}
+	if event.Err != nil {
+		record.Error = truncateRunes(event.Err.Error(), USAGE_MAX_ERROR_LEN)
+	}
+
+	select {
+	case this.queue <- record:
+	default:
+		utils.Logger.Warnf("Usage queue is full, dropping %s call of provider %d", record.CallType, record.ProviderID)
+	}
+}
+
+func (this *UsageService) runWriter(ctx context.Context) {
+	defer close(this.stopped)
+	ticker := time.NewTicker(USAGE_FLUSH_INTERVAL)
+	defer ticker.Stop()
+	batch := make([]models.UsageRecord, 0, USAGE_BATCH_SIZE)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		// Written even when ctx is done, the calls were made
+		if err := db.PgSqlDB.WithContext(context.Background()).CreateInBatches(batch, USAGE_BATCH_SIZE).Error; err != nil {
+			utils.Logger.Errorf("Failed to write %d usage records: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case record := <-this.queue:
+			batch = append(batch, record)
+			if len(batch) == USAGE_BATCH_SIZE {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case record := <-this.queue:
+					batch = append(batch, record)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// loadPrices reads the price table of USAGE_PRICE_FILE once. Without one, every call costs 0.
+func (this *UsageService) loadPrices() map[string]models.UsagePrice {
+	this.pricesOnce.Do(func() {
+		this.prices = map[string]models.UsagePrice{}
+		if config.Settings == nil || config.Settings.USAGE_PRICE_FILE == "" {
+			return
+		}
+		prices, err := LoadUsagePrices(config.Settings.USAGE_PRICE_FILE)
+		if err != nil {
+			utils.Logger.Errorf("Failed to load usage prices, costs are not estimated: %v", err)
+			return
+		}
+		this.prices = prices
+	})
+	return this.prices
+}
+
+// LoadUsagePrices reads a JSON price table mapping model names to their prices
+func LoadUsagePrices(path string) (map[string]models.UsagePrice, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var prices map[string]models.UsagePrice
+	if err := json.Unmarshal(content, &prices); err != nil {
+		return nil, fmt.Errorf("invalid price file %s: %w", path, err)
+	}
+	normalized := make(map[string]models.UsagePrice, len(prices))
+	for model, price := range prices {
+		normalized[normalizeModelName(model)] = price
+	}
+	return normalized, nil
+}
+
+// UsageCost estimates the cost in USD of a call from the price whose key is the longest prefix of the model name
+func UsageCost(prices map[string]models.UsagePrice, model string, inputTokens int64, outputTokens int64) float64 {
+	name := normalizeModelName(model)
+	var price *models.UsagePrice
+	matched := -1
+	for prefix := range prices {
+		if strings.HasPrefix(name, prefix) && len(prefix) > matched {
+			p := prices[prefix]
+			price, matched = &p, len(prefix)
+		}
+	}
+	if price == nil {
+		return 0
+	}
+	return price.Request + (float64(inputTokens)*price.Input+float64(outputTokens)*price.Output)/1e6
+}
+
+// EstimateTokens approximates the token count of texts for providers reporting none
+func EstimateTokens(texts ...string) int64 {
+	var chars int
+	for _, text := range texts {
+		chars += utf8.RuneCountInString(text)
+	}
+	return int64((chars + USAGE_CHARS_PER_TOKEN - 1) / USAGE_CHARS_PER_TOKEN)
+}
+
+func truncateRunes(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit])
+}
+
+// usageQuery applies the filter to the ledger, aliased u
+func usageQuery(ctx context.Context, filter models.UsageFilter) *gorm.DB {
+	query := db.PgSqlDB.WithContext(ctx).Table("usage_records AS u").Where("u.created_at >= ?", filter.Since)
+	if filter.UserID != 0 {
+		query = query.Where("u.user_id = ?", filter.UserID)
+	}
+	if filter.DatasetID != 0 {
+		query = query.Where("u.dataset_id = ?", filter.DatasetID)
+	}
+	if filter.ProviderID != 0 {
+		query = query.Where("u.provider_id = ?", filter.ProviderID)
+	}
+	if filter.CallType != "" {
+		query = query.Where("u.call_type = ?", filter.CallType)
+	}
+	return query
+}
+
+// UsageSince returns the start of a report period of the given days, DEFAULT_USAGE_REPORT_DAYS if zero
+func UsageSince(days int) time.Time {
+	if days <= 0 {
+		days = models.DEFAULT_USAGE_REPORT_DAYS
+	}
+	return time.Now().AddDate(0, 0, -days)
+}
+
+// Report aggregates the ledger by day, user, dataset or provider. Days are ordered chronologically, other groups by descending cost.
+func (this *UsageService) Report(ctx context.Context, groupBy string, filter models.UsageFilter) (*models.UsageReport, error) {
+	var key, name, order string
+	query := usageQuery(ctx, filter)
+	switch groupBy {
+	case models.USAGE_GROUP_DAY:
+		key = "to_char(u.created_at, 'YYYY-MM-DD')"
+		name = key
+		order = "key"
+	case models.USAGE_GROUP_USER:
+		key = "u.user_id::text"
+		name = "COALESCE(MAX(us.username), '')"
+		query = query.Joins("LEFT JOIN users us ON us.id = u.user_id")
+	case models.USAGE_GROUP_DATASET:
+		// Calls made outside of a dataset are grouped under an empty key
+		key = "COALESCE(u.dataset_id::text, '')"
+		name = "COALESCE(MAX(d.name), '')"
+		query = query.Joins("LEFT JOIN datasets d ON d.id = u.dataset_id")
+	case models.USAGE_GROUP_PROVIDER:
+		// The latest name, providers may have been renamed
+		key = "u.provider_id::text"
+		name = "(ARRAY_AGG(u.provider_name ORDER BY u.id DESC))[1]"
+	default:
+		return nil, fmt.Errorf("unsupported usage grouping: %s", groupBy)
+	}
+	if order == "" {
+		order = "cost DESC, calls DESC, key"
+	}
+
+	report := &models.UsageReport{GroupBy: groupBy, Since: filter.Since, Groups: []models.UsageStat{}}
+	if err := query.Select(key + ` AS key, ` + name + ` AS name,
+			COUNT(*) AS calls,
+			COUNT(*) FILTER (WHERE NOT u.success) AS failed_calls,
+			COALESCE(SUM(u.input_tokens), 0) AS input_tokens,
+			COALESCE(SUM(u.output_tokens), 0) AS output_tokens,
+			COALESCE(SUM(u.cost), 0) AS cost,
+			COALESCE(AVG(u.latency_ms), 0) AS avg_latency_ms`).
+		Group("1").
+		Order(order).
+		Scan(&report.Groups).Error; err != nil {
+		return nil, err
+	}
+
+	var latency float64
+	for _, group := range report.Groups {
+		report.Total.Calls += group.Calls
+		report.Total.FailedCalls += group.FailedCalls
+		report.Total.InputTokens += group.InputTokens
+		report.Total.OutputTokens += group.OutputTokens
+		report.Total.Cost += group.Cost
+		latency += group.AvgLatencyMs * float64(group.Calls)
+	}
+	if report.Total.Calls > 0 {
+		report.Total.AvgLatencyMs = latency / float64(report.Total.Calls)
+	}
+	return report, nil
+}
+
+// usageCSVHeader are the columns of the CSV export, one row per ledger entry
+var usageCSVHeader = []string{"id", "created_at", "user_id", "dataset_id", "provider_id", "provider_name", "provider_mode",
+	"call_type", "model", "input_tokens", "output_tokens", "estimated", "latency_ms", "cost", "success", "error"}
+
+// ExportCSV writes the ledger entries matching the filter to w as CSV, oldest first
+func (this *UsageService) ExportCSV(ctx context.Context, filter models.UsageFilter, w io.Writer) error {
+	rows, err := usageQuery(ctx, filter).Select("u.*").Order("u.id").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(usageCSVHeader); err != nil {
+		return err
+	}
+	for rows.Next() {
+		var record models.UsageRecord
+		if err := db.PgSqlDB.ScanRows(rows, &record); err != nil {
+			return err
+		}
+		if err := writer.Write(usageCSVRow(&record)); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func usageCSVRow(record *models.UsageRecord) []string {
+	datasetID := ""
+	if record.DatasetID != nil {
+		datasetID = strconv.FormatUint(uint64(*record.DatasetID), 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(record.ID), 10),
+		record.CreatedAt.UTC().Format(time.RFC3339),
+		strconv.FormatUint(uint64(record.UserID), 10),
+		datasetID,
+		strconv.FormatUint(uint64(record.ProviderID), 10),
+		EscapeCSVFormula(record.ProviderName),
+		record.ProviderMode,
+		record.CallType,
+		EscapeCSVFormula(record.Model),
+		strconv.FormatInt(record.InputTokens, 10),
+		strconv.FormatInt(record.OutputTokens, 10),
+		strconv.FormatBool(record.Estimated),
+		strconv.FormatInt(record.LatencyMs, 10),
+		strconv.FormatFloat(record.Cost, 'f', -1, 64),
+		strconv.FormatBool(record.Success),
+		EscapeCSVFormula(record.Error),
+	}
+}
+
+// EscapeCSVFormula quotes a cell that spreadsheets would evaluate as a formula.
+// Provider names, model names and provider errors come from users and providers.
+func EscapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,471 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Durable queue collecting the lifecycle events the webhooks are delivered from
+	WEBHOOK_EVENTS_QUEUE = "info-weaver-webhook-events"
+
+	// Wait between two looks for deliveries that are due
+	WEBHOOK_POLL_INTERVAL = 2 * time.Second
+	// Deliveries claimed at once by a replica, then sent concurrently
+	WEBHOOK_BATCH_SIZE = 20
+	// Wait for an endpoint to answer
+	WEBHOOK_REQUEST_TIMEOUT = 10 * time.Second
+	// A claimed delivery whose replica did not report back in this time is sent again.
+	// The batch is sent concurrently, so it takes about one request timeout.
+	WEBHOOK_CLAIM_LEASE = 3 * WEBHOOK_REQUEST_TIMEOUT
+
+	// Attempts before a delivery is dead, spaced by a backoff doubling from the base delay: about an hour in total
+	WEBHOOK_MAX_ATTEMPTS     = 8
+	WEBHOOK_RETRY_BASE_DELAY = 30 * time.Second
+	WEBHOOK_RETRY_MAX_DELAY  = 30 * time.Minute
+	// Backoff between attempts to consume the events queue, which fail while its dead-letter policy cannot be set
+	WEBHOOK_SUBSCRIBE_BASE_DELAY = 5 * time.Second
+	WEBHOOK_SUBSCRIBE_MAX_DELAY  = 5 * time.Minute
+	// Characters of an error kept in the delivery log
+	WEBHOOK_MAX_ERROR_LEN = 500
+
+	WEBHOOK_SIGNATURE_HEADER = "X-InfoWeaver-Signature"
+	WEBHOOK_EVENT_HEADER     = "X-InfoWeaver-Event"
+	WEBHOOK_DELIVERY_HEADER  = "X-InfoWeaver-Delivery"
+)
+
+var WebhookServiceApp = &WebhookService{
+	client: &http.Client{
+		Timeout: WEBHOOK_REQUEST_TIMEOUT,
+		// Endpoints are given by users, they must not reach the server's own network whatever their host resolves to
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				return utils.PublicDialer(WEBHOOK_REQUEST_TIMEOUT, config.Settings.GetOutboundAllowedNetworks()).DialContext(ctx, network, address)
+			},
+			TLSHandshakeTimeout: WEBHOOK_REQUEST_TIMEOUT,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// A redirect is an answer of its own, endpoints must answer 2xx at the registered URL
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	},
+}
+
+// WebhookService delivers the lifecycle events to the webhooks of their users.
+// Events are read from RabbitMQ into a delivery per matching webhook, then sent by a dispatcher
+// retrying failed deliveries with exponential backoff until they succeed or are dead.
+type WebhookService struct {
+	client *http.Client
+}
+
+// WebhookEventMatches tells whether an event passes the filters of a webhook, names or "<kind>.*"
+func WebhookEventMatches(filters []string, event string) bool {
+	for _, filter := range filters {
+		if prefix, ok := strings.CutSuffix(filter, "*"); ok && strings.HasPrefix(event, prefix) || filter == event {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhookPayload signs a delivery with HMAC-SHA256 over "<timestamp>.<payload>", giving the value of the
+// X-InfoWeaver-Signature header "t=<timestamp>,v1=<hex signature>". Receivers reject old timestamps to stop replays.
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// CreateWebhook registers an endpoint of the user, with a generated signing secret when none is given
+func (this *WebhookService) CreateWebhook(ctx context.Context, userID uint, req models.WebhookCreateReq) (*models.WebhookCreateResp, error) {
+	if err := checkWebhookURL(ctx, req.URL); err != nil {
+		return nil, err
+	}
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = utils.GenerateWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+	encryptedSecret, err := utils.EncryptAPIKey(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+	webhook := models.Webhook{
+		URL:         req.URL,
+		Events:      req.Events,
+		Description: req.Description,
+		Secret:      encryptedSecret,
+		Active:      true,
+		UserID:      userID,
+	}
+	if err := gorm.G[models.Webhook](db.PgSqlDB).Create(ctx, &webhook); err != nil {
+		return nil, err
+	}
+	return &models.WebhookCreateResp{WebhookInfo: webhookInfo(&webhook), Secret: secret}, nil
+}
+
+// checkWebhookURL refuses endpoints resolving to addresses that are not public, see utils.IsPublicIP
+func checkWebhookURL(ctx context.Context, rawURL string) error {
+	if err := utils.CheckPublicURL(ctx, rawURL, config.Settings.GetOutboundAllowedNetworks()); err != nil {
+		return fmt.Errorf("%w: %v", ErrWebhookAddressRefused, err)
+	}
+	return nil
+}
+
+func webhookInfo(webhook *models.Webhook) models.WebhookInfo {
+	return models.WebhookInfo{
+		ID:          webhook.ID,
+		URL:         webhook.URL,
+		Events:      webhook.Events,
+		Description: webhook.Description,
+		Active:      webhook.Active,
+		CreatedAt:   webhook.CreatedAt,
+		UpdatedAt:   webhook.UpdatedAt,
+	}
+}
+
+func (this *WebhookService) ListWebhooks(ctx context.Context, userID uint) (total int64, webhooks []models.WebhookInfo, err error) {
+	result := db.PgSqlDB.WithContext(ctx).Model(&models.Webhook{}).
+		Where("user_id = ?", userID).
+		Order("id").
+		Find(&webhooks)
+	return result.RowsAffected, webhooks, result.Error
+}
+
+// UpdateWebhook changes the endpoint and filters of a webhook, or pauses it. The secret stays the same.
+func (this *WebhookService) UpdateWebhook(ctx context.Context, userID uint, req models.WebhookUpdateReq) error {
+	if err := checkWebhookURL(ctx, req.URL); err != nil {
+		return err
+	}
+	rows, err := gorm.G[models.Webhook](db.PgSqlDB).
+		Where("id = ? AND user_id = ?", req.ID, userID).
+		Select("url", "events", "description", "active").
+		Updates(ctx, models.Webhook{URL: req.URL, Events: req.Events, Description: req.Description, Active: req.Active})
+	if err == nil && rows == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DeleteWebhook removes a webhook, its pending deliveries are dropped
+func (this *WebhookService) DeleteWebhook(ctx context.Context, webhookID uint, userID uint) error {
+	return db.PgSqlDB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rows, err := gorm.G[models.Webhook](tx).
+			Where("id = ? AND user_id = ?", webhookID, userID).
+			Delete(ctx)
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return ErrNotFound
+		}
+		_, err = gorm.G[models.WebhookDelivery](tx).
+			Where("webhook_id = ? AND status = ?", webhookID, models.WEBHOOK_DELIVERY_PENDING).
+			Select("status", "next_attempt_at", "last_error").
+			Updates(ctx, models.WebhookDelivery{Status: models.WEBHOOK_DELIVERY_DEAD, LastError: "webhook deleted"})
+		return err
+	})
+}
+
+// ListDeliveries returns a page of the delivery log of a webhook of the user, newest first
+func (this *WebhookService) ListDeliveries(ctx context.Context, userID uint, req models.WebhookDeliveryListReq) (total int64, deliveries []models.WebhookDeliveryInfo, err error) {
+	if _, err := gorm.G[models.Webhook](db.PgSqlDB).Where("id = ? AND user_id = ?", req.ID, userID).First(ctx); err != nil {
+		return 0, nil, err
+	}
+	page := max(req.Page, 1)
+	pageSize := req.PageSize
+	if pageSize <= 0 {
+		pageSize = models.DEFAULT_WEBHOOK_PAGE_SIZE
+	}
+	logOf := func() *gorm.DB {
+		query := db.PgSqlDB.WithContext(ctx).Model(&models.WebhookDelivery{}).Where("webhook_id = ?", req.ID)
+		if req.Status != "" {
+			query = query.Where("status = ?", req.Status)
+		}
+		return query
+	}
+
+	if err := logOf().Count(&total).Error; err != nil {
+		return 0, nil, err
+	}
+	deliveries = []models.WebhookDeliveryInfo{}
+	err = logOf().
+		Order("id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&deliveries).Error
+	return total, deliveries, err
+}
+
+// Redeliver queues a delivery of the user again as a new delivery sent right away, whatever the outcome of the first one
+func (this *WebhookService) Redeliver(ctx context.Context, deliveryID uint, userID uint) (*models.WebhookDeliveryInfo, error) {
+	original, err := gorm.G[models.WebhookDelivery](db.PgSqlDB).
+		Where("id = ? AND webhook_id IN (SELECT id FROM webhooks WHERE user_id = ? AND deleted_at IS NULL)", deliveryID, userID).
+		First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		WebhookID:     original.WebhookID,
+		EventID:       original.EventID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        models.WEBHOOK_DELIVERY_PENDING,
+		NextAttemptAt: &now,
+		RedeliveryOf:  &original.ID,
+	}
+	if err := gorm.G[models.WebhookDelivery](db.PgSqlDB).Create(ctx, &delivery); err != nil {
+		return nil, err
+	}
+	var info models.WebhookDeliveryInfo
+	err = db.PgSqlDB.WithContext(ctx).Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).First(&info).Error
+	return &info, err
+}
+
+// Start consumes the lifecycle events into deliveries and runs the dispatcher sending them until ctx is done
+func (this *WebhookService) Start(ctx context.Context) {
+	go this.consumeEvents(ctx)
+	go this.runDispatcher(ctx)
+}
+
+func (this *WebhookService) consumeEvents(ctx context.Context) {
+	topic, err := db.NewTopic(config.Settings.GetRabbitMQExchange())
+	if err != nil {
+		utils.Logger.Errorf("Webhooks will not receive events, failed to declare events exchange: %v", err)
+		return
+	}
+	// Events rejected before the queue's dead-letter policy is set would be lost, so nothing is consumed until it is
+	messages, err := topic.SubscribeWithPattern(ctx, WEBHOOK_EVENTS_QUEUE, "file.*", "dataset.*")
+	for attempts := 1; err != nil; attempts++ {
+		utils.Logger.Errorf("Webhooks receive no events until %s can be consumed, attempt %d: %v", WEBHOOK_EVENTS_QUEUE, attempts, err)
+		if err := sleepContext(ctx, utils.Backoff(WEBHOOK_SUBSCRIBE_BASE_DELAY, WEBHOOK_SUBSCRIBE_MAX_DELAY, attempts)); err != nil {
+			return
+		}
+		messages, err = topic.SubscribeWithPattern(ctx, WEBHOOK_EVENTS_QUEUE, "file.*", "dataset.*")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			switch err := this.queueDeliveries(ctx, message.Body); {
+			case err == nil:
+				message.Ack(false)
+			case errors.Is(err, errMalformedEvent):
+				utils.Logger.Warnf("Dead-lettering lifecycle event %q: %v", message.Body, err)
+				if err := db.RabbitMQClient.DeadLetter(ctx, db.NewRetryTopology(WEBHOOK_EVENTS_QUEUE), message, err.Error()); err != nil {
+					utils.Logger.Errorf("Failed to dead-letter lifecycle event: %v", err)
+					message.Nack(false, false)
+				}
+			default:
+				utils.Logger.Errorf("Failed to queue webhook deliveries of event: %v", err)
+				// Retried after a delay, once the database recovers
+				message.Nack(false, false)
+			}
+		}
+	}
+}
+
+// Lifecycle events that can never be delivered, dead-lettered at once
+var errMalformedEvent = errors.New("malformed lifecycle event")
+
+// queueDeliveries creates a delivery of an event for each active webhook of its user matching it
+func (this *WebhookService) queueDeliveries(ctx context.Context, body []byte) error {
+	var event models.LifecycleEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedEvent, err)
+	}
+	if event.ID == "" || event.Event == "" || event.UserID == 0 {
+		return fmt.Errorf("%w: missing ID, event or user", errMalformedEvent)
+	}
+	webhooks, err := gorm.G[models.Webhook](db.PgSqlDB).
+		Where("user_id = ? AND active", event.UserID).
+		Find(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var deliveries []models.WebhookDelivery
+	for _, webhook := range webhooks {
+		if WebhookEventMatches(webhook.Events, event.Event) {
+			deliveries = append(deliveries, models.WebhookDelivery{
+				WebhookID:     webhook.ID,
+				EventID:       event.ID,
+				Event:         event.Event,
+				Payload:       string(body),
+				Status:        models.WEBHOOK_DELIVERY_PENDING,
+				NextAttemptAt: &now,
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	// The broker may hand an event over again, its deliveries already exist then
+	return db.PgSqlDB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
+
+func (this *WebhookService) runDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(WEBHOOK_POLL_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deliveries, err := this.claimDueDeliveries(ctx)
+			if err != nil {
+				utils.Logger.Errorf("Failed to claim webhook deliveries: %v", err)
+				continue
+			}
+			var wg sync.WaitGroup
+			for _, delivery := range deliveries {
+				wg.Go(func() { this.deliver(ctx, &delivery) })
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// claimDueDeliveries takes the deliveries that are due for a lease
+func (this *WebhookService) claimDueDeliveries(ctx context.Context) ([]models.WebhookDelivery, error) {
+	return claimDue[models.WebhookDelivery](ctx, DueClaim{
+		Due:         "status = ? AND next_attempt_at <= ?",
+		Args:        []any{models.WEBHOOK_DELIVERY_PENDING, time.Now()},
+		Order:       "next_attempt_at",
+		Limit:       WEBHOOK_BATCH_SIZE,
+		LeaseColumn: "next_attempt_at",
+		Lease:       WEBHOOK_CLAIM_LEASE,
+	})
+}
+
+// deliver sends a delivery once and records the outcome: succeeded, another attempt later, or dead
+func (this *WebhookService) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	statusCode, err := this.send(ctx, delivery)
+	if ctx.Err() != nil {
+		// Shutting down, the lease runs out and another replica sends it
+		return
+	}
+
+	now := time.Now()
+	update := models.WebhookDelivery{Attempts: delivery.Attempts + 1, LastStatusCode: statusCode}
+	switch {
+	case err == nil:
+		update.Status = models.WEBHOOK_DELIVERY_SUCCEEDED
+		update.DeliveredAt = &now
+	case update.Attempts >= WEBHOOK_MAX_ATTEMPTS || errors.Is(err, errWebhookGone) || errors.Is(err, utils.ErrPrivateAddress):
+		update.Status = models.WEBHOOK_DELIVERY_DEAD
+		update.LastError = truncateRunes(err.Error(), WEBHOOK_MAX_ERROR_LEN)
+		utils.Logger.Warnf("Webhook delivery %d of event %s is dead after %d attempts: %v", delivery.ID, delivery.EventID, update.Attempts, err)
+	default:
+		delay := utils.Backoff(WEBHOOK_RETRY_BASE_DELAY, WEBHOOK_RETRY_MAX_DELAY, update.Attempts)
+		next := now.Add(delay + rand.N(delay/10+1))
+		update.Status = models.WEBHOOK_DELIVERY_PENDING
+		update.NextAttemptAt = &next
+		update.LastError = truncateRunes(err.Error(), WEBHOOK_MAX_ERROR_LEN)
+	}
+	if _, err := gorm.G[models.WebhookDelivery](db.PgSqlDB).
+		Where("id = ?", delivery.ID).
+		Select("status", "attempts", "next_attempt_at", "last_status_code", "last_error", "delivered_at", "updated_at").
+		Updates(context.WithoutCancel(ctx), update); err != nil {
+		utils.Logger.Errorf("Failed to record outcome of webhook delivery %d: %v", delivery.ID, err)
+	}
+}
+
+// Webhooks deleted or paused after their deliveries were queued
+var errWebhookGone = errors.New("webhook was deleted or paused")
+
+// send POSTs the event to the endpoint, signed with the webhook's secret. Only 2xx answers are a success.
+func (this *WebhookService) send(ctx context.Context, delivery *models.WebhookDelivery) (statusCode int, err error) {
+	webhook, err := gorm.G[models.Webhook](db.PgSqlDB).Where("id = ?", delivery.WebhookID).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && !webhook.Active {
+		return 0, errWebhookGone
+	} else if err != nil {
+		return 0, err
+	}
+	secret, err := utils.DecryptAPIKey(webhook.Secret)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decrypt webhook secret: %w", err)
+	}
+
+	payload := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "InfoWeaver-Webhook")
+	req.Header.Set(WEBHOOK_EVENT_HEADER, delivery.Event)
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, fmt.Sprint(delivery.ID))
+	req.Header.Set(WEBHOOK_SIGNATURE_HEADER, SignWebhookPayload(secret, time.Now().Unix(), payload))
+
+	resp, err := this.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// The answer is the endpoint's to show, only its status code is logged
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint answered status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// RotateWebhookSecrets encrypts again under the primary key of ENCRYPTION_KEYS the signing secrets
+// another key or the legacy key encrypted, deleted webhooks included
+func (this *WebhookService) RotateWebhookSecrets(ctx context.Context) (rotated int, err error) {
+	primaryID, err := utils.CheckEncryptionKeys()
+	if err != nil {
+		return 0, err
+	}
+	var webhooks []models.Webhook
+	if err := db.PgSqlDB.WithContext(ctx).Unscoped().Select("id", "secret").Order("id").Find(&webhooks).Error; err != nil {
+		return 0, err
+	}
+	for _, webhook := range slices.DeleteFunc(webhooks, func(webhook models.Webhook) bool { return !needsRotation(webhook.Secret, primaryID) }) {
+		secret, err := rotateSecretRef(webhook.Secret, primaryID)
+		if err != nil {
+			return rotated, fmt.Errorf("failed to re-encrypt secret of webhook %d: %w", webhook.ID, err)
+		}
+		result := db.PgSqlDB.WithContext(ctx).Unscoped().Model(&models.Webhook{}).
+			Where("id = ? AND secret = ?", webhook.ID, webhook.Secret).
+			UpdateColumn("secret", secret)
+		if result.Error != nil {
+			return rotated, result.Error
+		}
+		rotated += int(result.RowsAffected)
+	}
+	return rotated, nil
+}
@@ -0,0 +1,20 @@
+package tests
+
+import (
+	"server/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, utils.Backoff(5*time.Second, 5*time.Minute, 0))
+	assert.Equal(t, 5*time.Second, utils.Backoff(5*time.Second, 5*time.Minute, 1))
+	assert.Equal(t, 10*time.Second, utils.Backoff(5*time.Second, 5*time.Minute, 2))
+	assert.Equal(t, 80*time.Second, utils.Backoff(5*time.Second, 5*time.Minute, 5))
+	assert.Equal(t, 5*time.Minute, utils.Backoff(5*time.Second, 5*time.Minute, 7))
+	assert.Equal(t, 30*time.Second, utils.Backoff(time.Second, 30*time.Second, 1000))
+	// A base above the ceiling is capped
+	assert.Equal(t, time.Minute, utils.Backoff(2*time.Minute, time.Minute, 1))
+}
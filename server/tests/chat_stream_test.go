@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"server/models"
+	"server/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayChatStream(t *testing.T) {
+	// Fake RAG backend streaming newline-delimited JSON events
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req models.ChatBackendReq
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "what is milvus", req.Query)
+		assert.Equal(t, uint(7), req.DatasetID)
+		assert.Equal(t, "sk-test", req.LLMConfig.APIKey)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintln(w, `{"type":"thinking","content":"searching"}`)
+		fmt.Fprintln(w, `{"type":"source","content":"[{\"id\":101,\"content\":\"Milvus is\",\"score\":0.9},{\"id\":102,\"content\":\"a vector\",\"score\":0.8}]"}`)
+		fmt.Fprintln(w, `{"type":"text","content":"Milvus is "}`)
+		fmt.Fprintln(w, "not json")
+		fmt.Fprintln(w, `data: {"type":"text","content":"a vector database."}`)
+		fmt.Fprintln(w, `{"type":"usage","content":"{\"input_tokens\":120,\"output_tokens\":8}"}`)
+		fmt.Fprintln(w, `{"type":"usage","content":"{\"input_tokens\":30,\"output_tokens\":2}"}`)
+	}))
+	defer backend.Close()
+
+	var events []models.ChatStreamEvent
+	result, err := service.RelayChatStream(context.Background(), backend.Client(), backend.URL, models.ChatBackendReq{
+		Query:     "what is milvus",
+		DatasetID: 7,
+		LLMConfig: models.ChatBackendModelConfig{APIKey: "sk-test"},
+	}, func(event models.ChatStreamEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Milvus is a vector database.", result.Answer)
+	assert.Empty(t, result.Error)
+	// Usage is summed for the ledger and not relayed
+	if assert.NotNil(t, result.Usage) {
+		assert.Equal(t, int64(150), result.Usage.InputTokens)
+		assert.Equal(t, int64(10), result.Usage.OutputTokens)
+	}
+	if assert.Len(t, result.Sources, 2) {
+		assert.Equal(t, int64(101), result.Sources[0].ID)
+		assert.Equal(t, int64(102), result.Sources[1].ID)
+	}
+	if assert.Len(t, events, 4) {
+		assert.Equal(t, models.CHAT_EVENT_THINKING, events[0].Type)
+		assert.Equal(t, models.CHAT_EVENT_SOURCE, events[1].Type)
+		assert.Equal(t, models.CHAT_EVENT_TEXT, events[2].Type)
+		assert.Equal(t, models.CHAT_EVENT_TEXT, events[3].Type)
+	}
+}
+
+func TestRelayChatStreamCancel(t *testing.T) {
+	backendGone := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"type":"text","content":"Milvus"}`)
+		w.(http.Flusher).Flush()
+		// Keep streaming until the gateway hangs up
+		<-r.Context().Done()
+		close(backendGone)
+	}))
+	defer backend.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result, err := service.RelayChatStream(ctx, backend.Client(), backend.URL, models.ChatBackendReq{}, func(event models.ChatStreamEvent) error {
+		// The client disconnects after the first token
+		cancel()
+		return nil
+	})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	if assert.NotNil(t, result) {
+		assert.Equal(t, "Milvus", result.Answer)
+	}
+	select {
+	case <-backendGone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("backend request was not cancelled")
+	}
+}
+
+func TestRelayChatStreamBackendError(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not found", http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	_, err := service.RelayChatStream(context.Background(), backend.Client(), backend.URL, models.ChatBackendReq{}, func(event models.ChatStreamEvent) error {
+		t.Fatal("no event expected")
+		return nil
+	})
+	assert.ErrorIs(t, err, service.ErrChatBackendUnavailable)
+}
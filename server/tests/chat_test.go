@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"server/db"
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChatSessions(t *testing.T) {
+	connectTestDatabase(t)
+	fixture := createTestDataset(t, "https://api.example.com")
+	stranger := fixture.User.ID + 1
+	ctx := context.Background()
+
+	_, err := service.ChatServiceApp.CreateSession(ctx, stranger, fixture.Dataset.ID, "Not mine")
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	first, err := service.ChatServiceApp.CreateSession(ctx, fixture.User.ID, fixture.Dataset.ID, "First")
+	require.NoError(t, err)
+	assert.Equal(t, "First", first.Title)
+	assert.Equal(t, fixture.Dataset.ID, first.DatasetID)
+	second, err := service.ChatServiceApp.CreateSession(ctx, fixture.User.ID, fixture.Dataset.ID, "Second")
+	require.NoError(t, err)
+
+	sessionIDs := func(datasetID uint) []uint {
+		total, sessions, err := service.ChatServiceApp.ListSessions(ctx, fixture.User.ID, datasetID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(sessions)), total)
+		ids := []uint{}
+		for _, session := range sessions {
+			ids = append(ids, session.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []uint{second.ID, first.ID}, sessionIDs(0))
+	assert.Equal(t, []uint{second.ID, first.ID}, sessionIDs(fixture.Dataset.ID))
+	assert.Empty(t, sessionIDs(fixture.Dataset.ID+1))
+
+	// A new message brings its session back on top
+	_, err = service.ChatServiceApp.SaveMemory(ctx, first.ID, "What is Milvus?", "A vector database.", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{first.ID, second.ID}, sessionIDs(0))
+
+	assert.NoError(t, service.ChatServiceApp.RenameSession(ctx, first.ID, fixture.User.ID, "Renamed"))
+	assert.ErrorIs(t, service.ChatServiceApp.RenameSession(ctx, first.ID, stranger, "Stolen"), service.ErrNotFound)
+	session, err := service.ChatServiceApp.GetSession(ctx, first.ID, fixture.User.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", session.Title)
+	_, err = service.ChatServiceApp.GetSession(ctx, first.ID, stranger)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	// Deleting a session deletes its messages
+	assert.ErrorIs(t, service.ChatServiceApp.DeleteSession(ctx, first.ID, stranger), service.ErrNotFound)
+	require.NoError(t, service.ChatServiceApp.DeleteSession(ctx, first.ID, fixture.User.ID))
+	assert.Equal(t, []uint{second.ID}, sessionIDs(0))
+	var messages int64
+	require.NoError(t, db.PgSqlDB.Model(&models.Memory{}).Where("session_id = ?", first.ID).Count(&messages).Error)
+	assert.Zero(t, messages)
+	assert.ErrorIs(t, service.ChatServiceApp.DeleteSession(ctx, first.ID, fixture.User.ID), service.ErrNotFound)
+}
+
+func TestChatMessages(t *testing.T) {
+	connectTestDatabase(t)
+	fixture := createTestDataset(t, "https://api.example.com")
+	chunks := fixture.createTestChunks(t, "Milvus stores vectors.", "PostgreSQL stores rows.", "Removed passage.")
+	require.NoError(t, db.PgSqlDB.Delete(&chunks[2]).Error)
+	ctx := context.Background()
+
+	session, err := service.ChatServiceApp.CreateSession(ctx, fixture.User.ID, fixture.Dataset.ID, "Storage")
+	require.NoError(t, err)
+
+	// Deleted, unknown and repeated chunks are not cited
+	cited, err := service.ChatServiceApp.SaveMemory(ctx, session.ID, "Where are vectors kept?", "In Milvus.",
+		[]uint{chunks[0].ID, chunks[1].ID, chunks[2].ID, chunks[0].ID, 999999})
+	require.NoError(t, err)
+	second, err := service.ChatServiceApp.SaveMemory(ctx, session.ID, "And rows?", "In PostgreSQL.", []uint{chunks[1].ID})
+	require.NoError(t, err)
+	third, err := service.ChatServiceApp.SaveMemory(ctx, session.ID, "Thanks", "You are welcome.", nil)
+	require.NoError(t, err)
+
+	total, messages, err := service.ChatServiceApp.ListMessages(ctx, session.ID, fixture.User.ID, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, messages, 2)
+	assert.Equal(t, cited.ID, messages[0].ID)
+	assert.Equal(t, "Where are vectors kept?", messages[0].Question)
+	assert.Equal(t, "In Milvus.", messages[0].Answer)
+	assert.Nil(t, messages[0].Feedback)
+	assert.ElementsMatch(t, []models.ChatSource{
+		{ChunkID: chunks[0].ID, Content: chunks[0].Content, FileID: fixture.File.ID, FileName: fixture.File.Name, DatasetID: fixture.Dataset.ID},
+		{ChunkID: chunks[1].ID, Content: chunks[1].Content, FileID: fixture.File.ID, FileName: fixture.File.Name, DatasetID: fixture.Dataset.ID},
+	}, messages[0].Sources)
+	assert.Equal(t, second.ID, messages[1].ID)
+	assert.Len(t, messages[1].Sources, 1)
+
+	total, messages, err = service.ChatServiceApp.ListMessages(ctx, session.ID, fixture.User.ID, 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, messages, 1)
+	assert.Equal(t, third.ID, messages[0].ID)
+	assert.Empty(t, messages[0].Sources)
+
+	_, _, err = service.ChatServiceApp.ListMessages(ctx, session.ID, fixture.User.ID+1, 1, 2)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"server/models"
+	"server/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestDueClaimLock(t *testing.T) {
+	// Statements are only built, no database is reached
+	pg, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	claim := service.DueClaim{
+		Due:         "status = ? AND next_attempt_at <= ?",
+		Args:        []any{models.WEBHOOK_DELIVERY_PENDING, time.Now()},
+		Order:       "next_attempt_at",
+		Limit:       20,
+		LeaseColumn: "next_attempt_at",
+		Lease:       time.Minute,
+	}
+	sql := pg.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return claim.Lock(tx).Find(&[]models.WebhookDelivery{})
+	})
+	assert.Contains(t, sql, `WHERE status = 'pending' AND next_attempt_at <= `)
+	assert.Contains(t, sql, `ORDER BY next_attempt_at LIMIT 20 FOR UPDATE SKIP LOCKED`)
+
+	// Without a limit every due row is claimed
+	claim.Limit = 0
+	sql = pg.ToSQL(func(tx *gorm.DB) *gorm.DB {
+		return claim.Lock(tx).Find(&[]models.ReindexJob{})
+	})
+	assert.NotContains(t, sql, "LIMIT")
+	assert.Contains(t, sql, `"reindex_jobs"."deleted_at" IS NULL`)
+	assert.Contains(t, sql, "FOR UPDATE SKIP LOCKED")
+}
@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"encoding/json"
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCompletionModelID(t *testing.T) {
+	id := service.CompletionModelID(3, 5, "meta-llama/Llama-3.1-8B")
+	assert.Equal(t, "dataset-3/5/meta-llama/Llama-3.1-8B", id)
+
+	datasetID, providerID, model, err := service.ParseCompletionModelID(id)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), datasetID)
+	assert.Equal(t, uint(5), providerID)
+	assert.Equal(t, "meta-llama/Llama-3.1-8B", model)
+
+	for _, invalid := range []string{"gpt-4o", "dataset-3/5", "dataset-3/5/", "dataset-x/5/gpt-4o", "dataset-0/5/gpt-4o", "dataset-3/-1/gpt-4o"} {
+		_, _, _, err := service.ParseCompletionModelID(invalid)
+		assert.ErrorIs(t, err, service.ErrCompletionModelInvalid, invalid)
+	}
+}
+
+func TestOpenAIChatCompletionReqUnmarshal(t *testing.T) {
+	var req models.OpenAIChatCompletionReq
+	err := json.Unmarshal([]byte(`{
+		"model": "dataset-1/2/gpt-4o",
+		"stop": "END",
+		"messages": [
+			{"role": "system", "content": null},
+			{"role": "user", "content": "what is milvus"},
+			{"role": "user", "content": [{"type": "text", "text": "a"}, {"type": "image_url", "image_url": {}}, {"type": "text", "text": "b"}]}
+		]
+	}`), &req)
+	assert.NoError(t, err)
+	assert.Equal(t, models.OpenAIStop{"END"}, req.Stop)
+	if assert.Len(t, req.Messages, 3) {
+		assert.Equal(t, models.OpenAIMessageContent(""), req.Messages[0].Content)
+		assert.Equal(t, models.OpenAIMessageContent("what is milvus"), req.Messages[1].Content)
+		assert.Equal(t, models.OpenAIMessageContent("a\nb"), req.Messages[2].Content)
+	}
+
+	assert.NoError(t, json.Unmarshal([]byte(`{"stop": ["a", "b"]}`), &req))
+	assert.Equal(t, models.OpenAIStop{"a", "b"}, req.Stop)
+}
@@ -1 +1,121 @@
 package tests
+
+import (
+	"fmt"
+	"os"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/utils"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests using connectTestDatabase need a PostgreSQL server accepting postgres/postgres on the default port
+// of the host named by POSTGRES_TEST_HOST. Every test gets a database of its own. They are skipped without one.
+func connectTestDatabase(t *testing.T) {
+	host := os.Getenv("POSTGRES_TEST_HOST")
+	if host == "" {
+		t.Skip("POSTGRES_TEST_HOST is not set")
+	}
+	cfg := &config.Config{
+		JWT_SIGNING_KEY:        "signing-key",
+		POSTGRES_HOST:          host,
+		POSTGRES_PORT:          5432,
+		POSTGRES_USERNAME:      "postgres",
+		POSTGRES_PASSWORD:      "postgres",
+		POSTGRES_DB:            "postgres",
+		MILVUS_COLLECTION_NAME: "test_chunks",
+	}
+	server, err := db.ConnectPgSqlDB(cfg)
+	require.NoError(t, err)
+	name := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	require.NoError(t, server.Exec("CREATE DATABASE "+name).Error)
+
+	cfg.POSTGRES_DB = name
+	database, err := db.ConnectPgSqlDB(cfg)
+	require.NoError(t, err)
+	previous, previousDB := config.Settings, db.PgSqlDB
+	config.Settings, db.PgSqlDB = cfg, database
+	t.Cleanup(func() {
+		config.Settings, db.PgSqlDB = previous, previousDB
+		if sqlDB, err := database.DB(); err == nil {
+			sqlDB.Close()
+		}
+		server.Exec("DROP DATABASE " + name + " WITH (FORCE)")
+		if sqlDB, err := server.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	require.NoError(t, db.MigratePgSqlDB(database))
+}
+
+// connectTestRedis points db.RedisClient at an in-memory Redis, whose clock stands still at start
+func connectTestRedis(t *testing.T, start time.Time) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+	server.SetTime(start)
+	previous := db.RedisClient
+	db.RedisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		db.RedisClient.Close()
+		db.RedisClient = previous
+	})
+	return server
+}
+
+// testDataset is a dataset of an administrator, embedded by a provider at baseURL, with one file
+type testDataset struct {
+	User     models.User
+	Provider models.Provider
+	Dataset  models.Dataset
+	File     models.File
+}
+
+func createTestDataset(t *testing.T, baseURL string) *testDataset {
+	apiKey, err := utils.EncryptAPIKey("sk-test")
+	require.NoError(t, err)
+
+	fixture := &testDataset{}
+	fixture.User = models.User{Username: "admin", Email: "admin@example.com", Password: "-", Role: "admin"}
+	require.NoError(t, db.PgSqlDB.Create(&fixture.User).Error)
+	fixture.Provider = models.Provider{
+		Name:    "stub",
+		Mode:    models.PROVIDER_MODE_OPENAI_COMPATIBLE,
+		BaseURL: baseURL,
+		APIKey:  apiKey,
+		OwnerID: fixture.User.ID,
+	}
+	require.NoError(t, db.PgSqlDB.Create(&fixture.Provider).Error)
+	fixture.Dataset = models.Dataset{
+		Name:           "docs",
+		SearchType:     models.SEARCH_TYPE_DENSE,
+		EmbeddingModel: "embed-small",
+		ProviderID:     fixture.Provider.ID,
+		OwnerID:        fixture.User.ID,
+	}
+	require.NoError(t, db.PgSqlDB.Create(&fixture.Dataset).Error)
+	fixture.File = models.File{
+		Name:      "guide.pdf",
+		MinioPath: fmt.Sprintf("test/%d/guide.pdf", fixture.Dataset.ID),
+		Size:      1,
+		Type:      "application/pdf",
+		DatasetID: fixture.Dataset.ID,
+		UserID:    fixture.User.ID,
+	}
+	require.NoError(t, db.PgSqlDB.Create(&fixture.File).Error)
+	return fixture
+}
+
+// createTestChunks adds chunks with the given contents to the file of the dataset
+func (this *testDataset) createTestChunks(t *testing.T, contents ...string) []models.Chunk {
+	chunks := make([]models.Chunk, len(contents))
+	for i, content := range contents {
+		chunks[i] = models.Chunk{Content: content, VectorID: fmt.Sprintf("%d-%d", time.Now().UnixNano(), i), FileID: this.File.ID}
+	}
+	require.NoError(t, db.PgSqlDB.Create(&chunks).Error)
+	return chunks
+}
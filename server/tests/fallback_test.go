@@ -0,0 +1,28 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldFailover(t *testing.T) {
+	defaults := &models.FallbackChain{}
+	assert.True(t, service.ShouldFailover(defaults, &openai.Error{StatusCode: 503}))
+	assert.True(t, service.ShouldFailover(defaults, &openai.Error{StatusCode: 429}))
+	assert.True(t, service.ShouldFailover(defaults, fmt.Errorf("embed: %w", context.DeadlineExceeded)))
+	assert.False(t, service.ShouldFailover(defaults, &openai.Error{StatusCode: 401}))
+	assert.False(t, service.ShouldFailover(defaults, &openai.Error{StatusCode: 400}))
+	assert.False(t, service.ShouldFailover(defaults, context.Canceled))
+	assert.False(t, service.ShouldFailover(defaults, nil))
+
+	// A chain listing its own classes replaces the defaults
+	authOnly := &models.FallbackChain{FailoverOn: []string{models.PROVIDER_ERROR_AUTH}}
+	assert.True(t, service.ShouldFailover(authOnly, &openai.Error{StatusCode: 401}))
+	assert.False(t, service.ShouldFailover(authOnly, &openai.Error{StatusCode: 503}))
+}
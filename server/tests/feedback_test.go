@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"server/db"
+	"server/models"
+	"server/service"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmitFeedback(t *testing.T) {
+	connectTestDatabase(t)
+	fixture := createTestDataset(t, "https://api.example.com")
+	chunks := fixture.createTestChunks(t, "Milvus stores vectors.", "PostgreSQL stores rows.", "MinIO stores files.")
+	ctx := context.Background()
+	session, err := service.ChatServiceApp.CreateSession(ctx, fixture.User.ID, fixture.Dataset.ID, "Storage")
+	require.NoError(t, err)
+	answer, err := service.ChatServiceApp.SaveMemory(ctx, session.ID, "Where are vectors kept?", "In PostgreSQL.", []uint{chunks[0].ID, chunks[1].ID})
+	require.NoError(t, err)
+	flagged := func() []uint {
+		feedback, err := service.FeedbackServiceApp.ListFeedback(ctx, []uint{answer.ID})
+		require.NoError(t, err)
+		return feedback[answer.ID].WrongChunkIDs
+	}
+
+	_, err = service.FeedbackServiceApp.SubmitFeedback(ctx, answer.ID, fixture.User.ID, models.FEEDBACK_RATING_UP, "", []uint{chunks[0].ID})
+	assert.ErrorIs(t, err, service.ErrWrongChunksOnPositiveFeedback)
+	_, err = service.FeedbackServiceApp.SubmitFeedback(ctx, answer.ID, fixture.User.ID+1, models.FEEDBACK_RATING_DOWN, "", nil)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	first, err := service.FeedbackServiceApp.SubmitFeedback(ctx, answer.ID, fixture.User.ID, models.FEEDBACK_RATING_DOWN, "Wrong store", []uint{chunks[1].ID})
+	require.NoError(t, err)
+	assert.Equal(t, answer.ID, first.MessageID)
+	assert.Equal(t, []uint{chunks[1].ID}, first.WrongChunkIDs)
+
+	// A chunk the answer does not cite cannot be flagged, and the previous rating stays
+	_, err = service.FeedbackServiceApp.SubmitFeedback(ctx, answer.ID, fixture.User.ID, models.FEEDBACK_RATING_DOWN, "", []uint{chunks[0].ID, chunks[2].ID})
+	assert.ErrorIs(t, err, service.ErrChunkNotCited)
+	assert.Equal(t, []uint{chunks[1].ID}, flagged())
+
+	// Submitting again replaces the rating and its flags
+	second, err := service.FeedbackServiceApp.SubmitFeedback(ctx, answer.ID, fixture.User.ID, models.FEEDBACK_RATING_DOWN, "Both wrong", []uint{chunks[1].ID, chunks[0].ID, chunks[1].ID})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+	assert.True(t, first.CreatedAt.Equal(second.CreatedAt))
+	assert.Equal(t, "Both wrong", second.Reason)
+	assert.Equal(t, []uint{chunks[0].ID, chunks[1].ID}, second.WrongChunkIDs)
+	assert.Equal(t, []uint{chunks[0].ID, chunks[1].ID}, flagged())
+
+	third, err := service.FeedbackServiceApp.SubmitFeedback(ctx, answer.ID, fixture.User.ID, models.FEEDBACK_RATING_UP, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, third.ID)
+	assert.Equal(t, models.FEEDBACK_RATING_UP, third.Rating)
+	assert.Empty(t, flagged())
+
+	require.NoError(t, service.FeedbackServiceApp.DeleteFeedback(ctx, answer.ID, fixture.User.ID))
+	assert.ErrorIs(t, service.FeedbackServiceApp.DeleteFeedback(ctx, answer.ID, fixture.User.ID), service.ErrNotFound)
+}
+
+func TestSubmitFeedbackConcurrently(t *testing.T) {
+	connectTestDatabase(t)
+	fixture := createTestDataset(t, "https://api.example.com")
+	chunks := fixture.createTestChunks(t, "Milvus stores vectors.")
+	ctx := context.Background()
+	session, err := service.ChatServiceApp.CreateSession(ctx, fixture.User.ID, fixture.Dataset.ID, "Storage")
+	require.NoError(t, err)
+	answer, err := service.ChatServiceApp.SaveMemory(ctx, session.ID, "Where are vectors kept?", "In Milvus.", []uint{chunks[0].ID})
+	require.NoError(t, err)
+
+	// Every first submission succeeds, one rating is kept
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			_, err := service.FeedbackServiceApp.SubmitFeedback(ctx, answer.ID, fixture.User.ID, models.FEEDBACK_RATING_DOWN, "", []uint{chunks[0].ID})
+			assert.NoError(t, err)
+		})
+	}
+	wg.Wait()
+
+	var ratings int64
+	require.NoError(t, db.PgSqlDB.Model(&models.Feedback{}).Where("memory_id = ?", answer.ID).Count(&ratings).Error)
+	assert.Equal(t, int64(1), ratings)
+	feedback, err := service.FeedbackServiceApp.ListFeedback(ctx, []uint{answer.ID})
+	require.NoError(t, err)
+	assert.Equal(t, []uint{chunks[0].ID}, feedback[answer.ID].WrongChunkIDs)
+}
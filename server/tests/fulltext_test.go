@@ -0,0 +1,27 @@
+package tests
+
+import (
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildTSQuery(t *testing.T) {
+	cases := map[string]string{
+		"milvus index":              "milvus & index",
+		`"vector database" milvus`:  "(vector <-> database) & milvus",
+		"embed*":                    "embed:*",
+		"milvus -elastic":           "milvus & !elastic",
+		"milvus OR qdrant index":    "(milvus | qdrant) & index",
+		`-"full text"`:              "!(full <-> text)",
+		"e-mail client's":           "(e <-> mail) & (client <-> s)",
+		"OR milvus OR":              "milvus",
+		"'); DROP TABLE chunks; --": "DROP & TABLE & chunks",
+		"  ":                        "",
+		"向量 检索":                     "向量 & 检索",
+	}
+	for query, expected := range cases {
+		assert.Equal(t, expected, service.BuildTSQuery(query), query)
+	}
+}
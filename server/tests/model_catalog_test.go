@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyModel(t *testing.T) {
+	cases := map[string]models.ModelInfo{
+		"text-embedding-3-large":    {Types: []string{models.MODEL_TYPE_EMBEDDING}, Dimension: 3072, ContextLength: 8191},
+		"BAAI/bge-m3":               {Types: []string{models.MODEL_TYPE_EMBEDDING}, Dimension: 1024, ContextLength: 8192},
+		"nomic-embed-text:latest":   {Types: []string{models.MODEL_TYPE_EMBEDDING}, Dimension: 768, ContextLength: 8192},
+		"my-custom-embedder":        {Types: []string{models.MODEL_TYPE_EMBEDDING}},
+		"gemini-embedding-exp":      {Types: []string{models.MODEL_TYPE_EMBEDDING}},
+		"bge-reranker-v2-m3":        {Types: []string{models.MODEL_TYPE_RERANK}, ContextLength: 8192},
+		"gpt-4o-mini":               {Types: []string{models.MODEL_TYPE_CHAT, models.MODEL_TYPE_VISION}, ContextLength: 128000},
+		"gpt-4o-mini-tts":           {Types: []string{}},
+		"whisper-1":                 {Types: []string{}},
+		"o3-mini":                   {Types: []string{models.MODEL_TYPE_CHAT}, ContextLength: 200000},
+		"qwen2.5-vl-7b":             {Types: []string{models.MODEL_TYPE_CHAT, models.MODEL_TYPE_VISION}, ContextLength: 32768},
+		"some-unknown-chat-model":   {Types: []string{models.MODEL_TYPE_CHAT}},
+		"llama3.2-vision:11b-q4_0":  {Types: []string{models.MODEL_TYPE_CHAT, models.MODEL_TYPE_VISION}, ContextLength: 131072},
+		"meta-llama/llama3.1-8b-it": {Types: []string{models.MODEL_TYPE_CHAT}, ContextLength: 131072},
+	}
+	for id, expected := range cases {
+		info := models.ModelInfo{ID: id}
+		service.ClassifyModel(&info)
+		expected.ID = id
+		assert.Equal(t, expected, info, id)
+	}
+}
+
+func TestClassifyModelKeepsProviderMetadata(t *testing.T) {
+	// Types and sizes reported by the provider are not overridden by the table
+	info := models.ModelInfo{ID: "nomic-embed-text", Types: []string{models.MODEL_TYPE_EMBEDDING}, Dimension: 512, ContextLength: 2048}
+	service.ClassifyModel(&info)
+	assert.Equal(t, 512, info.Dimension)
+	assert.Equal(t, 2048, info.ContextLength)
+
+	// Empty types mean the provider knows the model is neither chat nor embedding
+	info = models.ModelInfo{ID: "gemini-2.5-flash-preview-tts", Types: []string{}}
+	service.ClassifyModel(&info)
+	assert.Empty(t, info.Types)
+	assert.Zero(t, info.ContextLength)
+}
+
+func TestCheckEmbeddingModel(t *testing.T) {
+	connectTestDatabase(t)
+	embedders := map[string]bool{"multilingual-e5-large": true, "prod-vectors": true}
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/models" {
+			json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": []map[string]any{
+				{"id": "multilingual-e5-large", "object": "model"},
+				{"id": "prod-vectors", "object": "model"},
+				{"id": "prod-assistant", "object": "model"},
+				{"id": "gpt-4o-mini", "object": "model"},
+			}})
+			return
+		}
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if !embedders[req.Model] && req.Model != "gpt-4o-mini" {
+			http.Error(w, `{"error":{"message":"model does not support embeddings"}}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"object": "list",
+			"model":  req.Model,
+			"data":   []map[string]any{{"object": "embedding", "index": 0, "embedding": []float32{1, 0, 0}}},
+			"usage":  map[string]any{"prompt_tokens": 1, "total_tokens": 1},
+		})
+	}))
+	t.Cleanup(stub.Close)
+	fixture := createTestDataset(t, stub.URL)
+
+	cases := map[string]error{
+		"embed-small":           nil,                          // Unlisted, classified by name
+		"multilingual-e5-large": nil,                          // Unknown name, embeds
+		"prod-vectors":          nil,                          // Deployment name, embeds
+		"prod-assistant":        service.ErrNotEmbeddingModel, // Unknown name, fails to embed
+		"gpt-4o-mini":           service.ErrNotEmbeddingModel, // Known chat model, not asked
+	}
+	for model, expected := range cases {
+		err := service.ProviderServiceApp.CheckEmbeddingModel(context.Background(), fixture.Provider.ID, fixture.User.ID, model)
+		if expected == nil {
+			assert.NoError(t, err, model)
+		} else {
+			assert.ErrorIs(t, err, expected, model)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	cases := map[string]bool{
+		"8.8.8.8":         true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"0.0.0.0":         false,
+		"100.64.0.1":      false,
+		"::ffff:10.0.0.1": false,
+		"64:ff9b::a00:1":  false,
+	}
+	for ip, public := range cases {
+		assert.Equal(t, public, utils.IsPublicIP(net.ParseIP(ip), nil), ip)
+	}
+
+	allowed := (&config.Config{OUTBOUND_ALLOWED_NETWORKS: "10.0.5.0/24, invalid"}).GetOutboundAllowedNetworks()
+	assert.Len(t, allowed, 1)
+	assert.True(t, utils.IsPublicIP(net.ParseIP("10.0.5.7"), allowed))
+	assert.False(t, utils.IsPublicIP(net.ParseIP("10.0.6.7"), allowed))
+}
+
+func TestCheckPublicURL(t *testing.T) {
+	ctx := context.Background()
+	assert.ErrorIs(t, utils.CheckPublicURL(ctx, "http://127.0.0.1:11434", nil), utils.ErrPrivateAddress)
+	assert.ErrorIs(t, utils.CheckPublicURL(ctx, "http://localhost/hook", nil), utils.ErrPrivateAddress)
+	assert.ErrorIs(t, utils.CheckPublicURL(ctx, "http://[::1]/hook", nil), utils.ErrPrivateAddress)
+	assert.ErrorIs(t, utils.CheckPublicURL(ctx, "/relative", nil), utils.ErrPrivateAddress)
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	assert.NoError(t, utils.CheckPublicURL(ctx, "http://127.0.0.1:11434", []*net.IPNet{loopback}))
+}
+
+func TestPublicDialer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: utils.PublicDialer(time.Second, nil).DialContext}}
+	_, err := client.Get(server.URL)
+	assert.ErrorIs(t, err, utils.ErrPrivateAddress)
+
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	client = &http.Client{Transport: &http.Transport{DialContext: utils.PublicDialer(time.Second, []*net.IPNet{loopback}).DialContext}}
+	resp, err := client.Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
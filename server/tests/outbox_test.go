@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLifecycleEventOutbox(t *testing.T) {
+	previous := config.Settings
+	t.Cleanup(func() { config.Settings = previous })
+	config.Settings = &config.Config{}
+
+	outboxEvent, err := service.EventServiceApp.Outbox(models.LifecycleEvent{Event: models.EVENT_DATASET_CREATED, UserID: 3, DatasetID: 7})
+	assert.NoError(t, err)
+	assert.Equal(t, "info-weaver-events", outboxEvent.Exchange)
+	assert.Equal(t, models.EVENT_DATASET_CREATED, outboxEvent.RoutingKey)
+	assert.NotNil(t, outboxEvent.AvailableAt)
+	assert.Nil(t, outboxEvent.SentAt)
+
+	var event models.LifecycleEvent
+	assert.NoError(t, json.Unmarshal([]byte(outboxEvent.Payload), &event))
+	assert.NotEmpty(t, event.ID)
+	assert.False(t, event.Timestamp.IsZero())
+	assert.Equal(t, uint(7), event.DatasetID)
+}
+
+func TestPublishOutboxEvents(t *testing.T) {
+	events := []models.OutboxEvent{{ID: 1}, {ID: 2, Attempts: 2}, {ID: 3}, {ID: 4}}
+	var published []uint
+	outcomes, handedBack := service.PublishOutboxEvents(context.Background(), events, func(ctx context.Context, event *models.OutboxEvent) error {
+		published = append(published, event.ID)
+		if event.ID == 2 {
+			return db.ErrMessageReturned
+		}
+		return nil
+	})
+	// The failure stops the batch, the rest goes back to the outbox unattempted
+	assert.Equal(t, []uint{1, 2}, published)
+	assert.Equal(t, []uint{3, 4}, handedBack)
+	if assert.Len(t, outcomes, 2) {
+		assert.Equal(t, uint(1), outcomes[0].ID)
+		assert.Equal(t, 1, outcomes[0].Attempts)
+		assert.NotNil(t, outcomes[0].SentAt)
+
+		failed := outcomes[1]
+		assert.Equal(t, 3, failed.Attempts)
+		assert.Nil(t, failed.SentAt)
+		assert.Contains(t, failed.LastError, db.ErrMessageReturned.Error())
+		// Held back by the backoff of the third attempt
+		if assert.NotNil(t, failed.AvailableAt) {
+			assert.WithinDuration(t, time.Now().Add(20*time.Second), *failed.AvailableAt, 2*time.Second)
+		}
+	}
+}
+
+func TestPublishOutboxEventsShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events := []models.OutboxEvent{{ID: 1}, {ID: 2}, {ID: 3}}
+	outcomes, handedBack := service.PublishOutboxEvents(ctx, events, func(ctx context.Context, event *models.OutboxEvent) error {
+		if event.ID == 2 {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	})
+	// The event cut off keeps its lease, so does the rest of the batch
+	if assert.Len(t, outcomes, 1) {
+		assert.Equal(t, uint(1), outcomes[0].ID)
+	}
+	assert.Empty(t, handedBack)
+}
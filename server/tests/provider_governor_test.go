@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"server/models"
+	"server/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestRetryAfterDelay(t *testing.T) {
+	cases := map[string]struct {
+		headers  map[string]string
+		expected time.Duration
+		ok       bool
+	}{
+		"seconds":      {map[string]string{"Retry-After": "3"}, 3 * time.Second, true},
+		"fraction":     {map[string]string{"Retry-After": "0.5"}, 500 * time.Millisecond, true},
+		"milliseconds": {map[string]string{"Retry-After-Ms": "250", "Retry-After": "1"}, 250 * time.Millisecond, true},
+		"past date":    {map[string]string{"Retry-After": "Wed, 21 Oct 2015 07:28:00 GMT"}, 0, true},
+		"missing":      {map[string]string{}, 0, false},
+		"invalid":      {map[string]string{"Retry-After": "soon"}, 0, false},
+	}
+	for name, c := range cases {
+		resp := &http.Response{Header: http.Header{}}
+		for key, value := range c.headers {
+			resp.Header.Set(key, value)
+		}
+		delay, ok := service.RetryAfterDelay(resp)
+		assert.Equal(t, c.ok, ok, name)
+		assert.Equal(t, c.expected, delay, name)
+	}
+
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Retry-After", time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	delay, ok := service.RetryAfterDelay(resp)
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute.Seconds(), delay.Seconds(), 2)
+}
+
+func TestGovernorConcurrency(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	server := connectTestRedis(t, start)
+	ctx := context.Background()
+	provider := &models.Provider{Model: gorm.Model{ID: 1}, MaxConcurrency: 2}
+	acquire := func(slot string) int64 {
+		wait, err := service.TryAcquireProviderBudget(ctx, provider, 0, slot)
+		require.NoError(t, err)
+		return wait
+	}
+
+	assert.Equal(t, int64(0), acquire("a"))
+	assert.Equal(t, int64(0), acquire("b"))
+	assert.Equal(t, int64(-1), acquire("c"))
+
+	// Slots of calls lost with their replica are freed once their lease ends
+	server.SetTime(start.Add(service.PROVIDER_GOVERNOR_SLOT_LEASE + time.Millisecond))
+	assert.Equal(t, int64(0), acquire("c"))
+}
+
+func TestGovernorBuckets(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	server := connectTestRedis(t, start)
+	ctx := context.Background()
+	acquire := func(provider *models.Provider, at time.Duration, tokens int64) int64 {
+		server.SetTime(start.Add(at))
+		wait, err := service.TryAcquireProviderBudget(ctx, provider, tokens, fmt.Sprint("slot-", at))
+		require.NoError(t, err)
+		return wait
+	}
+
+	// Requests refill continuously, one every 30 seconds at 2 per minute
+	provider := &models.Provider{Model: gorm.Model{ID: 1}, RequestsPerMinute: 2}
+	assert.Equal(t, int64(0), acquire(provider, 0, 0))
+	assert.Equal(t, int64(0), acquire(provider, 0, 0))
+	assert.Equal(t, int64(30000), acquire(provider, 0, 0))
+	assert.Equal(t, int64(15000), acquire(provider, 15*time.Second, 0))
+	assert.Equal(t, int64(0), acquire(provider, 30*time.Second, 0))
+
+	// Tokens are taken by the size of the call
+	provider = &models.Provider{Model: gorm.Model{ID: 2}, TokensPerMinute: 1000}
+	assert.Equal(t, int64(0), acquire(provider, 0, 600))
+	assert.Equal(t, int64(12000), acquire(provider, 0, 600))
+
+	// A call larger than the budget of a minute waits for a full bucket rather than forever
+	assert.Equal(t, int64(36000), acquire(provider, 0, 5000))
+	assert.Equal(t, int64(0), acquire(provider, 36*time.Second, 5000))
+	assert.Equal(t, int64(60000), acquire(provider, 36*time.Second, 5000))
+
+	// The request is only taken along with the tokens
+	provider = &models.Provider{Model: gorm.Model{ID: 3}, RequestsPerMinute: 10, TokensPerMinute: 100}
+	assert.Equal(t, int64(0), acquire(provider, 0, 100))
+	assert.Equal(t, int64(30000), acquire(provider, 0, 50))
+	level := server.HGet(fmt.Sprintf(service.PROVIDER_GOVERNOR_REQUESTS_KEY, 3), "level")
+	assert.Equal(t, "9", level)
+}
+
+func TestGovernorTransport(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	server := connectTestRedis(t, start)
+	status, retryAfter := http.StatusOK, ""
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "{}")
+	}))
+	t.Cleanup(stub.Close)
+	provider := &models.Provider{Model: gorm.Model{ID: 1}, MaxConcurrency: 1}
+	transport := service.NewGovernorTransport(http.DefaultTransport, provider)
+	call := func(ctx context.Context) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, stub.URL, nil)
+		require.NoError(t, err)
+		return transport.RoundTrip(req)
+	}
+	slotsKey := fmt.Sprintf(service.PROVIDER_GOVERNOR_SLOTS_KEY, 1)
+	pauseKey := fmt.Sprintf(service.PROVIDER_GOVERNOR_PAUSE_KEY, 1)
+
+	// The slot is held until the answer is closed
+	resp, err := call(context.Background())
+	require.NoError(t, err)
+	members, _ := server.ZMembers(slotsKey)
+	assert.Len(t, members, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = call(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, resp.Body.Close())
+	members, _ = server.ZMembers(slotsKey)
+	assert.Empty(t, members)
+	resp, err = call(context.Background())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// A 429 pauses every caller for its Retry-After, a second when it has none, and a minute at most
+	status = http.StatusTooManyRequests
+	for _, c := range []struct {
+		retryAfter string
+		pause      time.Duration
+	}{{"2", 2 * time.Second}, {"", service.PROVIDER_GOVERNOR_DEFAULT_PAUSE}, {"3600", service.PROVIDER_GOVERNOR_MAX_PAUSE}} {
+		server.Del(pauseKey)
+		retryAfter = c.retryAfter
+		resp, err = call(context.Background())
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+		assert.Equal(t, c.pause, server.TTL(pauseKey), c.retryAfter)
+	}
+	wait, err := service.TryAcquireProviderBudget(context.Background(), provider, 0, "other")
+	require.NoError(t, err)
+	assert.Equal(t, service.PROVIDER_GOVERNOR_MAX_PAUSE.Milliseconds(), wait)
+	server.FastForward(service.PROVIDER_GOVERNOR_MAX_PAUSE)
+	wait, err = service.TryAcquireProviderBudget(context.Background(), provider, 0, "other")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), wait)
+}
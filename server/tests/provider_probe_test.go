@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+	"github.com/openai/openai-go/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genai"
+)
+
+func TestClassifyProviderError(t *testing.T) {
+	cases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"openai 401":   {fmt.Errorf("failed to list OpenAI models: %w", &openai.Error{StatusCode: 401}), models.PROVIDER_ERROR_AUTH},
+		"openai 404":   {fmt.Errorf("%w: %w", service.ErrLLMRequestFailed, &openai.Error{StatusCode: 404}), models.PROVIDER_ERROR_NOT_FOUND},
+		"gemini 429":   {genai.APIError{Code: 429}, models.PROVIDER_ERROR_RATE_LIMIT},
+		"ollama 500":   {api.StatusError{StatusCode: 500}, models.PROVIDER_ERROR_SERVER},
+		"ollama 400":   {api.StatusError{StatusCode: 400}, models.PROVIDER_ERROR_BAD_REQUEST},
+		"dns":          {&net.OpError{Op: "dial", Err: &net.DNSError{Name: "api.invalid", IsNotFound: true}}, models.PROVIDER_ERROR_DNS},
+		"tls":          {fmt.Errorf("Get: %w", x509.UnknownAuthorityError{}), models.PROVIDER_ERROR_TLS},
+		"timeout":      {fmt.Errorf("list: %w", context.DeadlineExceeded), models.PROVIDER_ERROR_TIMEOUT},
+		"refused":      {&net.OpError{Op: "dial", Err: errors.New("connection refused")}, models.PROVIDER_ERROR_CONNECTION},
+		"unrecognized": {errors.New("boom"), models.PROVIDER_ERROR_UNKNOWN},
+		// Errors relayed as text by the RAG backend
+		"text 429":      {errors.New("Error code: 429 - {'error': {'message': 'Rate limit reached'}}"), models.PROVIDER_ERROR_RATE_LIMIT},
+		"text 502":      {fmt.Errorf("%w: status 502: upstream", service.ErrChatBackendFailed), models.PROVIDER_ERROR_SERVER},
+		"text 401":      {errors.New("Error code: 401 - invalid api key"), models.PROVIDER_ERROR_AUTH},
+		"text timeout":  {errors.New("Request timed out."), models.PROVIDER_ERROR_TIMEOUT},
+		"text refused":  {errors.New("Connection error: connection refused"), models.PROVIDER_ERROR_CONNECTION},
+		"text overload": {errors.New("Anthropic API is overloaded"), models.PROVIDER_ERROR_SERVER},
+	}
+	for name, c := range cases {
+		assert.Equal(t, c.expected, service.ClassifyProviderError(c.err), name)
+	}
+}
+
+func TestUserProviderAddresses(t *testing.T) {
+	connectTestDatabase(t)
+	ctx := context.Background()
+	fixture := createTestDataset(t, "https://api.example.com/v1")
+	user := models.User{Username: "user", Email: "user@example.com", Password: "-", Role: "user"}
+	require.NoError(t, db.PgSqlDB.Create(&user).Error)
+
+	// A user's provider may neither point at nor proxy through the server's own network
+	for _, settings := range []struct{ baseURL, proxyURL string }{
+		{"http://127.0.0.1:11434/v1", ""},
+		{"http://169.254.169.254/v1", ""},
+		{"https://api.example.com/v1", "http://10.0.0.1:3128"},
+	} {
+		err := service.ProviderServiceApp.CreateProvider(ctx, user.ID, "local", settings.baseURL, "sk-test", models.PROVIDER_MODE_OPENAI_COMPATIBLE,
+			models.ProviderSettings{ProxyURL: settings.proxyURL})
+		assert.ErrorIs(t, err, service.ErrProviderAddressRefused, settings.baseURL)
+	}
+	err := service.ProviderServiceApp.UpdateProvider(ctx, fixture.Provider.ID, fixture.User.ID, "stub", "http://127.0.0.1:11434/v1", "", models.PROVIDER_MODE_OPENAI_COMPATIBLE,
+		models.ProviderSettings{})
+	assert.NoError(t, err, "administrators may reach any address")
+
+	// Networks allowed by the operator are reachable
+	config.Settings.OUTBOUND_ALLOWED_NETWORKS = "127.0.0.0/8"
+	err = service.ProviderServiceApp.CreateProvider(ctx, user.ID, "local", "http://127.0.0.1:11434/v1", "sk-test", models.PROVIDER_MODE_OPENAI_COMPATIBLE,
+		models.ProviderSettings{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestGrantedModels(t *testing.T) {
+	shared := &models.Provider{Model: gorm.Model{ID: 7}, OwnerID: 1, Shared: true}
+	user, other := uint(2), uint(3)
+
+	authorize := func(grants []models.ProviderGrant, model string) error {
+		grantedModels, ok := service.GrantedModels(shared, user, grants)
+		return service.CheckGrantedModel(shared, grantedModels, ok, model)
+	}
+	toUser := []models.ProviderGrant{{ProviderID: 7, UserID: &user, Models: []string{"gpt-4o-mini"}}}
+	assert.NoError(t, authorize(toUser, "gpt-4o-mini"))
+	assert.ErrorIs(t, authorize(toUser, "gpt-4o"), service.ErrModelNotGranted)
+
+	// A revoked grant leaves none, and grants to someone else do not count
+	assert.ErrorIs(t, authorize(nil, "gpt-4o-mini"), service.ErrModelNotGranted)
+	assert.ErrorIs(t, authorize([]models.ProviderGrant{{ProviderID: 7, UserID: &other}}, "gpt-4o-mini"), service.ErrModelNotGranted)
+
+	// A grant to every user adds up with the user's own, and one without models allows all
+	toEveryone := []models.ProviderGrant{{ProviderID: 7, Models: []string{"gpt-4o"}}}
+	grantedModels, ok := service.GrantedModels(shared, user, append(toEveryone, toUser...))
+	assert.True(t, ok)
+	assert.ElementsMatch(t, []string{"gpt-4o", "gpt-4o-mini"}, grantedModels)
+	grantedModels, ok = service.GrantedModels(shared, user, []models.ProviderGrant{{ProviderID: 7}})
+	assert.True(t, ok)
+	assert.Nil(t, grantedModels)
+	assert.NoError(t, authorize([]models.ProviderGrant{{ProviderID: 7}}, "anything"))
+
+	// The owner uses every model, other users' own providers are not found
+	grantedModels, ok = service.GrantedModels(shared, 1, nil)
+	assert.True(t, ok)
+	assert.Nil(t, grantedModels)
+	private := &models.Provider{Model: gorm.Model{ID: 8}, OwnerID: other}
+	grantedModels, ok = service.GrantedModels(private, user, nil)
+	assert.ErrorIs(t, service.CheckGrantedModel(private, grantedModels, ok, "gpt-4o"), service.ErrNotFound)
+}
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/models"
+	"server/service"
+	"server/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// providerAt returns an unsaved provider of the mode at a test server, reachable through the guarded transport
+func providerAt(t *testing.T, mode string, baseURL string) *models.Provider {
+	previous := config.Settings
+	t.Cleanup(func() { config.Settings = previous })
+	config.Settings = &config.Config{JWT_SIGNING_KEY: "signing-key", OUTBOUND_ALLOWED_NETWORKS: "127.0.0.0/8"}
+	apiKey, err := utils.EncryptAPIKey("sk-test")
+	require.NoError(t, err)
+	return &models.Provider{Mode: mode, BaseURL: baseURL, APIKey: apiKey}
+}
+
+func TestListAzureDeployments(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/openai/deployments", r.URL.Path)
+		assert.Equal(t, service.AZURE_OPENAI_DEPLOYMENTS_API_VERSION, r.URL.Query().Get("api-version"))
+		assert.Equal(t, "sk-test", r.Header.Get("Api-Key"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{
+			{"id": "prod-vectors", "model": "text-embedding-3-large"},
+			{"id": "prod-assistant", "model": "gpt-4o-mini"},
+			{"id": "prod-speech", "model": "whisper"},
+			{"id": "prod-custom", "model": "fine-tuned-thing"},
+		}})
+	}))
+	t.Cleanup(stub.Close)
+	provider := providerAt(t, models.PROVIDER_MODE_AZURE_OPENAI, stub.URL)
+
+	// Deployments are named by the user and classified by the model they serve
+	resp, err := service.ProviderServiceApp.ListProviderModels(context.Background(), provider)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ModelInfo{
+		{ID: "prod-vectors", Object: "deployment", OwnedBy: "azure", Types: []string{models.MODEL_TYPE_EMBEDDING}, Dimension: 3072, ContextLength: 8191},
+		{ID: "prod-assistant", Object: "deployment", OwnedBy: "azure", Types: []string{models.MODEL_TYPE_CHAT, models.MODEL_TYPE_VISION}, ContextLength: 128000},
+		{ID: "prod-speech", Object: "deployment", OwnedBy: "azure", Types: []string{}},
+		{ID: "prod-custom", Object: "deployment", OwnedBy: "azure", Types: []string{models.MODEL_TYPE_CHAT}},
+	}, resp.Models)
+
+	// Deployments set on the provider are listed without asking Azure, and classified by name
+	provider.BaseURL = "http://127.0.0.1:1"
+	provider.Deployments = []string{"text-embedding-3-small", "team-gpt"}
+	resp, err = service.ProviderServiceApp.ListProviderModels(context.Background(), provider)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ModelInfo{
+		{ID: "text-embedding-3-small", Object: "deployment", OwnedBy: "azure", Types: []string{models.MODEL_TYPE_EMBEDDING}, Dimension: 1536, ContextLength: 8191},
+		{ID: "team-gpt", Object: "deployment", OwnedBy: "azure", Types: []string{models.MODEL_TYPE_CHAT}},
+	}, resp.Models)
+}
+
+func TestListMistralModels(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/models", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": []map[string]any{
+			{"id": "mistral-large-latest", "owned_by": "mistralai", "max_context_length": 131072,
+				"capabilities": map[string]any{"completion_chat": true}},
+			{"id": "pixtral-large-latest", "owned_by": "mistralai", "max_context_length": 131072,
+				"capabilities": map[string]any{"completion_chat": true, "vision": true}},
+			{"id": "mistral-embed", "owned_by": "mistralai", "max_context_length": 8192,
+				"capabilities": map[string]any{"completion_chat": false}},
+		}})
+	}))
+	t.Cleanup(stub.Close)
+	provider := providerAt(t, models.PROVIDER_MODE_MISTRAL, stub.URL+"/v1")
+
+	// Reported capabilities and context lengths are kept, models that cannot chat are classified by name
+	resp, err := service.ProviderServiceApp.ListProviderModels(context.Background(), provider)
+	require.NoError(t, err)
+	assert.Equal(t, []models.ModelInfo{
+		{ID: "mistral-large-latest", Object: "model", OwnedBy: "mistralai", Types: []string{models.MODEL_TYPE_CHAT}, ContextLength: 131072},
+		{ID: "pixtral-large-latest", Object: "model", OwnedBy: "mistralai", Types: []string{models.MODEL_TYPE_CHAT, models.MODEL_TYPE_VISION}, ContextLength: 131072},
+		{ID: "mistral-embed", Object: "model", OwnedBy: "mistralai", Types: []string{models.MODEL_TYPE_EMBEDDING}, Dimension: 1024, ContextLength: 8192},
+	}, resp.Models)
+}
+
+// modelsStub answers model listings with one model
+func modelsStub(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"object": "list", "data": []map[string]any{{"id": "gpt-4o-mini", "object": "model"}}})
+}
+
+func TestProviderTransportSettings(t *testing.T) {
+	noRetries := 0
+
+	// Requests go through the proxy, which gets its credentials
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "provider.invalid", r.URL.Host)
+		assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")), r.Header.Get("Proxy-Authorization"))
+		modelsStub(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+	provider := providerAt(t, models.PROVIDER_MODE_OPENAI_COMPATIBLE, "http://provider.invalid/v1")
+	proxyURL, err := utils.EncryptAPIKey("http://user:secret@" + proxy.Listener.Addr().String())
+	require.NoError(t, err)
+	provider.ProxyURL = proxyURL
+	resp, err := service.ProviderServiceApp.ListProviderModels(context.Background(), provider)
+	require.NoError(t, err)
+	assert.Len(t, resp.Models, 1)
+
+	// A server whose certificate is signed by an unknown CA is trusted once its CA is given
+	tlsStub := httptest.NewTLSServer(http.HandlerFunc(modelsStub))
+	t.Cleanup(tlsStub.Close)
+	provider = providerAt(t, models.PROVIDER_MODE_OPENAI_COMPATIBLE, tlsStub.URL+"/v1")
+	provider.MaxRetries = &noRetries
+	_, err = service.ProviderServiceApp.ListProviderModels(context.Background(), provider)
+	assert.Equal(t, models.PROVIDER_ERROR_TLS, service.ClassifyProviderError(err))
+	provider.CACert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsStub.Certificate().Raw}))
+	_, err = service.ProviderServiceApp.ListProviderModels(context.Background(), provider)
+	assert.NoError(t, err)
+
+	// The timeout limits the wait for the response headers
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(3 * time.Second):
+		case <-r.Context().Done():
+		}
+		modelsStub(w, r)
+	}))
+	t.Cleanup(slow.Close)
+	provider = providerAt(t, models.PROVIDER_MODE_OPENAI_COMPATIBLE, slow.URL+"/v1")
+	provider.MaxRetries = &noRetries
+	provider.TimeoutSeconds = 1
+	start := time.Now()
+	_, err = service.ProviderServiceApp.ListProviderModels(context.Background(), provider)
+	assert.ErrorContains(t, err, "timeout awaiting response headers")
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
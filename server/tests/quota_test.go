@@ -0,0 +1,33 @@
+package tests
+
+import (
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestResolveQuotaPlan(t *testing.T) {
+	free := models.QuotaPlan{Model: gorm.Model{ID: 1}, Name: "free", IsDefault: true}
+	pro := models.QuotaPlan{Model: gorm.Model{ID: 2}, Name: "pro"}
+	proID, deletedID := pro.ID, uint(3)
+
+	assert.Equal(t, "pro", service.ResolveQuotaPlan(&models.User{QuotaPlanID: &proID}, []models.QuotaPlan{free, pro}).Name)
+	assert.Equal(t, "free", service.ResolveQuotaPlan(&models.User{}, []models.QuotaPlan{free}).Name)
+	// A user whose plan is gone falls back to the default plan
+	assert.Equal(t, "free", service.ResolveQuotaPlan(&models.User{QuotaPlanID: &deletedID}, []models.QuotaPlan{free}).Name)
+	assert.Nil(t, service.ResolveQuotaPlan(&models.User{}, nil))
+	assert.Nil(t, service.ResolveQuotaPlan(&models.User{QuotaPlanID: &deletedID}, nil))
+}
+
+func TestCheckTokensLeft(t *testing.T) {
+	plan := &models.QuotaPlan{MonthlyTokens: 1000}
+	assert.NoError(t, service.CheckTokensLeft(plan, 999))
+	assert.ErrorIs(t, service.CheckTokensLeft(plan, 1000), service.ErrQuotaExceeded)
+	assert.ErrorIs(t, service.CheckTokensLeft(plan, 1500), service.ErrQuotaExceeded)
+	// No plan or a zero limit is unlimited
+	assert.NoError(t, service.CheckTokensLeft(nil, 1_000_000))
+	assert.NoError(t, service.CheckTokensLeft(&models.QuotaPlan{}, 1_000_000))
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"server/config"
+	"server/db"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests below need a RabbitMQ broker with its management plugin, guest/guest on the default ports
+// of the host named by RABBITMQ_TEST_HOST. They are skipped without one.
+func connectTestBroker(t *testing.T) *db.RabbitMQService {
+	host := os.Getenv("RABBITMQ_TEST_HOST")
+	if host == "" {
+		t.Skip("RABBITMQ_TEST_HOST is not set")
+	}
+	previous := config.Settings
+	t.Cleanup(func() { config.Settings = previous })
+	config.Settings = &config.Config{
+		RABBITMQ_HOST:         host,
+		RABBITMQ_PORT:         5672,
+		RABBITMQ_USERNAME:     "guest",
+		RABBITMQ_PASSWORD:     "guest",
+		RABBITMQ_VHOST:        "/",
+		RABBITMQ_RETRY_DELAYS: "1s",
+	}
+	client, err := db.ConnectRabbitMQ(config.Settings)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// brokerAPI calls the management API of the test broker
+func brokerAPI(t *testing.T, method string, path string) *http.Response {
+	req, err := http.NewRequest(method, config.Settings.GetRabbitMQManagementURL()+"/api/"+path, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("guest", "guest")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+// dropBrokerConnections closes every connection of the broker, as a broker restart would.
+// The management API lists a connection a few seconds after it opens, so this waits for one.
+func dropBrokerConnections(t *testing.T) {
+	require.Eventually(t, func() bool {
+		resp := brokerAPI(t, http.MethodGet, "connections")
+		defer resp.Body.Close()
+		var connections []struct {
+			Name string `json:"name"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&connections) != nil || len(connections) == 0 {
+			return false
+		}
+		for _, connection := range connections {
+			brokerAPI(t, http.MethodDelete, "connections/"+url.PathEscape(connection.Name)).Body.Close()
+		}
+		return true
+	}, 30*time.Second, 500*time.Millisecond)
+}
+
+func deleteBrokerQueues(t *testing.T, topology db.RetryTopology) {
+	t.Cleanup(func() {
+		for _, queue := range []string{topology.Queue, topology.RejectedQueue(), topology.RetryQueue(time.Second), topology.DeadQueue()} {
+			brokerAPI(t, http.MethodDelete, "queues/%2F/"+url.PathEscape(queue)).Body.Close()
+		}
+		brokerAPI(t, http.MethodDelete, "exchanges/%2F/"+url.PathEscape(topology.DeadLetterExchange())).Body.Close()
+		name, _ := topology.DeadLetterPolicy()
+		brokerAPI(t, http.MethodDelete, "policies/%2F/"+url.PathEscape(name)).Body.Close()
+	})
+}
+
+func testBrokerName(t *testing.T) string {
+	return fmt.Sprintf("info-weaver-test-%s-%d", t.Name(), time.Now().UnixNano())
+}
+
+func TestRabbitMQReturnsMandatoryMessage(t *testing.T) {
+	client := connectTestBroker(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := client.Publish(ctx, "", testBrokerName(t), true, amqp.Publishing{Body: []byte("lost")})
+	assert.ErrorIs(t, err, db.ErrMessageReturned)
+	// The channel goes back to the pool and the next message is not taken for returned
+	assert.NoError(t, client.Publish(ctx, "", testBrokerName(t), false, amqp.Publishing{Body: []byte("dropped")}))
+}
+
+func TestRabbitMQReconnectRestoresTopology(t *testing.T) {
+	client := connectTestBroker(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	exchange := testBrokerName(t)
+	require.NoError(t, client.DeclareExchange(exchange, "fanout"))
+	t.Cleanup(func() { brokerAPI(t, http.MethodDelete, "exchanges/%2F/"+url.PathEscape(exchange)).Body.Close() })
+
+	// Lost with the connection, the exchange is only there again once the client reconnected and declared it
+	brokerAPI(t, http.MethodDelete, "exchanges/%2F/"+url.PathEscape(exchange)).Body.Close()
+	dropBrokerConnections(t)
+	assert.Eventually(t, func() bool {
+		publishCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		return client.Publish(publishCtx, exchange, "", false, amqp.Publishing{Body: []byte("back")}) == nil
+	}, 45*time.Second, 500*time.Millisecond)
+}
+
+func TestRabbitMQConsumerResumes(t *testing.T) {
+	client := connectTestBroker(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	queue := &db.WorkQueue{Client: client, Topology: db.NewRetryTopology(testBrokerName(t))}
+	deleteBrokerQueues(t, queue.Topology)
+	deliveries, err := queue.Consume(ctx)
+	require.NoError(t, err)
+
+	dropBrokerConnections(t)
+	require.Eventually(t, func() bool {
+		publishCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		return queue.Publish(publishCtx, []byte("after the restart")) == nil
+	}, 45*time.Second, 500*time.Millisecond)
+
+	select {
+	case delivery := <-deliveries:
+		assert.Equal(t, "after the restart", string(delivery.Body))
+		delivery.Ack(false)
+	case <-ctx.Done():
+		t.Fatal("the consumer did not resume on the new connection")
+	}
+}
+
+func TestRabbitMQConsumerNeedsDeadLetterPolicy(t *testing.T) {
+	connectTestBroker(t)
+	management := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	t.Cleanup(management.Close)
+	config.Settings.RABBITMQ_MANAGEMENT_URL = management.URL
+	client, err := db.ConnectRabbitMQ(config.Settings)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Rejected messages would be dropped without the policy, so the queue is declared but not consumed
+	queue := &db.WorkQueue{Client: client, Topology: db.NewRetryTopology(testBrokerName(t))}
+	deleteBrokerQueues(t, queue.Topology)
+	_, err = queue.Consume(ctx)
+	assert.ErrorContains(t, err, "dead-letter policy")
+	assert.NoError(t, queue.Publish(ctx, []byte("kept until consumed")))
+}
@@ -0,0 +1,204 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/db"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTopology(t *testing.T) {
+	topology := db.RetryTopology{Queue: "q", Delays: []time.Duration{30 * time.Second, 2 * time.Minute}}
+	assert.Equal(t, "q.dlx", topology.DeadLetterExchange())
+	assert.Equal(t, "q.rejected", topology.RejectedQueue())
+	assert.Equal(t, "q.retry.30000ms", topology.RetryQueue(30*time.Second))
+	assert.Equal(t, "q.retry.120000ms", topology.RetryQueue(2*time.Minute))
+	assert.Equal(t, "q.dead", topology.DeadQueue())
+}
+
+func TestRetryCount(t *testing.T) {
+	assert.Equal(t, 0, db.RetryCount(nil))
+	assert.Equal(t, 0, db.RetryCount(amqp.Table{"other": int32(3)}))
+	assert.Equal(t, 2, db.RetryCount(amqp.Table{db.RETRY_COUNT_HEADER: int32(2)}))
+	assert.Equal(t, 4, db.RetryCount(amqp.Table{db.RETRY_COUNT_HEADER: int64(4)}))
+	assert.Equal(t, 0, db.RetryCount(amqp.Table{db.RETRY_COUNT_HEADER: "4"}))
+}
+
+func TestRabbitMQRetryDelays(t *testing.T) {
+	defaults := []time.Duration{30 * time.Second, 2 * time.Minute, 10 * time.Minute, 30 * time.Minute}
+	assert.Equal(t, defaults, (&config.Config{}).GetRabbitMQRetryDelays())
+	assert.Equal(t, []time.Duration{5 * time.Second, time.Minute},
+		(&config.Config{RABBITMQ_RETRY_DELAYS: " 5s, 1m "}).GetRabbitMQRetryDelays())
+	assert.Equal(t, defaults, (&config.Config{RABBITMQ_RETRY_DELAYS: "5s,soon"}).GetRabbitMQRetryDelays())
+	assert.Equal(t, defaults, (&config.Config{RABBITMQ_RETRY_DELAYS: "10ms"}).GetRabbitMQRetryDelays())
+}
+
+func TestDeadLetterPolicy(t *testing.T) {
+	name, policy := db.RetryTopology{Queue: "info-weaver.file-queue"}.DeadLetterPolicy()
+	assert.Equal(t, "info-weaver.file-queue.dead-letter", name)
+	assert.Equal(t, `^info-weaver\.file-queue$`, policy.Pattern)
+	assert.Equal(t, "queues", policy.ApplyTo)
+	assert.Equal(t, map[string]any{"dead-letter-exchange": "info-weaver.file-queue.dlx"}, policy.Definition)
+}
+
+func TestPutPolicy(t *testing.T) {
+	var path string
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		if r.Method != http.MethodPut || user != "guest" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path = r.URL.EscapedPath()
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	management := db.NewRabbitMQManagement(&config.Config{RABBITMQ_MANAGEMENT_URL: server.URL + "/", RABBITMQ_USERNAME: "guest", RABBITMQ_PASSWORD: "secret"})
+	name, policy := db.RetryTopology{Queue: "q"}.DeadLetterPolicy()
+	assert.NoError(t, management.PutPolicy(context.Background(), name, policy))
+	// The default virtual host "/" is escaped in the path
+	assert.Equal(t, "/api/policies/%2F/q.dead-letter", path)
+	assert.Equal(t, "^q$", body["pattern"])
+	assert.Equal(t, map[string]any{"dead-letter-exchange": "q.dlx"}, body["definition"])
+
+	management.Password = "wrong"
+	assert.ErrorContains(t, management.PutPolicy(context.Background(), name, policy), "401")
+}
+
+func TestPublishOutcome(t *testing.T) {
+	returns := make(chan amqp.Return, 1)
+	assert.NoError(t, db.PublishOutcome(true, returns, "", "q"))
+	assert.ErrorIs(t, db.PublishOutcome(false, returns, "", "q"), db.ErrMessageNacked)
+
+	// A mandatory message no queue took comes back before its confirmation
+	returns <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE"}
+	err := db.PublishOutcome(true, returns, "", "q")
+	assert.ErrorIs(t, err, db.ErrMessageReturned)
+	assert.ErrorContains(t, err, "NO_ROUTE")
+	assert.NoError(t, db.PublishOutcome(true, returns, "", "q"))
+}
+
+// fakePublisher records the messages published, failing with err when set
+type fakePublisher struct {
+	err       error
+	published []fakePublished
+}
+
+type fakePublished struct {
+	queue   string
+	message amqp.Publishing
+}
+
+func (this *fakePublisher) Publish(ctx context.Context, exchange string, routingKey string, mandatory bool, message amqp.Publishing) error {
+	if this.err != nil {
+		return this.err
+	}
+	this.published = append(this.published, fakePublished{queue: routingKey, message: message})
+	return nil
+}
+
+// fakeAcknowledger counts the acknowledgements of deliveries
+type fakeAcknowledger struct {
+	acked int
+}
+
+func (this *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	this.acked++
+	return nil
+}
+
+func (this *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error { return nil }
+
+func (this *fakeAcknowledger) Reject(tag uint64, requeue bool) error { return nil }
+
+func TestRouteRejectedMessage(t *testing.T) {
+	ctx := context.Background()
+	topology := db.RetryTopology{Queue: "q", Delays: []time.Duration{30 * time.Second, 2 * time.Minute}}
+	publisher := &fakePublisher{}
+
+	// Each rejection goes to the retry queue of the next delay, with its retries counted
+	delivery := amqp.Delivery{MessageId: "1", Body: []byte("file"), Headers: amqp.Table{"trace": "a"}}
+	assert.NoError(t, db.RouteRejectedMessage(ctx, publisher, topology, delivery))
+	delivery.Headers = amqp.Table{"trace": "a", db.RETRY_COUNT_HEADER: int32(1)}
+	assert.NoError(t, db.RouteRejectedMessage(ctx, publisher, topology, delivery))
+	if assert.Len(t, publisher.published, 2) {
+		assert.Equal(t, "q.retry.30000ms", publisher.published[0].queue)
+		assert.Equal(t, int32(1), publisher.published[0].message.Headers[db.RETRY_COUNT_HEADER])
+		assert.Equal(t, "q.retry.120000ms", publisher.published[1].queue)
+		assert.Equal(t, int32(2), publisher.published[1].message.Headers[db.RETRY_COUNT_HEADER])
+		assert.Equal(t, "a", publisher.published[1].message.Headers["trace"])
+		assert.Equal(t, "1", publisher.published[1].message.MessageId)
+		assert.Equal(t, []byte("file"), publisher.published[1].message.Body)
+	}
+	// The delivered headers are left as they were
+	assert.Equal(t, int32(1), delivery.Headers[db.RETRY_COUNT_HEADER])
+
+	// Once the retries are used up, it is dead with the reason
+	delivery.Headers = amqp.Table{db.RETRY_COUNT_HEADER: int32(2)}
+	assert.NoError(t, db.RouteRejectedMessage(ctx, publisher, topology, delivery))
+	dead := publisher.published[2]
+	assert.Equal(t, "q.dead", dead.queue)
+	assert.Equal(t, "rejected after 2 retries", dead.message.Headers[db.DEAD_LETTER_REASON_HEADER])
+	assert.IsType(t, time.Time{}, dead.message.Headers[db.DEAD_LETTERED_AT_HEADER])
+
+	// A topology without delays dead-letters at once
+	assert.NoError(t, db.RouteRejectedMessage(ctx, publisher, db.RetryTopology{Queue: "q"}, amqp.Delivery{MessageId: "2"}))
+	assert.Equal(t, "q.dead", publisher.published[3].queue)
+
+	publisher.err = db.ErrMessageReturned
+	assert.ErrorIs(t, db.RouteRejectedMessage(ctx, publisher, topology, amqp.Delivery{}), db.ErrMessageReturned)
+}
+
+func TestRequeueDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	topology := db.RetryTopology{Queue: "q"}
+	publisher := &fakePublisher{}
+	acknowledger := &fakeAcknowledger{}
+	deadLetter := func(id string) amqp.Delivery {
+		return amqp.Delivery{Acknowledger: acknowledger, MessageId: id, Body: []byte(id), Headers: amqp.Table{
+			db.RETRY_COUNT_HEADER:        int32(4),
+			db.DEAD_LETTER_REASON_HEADER: "rejected after 4 retries",
+			db.DEAD_LETTERED_AT_HEADER:   time.Now(),
+			"trace":                      id,
+		}}
+	}
+
+	// Only the messages with a given ID are requeued, the others stay unacknowledged in the dead queue
+	requeued, err := db.RequeueDeadLetter(ctx, publisher, topology, deadLetter("1"), []string{"2", "3"})
+	assert.NoError(t, err)
+	assert.False(t, requeued)
+	requeued, err = db.RequeueDeadLetter(ctx, publisher, topology, deadLetter("2"), []string{"2", "3"})
+	assert.NoError(t, err)
+	assert.True(t, requeued)
+	// Without IDs every message is requeued
+	requeued, err = db.RequeueDeadLetter(ctx, publisher, topology, deadLetter("4"), nil)
+	assert.NoError(t, err)
+	assert.True(t, requeued)
+
+	assert.Equal(t, 2, acknowledger.acked)
+	if assert.Len(t, publisher.published, 2) {
+		requeuedMessage := publisher.published[0]
+		assert.Equal(t, "q", requeuedMessage.queue)
+		assert.Equal(t, "2", requeuedMessage.message.MessageId)
+		// Its retries start over
+		assert.Equal(t, amqp.Table{"trace": "2"}, requeuedMessage.message.Headers)
+		assert.Equal(t, "4", publisher.published[1].message.MessageId)
+	}
+
+	// A message that could not be published stays in the dead queue
+	publisher.err = db.ErrMessageNacked
+	requeued, err = db.RequeueDeadLetter(ctx, publisher, topology, deadLetter("5"), nil)
+	assert.ErrorIs(t, err, db.ErrMessageNacked)
+	assert.False(t, requeued)
+	assert.Equal(t, 2, acknowledger.acked)
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"server/config"
+	"server/middleware"
+	"server/service"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	fallback := config.RateLimit{Requests: 10, Window: time.Minute}
+
+	assert.Equal(t, config.RateLimit{Requests: 5, Window: 30 * time.Second}, config.ParseRateLimit("5/30s", fallback))
+	assert.Equal(t, config.RateLimit{Requests: 100, Window: time.Hour}, config.ParseRateLimit(" 100 / h ", fallback))
+	assert.Equal(t, config.RateLimit{}, config.ParseRateLimit("0", fallback))
+	assert.Equal(t, fallback, config.ParseRateLimit("", fallback))
+	assert.Equal(t, fallback, config.ParseRateLimit("10", fallback))
+	assert.Equal(t, fallback, config.ParseRateLimit("-1/1m", fallback))
+	assert.Equal(t, fallback, config.ParseRateLimit("10/soon", fallback))
+}
+
+func TestRateLimitMiddlewareFailsOpen(t *testing.T) {
+	// Without Redis the requests go through, without rate limit headers
+	e := echo.New()
+	e.POST("/login", func(ctx *echo.Context) error {
+		return ctx.NoContent(http.StatusNoContent)
+	}, middleware.RateLimitMiddleware("login", config.RateLimit{Requests: 1, Window: time.Minute}))
+
+	for range 3 {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestRateLimitSlidingWindow(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	server := connectTestRedis(t, start)
+	ctx := context.Background()
+	limit := config.RateLimit{Requests: 3, Window: time.Minute}
+	key := fmt.Sprintf(service.RATE_LIMIT_KEY, "login", "ip:192.0.2.1")
+	allow := func(at time.Duration) *service.RateLimitResult {
+		server.SetTime(start.Add(at))
+		result, err := service.RateLimitServiceApp.Allow(ctx, "login", "ip:192.0.2.1", limit)
+		require.NoError(t, err)
+		return result
+	}
+
+	// Requests are allowed up to the limit, the window frees room a minute after the oldest one
+	for i, at := range []time.Duration{0, 10 * time.Second, 20 * time.Second} {
+		assert.Equal(t, &service.RateLimitResult{Allowed: true, Limit: 3, Remaining: 2 - i, Reset: time.Minute - at}, allow(at))
+	}
+	assert.Equal(t, &service.RateLimitResult{Allowed: false, Limit: 3, Remaining: 0, Reset: 30 * time.Second}, allow(30*time.Second))
+
+	// Denied requests take no room, so retrying does not push the reset back
+	assert.Equal(t, &service.RateLimitResult{Allowed: false, Limit: 3, Remaining: 0, Reset: 5 * time.Second}, allow(55*time.Second))
+	members, err := server.ZMembers(key)
+	require.NoError(t, err)
+	assert.Len(t, members, 3)
+
+	// Once the oldest request leaves the window there is room for one more
+	assert.Equal(t, &service.RateLimitResult{Allowed: true, Limit: 3, Remaining: 0, Reset: 10 * time.Second}, allow(time.Minute))
+	assert.False(t, allow(time.Minute+time.Second).Allowed)
+
+	// Other clients and route groups have windows of their own
+	result, err := service.RateLimitServiceApp.Allow(ctx, "login", "ip:192.0.2.2", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = service.RateLimitServiceApp.Allow(ctx, "api", "ip:192.0.2.1", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestRateLimitMiddlewareHeaders(t *testing.T) {
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	server := connectTestRedis(t, start)
+	e := echo.New()
+	e.POST("/login", func(ctx *echo.Context) error {
+		return ctx.NoContent(http.StatusNoContent)
+	}, middleware.RateLimitMiddleware("login", config.RateLimit{Requests: 2, Window: time.Minute}))
+	post := func(at time.Duration) *httptest.ResponseRecorder {
+		server.SetTime(start.Add(at))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+		return rec
+	}
+
+	rec := post(0)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "60", rec.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", rec.Header().Get("RateLimit-Policy"))
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusNoContent, post(15*time.Second).Code)
+
+	// The spent budget is answered with a 429 telling when to come back
+	rec = post(20500 * time.Millisecond)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "40", rec.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "40", rec.Header().Get("Retry-After"))
+
+	// Retry-After is never 0, even with the oldest request about to leave
+	rec = post(time.Minute - time.Millisecond)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+}
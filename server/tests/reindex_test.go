@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"server/config"
+	"server/db"
+	"server/models"
+	"server/service"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// connectTestMilvus points the vector store at the Milvus server on the default port of the host named by
+// MILVUS_TEST_HOST, skipping the test without one. It must follow connectTestDatabase, which replaces the settings.
+func connectTestMilvus(t *testing.T) {
+	host := os.Getenv("MILVUS_TEST_HOST")
+	if host == "" {
+		t.Skip("MILVUS_TEST_HOST is not set")
+	}
+	config.Settings.MILVUS_HOST, config.Settings.MILVUS_PORT = host, 19530
+	client, err := db.ConnectMilvusDB(config.Settings)
+	require.NoError(t, err)
+	previous := db.MilvusClient
+	db.MilvusClient = client
+	t.Cleanup(func() {
+		db.MilvusClient = previous
+		client.Close(context.Background())
+	})
+}
+
+// embeddingStub answers OpenAI embedding requests with vectors of the given dimension, and fails for the model "broken"
+func embeddingStub(t *testing.T, dimension int) *httptest.Server {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Model == "broken" {
+			http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusBadRequest)
+			return
+		}
+		data := []map[string]any{}
+		for i := range req.Input {
+			embedding := make([]float32, dimension)
+			embedding[i%dimension] = 1
+			data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": embedding})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]any{
+			"object": "list",
+			"model":  req.Model,
+			"data":   data,
+			"usage":  map[string]any{"prompt_tokens": len(req.Input), "total_tokens": len(req.Input)},
+		}))
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func TestStartReindexJobWithoutVectorStore(t *testing.T) {
+	previous := db.MilvusClient
+	t.Cleanup(func() { db.MilvusClient = previous })
+	db.MilvusClient = nil
+
+	_, err := service.ReindexServiceApp.StartReindexJob(context.Background(), 1, 1, 0, "embed-large")
+	assert.ErrorIs(t, err, service.ErrVectorStoreUnavailable)
+}
+
+func TestReindexJobsOfDatasetAreExclusive(t *testing.T) {
+	connectTestDatabase(t)
+	fixture := createTestDataset(t, "https://api.example.com")
+	other := models.Dataset{Name: "other", EmbeddingModel: "embed-small", ProviderID: fixture.Provider.ID, OwnerID: fixture.User.ID}
+	require.NoError(t, db.PgSqlDB.Create(&other).Error)
+
+	newJob := func(datasetID uint, status string) error {
+		return db.PgSqlDB.Create(&models.ReindexJob{DatasetID: datasetID, OwnerID: fixture.User.ID, Status: status, ProviderID: fixture.Provider.ID}).Error
+	}
+	require.NoError(t, newJob(fixture.Dataset.ID, models.REINDEX_STATUS_PENDING))
+	assert.ErrorIs(t, newJob(fixture.Dataset.ID, models.REINDEX_STATUS_PENDING), gorm.ErrDuplicatedKey)
+	assert.ErrorIs(t, newJob(fixture.Dataset.ID, models.REINDEX_STATUS_RUNNING), gorm.ErrDuplicatedKey)
+	assert.NoError(t, newJob(other.ID, models.REINDEX_STATUS_RUNNING))
+
+	// Finished jobs do not hold the dataset
+	require.NoError(t, db.PgSqlDB.Model(&models.ReindexJob{}).
+		Where("dataset_id = ?", fixture.Dataset.ID).
+		Update("status", models.REINDEX_STATUS_FAILED).Error)
+	assert.NoError(t, newJob(fixture.Dataset.ID, models.REINDEX_STATUS_COMPLETED))
+	assert.NoError(t, newJob(fixture.Dataset.ID, models.REINDEX_STATUS_RUNNING))
+}
+
+func TestCancelReindexJob(t *testing.T) {
+	connectTestDatabase(t)
+	fixture := createTestDataset(t, "https://api.example.com")
+	chunks := fixture.createTestChunks(t, "first", "second")
+	pendingVectorID := "42"
+	require.NoError(t, db.PgSqlDB.Model(&chunks[0]).Update("pending_vector_id", pendingVectorID).Error)
+
+	// The collection of the job is not created yet, so Milvus is not needed to discard it
+	job := models.ReindexJob{
+		DatasetID:      fixture.Dataset.ID,
+		OwnerID:        fixture.User.ID,
+		Status:         models.REINDEX_STATUS_RUNNING,
+		ProviderID:     fixture.Provider.ID,
+		EmbeddingModel: "embed-large",
+	}
+	require.NoError(t, db.PgSqlDB.Create(&job).Error)
+
+	ctx := context.Background()
+	_, err := service.ReindexServiceApp.CancelReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID+1)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	info, err := service.ReindexServiceApp.CancelReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID)
+	require.NoError(t, err)
+	assert.Equal(t, job.ID, info.ID)
+	assert.Equal(t, models.REINDEX_STATUS_CANCELLED, info.Status)
+
+	latest, err := service.ReindexServiceApp.GetLatestReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.REINDEX_STATUS_CANCELLED, latest.Status)
+	var chunk models.Chunk
+	require.NoError(t, db.PgSqlDB.First(&chunk, chunks[0].ID).Error)
+	assert.Nil(t, chunk.PendingVectorID)
+	dataset := models.Dataset{}
+	require.NoError(t, db.PgSqlDB.First(&dataset, fixture.Dataset.ID).Error)
+	assert.Equal(t, "embed-small", dataset.EmbeddingModel)
+
+	// Nothing is left to cancel
+	_, err = service.ReindexServiceApp.CancelReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+}
+
+func TestReindexJobSwitchesDataset(t *testing.T) {
+	connectTestDatabase(t)
+	connectTestMilvus(t)
+	stub := embeddingStub(t, 8)
+	fixture := createTestDataset(t, stub.URL)
+	chunks := fixture.createTestChunks(t, "first", "second", "third")
+	ctx := context.Background()
+
+	// A model that cannot embed starts no job
+	_, err := service.ReindexServiceApp.StartReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID, 0, "broken")
+	assert.ErrorIs(t, err, service.ErrEmbeddingProbeFailed)
+	_, err = service.ReindexServiceApp.GetLatestReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID)
+	assert.ErrorIs(t, err, service.ErrNotFound)
+
+	// A cancelled job leaves the dataset as it was and lets another one start
+	cancelled, err := service.ReindexServiceApp.StartReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID, 0, "embed-large")
+	require.NoError(t, err)
+	assert.Equal(t, models.REINDEX_STATUS_RUNNING, cancelled.Status)
+	_, err = service.ReindexServiceApp.StartReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID, 0, "embed-large")
+	assert.ErrorIs(t, err, service.ErrReindexJobRunning)
+	_, err = service.ReindexServiceApp.CancelReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID)
+	require.NoError(t, err)
+
+	job, err := service.ReindexServiceApp.StartReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID, 0, "embed-large")
+	require.NoError(t, err)
+	assert.NotEqual(t, cancelled.ID, job.ID)
+
+	workerCtx, stop := context.WithCancel(ctx)
+	t.Cleanup(stop)
+	service.ReindexServiceApp.Start(workerCtx)
+	require.Eventually(t, func() bool {
+		latest, err := service.ReindexServiceApp.GetLatestReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID)
+		return err == nil && latest.Status != models.REINDEX_STATUS_RUNNING
+	}, time.Minute, 500*time.Millisecond)
+	stop()
+
+	latest, err := service.ReindexServiceApp.GetLatestReindexJob(ctx, fixture.Dataset.ID, fixture.User.ID)
+	require.NoError(t, err)
+	assert.Equal(t, job.ID, latest.ID)
+	assert.Equal(t, models.REINDEX_STATUS_COMPLETED, latest.Status, latest.Error)
+	assert.Equal(t, 1, latest.TotalFiles)
+	assert.Equal(t, 1, latest.ProcessedFiles)
+
+	// The dataset and its chunks point to the rebuilt vectors
+	dataset := models.Dataset{}
+	require.NoError(t, db.PgSqlDB.First(&dataset, fixture.Dataset.ID).Error)
+	assert.Equal(t, "embed-large", dataset.EmbeddingModel)
+	assert.NotEmpty(t, dataset.CollectionName)
+	assert.NotEqual(t, config.Settings.MILVUS_COLLECTION_NAME, dataset.CollectionName)
+	t.Cleanup(func() { db.DropVectorCollection(context.Background(), dataset.CollectionName) })
+	for _, chunk := range chunks {
+		var rebuilt models.Chunk
+		require.NoError(t, db.PgSqlDB.First(&rebuilt, chunk.ID).Error)
+		assert.NotEqual(t, chunk.VectorID, rebuilt.VectorID)
+		assert.Nil(t, rebuilt.PendingVectorID)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPReranker(t *testing.T) {
+	// Stub of a Cohere/Jina-style rerank API that ranks the second document first
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/rerank", r.URL.Path)
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+
+		var req models.RerankAPIReq
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "rerank-model", req.Model)
+		assert.Equal(t, "what is milvus", req.Query)
+		assert.Len(t, req.Documents, 3)
+		assert.Equal(t, 2, req.TopN)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"results":[{"index":1,"relevance_score":0.9},{"index":0,"relevance_score":0.4}]}`))
+	}))
+	defer stub.Close()
+
+	for _, rerankType := range []string{models.RERANK_TYPE_COHERE, models.RERANK_TYPE_JINA} {
+		reranker, err := service.NewReranker(rerankType, stub.URL+"/v1/", "test-key", "rerank-model")
+		assert.NoError(t, err)
+
+		results, err := reranker.Rerank(context.Background(), "what is milvus", []string{"a", "b", "c"}, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, []models.RerankResult{{Index: 1, Score: 0.9}, {Index: 0, Score: 0.4}}, results)
+	}
+}
+
+func TestHTTPRerankerError(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer stub.Close()
+
+	reranker, err := service.NewReranker(models.RERANK_TYPE_COHERE, stub.URL, "bad-key", "rerank-model")
+	assert.NoError(t, err)
+
+	_, err = reranker.Rerank(context.Background(), "query", []string{"a"}, 1)
+	assert.Error(t, err)
+}
+
+func TestLexicalReranker(t *testing.T) {
+	reranker, err := service.NewReranker(models.RERANK_TYPE_LEXICAL, "", "", "")
+	assert.NoError(t, err)
+
+	documents := []string{
+		"Redis is an in-memory key value store",
+		"Milvus is a vector database built for similarity search",
+		"向量数据库用于相似度检索",
+	}
+	results, err := reranker.Rerank(context.Background(), "Vector database search", documents, 0)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, 1, results[0].Index)
+	assert.Equal(t, float32(1), results[0].Score)
+
+	results, err = reranker.Rerank(context.Background(), "向量检索", documents, 1)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 2, results[0].Index)
+}
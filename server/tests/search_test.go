@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"server/db"
+	"server/models"
+	"server/service"
+	"server/utils"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// vectorCollection answers searches with the first limit of size hits, and keeps every tenth hit when joined to chunks
+type vectorCollection struct {
+	size   int
+	limits []int
+}
+
+func (this *vectorCollection) search(limit int) ([]db.VectorHit, error) {
+	this.limits = append(this.limits, limit)
+	hits := []db.VectorHit{}
+	for id := range min(limit, this.size) {
+		hits = append(hits, db.VectorHit{ID: int64(id), Score: 1 / float32(id+1)})
+	}
+	return hits, nil
+}
+
+func (this *vectorCollection) join(hits []db.VectorHit) ([]models.SearchResultItem, error) {
+	results := []models.SearchResultItem{}
+	for _, hit := range hits {
+		if hit.ID%10 == 0 {
+			results = append(results, models.SearchResultItem{ChunkID: uint(hit.ID), Score: hit.Score})
+		}
+	}
+	return results, nil
+}
+
+func TestOverfetchVectorSearch(t *testing.T) {
+	resultIDs := func(results []models.SearchResultItem) []uint {
+		ids := []uint{}
+		for _, result := range results {
+			ids = append(ids, result.ChunkID)
+		}
+		return ids
+	}
+
+	// Without a file type filter the requested number of hits is fetched once
+	collection := &vectorCollection{size: 100}
+	results, err := service.OverfetchVectorSearch(nil, 3, collection.search, collection.join)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{0}, resultIDs(results))
+	assert.Equal(t, []int{3}, collection.limits)
+
+	// Filtered out chunks make it fetch again with a larger limit, then the results are trimmed to topK
+	collection = &vectorCollection{size: 100}
+	results, err = service.OverfetchVectorSearch([]string{"application/pdf"}, 3, collection.search, collection.join)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{0, 10, 20}, resultIDs(results))
+	assert.Equal(t, []int{12, 48}, collection.limits)
+
+	// Fewer hits than the limit means the collection is exhausted
+	collection = &vectorCollection{size: 20}
+	results, err = service.OverfetchVectorSearch([]string{"application/pdf"}, 3, collection.search, collection.join)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{0, 10}, resultIDs(results))
+	assert.Equal(t, []int{12, 48}, collection.limits)
+
+	// The limit never exceeds what Milvus accepts
+	collection = &vectorCollection{size: service.MILVUS_MAX_TOP_K * 2}
+	_, err = service.OverfetchVectorSearch([]string{"application/pdf"}, 5000, collection.search, func([]db.VectorHit) ([]models.SearchResultItem, error) {
+		return []models.SearchResultItem{}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{service.MILVUS_MAX_TOP_K}, collection.limits)
+
+	failure := errors.New("milvus unavailable")
+	_, err = service.OverfetchVectorSearch(nil, 3, func(int) ([]db.VectorHit, error) { return nil, failure }, collection.join)
+	assert.ErrorIs(t, err, failure)
+}
+
+func TestSearchRequestValidation(t *testing.T) {
+	e := echo.New()
+	e.POST("/dataset/:dataset_id/search", func(ctx *echo.Context) error {
+		if _, err := utils.BindAndValidate[models.DatasetSearchReq](ctx); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		return ctx.NoContent(http.StatusNoContent)
+	})
+	e.POST("/search", func(ctx *echo.Context) error {
+		if _, err := utils.BindAndValidate[models.CrossDatasetSearchReq](ctx); err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		return ctx.NoContent(http.StatusNoContent)
+	})
+
+	post := func(path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	cases := []struct {
+		name  string
+		path  string
+		body  string
+		field string // Empty when the request is valid
+	}{
+		{"valid", "/dataset/1/search", `{"query":"milvus","top_k":5}`, ""},
+		{"top_k defaults", "/dataset/1/search", `{"query":"milvus"}`, ""},
+		{"missing query", "/dataset/1/search", `{"top_k":5}`, "Query"},
+		{"query too long", "/dataset/1/search", `{"query":"` + strings.Repeat("a", 2001) + `"}`, "Query"},
+		{"top_k too large", "/dataset/1/search", `{"query":"milvus","top_k":101}`, "TopK"},
+		{"missing dataset", "/dataset/0/search", `{"query":"milvus"}`, "ID"},
+		{"cross dataset valid", "/search", `{"query":"milvus","dataset_ids":[1,2],"file_types":["application/pdf"]}`, ""},
+		{"too many datasets", "/search", `{"query":"milvus","dataset_ids":[` + strings.TrimSuffix(strings.Repeat("1,", 51), ",") + `]}`, "DatasetIDs"},
+		{"empty file type", "/search", `{"query":"milvus","file_types":[""]}`, "FileTypes[0]"},
+		{"empty tag", "/search", `{"query":"milvus","tags":[""]}`, "Tags[0]"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := post(c.path, c.body)
+			if c.field == "" {
+				assert.Equal(t, http.StatusNoContent, rec.Code, rec.Body.String())
+				return
+			}
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), "."+c.field+"'")
+		})
+	}
+}
+
+func TestFuseRankedResults(t *testing.T) {
+	list := func(chunkIDs ...uint) []models.SearchResultItem {
+		items := []models.SearchResultItem{}
+		for _, chunkID := range chunkIDs {
+			items = append(items, models.SearchResultItem{ChunkID: chunkID, Score: 100, Content: fmt.Sprint("chunk ", chunkID)})
+		}
+		return items
+	}
+	rrf := func(ranks ...int) float32 {
+		score := float32(0)
+		for _, rank := range ranks {
+			score += float32(1.0 / float64(service.RRF_K+rank))
+		}
+		return score
+	}
+	type fused struct {
+		chunkID uint
+		score   float32
+	}
+
+	cases := []struct {
+		name  string
+		lists [][]models.SearchResultItem
+		limit int
+		want  []fused
+	}{
+		{"no lists", nil, 10, []fused{}},
+		{"single list keeps its order", [][]models.SearchResultItem{list(3, 1, 2)}, 10,
+			[]fused{{3, rrf(1)}, {1, rrf(2)}, {2, rrf(3)}}},
+		{"chunk in both lists is merged and ranks first", [][]models.SearchResultItem{list(1, 2), list(3, 2)}, 10,
+			[]fused{{2, rrf(2, 2)}, {1, rrf(1)}, {3, rrf(1)}}},
+		{"ties keep the order of first appearance", [][]models.SearchResultItem{list(1, 2), list(3, 4)}, 10,
+			[]fused{{1, rrf(1)}, {3, rrf(1)}, {2, rrf(2)}, {4, rrf(2)}}},
+		{"chunk low in both lists beats the top of one", [][]models.SearchResultItem{list(1, 5, 6, 7, 8, 2), list(9, 10, 11, 12, 13, 2)}, 3,
+			[]fused{{2, rrf(6, 6)}, {1, rrf(1)}, {9, rrf(1)}}},
+		{"limit truncates", [][]models.SearchResultItem{list(1, 2, 3)}, 2,
+			[]fused{{1, rrf(1)}, {2, rrf(2)}}},
+		{"zero limit keeps everything", [][]models.SearchResultItem{list(1, 2, 3)}, 0,
+			[]fused{{1, rrf(1)}, {2, rrf(2)}, {3, rrf(3)}}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			results := service.FuseRankedResults(c.lists, service.RRF_K, c.limit)
+			got := []fused{}
+			for _, result := range results {
+				got = append(got, fused{result.ChunkID, result.Score})
+				assert.Equal(t, fmt.Sprint("chunk ", result.ChunkID), result.Content)
+			}
+			assert.Equal(t, len(c.want), len(got))
+			for i := range min(len(c.want), len(got)) {
+				assert.Equal(t, c.want[i].chunkID, got[i].chunkID)
+				assert.InDelta(t, c.want[i].score, got[i].score, 1e-7)
+			}
+		})
+	}
+
+	// With k = 60 the first rank scores 1/61, and the input lists are left untouched
+	lists := [][]models.SearchResultItem{list(1)}
+	results := service.FuseRankedResults(lists, 60, 10)
+	assert.InDelta(t, 1.0/61, results[0].Score, 1e-7)
+	assert.Equal(t, float32(100), lists[0][0].Score)
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"server/config"
+	"server/models"
+	"server/service"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileSecretStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := service.NewFileSecretStore(dir)
+
+	ref, err := store.Put(ctx, "provider-1-api-key", "sk-file")
+	assert.NoError(t, err)
+	assert.Equal(t, "file:provider-1-api-key", ref)
+	value, err := store.Get(ctx, ref)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-file", value)
+
+	// Mounted secrets often end with a newline
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "mounted"), []byte("sk-mounted\n"), 0o600))
+	value, err = store.Get(ctx, "file:mounted")
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-mounted", value)
+
+	_, err = store.Get(ctx, "file:../etc/passwd")
+	assert.Error(t, err)
+	assert.NoError(t, store.Delete(ctx, ref))
+	_, err = store.Get(ctx, ref)
+	assert.ErrorIs(t, err, service.ErrSecretNotFound)
+	assert.NoError(t, store.Delete(ctx, ref))
+}
+
+// vaultStub serves the KV version 2 API of Vault from memory
+func vaultStub(t *testing.T, token string) *httptest.Server {
+	var mu sync.Mutex
+	secrets := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch path := r.URL.Path; {
+		case r.Method == http.MethodPost && strings.HasPrefix(path, "/v1/secret/data/"):
+			var body struct {
+				Data map[string]string `json:"data"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			secrets[strings.TrimPrefix(path, "/v1/secret/data/")] = body.Data["value"]
+			w.Write([]byte(`{"data":{"version":1}}`))
+		case r.Method == http.MethodGet && strings.HasPrefix(path, "/v1/secret/data/"):
+			value, ok := secrets[strings.TrimPrefix(path, "/v1/secret/data/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"errors":[]}`))
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"data": map[string]string{"value": value}}})
+		case r.Method == http.MethodDelete && strings.HasPrefix(path, "/v1/secret/metadata/"):
+			delete(secrets, strings.TrimPrefix(path, "/v1/secret/metadata/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVaultSecretStore(t *testing.T) {
+	ctx := context.Background()
+	server := vaultStub(t, "root")
+	store := service.NewVaultSecretStore(server.URL, "root", "", "secret", "infoweaver/providers")
+
+	ref, err := store.Put(ctx, "provider-1-api-key", "sk-vault")
+	assert.NoError(t, err)
+	assert.Equal(t, "vault:infoweaver/providers/provider-1-api-key", ref)
+	value, err := store.Get(ctx, ref)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-vault", value)
+
+	assert.NoError(t, store.Delete(ctx, ref))
+	_, err = store.Get(ctx, ref)
+	assert.ErrorIs(t, err, service.ErrSecretNotFound)
+
+	denied := service.NewVaultSecretStore(server.URL, "wrong", "", "secret", "infoweaver/providers")
+	_, err = denied.Put(ctx, "provider-2-api-key", "sk-vault")
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestResolveProviderSecrets(t *testing.T) {
+	previous := config.Settings
+	t.Cleanup(func() { config.Settings = previous })
+	dir := t.TempDir()
+	config.Settings = &config.Config{JWT_SIGNING_KEY: "signing-key", SECRETS_FILE_DIR: dir}
+	ctx := context.Background()
+
+	// References are resolved by the backend that wrote them
+	encrypted, err := (&service.DatabaseSecretStore{}).Put(ctx, "", "sk-database")
+	assert.NoError(t, err)
+	apiKey, err := service.ProviderServiceApp.ResolveAPIKey(ctx, &models.Provider{APIKey: encrypted})
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-database", apiKey)
+
+	headersRef, err := service.NewFileSecretStore(dir).Put(ctx, "provider-1-headers", `{"X-Team":"search"}`)
+	assert.NoError(t, err)
+	headers, err := service.ProviderServiceApp.ResolveHeaders(ctx, &models.Provider{Headers: headersRef})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"X-Team": "search"}, headers)
+
+	headers, err = service.ProviderServiceApp.ResolveHeaders(ctx, &models.Provider{})
+	assert.NoError(t, err)
+	assert.Nil(t, headers)
+
+	// The Vault backend is not configured
+	_, err = service.ProviderServiceApp.ResolveAPIKey(ctx, &models.Provider{APIKey: "vault:infoweaver/providers/provider-1-api-key"})
+	assert.ErrorIs(t, err, service.ErrSecretsBackendNotEnabled)
+}
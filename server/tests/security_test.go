@@ -0,0 +1,81 @@
+package tests
+
+import (
+	"encoding/base64"
+	"server/config"
+	"server/utils"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withEncryptionKeys(t *testing.T, keys string, primaryID string) {
+	previous := config.Settings
+	t.Cleanup(func() { config.Settings = previous })
+	config.Settings = &config.Config{JWT_SIGNING_KEY: "signing-key", ENCRYPTION_KEYS: keys, ENCRYPTION_PRIMARY_KEY_ID: primaryID}
+}
+
+func encryptionKey(fill byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(fill), 32)))
+}
+
+func TestEncryptAPIKeyEnvelope(t *testing.T) {
+	withEncryptionKeys(t, "k1:"+encryptionKey('a')+", k2:"+encryptionKey('b'), "k2")
+
+	encrypted, err := utils.EncryptAPIKey("sk-secret")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "v1:k2:"))
+	assert.Equal(t, "k2", utils.EncryptionKeyID(encrypted))
+	decrypted, err := utils.DecryptAPIKey(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-secret", decrypted)
+
+	// Relabeling the ciphertext with another key ID fails
+	_, err = utils.DecryptAPIKey(strings.Replace(encrypted, "v1:k2:", "v1:k1:", 1))
+	assert.Error(t, err)
+	_, err = utils.DecryptAPIKey(strings.Replace(encrypted, "v1:k2:", "v1:k3:", 1))
+	assert.ErrorIs(t, err, utils.ErrUnknownEncryptionKey)
+}
+
+func TestDecryptAPIKeyLegacyAndRotation(t *testing.T) {
+	withEncryptionKeys(t, "", "")
+	legacy, err := utils.EncryptAPIKey("sk-legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, utils.LEGACY_ENCRYPTION_KEY_ID, utils.EncryptionKeyID(legacy))
+
+	// Configuring keys keeps the legacy ciphertexts readable until they are rotated
+	withEncryptionKeys(t, "k1:"+encryptionKey('a'), "")
+	decrypted, err := utils.DecryptAPIKey(legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-legacy", decrypted)
+
+	rotated, changed, err := utils.ReencryptAPIKey(legacy)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, "k1", utils.EncryptionKeyID(rotated))
+	decrypted, err = utils.DecryptAPIKey(rotated)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-legacy", decrypted)
+
+	again, changed, err := utils.ReencryptAPIKey(rotated)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, rotated, again)
+}
+
+func TestCheckEncryptionKeys(t *testing.T) {
+	withEncryptionKeys(t, "k1:"+encryptionKey('a')+",k2:"+encryptionKey('b'), "")
+	primaryID, err := utils.CheckEncryptionKeys()
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", primaryID)
+
+	for _, keys := range []string{"k1", ":" + encryptionKey('a'), "k1:short", "k1:" + encryptionKey('a') + ",k1:" + encryptionKey('b')} {
+		withEncryptionKeys(t, keys, "")
+		_, err := utils.CheckEncryptionKeys()
+		assert.ErrorIs(t, err, utils.ErrInvalidEncryptionKeys, keys)
+	}
+	withEncryptionKeys(t, "k1:"+encryptionKey('a'), "k2")
+	_, err = utils.CheckEncryptionKeys()
+	assert.ErrorIs(t, err, utils.ErrInvalidEncryptionKeys)
+}
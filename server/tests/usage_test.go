@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"server/models"
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageCost(t *testing.T) {
+	prices := map[string]models.UsagePrice{
+		"gpt-4o":      {Input: 2.5, Output: 10},
+		"gpt-4o-mini": {Input: 0.15, Output: 0.6},
+		"rerank-v3.5": {Request: 0.002},
+	}
+	// The longest prefix wins, organization paths and tags are ignored
+	assert.InDelta(t, 0.15+0.6, service.UsageCost(prices, "gpt-4o-mini-2024-07-18", 1_000_000, 1_000_000), 1e-9)
+	assert.InDelta(t, 2.5/1000, service.UsageCost(prices, "openai/gpt-4o", 1000, 0), 1e-9)
+	assert.InDelta(t, 0.002, service.UsageCost(prices, "rerank-v3.5", 5000, 0), 1e-9)
+	assert.Zero(t, service.UsageCost(prices, "llama3.2:latest", 1000, 1000))
+	assert.Zero(t, service.UsageCost(nil, "gpt-4o", 1000, 1000))
+}
+
+func TestLoadUsagePrices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"BAAI/bge-m3": {"input": 0.01}, "Claude-Sonnet-4": {"input": 3, "output": 15}}`), 0o600))
+
+	prices, err := service.LoadUsagePrices(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]models.UsagePrice{
+		"bge-m3":          {Input: 0.01},
+		"claude-sonnet-4": {Input: 3, Output: 15},
+	}, prices)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"gpt-4o": 2.5}`), 0o600))
+	_, err = service.LoadUsagePrices(path)
+	assert.Error(t, err)
+}
+
+func TestEstimateTokens(t *testing.T) {
+	assert.Zero(t, service.EstimateTokens())
+	assert.Equal(t, int64(1), service.EstimateTokens("ping"))
+	assert.Equal(t, int64(3), service.EstimateTokens("hello", "world!"))
+	// Characters rather than bytes
+	assert.Equal(t, int64(1), service.EstimateTokens("向量检索"))
+}
+
+func TestEscapeCSVFormula(t *testing.T) {
+	cases := map[string]string{
+		"":                           "",
+		"gpt-4o-mini":                "gpt-4o-mini",
+		"=HYPERLINK(\"http://x\")":   "'=HYPERLINK(\"http://x\")",
+		"+1+1":                       "'+1+1",
+		"-2+3":                       "'-2+3",
+		"@SUM(A1)":                   "'@SUM(A1)",
+		"\t=1":                       "'\t=1",
+		"\r=1":                       "'\r=1",
+		"rate limited: retry in =1s": "rate limited: retry in =1s",
+		"model-=x":                   "model-=x",
+	}
+	for value, expected := range cases {
+		assert.Equal(t, expected, service.EscapeCSVFormula(value), value)
+	}
+}
+
+func TestHTTPRerankerReportsUsage(t *testing.T) {
+	for body, expected := range map[string]int64{
+		`{"results":[{"index":0,"relevance_score":0.9}],"usage":{"total_tokens":42}}`:                 42,
+		`{"results":[{"index":0,"relevance_score":0.9}],"meta":{"billed_units":{"input_tokens":17}}}`: 17,
+		`{"results":[{"index":0,"relevance_score":0.9}],"meta":{"billed_units":{"search_units":1}}}`:  0,
+	} {
+		stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(body))
+		}))
+
+		reported := int64(-1)
+		reranker := &service.HTTPReranker{BaseURL: stub.URL, Model: "rerank-model", OnUsage: func(inputTokens int64) { reported = inputTokens }}
+		_, err := reranker.Rerank(context.Background(), "query", []string{"a"}, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, reported, body)
+		stub.Close()
+	}
+}
@@ -0,0 +1,29 @@
+package tests
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"server/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookEventMatches(t *testing.T) {
+	assert.True(t, service.WebhookEventMatches([]string{"file.uploaded"}, "file.uploaded"))
+	assert.True(t, service.WebhookEventMatches([]string{"dataset.*"}, "dataset.deleted"))
+	assert.True(t, service.WebhookEventMatches([]string{"file.deleted", "file.*"}, "file.failed"))
+	assert.False(t, service.WebhookEventMatches([]string{"file.*"}, "dataset.created"))
+	assert.False(t, service.WebhookEventMatches([]string{"file.uploaded"}, "file.processed"))
+	assert.False(t, service.WebhookEventMatches(nil, "file.uploaded"))
+}
+
+func TestSignWebhookPayload(t *testing.T) {
+	payload := []byte(`{"event":"file.uploaded"}`)
+	mac := hmac.New(sha256.New, []byte("whsec_secret"))
+	mac.Write([]byte("1700000000." + string(payload)))
+
+	assert.Equal(t, "t=1700000000,v1="+hex.EncodeToString(mac.Sum(nil)), service.SignWebhookPayload("whsec_secret", 1700000000, payload))
+	assert.NotEqual(t, service.SignWebhookPayload("whsec_secret", 1700000000, payload), service.SignWebhookPayload("whsec_other", 1700000000, payload))
+}
@@ -0,0 +1,12 @@
+package utils
+
+import "time"
+
+// Backoff returns the wait before the next attempt after attempts failures: base at first, doubled after each failure up to ceiling
+func Backoff(base time.Duration, ceiling time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 1; i < attempts && delay < ceiling; i++ {
+		delay *= 2
+	}
+	return min(delay, ceiling)
+}
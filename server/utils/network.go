@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var ErrPrivateAddress = errors.New("address is not publicly routable")
+
+// Ranges that are neither private nor loopback nor link-local for net.IP, yet never on the internet
+var reservedNetworks = mustParseNetworks(
+	"0.0.0.0/8",     // This network
+	"100.64.0.0/10", // Carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // Benchmarking
+	"240.0.0.0/4",   // Reserved, broadcast included
+	"64:ff9b::/96",  // NAT64, may embed any IPv4 address
+)
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// IsPublicIP reports whether ip may be reached on behalf of a user: it must be routable on the internet,
+// so not loopback, private, link-local, unspecified, multicast or reserved, unless one of the allowed networks holds it
+func IsPublicIP(ip net.IP, allowed []*net.IPNet) bool {
+	for _, network := range allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckPublicURL resolves the host of an HTTP(S) URL given by a user and fails with ErrPrivateAddress
+// when any of its addresses is not public, see IsPublicIP
+func CheckPublicURL(ctx context.Context, rawURL string, allowed []*net.IPNet) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := target.Hostname()
+	if host == "" {
+		return fmt.Errorf("%w: no host in %q", ErrPrivateAddress, rawURL)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !IsPublicIP(addr.IP, allowed) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrPrivateAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// PublicDialer returns a dialer refusing to connect to addresses that are not public.
+// The address is checked once resolved, so a host resolving elsewhere than when its URL was checked is refused too.
+func PublicDialer(timeout time.Duration, allowed []*net.IPNet) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip, allowed) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,67 +31,251 @@ func BcryptCheck(password, hash string) bool {
 	return err == nil
 }
 
-// getEncryptionKey derives a 32-byte key from JWT_SIGNING_KEY using SHA256
-func getEncryptionKey() []byte {
-	// Use JWT_SIGNING_KEY as the base key, hash it to get 32 bytes
+const (
+	// Prefix of the ciphertexts written with envelope encryption, "v1:<key id>:<base64 payload>".
+	// Ciphertexts without it were written under the legacy key derived from JWT_SIGNING_KEY.
+	ENCRYPTION_FORMAT_VERSION = "v1"
+	// Key ID reported for the ciphertexts of the legacy key
+	LEGACY_ENCRYPTION_KEY_ID = ""
+	// Size of the data keys and key-encryption keys, AES-256
+	ENCRYPTION_KEY_SIZE = 32
+	// Size of a data key encrypted by a key-encryption key: GCM nonce, key and tag
+	WRAPPED_KEY_SIZE = 12 + ENCRYPTION_KEY_SIZE + 16
+)
+
+var (
+	ErrInvalidEncryptionKeys = errors.New("invalid ENCRYPTION_KEYS")
+	ErrUnknownEncryptionKey  = errors.New("ciphertext encrypted with an unknown key")
+)
+
+// encryptionKeys are the key-encryption keys of ENCRYPTION_KEYS by ID, and the ID new secrets are encrypted with.
+// Every listed key stays usable for decryption, so a new key can be added and made primary before older
+// ciphertexts are rotated. No configured keys keep encrypting with the legacy key.
+func encryptionKeys() (keys map[string][]byte, primaryID string, err error) {
+	keys = map[string][]byte{}
+	for entry := range strings.SplitSeq(config.Settings.ENCRYPTION_KEYS, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return nil, "", fmt.Errorf("%w: entry %q is not written id:base64key", ErrInvalidEncryptionKeys, entry)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil || len(key) != ENCRYPTION_KEY_SIZE {
+			return nil, "", fmt.Errorf("%w: key %q must be %d bytes encoded in base64", ErrInvalidEncryptionKeys, id, ENCRYPTION_KEY_SIZE)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, "", fmt.Errorf("%w: key %q is listed twice", ErrInvalidEncryptionKeys, id)
+		}
+		keys[id] = key
+		if primaryID == "" {
+			primaryID = id
+		}
+	}
+	if configured := strings.TrimSpace(config.Settings.ENCRYPTION_PRIMARY_KEY_ID); configured != "" {
+		if _, ok := keys[configured]; !ok {
+			return nil, "", fmt.Errorf("%w: primary key %q is not listed", ErrInvalidEncryptionKeys, configured)
+		}
+		primaryID = configured
+	}
+	return keys, primaryID, nil
+}
+
+// CheckEncryptionKeys verifies ENCRYPTION_KEYS and ENCRYPTION_PRIMARY_KEY_ID, and returns the ID of the primary key,
+// LEGACY_ENCRYPTION_KEY_ID when no key is configured
+func CheckEncryptionKeys() (primaryID string, err error) {
+	_, primaryID, err = encryptionKeys()
+	return primaryID, err
+}
+
+// getLegacyEncryptionKey derives a 32-byte key from JWT_SIGNING_KEY using SHA256, it only decrypts
+// ciphertexts written before ENCRYPTION_KEYS was configured
+func getLegacyEncryptionKey() []byte {
 	hash := sha256.Sum256([]byte(config.Settings.JWT_SIGNING_KEY))
 	return hash[:]
 }
 
-// EncryptAPIKey encrypts an API key using AES-256-GCM
-func EncryptAPIKey(apiKey string) (string, error) {
-	key := getEncryptionKey()
-
+// sealGCM encrypts with AES-256-GCM and prepends the nonce
+func sealGCM(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openGCM decrypts data sealed by sealGCM
+func openGCM(key []byte, data []byte, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// EncryptAPIKey encrypts an API key with envelope encryption: a random data key encrypts the value with AES-256-GCM
+// and is itself encrypted by the primary key of ENCRYPTION_KEYS, whose ID is kept in the ciphertext
+func EncryptAPIKey(apiKey string) (string, error) {
+	keys, primaryID, err := encryptionKeys()
+	if err != nil {
 		return "", err
 	}
+	if primaryID == LEGACY_ENCRYPTION_KEY_ID {
+		ciphertext, err := sealGCM(getLegacyEncryptionKey(), []byte(apiKey), nil)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(ciphertext), nil
+	}
 
-	ciphertext := gcm.Seal(nonce, nonce, []byte(apiKey), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	dataKey := make([]byte, ENCRYPTION_KEY_SIZE)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	// The key ID is authenticated with the data key, so a ciphertext cannot be relabeled
+	wrappedKey, err := sealGCM(keys[primaryID], dataKey, []byte(primaryID))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealGCM(dataKey, []byte(apiKey), nil)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.StdEncoding.EncodeToString(append(wrappedKey, ciphertext...))
+	return ENCRYPTION_FORMAT_VERSION + ":" + primaryID + ":" + payload, nil
 }
 
-// DecryptAPIKey decrypts an API key using AES-256-GCM
+// DecryptAPIKey decrypts an API key encrypted by EncryptAPIKey under any key of ENCRYPTION_KEYS, or under the legacy key
 func DecryptAPIKey(encryptedKey string) (string, error) {
-	key := getEncryptionKey()
+	keyID, payload, ok := splitCiphertext(encryptedKey)
+	if !ok {
+		data, err := base64.StdEncoding.DecodeString(encryptedKey)
+		if err != nil {
+			return "", err
+		}
+		plaintext, err := openGCM(getLegacyEncryptionKey(), data, nil)
+		if err != nil {
+			return "", err
+		}
+		return string(plaintext), nil
+	}
 
-	data, err := base64.StdEncoding.DecodeString(encryptedKey)
+	keys, _, err := encryptionKeys()
 	if err != nil {
 		return "", err
 	}
-
-	block, err := aes.NewCipher(key)
+	key, ok := keys[keyID]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownEncryptionKey, keyID)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
 	if err != nil {
 		return "", err
 	}
-
-	gcm, err := cipher.NewGCM(block)
+	if len(data) < WRAPPED_KEY_SIZE {
+		return "", errors.New("ciphertext too short")
+	}
+	dataKey, err := openGCM(key, data[:WRAPPED_KEY_SIZE], []byte(keyID))
 	if err != nil {
 		return "", err
 	}
+	plaintext, err := openGCM(dataKey, data[WRAPPED_KEY_SIZE:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
 
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return "", errors.New("ciphertext too short")
+// splitCiphertext returns the key ID and payload of an envelope ciphertext, ok is false for legacy ones.
+// Base64 never contains ':', so legacy ciphertexts cannot be mistaken for envelope ones.
+func splitCiphertext(encrypted string) (keyID string, payload string, ok bool) {
+	rest, ok := strings.CutPrefix(encrypted, ENCRYPTION_FORMAT_VERSION+":")
+	if !ok {
+		return "", "", false
 	}
+	return strings.Cut(rest, ":")
+}
 
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+// EncryptionKeyID returns the ID of the key a ciphertext of EncryptAPIKey was encrypted with,
+// LEGACY_ENCRYPTION_KEY_ID for the legacy key
+func EncryptionKeyID(encrypted string) string {
+	keyID, _, ok := splitCiphertext(encrypted)
+	if !ok {
+		return LEGACY_ENCRYPTION_KEY_ID
+	}
+	return keyID
+}
+
+// ReencryptAPIKey encrypts again a ciphertext of EncryptAPIKey under the primary key.
+// It returns the ciphertext unchanged and false when it is empty or already under the primary key.
+func ReencryptAPIKey(encrypted string) (string, bool, error) {
+	primaryID, err := CheckEncryptionKeys()
 	if err != nil {
+		return "", false, err
+	}
+	if encrypted == "" || EncryptionKeyID(encrypted) == primaryID {
+		return encrypted, false, nil
+	}
+	plaintext, err := DecryptAPIKey(encrypted)
+	if err != nil {
+		return "", false, err
+	}
+	reencrypted, err := EncryptAPIKey(plaintext)
+	return reencrypted, err == nil, err
+}
+
+const (
+	USER_API_KEY_PREFIX = "iw-" // Marks InfoWeaver API keys, e.g. in secret scanners
+	// Characters of a key kept in listings so users can tell keys apart
+	USER_API_KEY_DISPLAY_LEN = 10
+)
+
+// GenerateUserAPIKey creates a random API key carrying 256 bits of entropy
+func GenerateUserAPIKey() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
 		return "", err
 	}
+	return USER_API_KEY_PREFIX + base64.RawURLEncoding.EncodeToString(secret), nil
+}
 
-	return string(plaintext), nil
+// Marks generated webhook signing secrets
+const WEBHOOK_SECRET_PREFIX = "whsec_"
+
+// GenerateWebhookSecret creates a random key signing webhook deliveries, carrying 192 bits of entropy
+func GenerateWebhookSecret() (string, error) {
+	secret := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	return WEBHOOK_SECRET_PREFIX + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// HashUserAPIKey returns the hex encoded SHA-256 of an API key.
+// Keys are random, so a fast unsalted hash is enough and allows lookups by hash.
+func HashUserAPIKey(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(hash[:])
 }
 
 var (